@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -11,18 +12,33 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/netauth/netauth/internal/auditlog"
+	_ "github.com/netauth/netauth/internal/auditlog/file"
+	_ "github.com/netauth/netauth/internal/auditlog/kv"
+	_ "github.com/netauth/netauth/internal/auditlog/syslog"
 	"github.com/netauth/netauth/internal/crypto"
 	_ "github.com/netauth/netauth/internal/crypto/bcrypt"
 	"github.com/netauth/netauth/internal/db"
 	_ "github.com/netauth/netauth/internal/db/bitcask"
 	_ "github.com/netauth/netauth/internal/db/filesystem"
+	_ "github.com/netauth/netauth/internal/db/raft"
 	plugin "github.com/netauth/netauth/internal/plugin/tree/manager"
+	"github.com/netauth/netauth/internal/policy"
+	"github.com/netauth/netauth/internal/replica"
 	"github.com/netauth/netauth/internal/token"
 	_ "github.com/netauth/netauth/internal/token/jwt"
 
 	"github.com/netauth/netauth/internal/rpc2"
+	"github.com/netauth/netauth/internal/snapshot"
 	"github.com/netauth/netauth/internal/tree"
 	_ "github.com/netauth/netauth/internal/tree/hooks"
+	"github.com/netauth/netauth/pkg/audit"
+	"github.com/netauth/netauth/pkg/capabilities"
+	"github.com/netauth/netauth/pkg/explain"
+	"github.com/netauth/netauth/pkg/history"
+	"github.com/netauth/netauth/pkg/rename"
+	"github.com/netauth/netauth/pkg/secrets"
+	"github.com/netauth/netauth/pkg/watch"
 
 	"github.com/netauth/netauth/internal/health"
 	"github.com/netauth/netauth/internal/startup"
@@ -66,11 +82,47 @@ func init() {
 
 	pflag.String("server.bind", "localhost", "Bind address, defaults to localhost")
 	pflag.Int("server.port", 1729, "Serving port")
+	pflag.StringSlice("server.selfservice.fields", []string{"keys"}, "Fields that entities may change on themselves without any capability")
+	pflag.StringSlice("server.selfservice.kv", nil, "KV keys that entities may change on themselves without any capability, other than netauth.* keys")
 
 	pflag.String("core.home", "", "Data directory for NetAuth")
 	pflag.String("core.conf", "", "Config directory for NetAuth (inferred from config file location)")
 
 	pflag.String("db.backend", "filesystem", "Database storage backend to use")
+	pflag.Duration("db.changelog.retention", time.Hour*24*30, "How long to keep the change log, 0 to keep forever")
+	pflag.Int("db.changelog.size", 100000, "Most changes to keep in the change log, 0 for no limit")
+	pflag.Int("db.history.depth", 10, "Revisions to keep of each entity and group, 0 to keep none")
+	pflag.Duration("db.snapshot.interval", 0, "How often to write a snapshot of the database, 0 to disable")
+	pflag.String("db.snapshot.dir", "", "Directory to write snapshots to, defaults to snapshots in core.home")
+	pflag.Int("db.snapshot.keep", 7, "Most snapshots to keep, 0 to keep all")
+	pflag.StringSlice("db.numbers.entity.pools", nil, "Pools of entity numbers as name:min-max, the first is the default")
+	pflag.StringSlice("db.numbers.entity.reserved", nil, "Entity numbers that are never allocated, as min-max or a single number")
+	pflag.StringSlice("db.numbers.group.pools", nil, "Pools of group numbers as name:min-max, the first is the default")
+	pflag.StringSlice("db.numbers.group.reserved", nil, "Group numbers that are never allocated, as min-max or a single number")
+	pflag.Bool("db.index.persist", false, "Keep the search index on disk rather than building it on every start")
+	pflag.String("db.index.dir", "", "Directory to keep the search index in, defaults to index in core.home")
+	pflag.StringSlice("db.index.kv", nil, "KV keys to make searchable as kv.<key>")
+	pflag.StringSlice("db.index.untyped", nil, "Untyped metadata keys to make searchable as untyped.<key>")
+	pflag.String("db.numbers.reuse", "never", "Whether the numbers of destroyed entities and groups are allocated again (never, freed)")
+
+	pflag.String("replica.master", "", "Address of a master to follow as a read replica")
+	pflag.String("replica.certificate", "", "Certificate to verify the master with, defaults to tls.certificate")
+
+	pflag.String("tree.destroy.mode", "cascade", "How references are handled when destroying entities and groups (cascade, restrict)")
+	pflag.Duration("tree.reaper.interval", time.Minute, "How often to remove expired group memberships, 0 to disable")
+
+	pflag.StringSlice("audit.sinks", nil, "Audit sinks to record changes to (file, kv, syslog)")
+	pflag.String("audit.file.path", "", "Path of the audit file, defaults to audit.log in core.home")
+	pflag.String("audit.syslog.socket", "", "Syslog socket to send audit records to, defaults to the local daemon")
+
+	pflag.String("policy.mode", "enforce", "Whether the authorization policy is enforced or only logged (enforce, dry-run)")
+	pflag.String("policy.file", "", "File of authorization policy rules, in addition to any in policy.rules")
+
+	pflag.Int("password.length.min", 0, "Shortest secret that entities may have, 0 for no limit")
+	pflag.Int("password.classes", 0, "Kinds of character (lower, upper, digit, other) that secrets must contain")
+	pflag.String("password.banned", "", "File of words that secrets may not contain, one on each line")
+	pflag.Bool("password.identity", false, "Reject secrets that contain the entity ID or part of the GECOS")
+	pflag.Int("password.history", 0, "Recent secrets, including the current one, that may not be chosen again, 0 to keep none")
 
 	pflag.String("crypto.backend", "bcrypt", "Cryptography system to use")
 
@@ -86,6 +138,27 @@ func init() {
 	viper.SetDefault("plugin.path", filepath.Join(viper.GetString("core.home"), "plugins"))
 }
 
+// numberConfig reads the configuration of the number pools under
+// the given key.
+func numberConfig(key string) (db.NumberConfig, error) {
+	c := db.NumberConfig{Reuse: viper.GetString("db.numbers.reuse")}
+	for _, s := range viper.GetStringSlice(key + ".pools") {
+		p, err := db.ParseNumberPool(s)
+		if err != nil {
+			return c, err
+		}
+		c.Pools = append(c.Pools, p)
+	}
+	for _, s := range viper.GetStringSlice(key + ".reserved") {
+		r, err := db.ParseNumberRange(s)
+		if err != nil {
+			return c, err
+		}
+		c.Reserved = append(c.Reserved, r)
+	}
+	return c, nil
+}
+
 // newSocket binds the listening socket to the ports specified in the
 // configuration file.
 func newSocket() (net.Listener, error) {
@@ -145,6 +218,31 @@ func newGRPCServer() (*grpc.Server, error) {
 	return grpcServer, nil
 }
 
+// newReplicaConn dials the master that this server will follow as a
+// replica.  The master is verified using the replica's own
+// certificate unless a different one is configured.
+func newReplicaConn() (*grpc.ClientConn, error) {
+	var opts []grpc.DialOption
+	if *insecure {
+		opts = []grpc.DialOption{grpc.WithInsecure()}
+	} else {
+		cFile := viper.GetString("replica.certificate")
+		if cFile == "" {
+			cFile = viper.GetString("tls.certificate")
+		}
+		if !filepath.IsAbs(cFile) {
+			cFile = filepath.Join(viper.GetString("core.conf"), cFile)
+		}
+		creds, err := credentials.NewClientTLSFromFile(cFile, "")
+		if err != nil {
+			appLogger.Error("Replica TLS could not be initialized", "error", err)
+			return nil, err
+		}
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	}
+	return grpc.Dial(viper.GetString("replica.master"), opts...)
+}
+
 // loadConfig is a convenience function that handles the loading of
 // the viper configuration singleton.  This function is called just
 // after flag parsing completes and if it is unsuccessful it aborts
@@ -279,8 +377,65 @@ func main() {
 		appLogger.Error("Fatal database error", "error", err)
 		os.Exit(1)
 	}
+	dbImpl.SetChangeLogRetention(viper.GetDuration("db.changelog.retention"), viper.GetInt("db.changelog.size"))
+	dbImpl.SetHistoryDepth(viper.GetInt("db.history.depth"))
+	entityNumbers, err := numberConfig("db.numbers.entity")
+	if err == nil {
+		err = dbImpl.SetEntityNumbers(entityNumbers)
+	}
+	if err != nil {
+		appLogger.Error("Bad entity number configuration", "error", err)
+		os.Exit(1)
+	}
+	groupNumbers, err := numberConfig("db.numbers.group")
+	if err == nil {
+		err = dbImpl.SetGroupNumbers(groupNumbers)
+	}
+	if err != nil {
+		appLogger.Error("Bad group number configuration", "error", err)
+		os.Exit(1)
+	}
+	dbImpl.SetIndexedKeys(viper.GetStringSlice("db.index.kv"), viper.GetStringSlice("db.index.untyped"))
+	if viper.GetBool("db.index.persist") {
+		dir := viper.GetString("db.index.dir")
+		if dir == "" {
+			dir = filepath.Join(viper.GetString("core.home"), "index")
+		}
+		if err := dbImpl.PersistIndex(dir); err != nil {
+			appLogger.Error("Fatal search index error", "error", err)
+			os.Exit(1)
+		}
+		r, err := dbImpl.ValidateIndex()
+		if err != nil {
+			appLogger.Error("Fatal search index error", "error", err)
+			os.Exit(1)
+		}
+		appLogger.Info("Search index validated", "directory", dir, "checked", r.Checked, "reindexed", r.Reindexed, "removed", r.Removed)
+	}
 	appLogger.Info("Database initialized", "backend", viper.GetString("db.backend"))
 
+	// A server may follow a master as a read replica, in which
+	// case all of its data comes from the master and it must not
+	// accept any changes of its own.  Independently, any server
+	// with a replication secret configured will serve replicas.
+	var follower *replica.Follower
+	if viper.GetString("replica.master") != "" {
+		cc, err := newReplicaConn()
+		if err != nil {
+			appLogger.Error("Fatal replica error", "error", err)
+			os.Exit(1)
+		}
+		follower = replica.NewFollower(dbImpl, cc, appLogger)
+		follower.RegisterCheck()
+		viper.Set("server.readonly", true)
+		appLogger.Info("Running as a read replica", "master", viper.GetString("replica.master"))
+	}
+	var source *replica.Source
+	if viper.GetString("replication.secret") != "" {
+		source = replica.NewSource(dbImpl, appLogger)
+		dbImpl.RegisterCallback("replication", source.Notify)
+	}
+
 	cryptoImpl, err := crypto.New(viper.GetString("crypto.backend"))
 	if err != nil {
 		appLogger.Error("Fatal crypto error", "error", err)
@@ -299,16 +454,46 @@ func main() {
 		appLogger.Error("Fatal initialization error", "error", err)
 		os.Exit(1)
 	}
+	if err := tree.SetDestroyMode(viper.GetString("tree.destroy.mode")); err != nil {
+		appLogger.Error("Fatal initialization error", "error", err)
+		os.Exit(1)
+	}
 	if viper.GetBool("plugin.enabled") {
 		pluginManager.ConfigureEntityChains(tree.RegisterEntityHookToChain)
 		pluginManager.ConfigureGroupChains(tree.RegisterGroupHookToChain)
 	}
 
+	// Every change made by the tree can be recorded to an audit
+	// log, which keeps who made the change and what it was.
+	var auditLog *auditlog.Log
+	if sinks := viper.GetStringSlice("audit.sinks"); len(sinks) > 0 {
+		auditLog, err = auditlog.New(sinks, auditlog.Refs{DB: dbImpl}, appLogger)
+		if err != nil {
+			appLogger.Error("Fatal audit error", "error", err)
+			os.Exit(1)
+		}
+		tree.SetAuditLog(auditLog)
+		appLogger.Info("Audit log initialized", "sinks", sinks)
+	}
+
+	// Requests that need authority can be decided by rules that
+	// the operator declares, ahead of the capabilities of the
+	// requestor.  In dry run mode the decisions are only logged.
+	authPolicy, err := policy.New(tree, appLogger)
+	if err != nil {
+		appLogger.Error("Fatal policy error", "error", err)
+		os.Exit(1)
+	}
+	if authPolicy != nil {
+		appLogger.Info("Authorization policy loaded", "mode", viper.GetString("policy.mode"))
+	}
+
 	// All internal components have initialized and registered for
 	// storage callbacks at this point.  We now run a storage
 	// callback claiming that everything on the server has been
 	// updated to allow data to load into memory that is not
-	// persisted to disk.
+	// persisted to disk.  A persistent search index skips the
+	// documents that it already has at their current revision.
 	if err := dbImpl.EventUpdateAll(); err != nil {
 		appLogger.Error("Error during initial event preload", "error", err)
 		os.Exit(1)
@@ -324,6 +509,10 @@ func main() {
 	// taken, the capability to bootstrap is disabled for the
 	// lifetime of the server.
 	if len(*bootstrap) != 0 {
+		if follower != nil {
+			appLogger.Error("A read replica cannot be bootstrapped, bootstrap the master instead")
+			os.Exit(1)
+		}
 		if err := doSuperuserBootstrap(tree); err != nil {
 			appLogger.Error("Critical error during superuser bootstrap", "error", err)
 			os.Exit(1)
@@ -356,17 +545,34 @@ func main() {
 
 	// A NetAuth server may serve more than one protocol version
 	// at a time.  This section binds the different application
-	// protocol versions to the grpcServer.
-	rpb.RegisterNetAuth2Server(
-		grpcServer,
-		rpc2.New(
-			rpc2.Refs{
-				TokenService: tokenService,
-				Tree:         tree,
+	// protocol versions to the grpcServer.  The watch, audit,
+	// history, rename, explain, and capabilities services are
+	// served alongside the protocol, and the watch service is fed
+	// by database events.
+	rpcServer := rpc2.New(
+		rpc2.Refs{
+			TokenService: tokenService,
+			Tree:         tree,
+			WithActor: func(a audit.Actor) rpc2.Manager {
+				return tree.WithActor(a)
 			},
-			appLogger,
-		),
+			Policy:    authPolicy,
+			ChangeLog: dbImpl,
+		},
+		appLogger,
 	)
+	rpb.RegisterNetAuth2Server(grpcServer, rpcServer)
+	watch.RegisterServer(grpcServer, rpcServer)
+	audit.RegisterServer(grpcServer, rpcServer)
+	history.RegisterServer(grpcServer, rpcServer)
+	rename.RegisterServer(grpcServer, rpcServer)
+	secrets.RegisterServer(grpcServer, rpcServer)
+	explain.RegisterServer(grpcServer, rpcServer)
+	capabilities.RegisterServer(grpcServer, rpcServer)
+	dbImpl.RegisterCallback("watch", rpcServer.Notify)
+	if source != nil {
+		source.Register(grpcServer)
+	}
 
 	// While the server is for the most part stateless, the
 	// plugins might not be.  This block registers the shutdown
@@ -374,6 +580,7 @@ func main() {
 	// not leak processes.  Any additional parallel shutdown tasks
 	// should be added to the goroutine which will be signalled in
 	// the event of a process interrupt or termination signal.
+	ctx, cancel := context.WithCancel(context.Background())
 	c := make(chan os.Signal, 1)
 	go func() {
 		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
@@ -382,8 +589,17 @@ func main() {
 	go func() {
 		<-c
 		appLogger.Info("Shutting down...")
+		cancel()
+		if source != nil {
+			source.Shutdown()
+		}
+		rpcServer.Shutdown()
 		grpcServer.GracefulStop()
 		pluginManager.Shutdown()
+		if auditLog != nil {
+			auditLog.Close()
+		}
+		dbImpl.Shutdown()
 		close(done)
 	}()
 
@@ -394,6 +610,15 @@ func main() {
 	// the server here, its comparatively more likely that file
 	// permissions will be wrong on some important file earlier on
 	// than the port won't bind.
+	if follower != nil {
+		go follower.Run(ctx)
+	}
+	if viper.GetDuration("db.snapshot.interval") > 0 {
+		go snapshot.New(dbImpl, appLogger).Run(ctx)
+	}
+	if follower == nil && viper.GetDuration("tree.reaper.interval") > 0 {
+		go tree.RunReaper(ctx, viper.GetDuration("tree.reaper.interval"))
+	}
 	appLogger.Info("Ready to Serve...")
 	sock, err := newSocket()
 	if err != nil {
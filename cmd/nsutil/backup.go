@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/netauth/netauth/internal/db"
+	_ "github.com/netauth/netauth/internal/db/bitcask"
+	_ "github.com/netauth/netauth/internal/db/filesystem"
+
+	"github.com/netauth/netauth/internal/startup"
+)
+
+var (
+	backupCmd = &cobra.Command{
+		Use:   "backup <backend> <archive>",
+		Short: "Write an archive of all entities and groups",
+		Long:  backupCmdLongDocs,
+		Run:   backupCmdRun,
+		Args:  cobra.ExactArgs(2),
+	}
+
+	backupCmdLongDocs = `
+The backup command writes every entity and group in a datastore to a
+single compressed archive.  The archive records the format version,
+the backend it was taken from, and a checksum of its contents, and can
+be loaded into any backend with the restore command.
+
+Running netauthd can take the same archives on a schedule, see the
+db.snapshot.interval option.
+`
+)
+
+func init() {
+	rootCmd.AddCommand(backupCmd)
+}
+
+func backupCmdRun(c *cobra.Command, args []string) {
+	startup.DoCallbacks()
+
+	source, err := db.New(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error initializing source: %s\n", err)
+		os.Exit(1)
+	}
+	defer source.Shutdown()
+
+	a, err := source.Snapshot()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading source: %s\n", err)
+		os.Exit(1)
+	}
+
+	// Write to a temporary file first so that an existing
+	// archive is never left half overwritten.
+	f, err := ioutil.TempFile(filepath.Dir(args[1]), ".backup-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating archive: %s\n", err)
+		os.Exit(1)
+	}
+	if _, err := a.WriteTo(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		fmt.Fprintf(os.Stderr, "Error writing archive: %s\n", err)
+		os.Exit(1)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		fmt.Fprintf(os.Stderr, "Error writing archive: %s\n", err)
+		os.Exit(1)
+	}
+	if err := os.Rename(f.Name(), args[1]); err != nil {
+		os.Remove(f.Name())
+		fmt.Fprintf(os.Stderr, "Error writing archive: %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Backup complete; %d entities and %d groups were written to %s.\n", a.Meta.Entities, a.Meta.Groups, args[1])
+	fmt.Printf("Checksum: %s\n", a.Meta.Checksum)
+}
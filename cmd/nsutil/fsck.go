@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/netauth/netauth/internal/db"
+	_ "github.com/netauth/netauth/internal/db/bitcask"
+	_ "github.com/netauth/netauth/internal/db/filesystem"
+	"github.com/netauth/netauth/internal/fsck"
+
+	"github.com/netauth/netauth/internal/startup"
+)
+
+var (
+	fsckCmd = &cobra.Command{
+		Use:   "fsck <backend>",
+		Short: "Check the consistency of a datastore",
+		Long:  fsckCmdLongDocs,
+		Run:   fsckCmdRun,
+		Args:  cobra.ExactArgs(1),
+	}
+
+	fsckCmdLongDocs = `
+The fsck command loads every entity and group and reports records that
+cannot be loaded, memberships in groups that do not exist, expansions
+and managing groups that refer to groups that do not exist, numbers
+that are used more than once, and expansions that form a cycle.
+
+Most problems can be repaired, and the repair that would be made is
+shown next to each problem.  Duplicate numbers are repaired by giving
+every entity or group after the first, by name, the next free number,
+which will change the ownership of any files it owned.  Records that
+cannot be loaded must be repaired by hand.  Nothing is changed unless
+--no-dry-run is passed.
+`
+
+	fsckCmdNoDryRun bool
+)
+
+func init() {
+	fsckCmd.Flags().BoolVar(&fsckCmdNoDryRun, "no-dry-run", false, "Make repairs, potentially destructive.")
+
+	rootCmd.AddCommand(fsckCmd)
+}
+
+func fsckCmdRun(c *cobra.Command, args []string) {
+	startup.DoCallbacks()
+
+	store, err := db.New(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error initializing datastore: %s\n", err)
+		os.Exit(1)
+	}
+
+	problems, err := fsck.Check(store)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error checking datastore: %s\n", err)
+		os.Exit(1)
+	}
+	if len(problems) == 0 {
+		fmt.Println("No problems found.")
+		return
+	}
+
+	repairable := 0
+	for _, p := range problems {
+		fmt.Println(p)
+		if p.Repair == "" {
+			fmt.Println("    must be repaired by hand")
+			continue
+		}
+		fmt.Printf("    repair: %s\n", p.Repair)
+		repairable++
+	}
+	fmt.Printf("%d problems found, %d can be repaired.\n", len(problems), repairable)
+
+	if !fsckCmdNoDryRun {
+		fmt.Println("You are in dry-run mode, pass --no-dry-run to make the repairs described above.")
+		os.Exit(1)
+	}
+
+	n, err := fsck.Repair(store, problems)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error repairing datastore: %s\n", err)
+	}
+	store.Shutdown()
+	fmt.Printf("%d problems were repaired.\n", n)
+	if err != nil || n < len(problems) {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/netauth/netauth/internal/db"
+	_ "github.com/netauth/netauth/internal/db/bitcask"
+	_ "github.com/netauth/netauth/internal/db/filesystem"
+
+	"github.com/netauth/netauth/internal/startup"
+)
+
+var (
+	indexCmd = &cobra.Command{
+		Use:   "index",
+		Short: "Manage the persistent search index",
+	}
+
+	indexRebuildCmd = &cobra.Command{
+		Use:   "rebuild <backend>",
+		Short: "Rebuild the persistent search index from scratch",
+		Long:  indexRebuildCmdLongDocs,
+		Run:   indexRebuildCmdRun,
+		Args:  cobra.ExactArgs(1),
+	}
+
+	indexRebuildCmdLongDocs = `
+The rebuild command discards the search index that netauthd keeps on
+disk when db.index.persist is set, and indexes every entity and group
+in the datastore again.
+
+netauthd validates the index each time it starts and only indexes the
+entities and groups that have changed, so a rebuild is only needed if
+the index has been damaged or was written by an incompatible version.
+`
+
+	indexRebuildDir string
+)
+
+func init() {
+	indexRebuildCmd.Flags().StringVar(&indexRebuildDir, "dir", "", "Index directory, defaults to db.index.dir or index in core.home")
+
+	indexCmd.AddCommand(indexRebuildCmd)
+	rootCmd.AddCommand(indexCmd)
+}
+
+func indexRebuildCmdRun(c *cobra.Command, args []string) {
+	startup.DoCallbacks()
+
+	dir := indexRebuildDir
+	if dir == "" {
+		dir = viper.GetString("db.index.dir")
+	}
+	if dir == "" {
+		dir = filepath.Join(viper.GetString("core.home"), "index")
+	}
+
+	store, err := db.New(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error initializing datastore: %s\n", err)
+		os.Exit(1)
+	}
+	defer store.Shutdown()
+	store.SetIndexedKeys(viper.GetStringSlice("db.index.kv"), viper.GetStringSlice("db.index.untyped"))
+
+	if err := os.RemoveAll(dir); err != nil {
+		fmt.Fprintf(os.Stderr, "Error removing index: %s\n", err)
+		os.Exit(1)
+	}
+	if err := store.PersistIndex(dir); err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating index: %s\n", err)
+		os.Exit(1)
+	}
+	r, err := store.ValidateIndex()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error building index: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Indexed %d entities and groups in %s\n", r.Reindexed, dir)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/netauth/netauth/internal/db"
+	_ "github.com/netauth/netauth/internal/db/bitcask"
+	_ "github.com/netauth/netauth/internal/db/filesystem"
+
+	"github.com/netauth/netauth/internal/startup"
+)
+
+var (
+	restoreCmd = &cobra.Command{
+		Use:   "restore <archive> [target]",
+		Short: "Load an archive into a datastore",
+		Long:  restoreCmdLongDocs,
+		Run:   restoreCmdRun,
+		Args:  cobra.RangeArgs(1, 2),
+	}
+
+	restoreCmdLongDocs = `
+The restore command loads an archive written by the backup command, or
+by a scheduled snapshot, into any backend.  The archive is checked
+against its checksum before anything is written, and every record is
+read back from the target once it has been written.
+
+With --verify the archive is only checked and no target is required.
+Otherwise the changes that would be made to the target are described,
+and nothing is written unless --no-dry-run is passed.  Entities and
+groups in the target that are not in the archive are kept unless
+--truncate is passed.
+`
+
+	restoreCmdNoDryRun bool
+	restoreCmdTruncate bool
+	restoreCmdVerify   bool
+)
+
+func init() {
+	restoreCmd.Flags().BoolVar(&restoreCmdNoDryRun, "no-dry-run", false, "Make changes, potentially destructive.")
+	restoreCmd.Flags().BoolVar(&restoreCmdTruncate, "truncate", false, "Remove entities and groups that are not in the archive.")
+	restoreCmd.Flags().BoolVar(&restoreCmdVerify, "verify", false, "Only verify the archive.")
+
+	rootCmd.AddCommand(restoreCmd)
+}
+
+func restoreCmdRun(c *cobra.Command, args []string) {
+	startup.DoCallbacks()
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening archive: %s\n", err)
+		os.Exit(1)
+	}
+	a, err := db.ReadArchive(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading archive: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Archive is valid; format version %d, taken from %s at %s.\n", a.Meta.Version, a.Meta.Backend, a.Meta.Time.Format(time.RFC3339))
+	fmt.Printf("Archive contains %d entities, %d groups, and %d secret histories.\n", a.Meta.Entities, a.Meta.Groups, a.Meta.Secrets)
+
+	if restoreCmdVerify {
+		os.Exit(0)
+	}
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "A target is required unless --verify is passed")
+		os.Exit(1)
+	}
+
+	target, err := db.NewKV(args[1], nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error initializing target: %s\n", err)
+		os.Exit(1)
+	}
+	target.SetEventFunc(func(db.Event) {})
+	defer target.Close()
+
+	res, err := a.Restore(target, restoreCmdTruncate, !restoreCmdNoDryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error restoring archive: %s\n", err)
+		os.Exit(1)
+	}
+
+	if !restoreCmdNoDryRun {
+		fmt.Printf("%d objects would be created, %d updated, and %d removed in %s; %d are unchanged.\n", res.Created, res.Updated, res.Removed, args[1], res.Unchanged)
+		fmt.Println("You are in dry-run mode, pass --no-dry-run to make changes described above.")
+		return
+	}
+	fmt.Printf("Restore complete; %d objects were created, %d updated, and %d removed; %d were unchanged.\n", res.Created, res.Updated, res.Removed, res.Unchanged)
+}
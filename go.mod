@@ -24,6 +24,8 @@ require (
 	github.com/google/renameio v0.1.0
 	github.com/hashicorp/go-hclog v0.9.2
 	github.com/hashicorp/go-plugin v1.0.1
+	github.com/hashicorp/raft v1.3.1
+	github.com/hashicorp/raft-boltdb v0.0.0-20171010151810-6e5ba93211ea
 	github.com/jmhodges/levigo v1.0.0 // indirect
 	github.com/netauth/protocol v0.0.0-20210308093302-0e6e0dd6dfb2
 	github.com/prologic/bitcask v0.3.10
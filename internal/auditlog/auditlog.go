@@ -0,0 +1,132 @@
+// Package auditlog records changes made to entities and groups.  The
+// tree hands each change to a Log, which writes it to every
+// configured sink.  Sinks register themselves by name in the same way
+// as KV stores, and are selected with the audit.sinks key.
+package auditlog
+
+import (
+	"errors"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/pkg/audit"
+)
+
+var (
+	// ErrUnknownSink is returned when a sink is requested that
+	// has not been registered.
+	ErrUnknownSink = errors.New("the specified audit sink does not exist")
+
+	// ErrNotQueryable is returned when the log is queried but
+	// none of the configured sinks can be read back.
+	ErrNotQueryable = errors.New("no configured audit sink can be queried")
+
+	sinks = make(map[string]Factory)
+)
+
+// A Sink is somewhere that records are written.
+type Sink interface {
+	Write(*audit.Record) error
+	Close() error
+}
+
+// A Reader is a Sink that can also be queried.  Records are returned
+// oldest first.
+type Reader interface {
+	Query(*audit.Query) ([]*audit.Record, error)
+}
+
+// Storage is provided to sinks that keep records in the server's own
+// KVStore.
+type Storage interface {
+	Bucket(string) *db.Bucket
+}
+
+// Refs contains the references that a sink may need.
+type Refs struct {
+	DB Storage
+}
+
+// A Factory creates a sink.
+type Factory func(Refs, hclog.Logger) (Sink, error)
+
+// RegisterSink registers a sink factory which can be called later.
+func RegisterSink(name string, f Factory) {
+	if _, ok := sinks[name]; ok {
+		return
+	}
+	sinks[name] = f
+}
+
+// Log writes records to a set of sinks.
+type Log struct {
+	sinks  []Sink
+	names  []string
+	reader Reader
+	l      hclog.Logger
+}
+
+// New opens each of the named sinks.  Queries are answered by the
+// first sink that can be read back.
+func New(names []string, r Refs, l hclog.Logger) (*Log, error) {
+	x := &Log{l: l.Named("audit")}
+	for _, n := range names {
+		f, ok := sinks[n]
+		if !ok {
+			x.l.Debug("Requested bad sink", "sink", n)
+			x.Close()
+			return nil, ErrUnknownSink
+		}
+		s, err := f(r, x.l)
+		if err != nil {
+			x.Close()
+			return nil, err
+		}
+		if rd, ok := s.(Reader); ok && x.reader == nil {
+			x.reader = rd
+		}
+		x.sinks = append(x.sinks, s)
+		x.names = append(x.names, n)
+		x.l.Debug("Audit sink initialized", "sink", n)
+	}
+	return x, nil
+}
+
+// Record writes a record to every sink.  The change being recorded
+// has already been made, so failures are logged rather than
+// returned.
+func (x *Log) Record(r *audit.Record) {
+	for i, s := range x.sinks {
+		if err := s.Write(r); err != nil {
+			x.l.Error("Error writing audit record", "sink", x.names[i], "target", r.Target, "error", err)
+		}
+	}
+}
+
+// Query returns the records that match the query, oldest first.
+func (x *Log) Query(q *audit.Query) ([]*audit.Record, error) {
+	if x.reader == nil {
+		return nil, ErrNotQueryable
+	}
+	return x.reader.Query(q)
+}
+
+// Close closes all sinks.
+func (x *Log) Close() {
+	for i, s := range x.sinks {
+		if err := s.Close(); err != nil {
+			x.l.Warn("Error closing audit sink", "sink", x.names[i], "error", err)
+		}
+	}
+}
+
+// Limit trims a set of matched records, oldest first, to the most
+// recent records allowed by the query.  It is provided for sinks
+// that scan their records in order.
+func Limit(q *audit.Query, r []*audit.Record) []*audit.Record {
+	if q.Limit > 0 && len(r) > q.Limit {
+		return r[len(r)-q.Limit:]
+	}
+	return r
+}
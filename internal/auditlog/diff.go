@@ -0,0 +1,101 @@
+package auditlog
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+
+	"github.com/netauth/netauth/pkg/audit"
+)
+
+// redacted replaces the value of fields that must never be written
+// to the audit log.  The change itself is still recorded.
+const redacted = "<redacted>"
+
+// Diff compares two versions of an entity or group and returns the
+// fields that differ, sorted by name.  Either version may be nil,
+// which is the case when the object is created or destroyed.  Nested
+// messages are compared field by field, while lists are compared as
+// a whole.
+func Diff(before, after proto.Message) []audit.FieldChange {
+	b := flatten(before)
+	a := flatten(after)
+
+	fields := []string{}
+	for f := range b {
+		fields = append(fields, f)
+	}
+	for f := range a {
+		if _, ok := b[f]; !ok {
+			fields = append(fields, f)
+		}
+	}
+	sort.Strings(fields)
+
+	out := []audit.FieldChange{}
+	for _, f := range fields {
+		if b[f] == a[f] {
+			continue
+		}
+		c := audit.FieldChange{Field: f, Old: b[f], New: a[f]}
+		if isSecret(f) {
+			c.Old = redact(c.Old)
+			c.New = redact(c.New)
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// flatten converts a message into a map of dotted field names to
+// values.  Unset fields are omitted.
+func flatten(m proto.Message) map[string]string {
+	out := make(map[string]string)
+	if m == nil || reflect.ValueOf(m).IsNil() {
+		return out
+	}
+
+	s, err := (&jsonpb.Marshaler{OrigName: true}).MarshalToString(m)
+	if err != nil {
+		return out
+	}
+	v := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return out
+	}
+	flattenInto(out, "", v)
+	return out
+}
+
+func flattenInto(out map[string]string, prefix string, v interface{}) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, c := range t {
+			if prefix != "" {
+				k = prefix + "." + k
+			}
+			flattenInto(out, k, c)
+		}
+	case string:
+		out[prefix] = t
+	default:
+		b, _ := json.Marshal(t)
+		out[prefix] = string(b)
+	}
+}
+
+func isSecret(f string) bool {
+	parts := strings.Split(f, ".")
+	return strings.EqualFold(parts[len(parts)-1], "secret")
+}
+
+func redact(s string) string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
@@ -0,0 +1,60 @@
+package auditlog
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/netauth/netauth/pkg/audit"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestDiff(t *testing.T) {
+	before := &pb.Entity{
+		ID:     proto.String("entity1"),
+		Number: proto.Int32(1),
+		Secret: proto.String("hash1"),
+		Meta: &pb.EntityMeta{
+			Shell:  proto.String("/bin/sh"),
+			Groups: []string{"group1"},
+		},
+	}
+	after := &pb.Entity{
+		ID:     proto.String("entity1"),
+		Number: proto.Int32(1),
+		Secret: proto.String("hash2"),
+		Meta: &pb.EntityMeta{
+			Shell:       proto.String("/bin/bash"),
+			DisplayName: proto.String("Entity One"),
+			Groups:      []string{"group1", "group2"},
+		},
+	}
+
+	want := []audit.FieldChange{
+		{Field: "meta.DisplayName", New: "Entity One"},
+		{Field: "meta.Groups", Old: `["group1"]`, New: `["group1","group2"]`},
+		{Field: "meta.Shell", Old: "/bin/sh", New: "/bin/bash"},
+		{Field: "secret", Old: redacted, New: redacted},
+	}
+	assert.Equal(t, want, Diff(before, after))
+	assert.Equal(t, []audit.FieldChange{}, Diff(before, before))
+}
+
+func TestDiffCreateDestroy(t *testing.T) {
+	g := &pb.Group{Name: proto.String("group1"), Number: proto.Int32(2)}
+
+	want := []audit.FieldChange{
+		{Field: "Name", New: "group1"},
+		{Field: "Number", New: "2"},
+	}
+	assert.Equal(t, want, Diff(nil, g))
+
+	var nilGroup *pb.Group
+	want = []audit.FieldChange{
+		{Field: "Name", Old: "group1"},
+		{Field: "Number", Old: "2"},
+	}
+	assert.Equal(t, want, Diff(g, nilGroup))
+}
@@ -0,0 +1,104 @@
+// Package file implements an audit sink that appends each record to a
+// file as a line of JSON.  The file can be read by other tools, and
+// is scanned from the start to answer queries.
+//
+// The path is configured with audit.file.path, and defaults to
+// audit.log in the server's home directory.
+package file
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/viper"
+
+	"github.com/netauth/netauth/internal/auditlog"
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/pkg/audit"
+)
+
+func init() {
+	startup.RegisterCallback(cb)
+}
+
+func cb() {
+	auditlog.RegisterSink("file", New)
+}
+
+// Sink appends records to a file.
+type Sink struct {
+	sync.Mutex
+
+	path string
+	f    *os.File
+	l    hclog.Logger
+}
+
+// New opens the audit file from the server configuration.
+func New(_ auditlog.Refs, l hclog.Logger) (auditlog.Sink, error) {
+	p := viper.GetString("audit.file.path")
+	if p == "" {
+		p = filepath.Join(viper.GetString("core.home"), "audit.log")
+	}
+	return open(p, l.Named("file"))
+}
+
+func open(p string, l hclog.Logger) (*Sink, error) {
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	l.Debug("Audit file opened", "path", p)
+	return &Sink{path: p, f: f, l: l}, nil
+}
+
+// Write appends a record to the file.
+func (s *Sink) Write(r *audit.Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	_, err = s.f.Write(append(b, '\n'))
+	return err
+}
+
+// Query scans the file for matching records.  Lines that can't be
+// parsed are skipped.
+func (s *Sink) Query(q *audit.Query) ([]*audit.Record, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	out := []*audit.Record{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		r := new(audit.Record)
+		if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+			s.l.Warn("Skipping unreadable audit record", "error", err)
+			continue
+		}
+		if q.Matches(r) {
+			out = append(out, r)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return auditlog.Limit(q, out), nil
+}
+
+// Close closes the file.
+func (s *Sink) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	return s.f.Close()
+}
@@ -0,0 +1,57 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/netauth/netauth/pkg/audit"
+)
+
+func TestWriteQuery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "audit.log")
+
+	s, err := open(p, hclog.NewNullLogger())
+	assert.Nil(t, err)
+
+	start := time.Now()
+	records := []*audit.Record{
+		{Time: start, Actor: audit.Actor{Entity: "admin"}, Chain: "CREATE", Kind: audit.KindEntity, Target: "entity1"},
+		{Time: start.Add(time.Minute), Actor: audit.Actor{Entity: "admin"}, Chain: "LOCK", Kind: audit.KindEntity, Target: "entity2"},
+		{Time: start.Add(2 * time.Minute), Actor: audit.Actor{Entity: "entity1"}, Chain: "SET-SECRET", Kind: audit.KindEntity, Target: "entity1"},
+	}
+	for _, r := range records {
+		assert.Nil(t, s.Write(r))
+	}
+
+	// A corrupt line doesn't prevent the rest from being read.
+	s.f.Write([]byte("not json\n"))
+	assert.Nil(t, s.Close())
+
+	s, err = open(p, hclog.NewNullLogger())
+	assert.Nil(t, err)
+	defer s.Close()
+
+	res, err := s.Query(&audit.Query{Target: "entity1"})
+	assert.Nil(t, err)
+	assert.Len(t, res, 2)
+	assert.Equal(t, "CREATE", res[0].Chain)
+
+	res, err = s.Query(&audit.Query{Actor: "admin", Since: start.Add(30 * time.Second)})
+	assert.Nil(t, err)
+	assert.Len(t, res, 1)
+	assert.Equal(t, "entity2", res[0].Target)
+
+	res, err = s.Query(&audit.Query{Limit: 1})
+	assert.Nil(t, err)
+	assert.Len(t, res, 1)
+	assert.Equal(t, "SET-SECRET", res[0].Chain)
+}
@@ -0,0 +1,95 @@
+// Package kv implements an audit sink that keeps records in the
+// server's own KVStore, in the audit bucket.  Records are replicated
+// and backed up along with the rest of the data, but every query
+// loads every record, so this sink suits servers with a modest rate
+// of change.
+package kv
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/netauth/netauth/internal/auditlog"
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/pkg/audit"
+)
+
+func init() {
+	startup.RegisterCallback(cb)
+}
+
+func cb() {
+	auditlog.RegisterSink("kv", New)
+}
+
+// Sink stores records in a bucket.
+type Sink struct {
+	sync.Mutex
+
+	b    *db.Bucket
+	last int64
+	l    hclog.Logger
+}
+
+// New returns a sink that writes to the audit bucket.
+func New(r auditlog.Refs, l hclog.Logger) (auditlog.Sink, error) {
+	return &Sink{b: r.DB.Bucket("audit"), l: l.Named("kv")}, nil
+}
+
+// Write stores a record keyed by its time, so that the keys sort in
+// the order the records were written.
+func (s *Sink) Write(r *audit.Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.b.Put(s.nextKey(r.Time.UnixNano()), b)
+}
+
+// nextKey returns a key for a record made at the given time, which
+// is moved forward if needed so that keys are never reused.
+func (s *Sink) nextKey(t int64) string {
+	s.Lock()
+	defer s.Unlock()
+	if t <= s.last {
+		t = s.last + 1
+	}
+	s.last = t
+	return fmt.Sprintf("%020d", t)
+}
+
+// Query loads each record in turn and returns those that match.
+func (s *Sink) Query(q *audit.Query) ([]*audit.Record, error) {
+	keys, err := s.b.Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	out := []*audit.Record{}
+	for _, k := range keys {
+		b, err := s.b.Get(k)
+		if err == db.ErrNoValue {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		r := new(audit.Record)
+		if err := json.Unmarshal(b, r); err != nil {
+			s.l.Warn("Skipping unreadable audit record", "key", k, "error", err)
+			continue
+		}
+		if q.Matches(r) {
+			out = append(out, r)
+		}
+	}
+	return auditlog.Limit(q, out), nil
+}
+
+// Close is required by the interface, the KVStore is closed with the
+// rest of the database.
+func (s *Sink) Close() error { return nil }
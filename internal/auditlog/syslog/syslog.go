@@ -0,0 +1,67 @@
+// Package syslog implements an audit sink that sends each record as
+// JSON to the local syslog daemon, from where it can be forwarded to
+// a central log store.  Records sent to syslog can't be queried by
+// the server, so this sink is usually combined with another.
+//
+// The socket is configured with audit.syslog.socket.  If it is empty
+// the usual locations such as /dev/log are tried.
+package syslog
+
+import (
+	"encoding/json"
+	"log/syslog"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/viper"
+
+	"github.com/netauth/netauth/internal/auditlog"
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/pkg/audit"
+)
+
+func init() {
+	startup.RegisterCallback(cb)
+}
+
+func cb() {
+	viper.SetDefault("audit.syslog.tag", "netauthd")
+	auditlog.RegisterSink("syslog", New)
+}
+
+// Sink writes records to syslog.
+type Sink struct {
+	w *syslog.Writer
+}
+
+// New connects to the local syslog socket.
+func New(_ auditlog.Refs, l hclog.Logger) (auditlog.Sink, error) {
+	prio := syslog.LOG_NOTICE | syslog.LOG_AUTH
+	tag := viper.GetString("audit.syslog.tag")
+
+	var w *syslog.Writer
+	var err error
+	if sock := viper.GetString("audit.syslog.socket"); sock != "" {
+		w, err = syslog.Dial("unixgram", sock, prio, tag)
+	} else {
+		w, err = syslog.New(prio, tag)
+	}
+	if err != nil {
+		return nil, err
+	}
+	l.Named("syslog").Debug("Connected to syslog")
+	return &Sink{w: w}, nil
+}
+
+// Write sends a record to syslog.
+func (s *Sink) Write(r *audit.Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.w.Notice(string(b))
+}
+
+// Close disconnects from syslog.
+func (s *Sink) Close() error {
+	return s.w.Close()
+}
@@ -25,7 +25,9 @@ var (
 	authChangeSecretLongDocs = `
 The change-secret command is used to change an entity's secret either
 reflexively (the entity requests the change) or administratively
-(another entity changes the secret).`
+(another entity changes the secret).  If the server enforces a
+password policy and the new secret doesn't meet it, the reasons are
+printed and the secret is left unchanged.`
 
 	authChangeSecretExample = `$ netauth auth change-secret
 Old Secret:
@@ -0,0 +1,48 @@
+package ctl
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/netauth/netauth/pkg/netauth"
+)
+
+var (
+	authPurgeHistoryCmd = &cobra.Command{
+		Use:     "purge-history <entity>",
+		Short:   "Forget the previous secrets of an entity",
+		Long:    authPurgeHistoryLongDocs,
+		Example: authPurgeHistoryExample,
+		Args:    cobra.ExactArgs(1),
+		Run:     authPurgeHistoryRun,
+	}
+
+	authPurgeHistoryLongDocs = `
+Servers may be configured to remember the recent secrets of each
+entity, and to refuse a new secret that matches any of them.  The
+purge-history command forgets the secrets that are remembered for an
+entity, so that it may choose any of them again.  The current secret
+can still not be chosen again.
+
+The caller must possess the CHANGE_ENTITY_SECRET capability or be a
+GLOBAL_ROOT operator for this command to succeed.`
+
+	authPurgeHistoryExample = `$ netauth auth purge-history demo
+Secret history purged`
+)
+
+func init() {
+	authCmd.AddCommand(authPurgeHistoryCmd)
+}
+
+func authPurgeHistoryRun(cmd *cobra.Command, args []string) {
+	ctx = netauth.Authorize(ctx, token())
+
+	if err := rpc.SecretHistoryPurge(ctx, args[0]); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("Secret history purged")
+}
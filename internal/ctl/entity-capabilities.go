@@ -0,0 +1,168 @@
+package ctl
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/netauth/netauth/pkg/capabilities"
+)
+
+var (
+	entityCapabilitiesCmd = &cobra.Command{
+		Use:     "capabilities <entity>",
+		Short:   "Show the capabilities an entity holds",
+		Long:    entityCapabilitiesLongDocs,
+		Example: entityCapabilitiesExample,
+		Args:    cobra.ExactArgs(1),
+		Run:     entityCapabilitiesRun,
+	}
+
+	entityCapabilitiesLongDocs = `
+The capabilities command shows the capabilities that an entity holds,
+which are the ones that it would be granted in a token.  Each
+capability is shown along with where it comes from: the entity
+itself, or the groups that grant it.
+
+The flags propose changes that are evaluated but not made.  When any
+are given the command shows the capabilities that the entity would
+hold after the changes, marking those that would be gained with a +
+and those that would be lost with a -.  The --join and --leave flags
+change the direct memberships of the entity.  The --include,
+--exclude, and --drop-expansion flags take a parent and child group
+separated by a colon and change the expansions of the parent.  The
+--grant and --revoke flags take a capability, which is held by the
+entity or, if it is prefixed with a group and a colon, by that group.
+`
+
+	entityCapabilitiesExample = `$ netauth entity capabilities demo2
+CREATE_ENTITY: direct
+LOCK_ENTITY: via helpdesk
+
+$ netauth entity capabilities demo2 --join admins --revoke helpdesk:LOCK_ENTITY
+  CREATE_ENTITY: direct
++ GLOBAL_ROOT: via admins
+- LOCK_ENTITY: via helpdesk
+`
+
+	entityCapabilitiesJoin    []string
+	entityCapabilitiesLeave   []string
+	entityCapabilitiesInclude []string
+	entityCapabilitiesExclude []string
+	entityCapabilitiesDrop    []string
+	entityCapabilitiesGrant   []string
+	entityCapabilitiesRevoke  []string
+)
+
+func init() {
+	entityCmd.AddCommand(entityCapabilitiesCmd)
+	entityCapabilitiesCmd.Flags().StringSliceVar(&entityCapabilitiesJoin, "join", nil, "Groups the entity would join")
+	entityCapabilitiesCmd.Flags().StringSliceVar(&entityCapabilitiesLeave, "leave", nil, "Groups the entity would leave")
+	entityCapabilitiesCmd.Flags().StringSliceVar(&entityCapabilitiesInclude, "include", nil, "Expansions that would be included, as parent:child")
+	entityCapabilitiesCmd.Flags().StringSliceVar(&entityCapabilitiesExclude, "exclude", nil, "Expansions that would be excluded, as parent:child")
+	entityCapabilitiesCmd.Flags().StringSliceVar(&entityCapabilitiesDrop, "drop-expansion", nil, "Expansions that would be dropped, as parent:child")
+	entityCapabilitiesCmd.Flags().StringSliceVar(&entityCapabilitiesGrant, "grant", nil, "Capabilities that would be granted, optionally as group:capability")
+	entityCapabilitiesCmd.Flags().StringSliceVar(&entityCapabilitiesRevoke, "revoke", nil, "Capabilities that would be revoked, optionally as group:capability")
+}
+
+func entityCapabilitiesRun(cmd *cobra.Command, args []string) {
+	changes, err := entityCapabilitiesChanges()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	res, err := rpc.EntityCapabilities(ctx, args[0], changes...)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if len(changes) == 0 {
+		for _, g := range res.Capabilities {
+			fmt.Printf("%s: %s\n", g.Capability, grantSources(g))
+		}
+		return
+	}
+
+	held := make(map[string]bool)
+	for _, g := range res.Capabilities {
+		held[g.Capability] = true
+	}
+	for _, g := range res.WhatIf {
+		mark := " "
+		if !held[g.Capability] {
+			mark = "+"
+		}
+		delete(held, g.Capability)
+		fmt.Printf("%s %s: %s\n", mark, g.Capability, grantSources(g))
+	}
+	for _, g := range res.Capabilities {
+		if held[g.Capability] {
+			fmt.Printf("- %s: %s\n", g.Capability, grantSources(g))
+		}
+	}
+}
+
+// entityCapabilitiesChanges returns the changes proposed by the
+// flags.
+func entityCapabilitiesChanges() ([]*capabilities.Change, error) {
+	var changes []*capabilities.Change
+	for _, g := range entityCapabilitiesJoin {
+		changes = append(changes, &capabilities.Change{Kind: capabilities.KindMembership, Mode: "ADD", Group: g})
+	}
+	for _, g := range entityCapabilitiesLeave {
+		changes = append(changes, &capabilities.Change{Kind: capabilities.KindMembership, Mode: "DROP", Group: g})
+	}
+
+	expansions := []struct {
+		mode  string
+		flags []string
+	}{
+		{"INCLUDE", entityCapabilitiesInclude},
+		{"EXCLUDE", entityCapabilitiesExclude},
+		{"DROP", entityCapabilitiesDrop},
+	}
+	for _, x := range expansions {
+		for _, f := range x.flags {
+			parts := strings.SplitN(f, ":", 2)
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return nil, fmt.Errorf("expansion %s must be given as parent:child", f)
+			}
+			changes = append(changes, &capabilities.Change{Kind: capabilities.KindExpansion, Mode: x.mode, Group: parts[0], Child: parts[1]})
+		}
+	}
+
+	caps := []struct {
+		mode  string
+		flags []string
+	}{
+		{"ADD", entityCapabilitiesGrant},
+		{"DROP", entityCapabilitiesRevoke},
+	}
+	for _, x := range caps {
+		for _, f := range x.flags {
+			c := &capabilities.Change{Kind: capabilities.KindCapability, Mode: x.mode, Capability: f}
+			if i := strings.LastIndex(f, ":"); i >= 0 {
+				c.Group = f[:i]
+				c.Capability = f[i+1:]
+			}
+			changes = append(changes, c)
+		}
+	}
+	return changes, nil
+}
+
+// grantSources describes where a capability comes from.
+func grantSources(g *capabilities.Grant) string {
+	var sources []string
+	if g.Direct {
+		sources = append(sources, "direct")
+	}
+	if len(g.Groups) > 0 {
+		sources = append(sources, "via "+strings.Join(g.Groups, ", "))
+	}
+	return strings.Join(sources, ", ")
+}
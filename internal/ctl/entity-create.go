@@ -13,6 +13,7 @@ var (
 	newEntityID string
 	newNumber   int
 	newSecret   string
+	newPool     string
 
 	entityCreateCmd = &cobra.Command{
 		Use:     "create <ID>",
@@ -34,13 +35,24 @@ Additional fields can be specified on the command line such as the
 number to assign or the initial secret to set.  If left blank the
 number will be chosen as the next unassigned number, and the secret
 will be prompted for.  To create an entity with an unset secret,
-specify the empty string as the initial secret.
+specify the empty string as the initial secret.  Servers that enforce
+a password policy may reject the secret, in which case the reasons
+are printed.
+
+Servers may be configured with several pools of numbers, for example
+one for people and another for service accounts.  The pool to choose
+the number from can be selected with --pool, otherwise the server's
+default pool is used.
 
 The caller must possess the CREATE_ENTITY capability or be a
 GLOBAL_ROOT operator for this command to succeed.`
 
 	entityCreateExample = `$ netauth entity create demo
 Initial Secret for demo:
+New entity created successfully
+
+$ netauth entity create backup-agent --pool service
+Initial Secret for backup-agent:
 New entity created successfully`
 )
 
@@ -48,6 +60,7 @@ func init() {
 	entityCmd.AddCommand(entityCreateCmd)
 	entityCreateCmd.Flags().IntVar(&newNumber, "number", -1, "Number to assign.")
 	entityCreateCmd.Flags().StringVar(&newSecret, "initial-secret", "", "Initial secret.")
+	entityCreateCmd.Flags().StringVar(&newPool, "pool", "", "Pool to allocate the number from.")
 }
 
 func entityCreateRun(cmd *cobra.Command, args []string) {
@@ -57,6 +70,9 @@ func entityCreateRun(cmd *cobra.Command, args []string) {
 	}
 
 	ctx = netauth.Authorize(ctx, token())
+	if newPool != "" {
+		ctx = netauth.WithNumberPool(ctx, newPool)
+	}
 
 	if err := rpc.EntityCreate(ctx, newEntityID, newSecret, newNumber); err != nil {
 		fmt.Println(err)
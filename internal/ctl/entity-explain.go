@@ -0,0 +1,84 @@
+package ctl
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/netauth/netauth/pkg/explain"
+)
+
+var (
+	entityExplainCmd = &cobra.Command{
+		Use:     "explain <entity> <group>",
+		Short:   "Explain why an entity is or isn't in a group",
+		Long:    entityExplainLongDocs,
+		Example: entityExplainExample,
+		Args:    cobra.ExactArgs(2),
+		Run:     entityExplainRun,
+	}
+
+	entityExplainLongDocs = `
+The explain command shows why an entity is or isn't a member of a
+group.  The group is printed along with how the entity came to be in
+it: as a direct member, by matching the rule of a dynamic group, or
+through an included group, which is explained in turn below it.
+Excluded groups that removed the entity are shown in the same way.
+Direct memberships that have expired are shown, but don't count.
+`
+
+	entityExplainExample = `$ netauth entity explain demo2 staff
+staff: member
+  include developers: member
+    direct
+  include contractors: not a member (expired)
+
+$ netauth entity explain demo3 staff
+staff: not a member
+  include developers: member
+    dynamic
+  exclude suspended: member
+    direct
+`
+)
+
+func init() {
+	entityCmd.AddCommand(entityExplainCmd)
+}
+
+func entityExplainRun(cmd *cobra.Command, args []string) {
+	res, err := rpc.EntityExplain(ctx, args[0], args[1])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	printExplanation(res, "", 0)
+}
+
+// printExplanation prints an explanation and the explanations of its
+// expansions indented below it.
+func printExplanation(x *explain.Explanation, how string, depth int) {
+	indent := strings.Repeat("  ", depth)
+	state := "not a member"
+	if x.Member {
+		state = "member"
+	}
+	if x.Expired {
+		state += " (expired)"
+	}
+	fmt.Printf("%s%s%s: %s\n", indent, how, x.Group, state)
+	if x.Direct {
+		fmt.Printf("%s  direct\n", indent)
+	}
+	if x.Dynamic {
+		fmt.Printf("%s  dynamic\n", indent)
+	}
+	for _, i := range x.Include {
+		printExplanation(i, "include ", depth+1)
+	}
+	for _, e := range x.Exclude {
+		printExplanation(e, "exclude ", depth+1)
+	}
+}
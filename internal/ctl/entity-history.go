@@ -0,0 +1,94 @@
+package ctl
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/netauth/netauth/pkg/netauth"
+)
+
+var (
+	entityHistoryFields   string
+	entityHistoryRollback bool
+
+	entityHistoryCmd = &cobra.Command{
+		Use:     "history <entity> [revision]",
+		Short:   "Show or roll back previous revisions of an entity",
+		Long:    entityHistoryLongDocs,
+		Example: entityHistoryExample,
+		Args:    cobra.RangeArgs(1, 2),
+		Run:     entityHistoryRun,
+	}
+
+	entityHistoryLongDocs = `
+The history command lists the previous revisions of an entity that
+the server keeps, oldest first.  The last revision listed is the
+current one unless the entity has been destroyed.  If a revision is
+given, the entity as it was at that revision is shown instead, and
+the output may be filtered with --fields in the same way as the info
+command.
+
+With --rollback the entity is restored to the given revision.  The
+secret is never rolled back.  The caller must possess the GLOBAL_ROOT
+capability for a rollback to succeed.`
+
+	entityHistoryExample = `$ netauth entity history demo
+2021-03-08T09:30:00Z  3f1a2b4c5d6e7f80
+2021-03-09T14:02:11Z  9c8b7a6d5e4f3a21
+
+$ netauth entity history demo 3f1a2b4c5d6e7f80
+ID: demo
+Number: 9
+
+$ netauth entity history --rollback demo 3f1a2b4c5d6e7f80
+Entity rolled back
+`
+)
+
+func init() {
+	entityCmd.AddCommand(entityHistoryCmd)
+	entityHistoryCmd.Flags().StringVar(&entityHistoryFields, "fields", "", "Fields to be displayed")
+	entityHistoryCmd.Flags().BoolVar(&entityHistoryRollback, "rollback", false, "Roll back to the specified revision")
+}
+
+func entityHistoryRun(cmd *cobra.Command, args []string) {
+	if entityHistoryRollback {
+		if len(args) != 2 {
+			fmt.Println("A revision is required to roll back")
+			os.Exit(1)
+		}
+
+		ctx = netauth.Authorize(ctx, token())
+		if err := rpc.EntityRollback(ctx, args[0], args[1]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println("Entity rolled back")
+		return
+	}
+
+	revs, err := rpc.EntityHistory(ctx, args[0])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if len(args) == 1 {
+		for _, r := range revs {
+			fmt.Printf("%s  %s\n", r.Time.Format(time.RFC3339), r.Revision)
+		}
+		return
+	}
+
+	for _, r := range revs {
+		if r.Revision == args[1] {
+			printEntity(r.Entity, entityHistoryFields)
+			return
+		}
+	}
+	fmt.Println("No such revision")
+	os.Exit(1)
+}
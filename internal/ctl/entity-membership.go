@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 
@@ -25,6 +26,13 @@ The membership command adds and removes groups from an entity.  These
 groups are direct memberships that are only influenced by EXCLUDE
 expansions.
 
+A membership that is added with --until expires at the given time,
+which may be a time in RFC 3339 format or a duration from now such as
+8h.  An expired membership is no longer honoured and is removed from
+the entity shortly after.  Adding an entity to a group that it is
+already in changes when the membership expires, and adding it without
+--until makes the membership permanent.
+
 The caller must posses the MODIFY_GROUP_MEMBERS capability or be a
 member of the group that is listed to manage the membership of the
 target group.`
@@ -32,12 +40,18 @@ target group.`
 	entityMembershipExample = `$ netauth entity membership demo2 add demo-group
 Membership updated successfully
 
+$ netauth entity membership demo2 add on-call --until 168h
+Membership updated successfully
+
 $ netauth entity membership demo2 drop demo-group
 Membership updated successfully`
+
+	entityMembershipUntil string
 )
 
 func init() {
 	entityCmd.AddCommand(entityMembershipCmd)
+	entityMembershipCmd.Flags().StringVar(&entityMembershipUntil, "until", "", "Time or duration after which an added membership expires")
 }
 
 func entityMembershipArgs(cmd *cobra.Command, args []string) error {
@@ -49,12 +63,23 @@ func entityMembershipArgs(cmd *cobra.Command, args []string) error {
 	if m != "ADD" && m != "DROP" {
 		return fmt.Errorf("mode must be one of ADD or DROP")
 	}
+	if entityMembershipUntil != "" && m != "ADD" {
+		return fmt.Errorf("--until can only be used to ADD a membership")
+	}
 
 	return nil
 }
 
 func entityMembershipRun(cmd *cobra.Command, args []string) {
 	ctx = netauth.Authorize(ctx, token())
+	if entityMembershipUntil != "" {
+		until, err := parseUntil(entityMembershipUntil)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		ctx = netauth.WithMembershipExpiry(ctx, until)
+	}
 
 	var err error
 	switch strings.ToUpper(args[1]) {
@@ -70,3 +95,15 @@ func entityMembershipRun(cmd *cobra.Command, args []string) {
 	}
 	fmt.Println("Membership Updated")
 }
+
+// parseUntil parses a time in RFC 3339 format or a duration from now.
+func parseUntil(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s is neither a time nor a duration", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,50 @@
+package ctl
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/netauth/netauth/pkg/netauth"
+)
+
+var (
+	entityRenameCmd = &cobra.Command{
+		Use:     "rename <ID> <new-ID>",
+		Short:   "Change the ID of an existing entity",
+		Long:    entityRenameLongDocs,
+		Example: entityRenameExample,
+		Args:    cobra.ExactArgs(2),
+		Run:     entityRenameRun,
+	}
+
+	entityRenameLongDocs = `
+Rename changes the ID of an entity.  The entity keeps its number,
+secret, memberships, and all other metadata.  The new ID must not
+already be in use.
+
+Tokens that were issued to the entity under its old ID are not
+reissued, so the entity will need to obtain a new token.
+
+The caller must possess both the CREATE_ENTITY and DESTROY_ENTITY
+capabilities or be a GLOBAL_ROOT operator for this command to
+succeed.`
+
+	entityRenameExample = `$ netauth entity rename demo demo2
+Entity renamed`
+)
+
+func init() {
+	entityCmd.AddCommand(entityRenameCmd)
+}
+
+func entityRenameRun(cmd *cobra.Command, args []string) {
+	ctx = netauth.Authorize(ctx, token())
+
+	if err := rpc.EntityRename(ctx, args[0], args[1]); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("Entity renamed")
+}
@@ -5,10 +5,16 @@ import (
 	"os"
 
 	"github.com/spf13/cobra"
+
+	"github.com/netauth/netauth/pkg/netauth"
+
+	pb "github.com/netauth/protocol"
 )
 
 var (
-	entitySearchFields string
+	entitySearchFields   string
+	entitySearchSort     []string
+	entitySearchPageSize int
 
 	entitySearchCmd = &cobra.Command{
 		Use:     "search <expression>",
@@ -29,7 +35,18 @@ only certain fields pass a comma separated list to the --fields
 argument of the field names you wish to display.
 
 Some fields on entities are part of the metadata, to address these
-fields in a search prefix them with 'meta.' as in 'meta.DisplayName'.`
+fields in a search prefix them with 'meta.' as in 'meta.DisplayName'.
+KV keys and untyped metadata keys that the server has been configured
+to index can be searched as 'kv.<key>' and 'untyped.<key>'.
+
+Entities can be filtered by the groups they are effectively members
+of with 'memberOf:<group>'.  Clauses may be joined with AND, OR, and
+NOT, as in 'kv.department:eng AND NOT memberOf:contractors'.
+
+Results are fetched from the server a page at a time and printed as
+they arrive.  By default the best matches are shown first, to order the
+results by a field pass it to --sort, prefixed with '-' to reverse the
+order.  Entities can be ordered by ID with '_id'.`
 
 	entitySearchExample = `$ netauth entity search 'ID:demo*'
 ID: demo2
@@ -45,27 +62,46 @@ $ netauth entity search 'meta.Shell: /bin/bash'
 ID: demo3
 Number: 10
 shell: /bin/bash
+
+$ netauth entity search 'memberOf:admins AND meta.Shell:zsh' --fields ID
+ID: demo4
+
+$ netauth entity search 'ID:demo*' --sort -Number --fields ID
+ID: demo4
+---
+ID: demo3
+---
+ID: demo2
 `
 )
 
 func init() {
 	entityCmd.AddCommand(entitySearchCmd)
 	entitySearchCmd.Flags().StringVar(&entitySearchFields, "fields", "", "Fields to be displayed")
+	entitySearchCmd.Flags().StringSliceVar(&entitySearchSort, "sort", nil, "Fields to order results by")
+	entitySearchCmd.Flags().IntVar(&entitySearchPageSize, "page-size", netauth.DefaultSearchPageSize, "Results to fetch at a time")
 }
 
 func entitySearchRun(cmd *cobra.Command, args []string) {
-	// Obtain entity info
-	res, err := rpc.EntitySearch(ctx, args[0])
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	opts := netauth.SearchOptions{
+		PageSize: entitySearchPageSize,
+		Sort:     entitySearchSort,
 	}
 
-	// Print the fields
-	for i, e := range res {
-		printEntity(e, entitySearchFields)
-		if i < len(res)-1 {
-			fmt.Println("---")
+	// Print the fields of each page as it arrives
+	first := true
+	err := rpc.EntitySearchPages(ctx, args[0], opts, func(page []*pb.Entity) error {
+		for _, e := range page {
+			if !first {
+				fmt.Println("---")
+			}
+			printEntity(e, entitySearchFields)
+			first = false
 		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
 }
@@ -14,6 +14,8 @@ var (
 	newGroupNumber      int
 	newGroupDisplayName string
 	newGroupManagedBy   string
+	newGroupPool        string
+	newGroupRule        string
 
 	groupCreateCmd = &cobra.Command{
 		Use:     "create <name>",
@@ -35,11 +37,24 @@ Additional fields can be specified on the command line such as the
 display name, or a group to defer management capability to.  If
 desired a custom number can be provided, but the default behavior is
 sufficient to select a valid unallocated number for the new group.
+If the server has more than one pool of group numbers, --pool selects
+the pool that the number is chosen from.
+
+A group created with --rule is a dynamic group, and its members are
+the entities that match the rule in addition to any direct members.
+The rule is a list of clauses of the form field:value that must all
+match, named in the same way as for a search, and a clause can be
+negated with a leading '-'.  The rule is kept in the KV data of the
+group under the key netauth.rule, and can be changed or removed later
+with the group kv commands.
 
 The caller must possess the CREATE_GROUP capability or be a GLOBAL_ROOT
 operator for this command to succeed.`
 
 	groupCreateExample = `$ netauth group create demo-group
+New group created successfully
+
+$ netauth group create berlin --rule 'kv.location:berlin -meta.Locked:true'
 New group created successfully`
 )
 
@@ -48,16 +63,27 @@ func init() {
 	groupCreateCmd.Flags().IntVar(&newGroupNumber, "number", -1, "Number to assign.")
 	groupCreateCmd.Flags().StringVar(&newGroupDisplayName, "display-name", "", "Group display name")
 	groupCreateCmd.Flags().StringVar(&newGroupManagedBy, "managed-by", "", "Delegate management to this group")
+	groupCreateCmd.Flags().StringVar(&newGroupPool, "pool", "", "Pool to allocate the number from")
+	groupCreateCmd.Flags().StringVar(&newGroupRule, "rule", "", "Rule that selects the members of a dynamic group")
 }
 
 func groupCreateRun(cmd *cobra.Command, args []string) {
 	newGroupName = args[0]
 
 	ctx = netauth.Authorize(ctx, token())
+	if newGroupPool != "" {
+		ctx = netauth.WithNumberPool(ctx, newGroupPool)
+	}
 
 	if err := rpc.GroupCreate(ctx, newGroupName, newGroupDisplayName, newGroupManagedBy, newGroupNumber); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	if newGroupRule != "" {
+		if err := rpc.GroupKVAdd(ctx, newGroupName, netauth.GroupRuleKey, []string{newGroupRule}); err != nil {
+			fmt.Println("Group created, but the rule could not be set:", err)
+			os.Exit(1)
+		}
+	}
 	fmt.Println("Group Created")
 }
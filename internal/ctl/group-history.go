@@ -0,0 +1,94 @@
+package ctl
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/netauth/netauth/pkg/netauth"
+)
+
+var (
+	groupHistoryFields   string
+	groupHistoryRollback bool
+
+	groupHistoryCmd = &cobra.Command{
+		Use:     "history <group> [revision]",
+		Short:   "Show or roll back previous revisions of a group",
+		Long:    groupHistoryLongDocs,
+		Example: groupHistoryExample,
+		Args:    cobra.RangeArgs(1, 2),
+		Run:     groupHistoryRun,
+	}
+
+	groupHistoryLongDocs = `
+The history command lists the previous revisions of a group that
+the server keeps, oldest first.  The last revision listed is the
+current one unless the group has been destroyed.  If a revision is
+given, the group as it was at that revision is shown instead, and
+the output may be filtered with --fields in the same way as the info
+command.
+
+With --rollback the group is restored to the given revision.  The
+caller must possess the GLOBAL_ROOT capability for a rollback to
+succeed.`
+
+	groupHistoryExample = `$ netauth group history demo-group
+2021-03-08T09:30:00Z  3f1a2b4c5d6e7f80
+2021-03-09T14:02:11Z  9c8b7a6d5e4f3a21
+
+$ netauth group history demo-group 3f1a2b4c5d6e7f80
+Name: demo-group
+Number: 10
+
+$ netauth group history --rollback demo-group 3f1a2b4c5d6e7f80
+Group rolled back
+`
+)
+
+func init() {
+	groupCmd.AddCommand(groupHistoryCmd)
+	groupHistoryCmd.Flags().StringVar(&groupHistoryFields, "fields", "", "Fields to be displayed")
+	groupHistoryCmd.Flags().BoolVar(&groupHistoryRollback, "rollback", false, "Roll back to the specified revision")
+}
+
+func groupHistoryRun(cmd *cobra.Command, args []string) {
+	if groupHistoryRollback {
+		if len(args) != 2 {
+			fmt.Println("A revision is required to roll back")
+			os.Exit(1)
+		}
+
+		ctx = netauth.Authorize(ctx, token())
+		if err := rpc.GroupRollback(ctx, args[0], args[1]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println("Group rolled back")
+		return
+	}
+
+	revs, err := rpc.GroupHistory(ctx, args[0])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if len(args) == 1 {
+		for _, r := range revs {
+			fmt.Printf("%s  %s\n", r.Time.Format(time.RFC3339), r.Revision)
+		}
+		return
+	}
+
+	for _, r := range revs {
+		if r.Revision == args[1] {
+			printGroup(r.Group, groupHistoryFields)
+			return
+		}
+	}
+	fmt.Println("No such revision")
+	os.Exit(1)
+}
@@ -0,0 +1,50 @@
+package ctl
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/netauth/netauth/pkg/netauth"
+)
+
+var (
+	groupRenameCmd = &cobra.Command{
+		Use:     "rename <group> <new-name>",
+		Short:   "Change the name of an existing group",
+		Long:    groupRenameLongDocs,
+		Example: groupRenameExample,
+		Args:    cobra.ExactArgs(2),
+		Run:     groupRenameRun,
+	}
+
+	groupRenameLongDocs = `
+Rename changes the name of a group.  The group keeps its number and
+all other metadata, and every reference to the group is updated in
+the same change: entities that are direct members of the group or
+have it as their primary group, expansions in other groups, and
+groups that are managed by it.  The new name must not already be in
+use.
+
+The caller must possess both the CREATE_GROUP and DESTROY_GROUP
+capabilities or be a GLOBAL_ROOT operator for this command to
+succeed.`
+
+	groupRenameExample = `$ netauth group rename demo-group demo-team
+Group renamed`
+)
+
+func init() {
+	groupCmd.AddCommand(groupRenameCmd)
+}
+
+func groupRenameRun(cmd *cobra.Command, args []string) {
+	ctx = netauth.Authorize(ctx, token())
+
+	if err := rpc.GroupRename(ctx, args[0], args[1]); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("Group renamed")
+}
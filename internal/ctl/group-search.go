@@ -5,10 +5,16 @@ import (
 	"os"
 
 	"github.com/spf13/cobra"
+
+	"github.com/netauth/netauth/pkg/netauth"
+
+	pb "github.com/netauth/protocol"
 )
 
 var (
-	groupSearchFields string
+	groupSearchFields   string
+	groupSearchSort     []string
+	groupSearchPageSize int
 
 	groupSearchCmd = &cobra.Command{
 		Use:     "search <expression>",
@@ -26,7 +32,16 @@ sure to quote the expression if making a complex query.
 
 All set fields on returned groups will be displayed.  To display
 only certain fields pass a comma separated list to the --fields
-argument of the field names you wish to display.`
+argument of the field names you wish to display.
+
+KV keys and untyped metadata keys that the server has been configured
+to index can be searched as 'kv.<key>' and 'untyped.<key>', and
+clauses may be joined with AND, OR, and NOT.
+
+Results are fetched from the server a page at a time and printed as
+they arrive.  By default the best matches are shown first, to order the
+results by a field pass it to --sort, prefixed with '-' to reverse the
+order.  Groups can be ordered by name with '_id'.`
 
 	groupSearchExample = `$ netauth group search 'Name:example*'
 Name: example-group
@@ -43,20 +58,30 @@ Number: 11
 func init() {
 	groupCmd.AddCommand(groupSearchCmd)
 	groupSearchCmd.Flags().StringVar(&groupSearchFields, "fields", "", "Fields to be displayed")
+	groupSearchCmd.Flags().StringSliceVar(&groupSearchSort, "sort", nil, "Fields to order results by")
+	groupSearchCmd.Flags().IntVar(&groupSearchPageSize, "page-size", netauth.DefaultSearchPageSize, "Results to fetch at a time")
 }
 
 func groupSearchRun(cmd *cobra.Command, args []string) {
-	res, err := rpc.GroupSearch(ctx, args[0])
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	opts := netauth.SearchOptions{
+		PageSize: groupSearchPageSize,
+		Sort:     groupSearchSort,
 	}
 
-	// Print the fields
-	for i, g := range res {
-		printGroup(g, groupSearchFields)
-		if i < len(res)-1 {
-			fmt.Println("---")
+	// Print the fields of each page as it arrives
+	first := true
+	err := rpc.GroupSearchPages(ctx, args[0], opts, func(page []*pb.Group) error {
+		for _, g := range page {
+			if !first {
+				fmt.Println("---")
+			}
+			printGroup(g, groupSearchFields)
+			first = false
 		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,111 @@
+package ctl
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/netauth/netauth/pkg/audit"
+	"github.com/netauth/netauth/pkg/netauth"
+)
+
+var (
+	systemAuditEntity string
+	systemAuditActor  string
+	systemAuditSince  string
+	systemAuditUntil  string
+	systemAuditLimit  int
+
+	systemAuditCmd = &cobra.Command{
+		Use:     "audit",
+		Short:   "Query the audit log",
+		Long:    systemAuditLongDocs,
+		Example: systemAuditExample,
+		Args:    cobra.NoArgs,
+		Run:     systemAuditRun,
+	}
+
+	systemAuditLongDocs = `
+The audit command queries the record that the server keeps of every
+change made to entities and groups.  Each record shows when the change
+was made, who made it, the chain that carried it out, and the fields
+that were changed.  Secrets are never shown.
+
+Records may be filtered by the entity or group that was changed with
+--entity, and by the entity that made the change with --actor.  The
+--since and --until flags accept either a time in RFC3339 format or a
+duration such as 24h, which is taken to mean that long ago.
+
+The server must be configured with an audit sink that can be queried,
+and the caller must possess the GLOBAL_ROOT capability for this
+command to succeed.`
+
+	systemAuditExample = `$ netauth system audit --entity demo --since 24h
+2021-03-08T09:30:00Z  admin  LOCK  entity demo
+    meta.Locked: "" -> "true"
+`
+)
+
+func init() {
+	systemCmd.AddCommand(systemAuditCmd)
+	systemAuditCmd.Flags().StringVar(&systemAuditEntity, "entity", "", "Entity or group that was changed")
+	systemAuditCmd.Flags().StringVar(&systemAuditActor, "actor", "", "Entity that made the change")
+	systemAuditCmd.Flags().StringVar(&systemAuditSince, "since", "", "Only show changes after this time")
+	systemAuditCmd.Flags().StringVar(&systemAuditUntil, "until", "", "Only show changes before this time")
+	systemAuditCmd.Flags().IntVar(&systemAuditLimit, "limit", 0, "Show at most this many of the most recent changes")
+}
+
+func systemAuditRun(cmd *cobra.Command, args []string) {
+	ctx = netauth.Authorize(ctx, token())
+
+	q := &audit.Query{
+		Target: systemAuditEntity,
+		Actor:  systemAuditActor,
+		Limit:  systemAuditLimit,
+	}
+	var err error
+	if q.Since, err = parseAuditTime(systemAuditSince); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if q.Until, err = parseAuditTime(systemAuditUntil); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	res, err := rpc.AuditQuery(ctx, q)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	for _, r := range res {
+		actor := r.Actor.Entity
+		if actor == "" {
+			actor = "<server>"
+		}
+		fmt.Printf("%s  %s  %s  %s %s\n", r.Time.Format(time.RFC3339), actor, r.Chain, r.Kind, r.Target)
+		for _, c := range r.Changes {
+			fmt.Printf("    %s: %q -> %q\n", c.Field, c.Old, c.New)
+		}
+	}
+}
+
+// parseAuditTime accepts either an absolute time or a duration which
+// is subtracted from the current time.
+func parseAuditTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(strings.TrimPrefix(s, "-")); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s is neither an RFC3339 time nor a duration", s)
+	}
+	return t, nil
+}
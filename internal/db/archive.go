@@ -0,0 +1,322 @@
+package db
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	types "github.com/netauth/protocol"
+)
+
+// ArchiveVersion is the version of the archive format written by
+// Snapshot.  Archives with a greater version cannot be read.  Version
+// 2 added the secret history of each entity.
+const ArchiveVersion = 2
+
+// SecretHistoryBucket is the bucket that the previous secrets of each
+// entity are kept in, under the ID of the entity.  It is archived
+// with the entities, since the history can't be rebuilt from them.
+const SecretHistoryBucket = "secrethistory"
+
+// archivePatterns are the keys that are kept in an archive.
+var archivePatterns = []string{"/entities/*", "/groups/*", "/" + SecretHistoryBucket + "/*"}
+
+// ArchiveMeta describes where and when an archive was taken.  Seq is
+// the head of the change log at the time of the snapshot, and the
+// Checksum covers every record in the archive.
+type ArchiveMeta struct {
+	Version  int
+	Backend  string
+	Time     time.Time
+	Seq      uint64
+	Entities int
+	Groups   int
+	Secrets  int
+	Checksum string
+}
+
+// ArchiveRecord is a single entity, group, or secret history in an
+// archive, stored exactly as it was in the KVStore.
+type ArchiveRecord struct {
+	Key   string
+	Value []byte
+}
+
+// An Archive is a point in time copy of every entity and group in a
+// database, along with the secret history of each entity.  Records
+// are sorted by key.
+type Archive struct {
+	Meta    ArchiveMeta
+	Records []ArchiveRecord
+}
+
+// Snapshot returns an archive of all entities and groups.  No
+// transaction may commit while the snapshot is being taken, so the
+// archive is consistent with respect to all changes made through the
+// tree.
+func (db *DB) Snapshot() (*Archive, error) {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	a := &Archive{Meta: ArchiveMeta{Version: ArchiveVersion, Backend: db.backend, Time: time.Now()}}
+
+	seq, err := db.ChangeLogHead()
+	if err != nil {
+		return nil, err
+	}
+	a.Meta.Seq = seq
+
+	for _, pattern := range archivePatterns {
+		keys, err := db.kv.Keys(pattern)
+		if err != nil {
+			db.log.Warn("Error listing keys for snapshot", "pattern", pattern, "error", err)
+			return nil, ErrInternalError
+		}
+		for _, k := range keys {
+			b, err := db.kv.Get(k)
+			switch err {
+			case nil:
+			case ErrNoValue:
+				continue
+			default:
+				db.log.Warn("Error reading key for snapshot", "key", k, "error", err)
+				return nil, ErrInternalError
+			}
+			a.Records = append(a.Records, ArchiveRecord{Key: k, Value: b})
+		}
+	}
+	a.seal()
+	return a, nil
+}
+
+// seal sorts the records and fills in the counts and checksum.
+func (a *Archive) seal() {
+	sort.Slice(a.Records, func(i, j int) bool { return a.Records[i].Key < a.Records[j].Key })
+	a.Meta.Entities, a.Meta.Groups, a.Meta.Secrets = a.count()
+	a.Meta.Checksum = a.checksum()
+}
+
+func (a *Archive) count() (int, int, int) {
+	entities, groups, secrets := 0, 0, 0
+	for _, r := range a.Records {
+		switch {
+		case strings.HasPrefix(r.Key, "/entities/"):
+			entities++
+		case strings.HasPrefix(r.Key, "/groups/"):
+			groups++
+		case strings.HasPrefix(r.Key, "/"+SecretHistoryBucket+"/"):
+			secrets++
+		}
+	}
+	return entities, groups, secrets
+}
+
+// checksum hashes every record, with the lengths included so that
+// moving bytes between a key and its value changes the sum.
+func (a *Archive) checksum() string {
+	h := sha256.New()
+	for _, r := range a.Records {
+		fmt.Fprintf(h, "%d:%s:%d:", len(r.Key), r.Key, len(r.Value))
+		h.Write(r.Value)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Verify checks that the archive is intact and that every record in
+// it can be loaded.
+func (a *Archive) Verify() error {
+	if a.Meta.Version < 1 || a.Meta.Version > ArchiveVersion {
+		return ErrArchiveVersion
+	}
+	if a.checksum() != a.Meta.Checksum {
+		return ErrArchiveChecksum
+	}
+	e, g, h := a.count()
+	if e != a.Meta.Entities || g != a.Meta.Groups || h != a.Meta.Secrets {
+		return ErrArchiveChecksum
+	}
+
+	entities := make(map[string]struct{}, e)
+	for _, r := range a.Records {
+		if path.Dir(r.Key) == "/entities" {
+			entities[path.Base(r.Key)] = struct{}{}
+		}
+	}
+
+	for _, r := range a.Records {
+		var name string
+		switch path.Dir(r.Key) {
+		case "/entities":
+			e := &types.Entity{}
+			if err := proto.Unmarshal(r.Value, e); err != nil {
+				return fmt.Errorf("%s: %v", r.Key, err)
+			}
+			name = e.GetID()
+		case "/groups":
+			g := &types.Group{}
+			if err := proto.Unmarshal(r.Value, g); err != nil {
+				return fmt.Errorf("%s: %v", r.Key, err)
+			}
+			name = g.GetName()
+		case "/" + SecretHistoryBucket:
+			if a.Meta.Version < 2 {
+				return fmt.Errorf("%s: not an entity or group", r.Key)
+			}
+			var hist []string
+			if err := json.Unmarshal(r.Value, &hist); err != nil {
+				return fmt.Errorf("%s: %v", r.Key, err)
+			}
+			if _, ok := entities[path.Base(r.Key)]; !ok {
+				return fmt.Errorf("%s: entity is not in the archive", r.Key)
+			}
+			continue
+		default:
+			return fmt.Errorf("%s: not an entity or group", r.Key)
+		}
+		if name != path.Base(r.Key) {
+			return fmt.Errorf("%s: record is for %q", r.Key, name)
+		}
+	}
+	return nil
+}
+
+// WriteTo writes the archive in its compressed form.
+func (a *Archive) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	zw := gzip.NewWriter(cw)
+	if err := json.NewEncoder(zw).Encode(a); err != nil {
+		return cw.n, err
+	}
+	if err := zw.Close(); err != nil {
+		return cw.n, err
+	}
+	return cw.n, nil
+}
+
+// ReadArchive reads an archive written by WriteTo and verifies it.
+func ReadArchive(r io.Reader) (*Archive, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	a := &Archive{}
+	if err := json.NewDecoder(zr).Decode(a); err != nil {
+		return nil, err
+	}
+	if err := a.Verify(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// RestoreResult counts the changes that restoring an archive makes,
+// or would make, to a KVStore.
+type RestoreResult struct {
+	Created   int
+	Updated   int
+	Unchanged int
+	Removed   int
+}
+
+// Restore writes the records in an archive to a KVStore.  If
+// truncate is set, entities, groups, and secret histories in the
+// store that are not in the archive are removed.  Secret histories
+// are left alone when restoring an archive from before they were
+// kept.  If dryRun is set nothing is written, but
+// the result still describes what would have changed.  After writing,
+// every record is read back and compared to the archive, and the
+// number pools are reset so that they are rebuilt from the restored
+// records.
+func (a *Archive) Restore(kv KVStore, truncate, dryRun bool) (RestoreResult, error) {
+	res := RestoreResult{}
+	want := make(map[string]struct{}, len(a.Records))
+
+	for _, r := range a.Records {
+		want[r.Key] = struct{}{}
+		b, err := kv.Get(r.Key)
+		switch {
+		case err == ErrNoValue:
+			res.Created++
+		case err != nil:
+			return res, err
+		case bytes.Equal(b, r.Value):
+			res.Unchanged++
+			continue
+		default:
+			res.Updated++
+		}
+		if dryRun {
+			continue
+		}
+		if err := kv.Put(r.Key, r.Value); err != nil {
+			return res, err
+		}
+	}
+
+	if truncate {
+		patterns := archivePatterns
+		if a.Meta.Version < 2 {
+			// Older archives have no secret history to
+			// compare with.
+			patterns = patterns[:2]
+		}
+		for _, pattern := range patterns {
+			keys, err := kv.Keys(pattern)
+			if err != nil {
+				return res, err
+			}
+			for _, k := range keys {
+				if _, ok := want[k]; ok {
+					continue
+				}
+				res.Removed++
+				if dryRun {
+					continue
+				}
+				if err := kv.Del(k); err != nil && err != ErrNoValue {
+					return res, err
+				}
+			}
+		}
+	}
+
+	if dryRun {
+		return res, nil
+	}
+	if err := ResetNumberPools(kv); err != nil {
+		return res, err
+	}
+	for _, r := range a.Records {
+		b, err := kv.Get(r.Key)
+		if err != nil {
+			return res, fmt.Errorf("%s: %v", r.Key, err)
+		}
+		if !bytes.Equal(b, r.Value) {
+			return res, fmt.Errorf("%s: %v", r.Key, ErrArchiveChecksum)
+		}
+	}
+	return res, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+
+	types "github.com/netauth/protocol"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	RegisterKV("map", newMapKV)
+	m, err := New("map")
+	assert.Nil(t, err)
+
+	assert.Nil(t, m.SaveEntity(&types.Entity{ID: proto.String("entity1"), Number: proto.Int32(1)}))
+	assert.Nil(t, m.SaveEntity(&types.Entity{ID: proto.String("entity2"), Number: proto.Int32(2)}))
+	assert.Nil(t, m.SaveGroup(&types.Group{Name: proto.String("group1"), Number: proto.Int32(1)}))
+	assert.Nil(t, m.Bucket(SecretHistoryBucket).Put("entity1", []byte(`["old"]`)))
+
+	a, err := m.Snapshot()
+	assert.Nil(t, err)
+	assert.Equal(t, ArchiveVersion, a.Meta.Version)
+	assert.Equal(t, "map", a.Meta.Backend)
+	assert.Equal(t, uint64(3), a.Meta.Seq)
+	assert.Equal(t, 2, a.Meta.Entities)
+	assert.Equal(t, 1, a.Meta.Groups)
+	assert.Equal(t, 1, a.Meta.Secrets)
+
+	var buf bytes.Buffer
+	_, err = a.WriteTo(&buf)
+	assert.Nil(t, err)
+
+	b, err := ReadArchive(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, a.Meta.Checksum, b.Meta.Checksum)
+	assert.Len(t, b.Records, 4)
+
+	// A dry run reports the changes without making them.
+	kv, _ := newMapKV(nil)
+	kv.Put("/entities/stale", []byte{})
+	kv.Put("/secrethistory/stale", []byte{})
+	res, err := b.Restore(kv, true, true)
+	assert.Nil(t, err)
+	assert.Equal(t, RestoreResult{Created: 4, Removed: 2}, res)
+	keys, _ := kv.Keys("/*/*")
+	assert.Len(t, keys, 2)
+
+	res, err = b.Restore(kv, true, false)
+	assert.Nil(t, err)
+	assert.Equal(t, RestoreResult{Created: 4, Removed: 2}, res)
+	keys, _ = kv.Keys("/*/*")
+	assert.Len(t, keys, 4)
+
+	res, err = b.Restore(kv, false, false)
+	assert.Nil(t, err)
+	assert.Equal(t, RestoreResult{Unchanged: 4}, res)
+}
+
+func TestArchiveRestoreVersion1(t *testing.T) {
+	e, _ := proto.Marshal(&types.Entity{ID: proto.String("entity1")})
+	a := &Archive{Meta: ArchiveMeta{Version: 1}, Records: []ArchiveRecord{{"/entities/entity1", e}}}
+	a.seal()
+	assert.Nil(t, a.Verify())
+
+	// An archive from before secret histories were kept leaves
+	// them alone when truncating.
+	kv, _ := newMapKV(nil)
+	kv.Put("/secrethistory/entity1", []byte(`["old"]`))
+	res, err := a.Restore(kv, true, false)
+	assert.Nil(t, err)
+	assert.Equal(t, RestoreResult{Created: 1}, res)
+	_, err = kv.Get("/secrethistory/entity1")
+	assert.Nil(t, err)
+}
+
+func TestArchiveVerify(t *testing.T) {
+	e, _ := proto.Marshal(&types.Entity{ID: proto.String("entity1")})
+	g, _ := proto.Marshal(&types.Group{Name: proto.String("group1")})
+
+	cases := []struct {
+		records []ArchiveRecord
+		tamper  func(*Archive)
+		wantErr bool
+	}{
+		{[]ArchiveRecord{{"/entities/entity1", e}, {"/groups/group1", g}}, func(*Archive) {}, false},
+		{[]ArchiveRecord{{"/entities/entity1", e}}, func(a *Archive) { a.Records[0].Value = g }, true},
+		{[]ArchiveRecord{{"/entities/entity1", e}}, func(a *Archive) { a.Meta.Version = ArchiveVersion + 1 }, true},
+		{[]ArchiveRecord{{"/entities/entity1", e}}, func(a *Archive) { a.Meta.Entities = 2 }, true},
+		{[]ArchiveRecord{{"/entities/entity2", e}}, func(*Archive) {}, true},
+		{[]ArchiveRecord{{"/entities/entity1", []byte("garbage")}}, func(*Archive) {}, true},
+		{[]ArchiveRecord{{"/changelog/1", e}}, func(*Archive) {}, true},
+		{[]ArchiveRecord{{"/entities/entity1", e}, {"/secrethistory/entity1", []byte(`["old"]`)}}, func(*Archive) {}, false},
+		{[]ArchiveRecord{{"/entities/entity1", e}, {"/secrethistory/entity1", []byte("garbage")}}, func(*Archive) {}, true},
+		{[]ArchiveRecord{{"/secrethistory/entity1", []byte(`["old"]`)}}, func(*Archive) {}, true},
+		{[]ArchiveRecord{{"/entities/entity1", e}, {"/secrethistory/entity1", []byte(`["old"]`)}}, func(a *Archive) { a.Meta.Secrets = 0 }, true},
+	}
+
+	for i, c := range cases {
+		a := &Archive{Meta: ArchiveMeta{Version: ArchiveVersion}, Records: c.records}
+		a.seal()
+		c.tamper(a)
+		err := a.Verify()
+		assert.Equalf(t, c.wantErr, err != nil, "Test Number %d: %v", i, err)
+	}
+}
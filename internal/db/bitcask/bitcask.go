@@ -3,9 +3,11 @@
 package bitcask
 
 import (
+	"bytes"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/prologic/bitcask"
@@ -29,6 +31,10 @@ type BCStore struct {
 	s *bitcask.Bitcask
 	l hclog.Logger
 
+	// mu serializes writes so that a compare and swap can't
+	// interleave with another mutation.
+	mu sync.Mutex
+
 	eF func(db.Event)
 }
 
@@ -41,6 +47,11 @@ const (
 	eventDelete
 )
 
+// journalKey is where a transaction is stored before it is applied.
+// It intentionally lacks a leading slash so that it can never be
+// matched as part of the entity or group keyspace.
+const journalKey = "_journal"
+
 // New creates a new instance of the bitcask store.
 func New(l hclog.Logger) (db.KVStore, error) {
 	p := filepath.Join(viper.GetString("core.home"), "bc")
@@ -57,13 +68,20 @@ func New(l hclog.Logger) (db.KVStore, error) {
 		return nil, err
 	}
 	x.s = b
+
+	if err := x.recoverJournal(); err != nil {
+		return nil, err
+	}
 	return x, nil
 }
 
 // Put stores the bytes of v at a location identitified by the key k.
 // If the operation fails an error will be returned explaining why.
 func (bcs *BCStore) Put(k string, v []byte) error {
-	if err := bcs.s.Put([]byte(k), v); err != nil {
+	bcs.mu.Lock()
+	err := bcs.s.Put([]byte(k), v)
+	bcs.mu.Unlock()
+	if err != nil {
 		return err
 	}
 	bcs.fireEventForKey(k, eventUpdate)
@@ -89,11 +107,41 @@ func (bcs *BCStore) Del(k string) error {
 	// fail, up to and including nuking the entire data directory.
 	// If you can write a way to check this error and its
 	// associated test, open a PR.
+	bcs.mu.Lock()
 	bcs.s.Delete([]byte(k))
+	bcs.mu.Unlock()
 	bcs.fireEventForKey(k, eventDelete)
 	return nil
 }
 
+// CompareAndSwap replaces the value at k with new only if the current
+// value is old.
+func (bcs *BCStore) CompareAndSwap(k string, old, new []byte) error {
+	bcs.mu.Lock()
+	cur, err := bcs.s.Get([]byte(k))
+	exists := err == nil
+	if exists != (old != nil) || !bytes.Equal(cur, old) {
+		bcs.mu.Unlock()
+		return db.ErrConflict
+	}
+
+	t := eventUpdate
+	if new == nil {
+		t = eventDelete
+		bcs.s.Delete([]byte(k))
+		err = nil
+	} else {
+		err = bcs.s.Put([]byte(k), new)
+	}
+	bcs.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	bcs.fireEventForKey(k, t)
+	return nil
+}
+
 // Keys is a way to enumerate the keys in the key/value store and to
 // optionally filter them based on a globbing expression.  This cheats
 // and uses superior knowledge that NetAuth uses only a single key
@@ -120,8 +168,130 @@ func (bcs *BCStore) Close() error {
 
 // Capabilities returns that this key/value store supports te mutable
 // property, allowing it to be writeable to the higher level systems.
+// Transactions are supported via a journal stored in the cask.
 func (bcs *BCStore) Capabilities() []db.KVCapability {
-	return []db.KVCapability{db.KVMutable}
+	return []db.KVCapability{db.KVMutable, db.KVTransactional, db.KVCompareAndSwap}
+}
+
+// Begin returns a new transaction.  Bitcask has no native notion of
+// a transaction, so the batch is written to a journal key first and
+// then applied.  An interrupted transaction is replayed when the
+// cask is next opened.
+func (bcs *BCStore) Begin() (db.KVTxn, error) {
+	return &txn{bcs: bcs}, nil
+}
+
+// txn buffers mutations until Commit is called.
+type txn struct {
+	bcs    *BCStore
+	ops    []db.TxnOp
+	closed bool
+}
+
+// Put stages a value to be stored on commit.
+func (t *txn) Put(k string, v []byte) error {
+	if t.closed {
+		return db.ErrTxnClosed
+	}
+	t.ops = append(t.ops, db.TxnOp{Key: k, Value: v})
+	return nil
+}
+
+// Del stages a key to be removed on commit.
+func (t *txn) Del(k string) error {
+	if t.closed {
+		return db.ErrTxnClosed
+	}
+	t.ops = append(t.ops, db.TxnOp{Key: k, Delete: true})
+	return nil
+}
+
+// Commit writes the journal, applies it, removes it, and then fires
+// events for all the keys that were changed.
+func (t *txn) Commit() error {
+	if t.closed {
+		return db.ErrTxnClosed
+	}
+	t.closed = true
+
+	j, err := db.EncodeJournal(t.ops)
+	if err != nil {
+		return err
+	}
+	if err := t.bcs.commitJournal(j, t.ops); err != nil {
+		return err
+	}
+
+	for _, op := range t.ops {
+		if op.Delete {
+			t.bcs.fireEventForKey(op.Key, eventDelete)
+			continue
+		}
+		t.bcs.fireEventForKey(op.Key, eventUpdate)
+	}
+	return nil
+}
+
+// Abort discards the transaction.
+func (t *txn) Abort() error {
+	if t.closed {
+		return db.ErrTxnClosed
+	}
+	t.closed = true
+	t.ops = nil
+	return nil
+}
+
+// commitJournal writes down the journal, applies it, and then
+// removes it.
+func (bcs *BCStore) commitJournal(j []byte, ops []db.TxnOp) error {
+	bcs.mu.Lock()
+	defer bcs.mu.Unlock()
+
+	if err := bcs.s.Put([]byte(journalKey), j); err != nil {
+		return err
+	}
+	if err := bcs.applyJournal(ops); err != nil {
+		return err
+	}
+	return bcs.s.Delete([]byte(journalKey))
+}
+
+// applyJournal applies the operations in a journal to the cask.
+// Applying the same journal twice has the same effect as applying it
+// once.
+func (bcs *BCStore) applyJournal(ops []db.TxnOp) error {
+	for _, op := range ops {
+		if op.Delete {
+			bcs.s.Delete([]byte(op.Key))
+			continue
+		}
+		if err := bcs.s.Put([]byte(op.Key), op.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recoverJournal completes any transaction that was interrupted
+// while it was being applied.
+func (bcs *BCStore) recoverJournal() error {
+	b, err := bcs.s.Get([]byte(journalKey))
+	if err != nil {
+		// No journal, nothing to recover.
+		return nil
+	}
+
+	ops, err := db.DecodeJournal(b)
+	if err != nil {
+		bcs.l.Warn("Discarding incomplete transaction journal", "error", err)
+		return bcs.s.Delete([]byte(journalKey))
+	}
+	bcs.l.Info("Recovering interrupted transaction", "mutations", len(ops))
+	if err := bcs.applyJournal(ops); err != nil {
+		return err
+	}
+	return bcs.s.Delete([]byte(journalKey))
 }
 
 // fireEventForKey maps from a key to an entity or group and fires an
@@ -149,7 +319,9 @@ func (bcs *BCStore) fireEventForKey(k string, t eventType) {
 			Type: db.EventGroupDestroy,
 		})
 	default:
-		bcs.l.Warn("Event translation called with unknown key prefix", "type", t, "key", k)
+		// Keys outside of entities and groups, such as the
+		// change log, do not fire events.
+		bcs.l.Trace("No event for key", "type", t, "key", k)
 	}
 }
 
@@ -131,7 +131,79 @@ func TestCapabilities(t *testing.T) {
 	assert.Nil(t, err)
 	kv.SetEventFunc(func(db.Event) {})
 
-	assert.Equal(t, []db.KVCapability{db.KVMutable}, kv.Capabilities())
+	assert.Equal(t, []db.KVCapability{db.KVMutable, db.KVTransactional, db.KVCompareAndSwap}, kv.Capabilities())
+}
+
+func TestTxnCommit(t *testing.T) {
+	viper.Set("core.home", t.TempDir())
+	kv, err := New(hclog.NewNullLogger())
+	assert.Nil(t, err)
+	events := []db.Event{}
+	kv.SetEventFunc(func(e db.Event) { events = append(events, e) })
+
+	assert.Nil(t, kv.Put("/groups/group1", []byte("old data")))
+	events = nil
+
+	txn, err := kv.(db.KVTransactor).Begin()
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Put("/entities/entity1", []byte("some data")))
+	assert.Nil(t, txn.Del("/groups/group1"))
+
+	_, err = kv.Get("/entities/entity1")
+	assert.Equal(t, db.ErrNoValue, err)
+	assert.Equal(t, 0, len(events))
+
+	assert.Nil(t, txn.Commit())
+	v, err := kv.Get("/entities/entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("some data"), v)
+	_, err = kv.Get("/groups/group1")
+	assert.Equal(t, db.ErrNoValue, err)
+	_, err = kv.Get(journalKey)
+	assert.Equal(t, db.ErrNoValue, err)
+	assert.Equal(t, []db.Event{
+		{Type: db.EventEntityUpdate, PK: "entity1"},
+		{Type: db.EventGroupDestroy, PK: "group1"},
+	}, events)
+
+	assert.Equal(t, db.ErrTxnClosed, txn.Commit())
+}
+
+func TestTxnAbort(t *testing.T) {
+	viper.Set("core.home", t.TempDir())
+	kv, err := New(hclog.NewNullLogger())
+	assert.Nil(t, err)
+	kv.SetEventFunc(func(db.Event) { t.Error("Event fired for aborted transaction") })
+
+	txn, err := kv.(db.KVTransactor).Begin()
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Put("/entities/entity1", []byte("some data")))
+	assert.Nil(t, txn.Abort())
+	assert.Equal(t, db.ErrTxnClosed, txn.Abort())
+
+	_, err = kv.Get("/entities/entity1")
+	assert.Equal(t, db.ErrNoValue, err)
+}
+
+func TestRecoverJournal(t *testing.T) {
+	viper.Set("core.home", t.TempDir())
+	kv, err := New(hclog.NewNullLogger())
+	assert.Nil(t, err)
+	kv.SetEventFunc(func(db.Event) {})
+
+	// Simulate a crash after the journal was written.
+	j, err := db.EncodeJournal([]db.TxnOp{
+		{Key: "/entities/entity1", Value: []byte("some data")},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, kv.Put(journalKey, j))
+	assert.Nil(t, kv.(*BCStore).recoverJournal())
+
+	v, err := kv.Get("/entities/entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("some data"), v)
+	_, err = kv.Get(journalKey)
+	assert.Equal(t, db.ErrNoValue, err)
 }
 
 type eventHandler struct{ mock.Mock }
@@ -172,3 +244,30 @@ func TestFireEventForKey(t *testing.T) {
 	// trigger an event.
 	ef.AssertNumberOfCalls(t, "FireEvent", 4)
 }
+
+func TestCompareAndSwap(t *testing.T) {
+	viper.Set("core.home", t.TempDir())
+	kv, err := New(hclog.NewNullLogger())
+	assert.Nil(t, err)
+	events := []db.Event{}
+	kv.SetEventFunc(func(e db.Event) { events = append(events, e) })
+	cas := kv.(db.KVSwapper)
+
+	assert.Nil(t, cas.CompareAndSwap("/entities/entity1", nil, []byte("v1")))
+	assert.Equal(t, db.ErrConflict, cas.CompareAndSwap("/entities/entity1", nil, []byte("v2")))
+	assert.Equal(t, db.ErrConflict, cas.CompareAndSwap("/entities/entity1", []byte("v0"), []byte("v2")))
+	assert.Nil(t, cas.CompareAndSwap("/entities/entity1", []byte("v1"), []byte("v2")))
+
+	v, err := kv.Get("/entities/entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), v)
+
+	assert.Nil(t, cas.CompareAndSwap("/entities/entity1", []byte("v2"), nil))
+	_, err = kv.Get("/entities/entity1")
+	assert.Equal(t, db.ErrNoValue, err)
+	assert.Equal(t, []db.Event{
+		{Type: db.EventEntityUpdate, PK: "entity1"},
+		{Type: db.EventEntityUpdate, PK: "entity1"},
+		{Type: db.EventEntityDestroy, PK: "entity1"},
+	}, events)
+}
@@ -0,0 +1,106 @@
+package db
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// A Bucket is a namespace in the KVStore for data that other
+// subsystems keep alongside the entities and groups.  Keys within a
+// bucket must not contain a slash.  A bucket obtained from a Txn is
+// part of that transaction, otherwise bucket operations go straight
+// to the KVStore.
+type Bucket struct {
+	db     *DB
+	tx     *Txn
+	prefix string
+}
+
+// Bucket returns the bucket with the given name.  Buckets do not
+// need to be created before they are used.
+func (db *DB) Bucket(name string) *Bucket {
+	return &Bucket{db: db, prefix: "/" + name + "/"}
+}
+
+// Bucket returns the bucket with the given name within the
+// transaction.
+func (t *Txn) Bucket(name string) *Bucket {
+	return &Bucket{db: t.db, tx: t, prefix: "/" + name + "/"}
+}
+
+// Put stores a value in the bucket.
+func (b *Bucket) Put(k string, v []byte) error {
+	if b.tx != nil {
+		b.tx.stage(TxnOp{Key: b.key(k), Value: v})
+		return nil
+	}
+
+	b.db.writeMu.Lock()
+	defer b.db.writeMu.Unlock()
+	if err := b.db.kv.Put(b.key(k), v); err != nil {
+		b.db.log.Warn("Error storing value", "key", b.key(k), "error", err)
+		return ErrInternalError
+	}
+	return nil
+}
+
+// Get returns a value from the bucket, or ErrNoValue if there is no
+// value with that key.
+func (b *Bucket) Get(k string) ([]byte, error) {
+	v, err := b.db.get(b.tx, b.key(k))
+	switch err {
+	case nil:
+		return v, nil
+	case ErrNoValue:
+		return nil, ErrNoValue
+	default:
+		b.db.log.Warn("Error loading value", "key", b.key(k), "error", err)
+		return nil, ErrInternalError
+	}
+}
+
+// Del removes a value from the bucket.
+func (b *Bucket) Del(k string) error {
+	if b.tx != nil {
+		b.tx.stage(TxnOp{Key: b.key(k), Delete: true})
+		return nil
+	}
+
+	b.db.writeMu.Lock()
+	defer b.db.writeMu.Unlock()
+	switch err := b.db.kv.Del(b.key(k)); err {
+	case nil, ErrNoValue:
+		return nil
+	default:
+		b.db.log.Warn("Error removing value", "key", b.key(k), "error", err)
+		return ErrInternalError
+	}
+}
+
+// Keys returns the keys in the bucket in sorted order.
+func (b *Bucket) Keys() ([]string, error) {
+	var keys []string
+	var err error
+	if b.tx != nil {
+		keys, err = b.tx.keys(b.prefix + "*")
+	} else {
+		keys, err = b.db.kv.Keys(b.prefix + "*")
+	}
+	if err != nil {
+		b.db.log.Warn("Error listing bucket", "bucket", b.prefix, "error", err)
+		return nil, ErrInternalError
+	}
+	out := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if strings.HasPrefix(k, b.prefix) {
+			out = append(out, path.Base(k))
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func (b *Bucket) key(k string) string {
+	return b.prefix + k
+}
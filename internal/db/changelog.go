@@ -0,0 +1,421 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// changeLogPrefix is the location in the KVStore where each
+	// change is recorded under its sequence number.
+	changeLogPrefix = "/changelog/"
+
+	// changeLogSeqKey holds the most recently allocated sequence
+	// number.
+	changeLogSeqKey = "/sequence/changelog"
+
+	// changeLogPrunedKey holds the sequence number of the most
+	// recent change that has been pruned.
+	changeLogPrunedKey = "/sequence/changelog-pruned"
+
+	// pruneInterval is the number of changes that are recorded
+	// between each pruning of the change log.
+	pruneInterval = 64
+)
+
+// A Change is a single entry in the change log.  Sequence numbers are
+// strictly increasing, but may have gaps if a mutation failed after
+// its sequence number was allocated.
+type Change struct {
+	Seq  uint64
+	Time time.Time
+	Type EventType
+	PK   string
+
+	// value is the new value of an updated object, which is
+	// kept in the history but not in the change log.
+	value []byte
+}
+
+// Value returns the serialized value that an update gave the object.
+// It is only available for the changes of a transaction, and is nil
+// for changes that removed the object.
+func (c Change) Value() []byte {
+	return c.value
+}
+
+// SetChangeLogRetention configures how much of the change log is
+// kept.  Changes older than maxAge are removed, as are the oldest
+// changes once there are more than maxSize.  A zero value for either
+// disables that limit.
+func (db *DB) SetChangeLogRetention(maxAge time.Duration, maxSize int) {
+	db.logMu.Lock()
+	defer db.logMu.Unlock()
+	db.logMaxAge = maxAge
+	db.logMaxSize = maxSize
+}
+
+// ChangeLogHead returns the sequence number of the most recent
+// change, or zero if nothing has been recorded.
+func (db *DB) ChangeLogHead() (uint64, error) {
+	return db.loadSeq(changeLogSeqKey)
+}
+
+// ChangeLogPruned returns the sequence number of the most recent
+// change that has been removed from the change log, or zero if
+// nothing has been removed.  A reader that has seen every change up
+// to a sequence number lower than this has missed changes, and must
+// check this after calling ChangesSince, since changes may be pruned
+// while it is reading.
+func (db *DB) ChangeLogPruned() (uint64, error) {
+	return db.loadSeq(changeLogPrunedKey)
+}
+
+func (db *DB) loadSeq(k string) (uint64, error) {
+	b, err := db.kv.Get(k)
+	switch err {
+	case nil:
+	case ErrNoValue:
+		return 0, nil
+	default:
+		return 0, ErrInternalError
+	}
+	seq, err := strconv.ParseUint(string(b), 10, 64)
+	if err != nil {
+		db.log.Warn("Change log sequence is corrupt", "error", err)
+		return 0, ErrInternalError
+	}
+	return seq, nil
+}
+
+// ChangesSince returns the changes recorded after the given sequence
+// number, oldest first.  If limit is greater than zero at most that
+// many changes are returned.
+func (db *DB) ChangesSince(seq uint64, limit int) ([]Change, error) {
+	keys, err := db.changeKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	out := []Change{}
+	for _, k := range keys {
+		if limit > 0 && len(out) == limit {
+			break
+		}
+		if s, _ := changeSeq(k); s <= seq {
+			continue
+		}
+		c, err := db.loadChange(k)
+		if err == ErrNoValue {
+			// Pruned since the keys were listed.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// PruneChangeLog removes changes that are outside the configured
+// retention.  This is called periodically as changes are recorded,
+// but may also be called directly.
+func (db *DB) PruneChangeLog() error {
+	db.logMu.Lock()
+	maxAge, maxSize := db.logMaxAge, db.logMaxSize
+	db.logMu.Unlock()
+	if maxAge == 0 && maxSize == 0 {
+		return nil
+	}
+
+	keys, err := db.changeKeys()
+	if err != nil {
+		return err
+	}
+
+	drop := 0
+	if maxSize > 0 && len(keys) > maxSize {
+		drop = len(keys) - maxSize
+	}
+	if maxAge > 0 {
+		// Changes are in time order, so only the oldest need
+		// to be checked.
+		cutoff := time.Now().Add(-maxAge)
+		for ; drop < len(keys); drop++ {
+			c, err := db.loadChange(keys[drop])
+			if err != nil && err != ErrNoValue {
+				return err
+			}
+			if err == nil && c.Time.After(cutoff) {
+				break
+			}
+		}
+	}
+
+	if drop == 0 {
+		return nil
+	}
+
+	// The watermark is moved before anything is removed, so that
+	// a reader never misses a change without being able to tell.
+	last, _ := changeSeq(keys[drop-1])
+	if err := db.kv.Put(changeLogPrunedKey, []byte(strconv.FormatUint(last, 10))); err != nil {
+		db.log.Warn("Error storing change log watermark", "error", err)
+		return ErrInternalError
+	}
+	for _, k := range keys[:drop] {
+		if err := db.kv.Del(k); err != nil && err != ErrNoValue {
+			db.log.Warn("Error pruning change log", "key", k, "error", err)
+			return ErrInternalError
+		}
+	}
+	db.log.Debug("Change log pruned", "removed", drop)
+	return nil
+}
+
+// changeKeys returns the keys of all recorded changes, oldest first.
+func (db *DB) changeKeys() ([]string, error) {
+	keys, err := db.kv.Keys(changeLogPrefix + "*")
+	if err != nil {
+		db.log.Warn("Error listing change log", "error", err)
+		return nil, ErrInternalError
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (db *DB) loadChange(k string) (Change, error) {
+	c := Change{}
+	b, err := db.kv.Get(k)
+	switch err {
+	case nil:
+	case ErrNoValue:
+		return c, ErrNoValue
+	default:
+		db.log.Warn("Error loading change", "key", k, "error", err)
+		return c, ErrInternalError
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		db.log.Warn("Error unmarshaling change", "key", k, "error", err)
+		return c, ErrInternalError
+	}
+	return c, nil
+}
+
+// changeKey returns the key for a sequence number.  Sequence numbers
+// are zero padded so that the keys sort in order.
+func changeKey(seq uint64) string {
+	return fmt.Sprintf("%s%020d", changeLogPrefix, seq)
+}
+
+func isChangeRecord(k string) bool {
+	return strings.HasPrefix(k, changeLogPrefix)
+}
+
+func changeSeq(k string) (uint64, error) {
+	return strconv.ParseUint(path.Base(k), 10, 64)
+}
+
+// changesFor returns the changes that describe a set of mutations.
+// Mutations to anything other than entities and groups are not
+// recorded.
+func changesFor(ops []TxnOp) []Change {
+	now := time.Now()
+	out := []Change{}
+	for _, op := range ops {
+		c := Change{Time: now, PK: path.Base(op.Key), value: op.Value}
+		switch {
+		case strings.HasPrefix(op.Key, "/entities/") && op.Delete:
+			c.Type = EventEntityDestroy
+		case strings.HasPrefix(op.Key, "/entities/"):
+			c.Type = EventEntityUpdate
+		case strings.HasPrefix(op.Key, "/groups/") && op.Delete:
+			c.Type = EventGroupDestroy
+		case strings.HasPrefix(op.Key, "/groups/"):
+			c.Type = EventGroupUpdate
+		default:
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// unchanged returns true if a mutation would leave an entity or group
+// as it was when it was read.  Mutations of anything else are never
+// considered unchanged.
+func unchanged(op TxnOp, r txRead) bool {
+	if len(changesFor([]TxnOp{op})) == 0 {
+		return false
+	}
+	if op.Delete {
+		return !r.found
+	}
+	return r.found && bytes.Equal(r.value, op.Value)
+}
+
+// current returns true if the value stored at k is already b.  It is
+// used to skip saves made outside of a transaction that wouldn't
+// change anything.
+func (db *DB) current(k string, b []byte) bool {
+	v, err := db.kv.Get(k)
+	return err == nil && bytes.Equal(v, b)
+}
+
+// dropUnchanged removes the mutations that would leave an entity or
+// group as it was read, so that saving an object without changing it
+// doesn't fill the change log.  A key that is mutated more than once
+// is always kept, since the mutations in between matter.  The reads
+// must already have been verified.
+func dropUnchanged(ops []TxnOp, reads map[string]txRead) []TxnOp {
+	n := make(map[string]int, len(ops))
+	for _, op := range ops {
+		n[op.Key]++
+	}
+	out := make([]TxnOp, 0, len(ops))
+	for _, op := range ops {
+		if r, ok := reads[op.Key]; ok && n[op.Key] == 1 && unchanged(op, r) {
+			continue
+		}
+		out = append(out, op)
+	}
+	return out
+}
+
+// changeOps allocates sequence numbers for a set of mutations and
+// returns the mutations that record them in the change log and in
+// the history of each object.  These can be added to a batch so that
+// the record is written atomically with the change.
+func (db *DB) changeOps(ops []TxnOp) ([]TxnOp, error) {
+	changes := changesFor(ops)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	first, err := db.allocSeq(len(changes))
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]TxnOp, len(changes))
+	for i := range changes {
+		changes[i].Seq = first + uint64(i)
+		b, err := json.Marshal(changes[i])
+		if err != nil {
+			return nil, ErrInternalError
+		}
+		out[i] = TxnOp{Key: changeKey(changes[i].Seq), Value: b}
+	}
+
+	history, err := db.historyOps(changes)
+	if err != nil {
+		return nil, err
+	}
+	return append(out, history...), nil
+}
+
+// recordChanges writes the change log entries and history for
+// mutations that have already been applied.  The mutations can't be
+// undone at this point, so failures are logged rather than returned.
+func (db *DB) recordChanges(ops []TxnOp) {
+	records, err := db.changeOps(ops)
+	if err != nil {
+		db.log.Error("Unable to allocate change log sequence", "error", err)
+		return
+	}
+	for _, r := range records {
+		var err error
+		if r.Delete {
+			err = db.kv.Del(r.Key)
+		} else {
+			err = db.kv.Put(r.Key, r.Value)
+		}
+		if err != nil && err != ErrNoValue {
+			db.log.Error("Error recording change", "key", r.Key, "error", err)
+			return
+		}
+	}
+	db.maybePrune(records)
+}
+
+// maybePrune prunes the change log once enough changes have been
+// recorded since it was last pruned.
+func (db *DB) maybePrune(records []TxnOp) {
+	n := 0
+	for _, r := range records {
+		if isChangeRecord(r.Key) {
+			n++
+		}
+	}
+
+	db.logMu.Lock()
+	db.logSincePrune += n
+	prune := db.logSincePrune >= pruneInterval
+	if prune {
+		db.logSincePrune = 0
+	}
+	db.logMu.Unlock()
+
+	if prune {
+		if err := db.PruneChangeLog(); err != nil {
+			db.log.Warn("Error pruning change log", "error", err)
+		}
+	}
+}
+
+// allocSeq reserves n sequence numbers and returns the first of
+// them.  If the KVStore supports compare and swap then the
+// allocation is safe against other servers sharing the same store,
+// otherwise it is only safe within this process.
+func (db *DB) allocSeq(n int) (uint64, error) {
+	db.seqMu.Lock()
+	defer db.seqMu.Unlock()
+
+	for attempt := 0; attempt < 10; attempt++ {
+		b, err := db.kv.Get(changeLogSeqKey)
+		var cur uint64
+		switch err {
+		case nil:
+			if b == nil {
+				b = []byte{}
+			}
+			cur, err = strconv.ParseUint(string(b), 10, 64)
+			if err != nil {
+				db.log.Warn("Change log sequence is corrupt", "error", err)
+				return 0, ErrInternalError
+			}
+		case ErrNoValue:
+			b = nil
+		default:
+			db.log.Warn("Error loading change log sequence", "error", err)
+			return 0, ErrInternalError
+		}
+
+		next := []byte(strconv.FormatUint(cur+uint64(n), 10))
+		if !db.swappable() {
+			if err := db.kv.Put(changeLogSeqKey, next); err != nil {
+				db.log.Warn("Error storing change log sequence", "error", err)
+				return 0, ErrInternalError
+			}
+			return cur + 1, nil
+		}
+
+		switch err := db.kv.(KVSwapper).CompareAndSwap(changeLogSeqKey, b, next); err {
+		case nil:
+			return cur + 1, nil
+		case ErrConflict:
+			continue
+		default:
+			db.log.Warn("Error storing change log sequence", "error", err)
+			return 0, ErrInternalError
+		}
+	}
+	return 0, ErrConflict
+}
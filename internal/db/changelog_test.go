@@ -0,0 +1,215 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+
+	types "github.com/netauth/protocol"
+)
+
+// mapKV is a minimal working store, which is simpler than a mock when
+// the test needs to read back what was written.
+type mapKV struct {
+	sync.Mutex
+	m map[string][]byte
+}
+
+func newMapKV(hclog.Logger) (KVStore, error) {
+	return &mapKV{m: make(map[string][]byte)}, nil
+}
+
+func (kv *mapKV) Put(k string, v []byte) error {
+	kv.Lock()
+	defer kv.Unlock()
+	kv.m[k] = v
+	return nil
+}
+
+func (kv *mapKV) Get(k string) ([]byte, error) {
+	kv.Lock()
+	defer kv.Unlock()
+	v, ok := kv.m[k]
+	if !ok {
+		return nil, ErrNoValue
+	}
+	return v, nil
+}
+
+func (kv *mapKV) Del(k string) error {
+	kv.Lock()
+	defer kv.Unlock()
+	delete(kv.m, k)
+	return nil
+}
+
+func (kv *mapKV) Keys(f string) ([]string, error) {
+	kv.Lock()
+	defer kv.Unlock()
+	out := []string{}
+	for k := range kv.m {
+		if m, _ := path.Match(f, k); m {
+			out = append(out, k)
+		}
+	}
+	return out, nil
+}
+
+func (kv *mapKV) CompareAndSwap(k string, old, new []byte) error {
+	kv.Lock()
+	defer kv.Unlock()
+	cur, ok := kv.m[k]
+	if ok != (old != nil) || !bytes.Equal(cur, old) {
+		return ErrConflict
+	}
+	if new == nil {
+		delete(kv.m, k)
+		return nil
+	}
+	kv.m[k] = new
+	return nil
+}
+
+func (kv *mapKV) Close() error             { return nil }
+func (kv *mapKV) SetEventFunc(func(Event)) {}
+func (kv *mapKV) Capabilities() []KVCapability {
+	return []KVCapability{KVMutable, KVCompareAndSwap}
+}
+
+func TestChangeLog(t *testing.T) {
+	RegisterKV("map", newMapKV)
+	m, err := New("map")
+	assert.Nil(t, err)
+
+	head, err := m.ChangeLogHead()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), head)
+
+	assert.Nil(t, m.SaveEntity(&types.Entity{ID: proto.String("entity1")}))
+	assert.Nil(t, m.SaveGroup(&types.Group{Name: proto.String("group1")}))
+	assert.Nil(t, m.DeleteEntity("entity1"))
+
+	tx := m.Begin()
+	assert.Nil(t, tx.SaveEntity(&types.Entity{ID: proto.String("entity2")}))
+	assert.Nil(t, tx.DeleteGroup("group1"))
+	assert.Nil(t, tx.Commit())
+
+	// Aborted changes are not recorded.
+	tx = m.Begin()
+	assert.Nil(t, tx.SaveEntity(&types.Entity{ID: proto.String("entity3")}))
+	assert.Nil(t, tx.Abort())
+
+	head, err = m.ChangeLogHead()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(5), head)
+
+	changes, err := m.ChangesSince(0, 0)
+	assert.Nil(t, err)
+	want := []struct {
+		Type EventType
+		PK   string
+	}{
+		{EventEntityUpdate, "entity1"},
+		{EventGroupUpdate, "group1"},
+		{EventEntityDestroy, "entity1"},
+		{EventEntityUpdate, "entity2"},
+		{EventGroupDestroy, "group1"},
+	}
+	assert.Len(t, changes, len(want))
+	for i, c := range changes {
+		assert.Equal(t, uint64(i+1), c.Seq)
+		assert.Equal(t, want[i].Type, c.Type)
+		assert.Equal(t, want[i].PK, c.PK)
+	}
+
+	changes, err = m.ChangesSince(2, 2)
+	assert.Nil(t, err)
+	assert.Len(t, changes, 2)
+	assert.Equal(t, uint64(3), changes[0].Seq)
+}
+
+func TestChangeLogUnchanged(t *testing.T) {
+	RegisterKV("map", newMapKV)
+	m, err := New("map")
+	assert.Nil(t, err)
+
+	assert.Nil(t, m.SaveEntity(&types.Entity{ID: proto.String("entity1")}))
+
+	// Saving an object as it already is records nothing, whether
+	// or not it is done in a transaction.
+	assert.Nil(t, m.SaveEntity(&types.Entity{ID: proto.String("entity1")}))
+	tx := m.Begin()
+	e, err := tx.LoadEntity("entity1")
+	assert.Nil(t, err)
+	assert.Nil(t, tx.SaveEntity(e))
+	assert.Empty(t, tx.Changes())
+	assert.Nil(t, tx.Commit())
+
+	head, err := m.ChangeLogHead()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), head)
+}
+
+func TestPruneChangeLog(t *testing.T) {
+	RegisterKV("map", newMapKV)
+	m, err := New("map")
+	assert.Nil(t, err)
+
+	// With no retention configured nothing is removed.
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, m.SaveEntity(&types.Entity{ID: proto.String("entity1"), Number: proto.Int32(int32(i))}))
+	}
+	assert.Nil(t, m.PruneChangeLog())
+	changes, _ := m.ChangesSince(0, 0)
+	assert.Len(t, changes, 4)
+	pruned, err := m.ChangeLogPruned()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), pruned)
+
+	m.SetChangeLogRetention(0, 2)
+	assert.Nil(t, m.PruneChangeLog())
+	changes, _ = m.ChangesSince(0, 0)
+	assert.Len(t, changes, 2)
+	assert.Equal(t, uint64(3), changes[0].Seq)
+	pruned, _ = m.ChangeLogPruned()
+	assert.Equal(t, uint64(2), pruned)
+
+	// Age is checked from the oldest change.
+	old, _ := json.Marshal(Change{Seq: 3, Time: time.Now().Add(-2 * time.Hour)})
+	m.kv.Put(changeKey(3), old)
+	m.SetChangeLogRetention(time.Hour, 0)
+	assert.Nil(t, m.PruneChangeLog())
+	changes, _ = m.ChangesSince(0, 0)
+	assert.Len(t, changes, 1)
+	assert.Equal(t, uint64(4), changes[0].Seq)
+	pruned, _ = m.ChangeLogPruned()
+	assert.Equal(t, uint64(3), pruned)
+
+	// Sequence numbers are never reused after pruning.
+	assert.Nil(t, m.SaveEntity(&types.Entity{ID: proto.String("entity1")}))
+	head, _ := m.ChangeLogHead()
+	assert.Equal(t, uint64(5), head)
+}
+
+func TestAllocSeqConflict(t *testing.T) {
+	RegisterKV("mockSwap", newMockSwapKV)
+	m, err := New("mockSwap")
+	assert.Nil(t, err)
+
+	kv := m.kv.(*mockSwapKV)
+	kv.ExpectedCalls = nil
+	kv.On("Capabilities").Return([]KVCapability{KVMutable, KVCompareAndSwap})
+	kv.On("Get", changeLogSeqKey).Return([]byte("7"), nil)
+	kv.On("CompareAndSwap", changeLogSeqKey, []byte("7"), []byte("9")).Return(ErrConflict)
+
+	_, err = m.allocSeq(2)
+	assert.Equal(t, ErrConflict, err)
+	kv.AssertNumberOfCalls(t, "CompareAndSwap", 10)
+}
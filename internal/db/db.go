@@ -25,10 +25,13 @@ func New(backend string) (*DB, error) {
 
 	idx := NewIndex(log())
 	x := &DB{
-		log:   log(),
-		Index: idx,
-		kv:    kv,
-		cbs:   make(map[string]Callback),
+		log:     log(),
+		Index:   idx,
+		kv:      kv,
+		backend: backend,
+		cbs:     make(map[string]Callback),
+
+		historyDepth: defaultHistoryDepth,
 	}
 	kv.SetEventFunc(x.FireEvent)
 	x.Index.ConfigureCallback(x.LoadEntity, x.LoadGroup)
@@ -43,9 +46,25 @@ func (db *DB) DiscoverEntityIDs() ([]string, error) {
 	return db.kv.Keys("/entities/*")
 }
 
+// DiscoverEntityIDs searches the keyspace for all entity IDs,
+// including those created in the transaction and excluding those
+// deleted in it.
+func (t *Txn) DiscoverEntityIDs() ([]string, error) {
+	return t.keys("/entities/*")
+}
+
 // LoadEntity retrieves a single entity from the kv store.
 func (db *DB) LoadEntity(ID string) (*types.Entity, error) {
-	b, err := db.kv.Get(path.Join("/entities", ID))
+	return db.loadEntity(nil, ID)
+}
+
+// LoadEntity retrieves a single entity within the transaction.
+func (t *Txn) LoadEntity(ID string) (*types.Entity, error) {
+	return t.db.loadEntity(t, ID)
+}
+
+func (db *DB) loadEntity(tx *Txn, ID string) (*types.Entity, error) {
+	b, err := db.get(tx, path.Join("/entities", ID))
 	if err == ErrNoValue {
 		return nil, ErrUnknownEntity
 	}
@@ -59,30 +78,84 @@ func (db *DB) LoadEntity(ID string) (*types.Entity, error) {
 		db.log.Warn("Error unmarshaling entity", "error", err)
 		return nil, ErrInternalError
 	}
+	if tx == nil {
+		return e, nil
+	}
+	if err := tx.checkExpected(path.Join("/entities", ID), EntityRevision(e)); err != nil {
+		return nil, err
+	}
 	return e, nil
 }
 
 // SaveEntity writes an entity to the kv store.
 func (db *DB) SaveEntity(e *types.Entity) error {
+	return db.saveEntity(nil, e)
+}
+
+// SaveEntity stages an entity to be written when the transaction is
+// committed.
+func (t *Txn) SaveEntity(e *types.Entity) error {
+	return t.db.saveEntity(t, e)
+}
+
+func (db *DB) saveEntity(tx *Txn, e *types.Entity) error {
 	b, err := proto.Marshal(e)
 	if err != nil {
 		db.log.Warn("Error marshaling entity", "error", err)
 		return err
 	}
 
-	if err := db.kv.Put(path.Join("/entities", e.GetID()), b); err != nil {
+	k := path.Join("/entities", e.GetID())
+	if tx != nil {
+		if err := tx.stale(k); err != nil {
+			return err
+		}
+		tx.stage(TxnOp{Key: k, Value: b})
+		return nil
+	}
+
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	if db.current(k, b) {
+		return nil
+	}
+	if err := db.kv.Put(k, b); err != nil {
 		db.log.Warn("Error storing entity", "error", err)
 		return ErrInternalError
 	}
+	db.recordChanges([]TxnOp{{Key: k, Value: b}})
 	return nil
 }
 
 // DeleteEntity tries to delete an entity that already exists.
 func (db *DB) DeleteEntity(ID string) error {
-	err := db.kv.Del(path.Join("/entities", ID))
+	return db.deleteEntity(nil, ID)
+}
+
+// DeleteEntity stages the deletion of an entity that already exists.
+func (t *Txn) DeleteEntity(ID string) error {
+	return t.db.deleteEntity(t, ID)
+}
+
+func (db *DB) deleteEntity(tx *Txn, ID string) error {
+	k := path.Join("/entities", ID)
+	if tx != nil {
+		if _, err := db.get(tx, k); err == ErrNoValue {
+			return ErrUnknownEntity
+		}
+		tx.stage(TxnOp{Key: k, Delete: true})
+		return nil
+	}
+
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	err := db.kv.Del(k)
 	if err == ErrNoValue {
 		return ErrUnknownEntity
 	}
+	if err == nil {
+		db.recordChanges([]TxnOp{{Key: k, Delete: true}})
+	}
 	return err
 }
 
@@ -92,9 +165,25 @@ func (db *DB) DiscoverGroupNames() ([]string, error) {
 	return db.kv.Keys("/groups/*")
 }
 
+// DiscoverGroupNames searches the keyspace for all group names,
+// including those created in the transaction and excluding those
+// deleted in it.
+func (t *Txn) DiscoverGroupNames() ([]string, error) {
+	return t.keys("/groups/*")
+}
+
 // LoadGroup retrieves a single group from the kv store.
 func (db *DB) LoadGroup(ID string) (*types.Group, error) {
-	b, err := db.kv.Get(path.Join("/groups", ID))
+	return db.loadGroup(nil, ID)
+}
+
+// LoadGroup retrieves a single group within the transaction.
+func (t *Txn) LoadGroup(ID string) (*types.Group, error) {
+	return t.db.loadGroup(t, ID)
+}
+
+func (db *DB) loadGroup(tx *Txn, ID string) (*types.Group, error) {
+	b, err := db.get(tx, path.Join("/groups", ID))
 	if err == ErrNoValue {
 		return nil, ErrUnknownGroup
 	}
@@ -108,30 +197,84 @@ func (db *DB) LoadGroup(ID string) (*types.Group, error) {
 		db.log.Warn("Error unmarshaling group", "error", err)
 		return nil, err
 	}
+	if tx == nil {
+		return g, nil
+	}
+	if err := tx.checkExpected(path.Join("/groups", ID), GroupRevision(g)); err != nil {
+		return nil, err
+	}
 	return g, nil
 }
 
 // SaveGroup writes an group to the kv store.
 func (db *DB) SaveGroup(g *types.Group) error {
+	return db.saveGroup(nil, g)
+}
+
+// SaveGroup stages a group to be written when the transaction is
+// committed.
+func (t *Txn) SaveGroup(g *types.Group) error {
+	return t.db.saveGroup(t, g)
+}
+
+func (db *DB) saveGroup(tx *Txn, g *types.Group) error {
 	b, err := proto.Marshal(g)
 	if err != nil {
 		db.log.Warn("Error marshaling group", "error", err)
 		return err
 	}
 
-	if err := db.kv.Put(path.Join("/groups", g.GetName()), b); err != nil {
+	k := path.Join("/groups", g.GetName())
+	if tx != nil {
+		if err := tx.stale(k); err != nil {
+			return err
+		}
+		tx.stage(TxnOp{Key: k, Value: b})
+		return nil
+	}
+
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	if db.current(k, b) {
+		return nil
+	}
+	if err := db.kv.Put(k, b); err != nil {
 		db.log.Warn("Error storing group", "error", err)
 		return err
 	}
+	db.recordChanges([]TxnOp{{Key: k, Value: b}})
 	return nil
 }
 
 // DeleteGroup tries to delete an group that already exists.
 func (db *DB) DeleteGroup(ID string) error {
-	err := db.kv.Del(path.Join("/groups", ID))
+	return db.deleteGroup(nil, ID)
+}
+
+// DeleteGroup stages the deletion of a group that already exists.
+func (t *Txn) DeleteGroup(ID string) error {
+	return t.db.deleteGroup(t, ID)
+}
+
+func (db *DB) deleteGroup(tx *Txn, ID string) error {
+	k := path.Join("/groups", ID)
+	if tx != nil {
+		if _, err := db.get(tx, k); err == ErrNoValue {
+			return ErrUnknownGroup
+		}
+		tx.stage(TxnOp{Key: k, Delete: true})
+		return nil
+	}
+
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	err := db.kv.Del(k)
 	if err == ErrNoValue {
 		return ErrUnknownGroup
 	}
+	if err == nil {
+		db.recordChanges([]TxnOp{{Key: k, Delete: true}})
+	}
 	return err
 }
 
@@ -141,56 +284,19 @@ func (db *DB) Shutdown() {
 	if err := db.kv.Close(); err != nil {
 		db.log.Error("Error shutting down KV store", "error", err)
 	}
-}
-
-// NextEntityNumber computes and returns the next unnassigned number
-// in the entity space.
-func (db *DB) NextEntityNumber() (int32, error) {
-	var largest int32
-
-	// Iterate over the entities and return the largest ID found
-	// +1.  This allows them to be in any order or have IDs
-	// missing in the middle and still work.  Though an
-	// inefficient search this is worst case O(N) and happens only
-	// on provisioning a new entry in the database.
-	el, err := db.DiscoverEntityIDs()
-	if err != nil {
-		return 0, err
-	}
-
-	for _, en := range el {
-		e, err := db.LoadEntity(path.Base(en))
-		if err != nil {
-			return 0, err
-		}
-		if e.GetNumber() > largest {
-			largest = e.GetNumber()
-		}
+	if err := db.Index.Close(); err != nil {
+		db.log.Error("Error closing search index", "error", err)
 	}
-
-	return largest + 1, nil
 }
 
-// NextGroupNumber computes the next available group number and
-// returns it.
-func (db *DB) NextGroupNumber() (int32, error) {
-	var largest int32
-
-	l, err := db.DiscoverGroupNames()
-	if err != nil {
-		return 0, err
-	}
-	for _, i := range l {
-		g, err := db.LoadGroup(path.Base(i))
-		if err != nil {
-			return 0, err
-		}
-		if g.GetNumber() > largest {
-			largest = g.GetNumber()
-		}
+// Leader returns true if this server should do work that only one
+// server sharing the KVStore should do.  Unless the KVStore is shared
+// by a cluster that elects a leader this is always true.
+func (db *DB) Leader() bool {
+	if l, ok := db.kv.(KVLeader); ok {
+		return l.Leader()
 	}
-
-	return largest + 1, nil
+	return true
 }
 
 // Capabilities returns a slice of capabilities the backing store
@@ -204,23 +310,64 @@ func (db *DB) Capabilities() []KVCapability {
 // SearchEntities performs a search of all entities using the given
 // query and then batch loads the result.
 func (db *DB) SearchEntities(r SearchRequest) ([]*types.Entity, error) {
-	ids, err := db.Index.SearchEntities(r)
+	res, _, err := db.SearchEntityPage(r)
+	return res, err
+}
+
+// SearchEntityPage performs a search in the same way as
+// SearchEntities, but returns only the page of results asked for,
+// with only the fields asked for, and the token for the next page.
+// The token is empty if there are no more results.
+func (db *DB) SearchEntityPage(r SearchRequest) ([]*types.Entity, string, error) {
+	ids, next, err := db.Index.SearchEntityPage(r)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	return db.loadEntityBatch(ids)
+	res, err := db.loadEntityBatch(ids)
+	if err != nil || len(r.Fields) == 0 {
+		return res, next, err
+	}
+	fields := append([]string{"ID"}, r.Fields...)
+	for i := range res {
+		e := &types.Entity{}
+		if err := project(res[i], e, fields); err != nil {
+			return nil, "", err
+		}
+		res[i] = e
+	}
+	return res, next, nil
 }
 
 // SearchGroups performs a search of all groups using the given query
 // and then batch loads the result.
 func (db *DB) SearchGroups(r SearchRequest) ([]*types.Group, error) {
-	ids, err := db.Index.SearchGroups(r)
+	res, _, err := db.SearchGroupPage(r)
+	return res, err
+}
+
+// SearchGroupPage performs a search in the same way as SearchGroups,
+// but returns only the page of results asked for, with only the
+// fields asked for, and the token for the next page.
+func (db *DB) SearchGroupPage(r SearchRequest) ([]*types.Group, string, error) {
+	names, next, err := db.Index.SearchGroupPage(r)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	return db.loadGroupBatch(ids)
+	res, err := db.loadGroupBatch(names)
+	if err != nil || len(r.Fields) == 0 {
+		return res, next, err
+	}
+	fields := append([]string{"Name"}, r.Fields...)
+	for i := range res {
+		g := &types.Group{}
+		if err := project(res[i], g, fields); err != nil {
+			return nil, "", err
+		}
+		res[i] = g
+	}
+	return res, next, nil
 }
 
 func (db *DB) loadEntityBatch(ids []string) ([]*types.Entity, error) {
@@ -67,12 +67,21 @@ func TestSaveEntity(t *testing.T) {
 	m, err := New("mock")
 	assert.Nil(t, err)
 
+	same, _ := proto.Marshal(&types.Entity{ID: proto.String("same")})
+	m.kv.(*mockKV).On("Get", "/entities/good").Return([]byte{}, ErrNoValue)
+	m.kv.(*mockKV).On("Get", "/entities/bad").Return([]byte{}, ErrNoValue)
+	m.kv.(*mockKV).On("Get", "/entities/same").Return(same, nil)
 	m.kv.(*mockKV).On("Put", "/entities/good", mock.Anything).Return(nil)
 	m.kv.(*mockKV).On("Put", "/entities/bad", mock.Anything).Return(errors.New("something internal"))
 
 	err = m.SaveEntity(&types.Entity{ID: proto.String("good")})
 	assert.Nil(t, err)
 
+	// An entity that is saved unchanged isn't written again.
+	err = m.SaveEntity(&types.Entity{ID: proto.String("same")})
+	assert.Nil(t, err)
+	m.kv.(*mockKV).AssertNotCalled(t, "Put", "/entities/same", mock.Anything)
+
 	err = m.SaveEntity(nil)
 	assert.NotNil(t, err)
 
@@ -136,6 +145,8 @@ func TestSaveGroup(t *testing.T) {
 	m, err := New("mock")
 	assert.Nil(t, err)
 
+	m.kv.(*mockKV).On("Get", "/groups/good").Return([]byte{}, ErrNoValue)
+	m.kv.(*mockKV).On("Get", "/groups/bad").Return([]byte{}, ErrNoValue)
 	m.kv.(*mockKV).On("Put", "/groups/good", mock.Anything).Return(nil)
 	m.kv.(*mockKV).On("Put", "/groups/bad", mock.Anything).Return(errors.New("something internal"))
 
@@ -179,6 +190,11 @@ func TestNextEntityNumber(t *testing.T) {
 	m.kv.(*mockKV).On("Get", "/entities/entity1").Return(goodEntityBytes1, nil)
 	m.kv.(*mockKV).On("Get", "/entities/entity2").Return(goodEntityBytes2, nil)
 
+	// The pool state is never stored, so each allocation scans
+	// the entities again.
+	m.kv.(*mockKV).On("Get", "/entitynumbers/default").Return([]byte{}, ErrNoValue)
+	m.kv.(*mockKV).On("Put", "/entitynumbers/default", mock.Anything).Return(nil)
+
 	m.kv.(*mockKV).On("Keys", "/entities/*").Return([]string{}, nil).Once()
 	res, err := m.NextEntityNumber()
 	assert.Nil(t, err)
@@ -210,6 +226,8 @@ func TestNextGroupNumber(t *testing.T) {
 	m.kv.(*mockKV).On("Get", "/groups/load-error").Return([]byte{}, errors.New("KV Load error"))
 	m.kv.(*mockKV).On("Get", "/groups/group1").Return(goodGroupBytes1, nil)
 	m.kv.(*mockKV).On("Get", "/groups/group2").Return(goodGroupBytes2, nil)
+	m.kv.(*mockKV).On("Get", "/groupnumbers/default").Return([]byte{}, ErrNoValue)
+	m.kv.(*mockKV).On("Put", "/groupnumbers/default", mock.Anything).Return(nil)
 
 	m.kv.(*mockKV).On("Keys", "/groups/*").Return([]string{}, nil).Once()
 	res, err := m.NextGroupNumber()
@@ -244,6 +262,15 @@ func TestCapabilities(t *testing.T) {
 	assert.Equal(t, []KVCapability{}, m.Capabilities())
 }
 
+func TestLeader(t *testing.T) {
+	RegisterKV("mock", newMockKV)
+	m, err := New("mock")
+	assert.Nil(t, err)
+
+	// A store that isn't shared by a cluster always leads.
+	assert.True(t, m.Leader())
+}
+
 func TestDBSearchEntities(t *testing.T) {
 	RegisterKV("mock", newMockKV)
 	m, err := New("mock")
@@ -272,6 +299,50 @@ func TestDBSearchGroups(t *testing.T) {
 	assert.Equal(t, []*types.Group{}, res)
 }
 
+func TestDBSearchPageFields(t *testing.T) {
+	RegisterKV("map", newMapKV)
+	m, err := New("map")
+	assert.Nil(t, err)
+
+	e := &types.Entity{
+		ID:     proto.String("entity1"),
+		Number: proto.Int32(1),
+		Meta: &types.EntityMeta{
+			Shell:        proto.String("/bin/korn"),
+			GECOS:        proto.String("Entity One"),
+			Capabilities: []types.Capability{types.Capability_GLOBAL_ROOT},
+		},
+	}
+	assert.Nil(t, m.SaveEntity(e))
+	assert.Nil(t, m.Index.IndexEntity(e))
+	g := &types.Group{Name: proto.String("group1"), DisplayName: proto.String("Group One"), Number: proto.Int32(1)}
+	assert.Nil(t, m.SaveGroup(g))
+	assert.Nil(t, m.Index.IndexGroup(g))
+
+	res, next, err := m.SearchEntityPage(SearchRequest{
+		Expression: "ID:entity1",
+		Fields:     []string{"meta.Shell", "meta.Capabilities", "meta.Missing"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "", next)
+	assert.Equal(t, []*types.Entity{{
+		ID: proto.String("entity1"),
+		Meta: &types.EntityMeta{
+			Shell:        proto.String("/bin/korn"),
+			Capabilities: []types.Capability{types.Capability_GLOBAL_ROOT},
+		},
+	}}, res)
+
+	gres, _, err := m.SearchGroupPage(SearchRequest{Expression: "Name:group1", Fields: []string{"Number"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []*types.Group{{Name: proto.String("group1"), Number: proto.Int32(1)}}, gres)
+
+	// Without any fields the whole entity is returned.
+	res, _, err = m.SearchEntityPage(SearchRequest{Expression: "ID:entity1"})
+	assert.Nil(t, err)
+	assert.True(t, proto.Equal(e, res[0]))
+}
+
 func TestLoadEntityBatch(t *testing.T) {
 	RegisterKV("mock", newMockKV)
 	m, err := New("mock")
@@ -281,6 +352,11 @@ func TestLoadEntityBatch(t *testing.T) {
 	m.kv.(*mockKV).On("Get", "/entities/entity1").Return(goodEntityBytes1, nil)
 	m.kv.(*mockKV).On("Get", "/entities/entity2").Return(goodEntityBytes2, nil)
 
+	// The pool state is never stored, so each allocation scans
+	// the entities again.
+	m.kv.(*mockKV).On("Get", "/entitynumbers/default").Return([]byte{}, ErrNoValue)
+	m.kv.(*mockKV).On("Put", "/entitynumbers/default", mock.Anything).Return(nil)
+
 	res, err := m.loadEntityBatch([]string{"entity1", "load-error"})
 	assert.NotNil(t, err)
 	assert.Nil(t, res)
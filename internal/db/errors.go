@@ -25,6 +25,51 @@ var (
 	// filled for some reason.
 	ErrBadSearch = errors.New("the provided SearchRequest is invalid")
 
+	// ErrBadPageToken is returned when a search is continued with
+	// a page token that wasn't returned by the same search.
+	ErrBadPageToken = errors.New("the page token does not belong to this search")
+
+	// ErrBadRule is returned when a rule for a dynamic group
+	// can't be parsed.
+	ErrBadRule = errors.New("the rule is malformed")
+
 	// ErrNoValue is returned when no value exists for a given key.
 	ErrNoValue = errors.New("no value exists")
+
+	// ErrTxnClosed is returned when a transaction that has
+	// already been committed or aborted is used again.
+	ErrTxnClosed = errors.New("the transaction is already closed")
+
+	// ErrConflict is returned when an entity or group has been
+	// modified since it was loaded.  The operation may be retried
+	// against the new value.
+	ErrConflict = errors.New("the value has been modified concurrently")
+
+	// ErrUnknownRevision is returned when a revision of an entity
+	// or group is requested that is not kept in the history.
+	ErrUnknownRevision = errors.New("the specified revision does not exist")
+
+	// ErrArchiveVersion is returned when an archive was written
+	// by a newer version of the server than is reading it.
+	ErrArchiveVersion = errors.New("the archive format version is not supported")
+
+	// ErrArchiveChecksum is returned when the contents of an
+	// archive do not match its checksum.
+	ErrArchiveChecksum = errors.New("the archive is corrupt")
+
+	// ErrBadNumberConfig is returned when a number pool or
+	// reserved range is malformed, or pools overlap.
+	ErrBadNumberConfig = errors.New("the number configuration is invalid")
+
+	// ErrUnknownReusePolicy is returned when numbers are
+	// configured with a reuse policy that isn't known.
+	ErrUnknownReusePolicy = errors.New("the number reuse policy is unknown")
+
+	// ErrUnknownNumberPool is returned when a number is requested
+	// from a pool that isn't configured.
+	ErrUnknownNumberPool = errors.New("the number pool does not exist")
+
+	// ErrNumberPoolExhausted is returned when every number in a
+	// pool has been allocated.
+	ErrNumberPoolExhausted = errors.New("the number pool is exhausted")
 )
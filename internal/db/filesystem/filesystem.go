@@ -13,11 +13,13 @@
 package filesystem
 
 import (
+	"bytes"
 	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	atomic "github.com/google/renameio"
 	"github.com/hashicorp/go-hclog"
@@ -32,6 +34,10 @@ import (
 type Filesystem struct {
 	basePath string
 
+	// mu serializes writes so that a compare and swap can't
+	// interleave with another mutation.
+	mu sync.Mutex
+
 	l  hclog.Logger
 	eF func(db.Event)
 }
@@ -51,6 +57,13 @@ var (
 	ErrPathEscape = errors.New("attempted path escape")
 )
 
+const (
+	// journalName is the name of the file that a transaction is
+	// written to before it is applied.  It lives next to the
+	// .mutable flag and is outside of the keyspace.
+	journalName = ".journal"
+)
+
 func init() {
 	startup.RegisterCallback(cb)
 }
@@ -66,6 +79,10 @@ func newKV(l hclog.Logger) (db.KVStore, error) {
 		basePath: filepath.Join(viper.GetString("core.home"), "kv"),
 	}
 
+	if err := x.recoverJournal(); err != nil {
+		return nil, err
+	}
+
 	return x, nil
 }
 
@@ -83,11 +100,10 @@ func (fs *Filesystem) Put(k string, v []byte) error {
 		return err
 	}
 
-	if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
-		return err
-	}
-
-	if err := atomic.WriteFile(p, v, 0640); err != nil {
+	fs.mu.Lock()
+	err = fs.write(p, v)
+	fs.mu.Unlock()
+	if err != nil {
 		return err
 	}
 
@@ -120,11 +136,13 @@ func (fs *Filesystem) Del(k string) error {
 		return err
 	}
 
-	if _, err := os.Stat(p); os.IsNotExist(err) {
+	fs.mu.Lock()
+	err = os.Remove(p)
+	fs.mu.Unlock()
+	if os.IsNotExist(err) {
 		return db.ErrNoValue
 	}
-
-	if err := os.Remove(p); err != nil {
+	if err != nil {
 		return err
 	}
 
@@ -132,6 +150,47 @@ func (fs *Filesystem) Del(k string) error {
 	return nil
 }
 
+// CompareAndSwap replaces the value at k with new only if the value
+// on disk is currently old.  This only protects against other writers
+// within this process, changes made to the filesystem behind the
+// server's back are not detected until they are read.
+func (fs *Filesystem) CompareAndSwap(k string, old, new []byte) error {
+	p, err := fs.cleanPath(k)
+	if err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	cur, err := ioutil.ReadFile(p)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		fs.mu.Unlock()
+		return err
+	}
+	if exists != (old != nil) || !bytes.Equal(cur, old) {
+		fs.mu.Unlock()
+		return db.ErrConflict
+	}
+
+	t := eventUpdate
+	if new == nil {
+		t = eventDelete
+		err = os.Remove(p)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	} else {
+		err = fs.write(p, new)
+	}
+	fs.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	fs.fireEventForKey(k, t)
+	return nil
+}
+
 // Keys is a way to enumerate the keys in the key/value store and to
 // optionally filter them based on a globbing expression.  This cheats
 // and uses superior knowledge that NetAuth uses only a single key
@@ -182,9 +241,169 @@ func (fs *Filesystem) Capabilities() []db.KVCapability {
 		out = append(out, db.KVMutable)
 	}
 
+	// Transactions are always available since they are
+	// implemented with a journal that is replayed on startup.
+	out = append(out, db.KVTransactional, db.KVCompareAndSwap)
+
 	return out
 }
 
+// Begin returns a new transaction.  Transactions on the filesystem
+// are written to a journal which is then applied file by file.  If
+// the process dies part way through, the journal is replayed the next
+// time the store is opened.
+func (fs *Filesystem) Begin() (db.KVTxn, error) {
+	return &txn{fs: fs}, nil
+}
+
+// txn buffers mutations until Commit is called.
+type txn struct {
+	fs     *Filesystem
+	ops    []db.TxnOp
+	closed bool
+}
+
+// Put stages a value to be written on commit.
+func (t *txn) Put(k string, v []byte) error {
+	if t.closed {
+		return db.ErrTxnClosed
+	}
+	if _, err := t.fs.cleanPath(k); err != nil {
+		return err
+	}
+	t.ops = append(t.ops, db.TxnOp{Key: k, Value: v})
+	return nil
+}
+
+// Del stages a key to be removed on commit.
+func (t *txn) Del(k string) error {
+	if t.closed {
+		return db.ErrTxnClosed
+	}
+	if _, err := t.fs.cleanPath(k); err != nil {
+		return err
+	}
+	t.ops = append(t.ops, db.TxnOp{Key: k, Delete: true})
+	return nil
+}
+
+// Commit writes the journal, applies it, and then removes it.  Events
+// are only fired once the journal has been fully applied.
+func (t *txn) Commit() error {
+	if t.closed {
+		return db.ErrTxnClosed
+	}
+	t.closed = true
+
+	j, err := db.EncodeJournal(t.ops)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(t.fs.basePath, 0750); err != nil {
+		return err
+	}
+
+	if err := t.fs.commitJournal(j, t.ops); err != nil {
+		return err
+	}
+
+	for _, op := range t.ops {
+		if op.Delete {
+			t.fs.fireEventForKey(op.Key, eventDelete)
+			continue
+		}
+		t.fs.fireEventForKey(op.Key, eventUpdate)
+	}
+	return nil
+}
+
+// Abort discards the transaction.  Nothing has been written to disk
+// so there is nothing to undo.
+func (t *txn) Abort() error {
+	if t.closed {
+		return db.ErrTxnClosed
+	}
+	t.closed = true
+	t.ops = nil
+	return nil
+}
+
+// commitJournal writes down the journal, applies it, and then
+// removes it.
+func (fs *Filesystem) commitJournal(j []byte, ops []db.TxnOp) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	jPath := filepath.Join(fs.basePath, journalName)
+	if err := atomic.WriteFile(jPath, j, 0640); err != nil {
+		return err
+	}
+
+	if err := fs.applyJournal(ops); err != nil {
+		// The journal is intentionally left behind so that
+		// the transaction will be completed on next startup.
+		return err
+	}
+	return os.Remove(jPath)
+}
+
+// applyJournal writes out the operations in a journal.  Each
+// operation is idempotent so a partially applied journal can be
+// safely applied again.
+func (fs *Filesystem) applyJournal(ops []db.TxnOp) error {
+	for _, op := range ops {
+		p, err := fs.cleanPath(op.Key)
+		if err != nil {
+			return err
+		}
+		if op.Delete {
+			if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		if err := fs.write(p, op.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write atomically replaces the file at p, creating any parent
+// directories as required.
+func (fs *Filesystem) write(p string, v []byte) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+		return err
+	}
+	return atomic.WriteFile(p, v, 0640)
+}
+
+// recoverJournal completes any transaction that was interrupted
+// while it was being applied.
+func (fs *Filesystem) recoverJournal() error {
+	jPath := filepath.Join(fs.basePath, journalName)
+	b, err := ioutil.ReadFile(jPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	ops, err := db.DecodeJournal(b)
+	if err != nil {
+		// A journal that can't be decoded was never fully
+		// written, so none of it was applied.
+		fs.l.Warn("Discarding incomplete transaction journal", "error", err)
+		return os.Remove(jPath)
+	}
+	fs.l.Info("Recovering interrupted transaction", "mutations", len(ops))
+	if err := fs.applyJournal(ops); err != nil {
+		return err
+	}
+	return os.Remove(jPath)
+}
+
 // cleanPath ensures that the path is inside of the base path.  This
 // is only promised to work on *nix systems, as Windows is an unholy
 // hellscape of legacy support that I'm pretty sure would let you
@@ -224,6 +443,8 @@ func (fs *Filesystem) fireEventForKey(k string, t eventType) {
 			Type: db.EventGroupDestroy,
 		})
 	default:
-		fs.l.Warn("Event translation called with unknown key prefix", "type", t, "key", k)
+		// Keys outside of entities and groups, such as the
+		// change log, do not fire events.
+		fs.l.Trace("No event for key", "type", t, "key", k)
 	}
 }
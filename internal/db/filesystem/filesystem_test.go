@@ -1,6 +1,7 @@
 package filesystem
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
@@ -113,12 +114,93 @@ func TestCapabilities(t *testing.T) {
 	assert.Nil(t, err)
 	kv.(*Filesystem).basePath = t.TempDir()
 
-	assert.Equal(t, []db.KVCapability{}, kv.Capabilities())
+	assert.Equal(t, []db.KVCapability{db.KVTransactional, db.KVCompareAndSwap}, kv.Capabilities())
 
 	f, err := os.Create(filepath.Join(kv.(*Filesystem).basePath, ".mutable"))
 	assert.Nil(t, err)
 	f.Close()
-	assert.Equal(t, []db.KVCapability{db.KVMutable}, kv.Capabilities())
+	assert.Equal(t, []db.KVCapability{db.KVMutable, db.KVTransactional, db.KVCompareAndSwap}, kv.Capabilities())
+}
+
+func TestTxnCommit(t *testing.T) {
+	kv, err := newKV(hclog.NewNullLogger())
+	assert.Nil(t, err)
+	kv.(*Filesystem).basePath = t.TempDir()
+	events := []db.Event{}
+	kv.SetEventFunc(func(e db.Event) { events = append(events, e) })
+
+	assert.Nil(t, kv.Put("/groups/group1", []byte("old data")))
+	events = nil
+
+	txn, err := kv.(db.KVTransactor).Begin()
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Put("/entities/entity1", []byte("some data")))
+	assert.Nil(t, txn.Del("/groups/group1"))
+	assert.Equal(t, ErrPathEscape, txn.Put("../out/of/chroot", []byte("evil data")))
+	assert.Equal(t, ErrPathEscape, txn.Del("../out/of/chroot"))
+
+	_, err = kv.Get("/entities/entity1")
+	assert.Equal(t, db.ErrNoValue, err)
+	assert.Equal(t, 0, len(events))
+
+	assert.Nil(t, txn.Commit())
+	res, err := kv.Get("/entities/entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("some data"), res)
+	_, err = kv.Get("/groups/group1")
+	assert.Equal(t, db.ErrNoValue, err)
+	assert.Equal(t, 2, len(events))
+
+	_, err = os.Stat(filepath.Join(kv.(*Filesystem).basePath, journalName))
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, db.ErrTxnClosed, txn.Commit())
+}
+
+func TestTxnAbort(t *testing.T) {
+	kv, err := newKV(hclog.NewNullLogger())
+	assert.Nil(t, err)
+	kv.(*Filesystem).basePath = t.TempDir()
+	kv.SetEventFunc(func(db.Event) { t.Error("Event fired for aborted transaction") })
+
+	txn, err := kv.(db.KVTransactor).Begin()
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Put("/entities/entity1", []byte("some data")))
+	assert.Nil(t, txn.Abort())
+	assert.Equal(t, db.ErrTxnClosed, txn.Abort())
+
+	_, err = kv.Get("/entities/entity1")
+	assert.Equal(t, db.ErrNoValue, err)
+}
+
+func TestRecoverJournal(t *testing.T) {
+	kv, err := newKV(hclog.NewNullLogger())
+	assert.Nil(t, err)
+	fs := kv.(*Filesystem)
+	fs.basePath = t.TempDir()
+
+	// No journal present is not an error.
+	assert.Nil(t, fs.recoverJournal())
+
+	// Simulate a crash after the journal was written.
+	j, err := db.EncodeJournal([]db.TxnOp{
+		{Key: "/entities/entity1", Value: []byte("some data")},
+		{Key: "/groups/group1", Delete: true},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(fs.basePath, journalName), j, 0640))
+	assert.Nil(t, fs.recoverJournal())
+
+	res, err := kv.Get("/entities/entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("some data"), res)
+	_, err = os.Stat(filepath.Join(fs.basePath, journalName))
+	assert.True(t, os.IsNotExist(err))
+
+	// A garbled journal is discarded.
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(fs.basePath, journalName), []byte("{not json"), 0640))
+	assert.Nil(t, fs.recoverJournal())
+	_, err = os.Stat(filepath.Join(fs.basePath, journalName))
+	assert.True(t, os.IsNotExist(err))
 }
 
 type eventHandler struct{ mock.Mock }
@@ -159,3 +241,28 @@ func TestFireEventForKey(t *testing.T) {
 	// trigger an event.
 	ef.AssertNumberOfCalls(t, "FireEvent", 4)
 }
+
+func TestCompareAndSwap(t *testing.T) {
+	kv, err := newKV(hclog.NewNullLogger())
+	assert.Nil(t, err)
+	kv.(*Filesystem).basePath = t.TempDir()
+	events := []db.Event{}
+	kv.SetEventFunc(func(e db.Event) { events = append(events, e) })
+	cas := kv.(db.KVSwapper)
+
+	assert.Nil(t, cas.CompareAndSwap("/entities/entity1", nil, []byte("v1")))
+	assert.Equal(t, db.ErrConflict, cas.CompareAndSwap("/entities/entity1", nil, []byte("v2")))
+	assert.Equal(t, db.ErrConflict, cas.CompareAndSwap("/entities/entity1", []byte("v0"), []byte("v2")))
+	assert.Nil(t, cas.CompareAndSwap("/entities/entity1", []byte("v1"), []byte("v2")))
+
+	res, err := kv.Get("/entities/entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), res)
+
+	assert.Nil(t, cas.CompareAndSwap("/entities/entity1", []byte("v2"), nil))
+	_, err = kv.Get("/entities/entity1")
+	assert.Equal(t, db.ErrNoValue, err)
+	assert.Equal(t, 3, len(events))
+
+	assert.Equal(t, ErrPathEscape, cas.CompareAndSwap("../out/of/chroot", nil, []byte("evil data")))
+}
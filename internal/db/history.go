@@ -0,0 +1,252 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	types "github.com/netauth/protocol"
+)
+
+const (
+	// entityHistoryPrefix and groupHistoryPrefix are the
+	// locations in the KVStore where previous revisions are kept.
+	// Each revision is stored under the ID of the object and the
+	// change log sequence number of the change that created it.
+	entityHistoryPrefix = "/entityhistory/"
+	groupHistoryPrefix  = "/grouphistory/"
+
+	// defaultHistoryDepth is the number of revisions kept for
+	// each entity and group unless configured otherwise.
+	defaultHistoryDepth = 10
+)
+
+// History describes a single revision of an entity or group.  The
+// Seq is the sequence number of the change in the change log that
+// produced this revision.
+type History struct {
+	Seq      uint64
+	Time     time.Time
+	Revision string
+}
+
+// HistoricEntity is an entity as it was at a previous revision.
+type HistoricEntity struct {
+	History
+	Entity *types.Entity
+}
+
+// HistoricGroup is a group as it was at a previous revision.
+type HistoricGroup struct {
+	History
+	Group *types.Group
+}
+
+// historyRecord is the format revisions are stored in.
+type historyRecord struct {
+	History
+	Value []byte
+}
+
+// SetHistoryDepth configures how many revisions are kept for each
+// entity and group, including the current one.  A value of zero
+// stops revisions from being kept, but does not remove those that
+// already exist.
+func (db *DB) SetHistoryDepth(n int) {
+	db.logMu.Lock()
+	defer db.logMu.Unlock()
+	db.historyDepth = n
+}
+
+// EntityHistory returns the revisions that are kept for an entity,
+// oldest first.  The last revision is the current one unless the
+// entity has since been destroyed.
+func (db *DB) EntityHistory(ID string) ([]HistoricEntity, error) {
+	records, err := db.loadHistory(entityHistoryPrefix, ID)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]HistoricEntity, 0, len(records))
+	for _, r := range records {
+		e := &types.Entity{}
+		if err := proto.Unmarshal(r.Value, e); err != nil {
+			db.log.Warn("Error unmarshaling historic entity", "entity", ID, "seq", r.Seq, "error", err)
+			return nil, ErrInternalError
+		}
+		out = append(out, HistoricEntity{History: r.History, Entity: e})
+	}
+	return out, nil
+}
+
+// GroupHistory returns the revisions that are kept for a group,
+// oldest first.  The last revision is the current one unless the
+// group has since been destroyed.
+func (db *DB) GroupHistory(name string) ([]HistoricGroup, error) {
+	records, err := db.loadHistory(groupHistoryPrefix, name)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]HistoricGroup, 0, len(records))
+	for _, r := range records {
+		g := &types.Group{}
+		if err := proto.Unmarshal(r.Value, g); err != nil {
+			db.log.Warn("Error unmarshaling historic group", "group", name, "seq", r.Seq, "error", err)
+			return nil, ErrInternalError
+		}
+		out = append(out, HistoricGroup{History: r.History, Group: g})
+	}
+	return out, nil
+}
+
+// LoadEntityRevision returns an entity as it was at the given
+// revision, or ErrUnknownRevision if that revision is not kept.
+func (db *DB) LoadEntityRevision(ID, rev string) (*types.Entity, error) {
+	h, err := db.EntityHistory(ID)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(h) - 1; i >= 0; i-- {
+		if h[i].Revision == rev {
+			return h[i].Entity, nil
+		}
+	}
+	return nil, ErrUnknownRevision
+}
+
+// LoadGroupRevision returns a group as it was at the given revision,
+// or ErrUnknownRevision if that revision is not kept.
+func (db *DB) LoadGroupRevision(name, rev string) (*types.Group, error) {
+	h, err := db.GroupHistory(name)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(h) - 1; i >= 0; i-- {
+		if h[i].Revision == rev {
+			return h[i].Group, nil
+		}
+	}
+	return nil, ErrUnknownRevision
+}
+
+func (db *DB) loadHistory(prefix, pk string) ([]historyRecord, error) {
+	keys, err := db.historyKeys(prefix, pk)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]historyRecord, 0, len(keys))
+	for _, k := range keys {
+		r, err := db.loadHistoryRecord(k)
+		switch err {
+		case nil:
+		case ErrNoValue:
+			// Pruned since the keys were listed.
+			continue
+		default:
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+func (db *DB) loadHistoryRecord(k string) (historyRecord, error) {
+	r := historyRecord{}
+	b, err := db.kv.Get(k)
+	switch err {
+	case nil:
+	case ErrNoValue:
+		return r, ErrNoValue
+	default:
+		db.log.Warn("Error loading revision", "key", k, "error", err)
+		return r, ErrInternalError
+	}
+	if err := json.Unmarshal(b, &r); err != nil {
+		db.log.Warn("Error unmarshaling revision", "key", k, "error", err)
+		return r, ErrInternalError
+	}
+	return r, nil
+}
+
+// historyKeys returns the keys of the revisions kept for an object,
+// oldest first.
+func (db *DB) historyKeys(prefix, pk string) ([]string, error) {
+	keys, err := db.kv.Keys(prefix + pk + "@*")
+	if err != nil {
+		db.log.Warn("Error listing history", "key", prefix+pk, "error", err)
+		return nil, ErrInternalError
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// historyKey returns the key for a revision.  As with the change log
+// the sequence number is zero padded so that the keys sort in order.
+func historyKey(prefix, pk string, seq uint64) string {
+	return fmt.Sprintf("%s%s@%020d", prefix, pk, seq)
+}
+
+// historyOps returns the mutations that record the new revisions
+// produced by a set of changes, and that remove the revisions that
+// fall outside of the configured depth.  The changes must already
+// have sequence numbers.
+func (db *DB) historyOps(changes []Change) ([]TxnOp, error) {
+	db.logMu.Lock()
+	depth := db.historyDepth
+	db.logMu.Unlock()
+	if depth <= 0 {
+		return nil, nil
+	}
+
+	out := []TxnOp{}
+	for _, c := range changes {
+		var prefix string
+		switch c.Type {
+		case EventEntityUpdate:
+			prefix = entityHistoryPrefix
+		case EventGroupUpdate:
+			prefix = groupHistoryPrefix
+		default:
+			continue
+		}
+
+		keys, err := db.historyKeys(prefix, c.PK)
+		if err != nil {
+			return nil, err
+		}
+
+		// Saving an object without changing it doesn't make a
+		// new revision, otherwise repeated saves would push
+		// the real revisions out of the history.
+		if len(keys) > 0 {
+			last, err := db.loadHistoryRecord(keys[len(keys)-1])
+			if err != nil && err != ErrNoValue {
+				return nil, err
+			}
+			if err == nil && bytes.Equal(last.Value, c.value) {
+				continue
+			}
+		}
+
+		r := historyRecord{
+			History: History{Seq: c.Seq, Time: c.Time, Revision: revision(c.value)},
+			Value:   c.value,
+		}
+		b, err := json.Marshal(r)
+		if err != nil {
+			return nil, ErrInternalError
+		}
+		out = append(out, TxnOp{Key: historyKey(prefix, c.PK, c.Seq), Value: b})
+
+		if drop := len(keys) + 1 - depth; drop > 0 {
+			for _, k := range keys[:drop] {
+				out = append(out, TxnOp{Key: k, Delete: true})
+			}
+		}
+	}
+	return out, nil
+}
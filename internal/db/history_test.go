@@ -0,0 +1,90 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+
+	types "github.com/netauth/protocol"
+)
+
+func TestEntityHistory(t *testing.T) {
+	RegisterKV("map", newMapKV)
+	m, err := New("map")
+	assert.Nil(t, err)
+	m.SetHistoryDepth(2)
+
+	revs := []string{}
+	for i := 1; i <= 3; i++ {
+		e := &types.Entity{ID: proto.String("entity1"), Number: proto.Int32(int32(i))}
+		assert.Nil(t, m.SaveEntity(e))
+		revs = append(revs, EntityRevision(e))
+	}
+
+	// Only the most recent revisions are kept.
+	h, err := m.EntityHistory("entity1")
+	assert.Nil(t, err)
+	assert.Len(t, h, 2)
+	assert.Equal(t, revs[1], h[0].Revision)
+	assert.Equal(t, revs[2], h[1].Revision)
+	assert.Equal(t, int32(3), h[1].Entity.GetNumber())
+	assert.True(t, h[0].Seq < h[1].Seq)
+
+	// Saving the entity again unchanged doesn't add a revision.
+	assert.Nil(t, m.SaveEntity(&types.Entity{ID: proto.String("entity1"), Number: proto.Int32(3)}))
+	h, err = m.EntityHistory("entity1")
+	assert.Nil(t, err)
+	assert.Len(t, h, 2)
+	assert.Equal(t, revs[1], h[0].Revision)
+
+	e, err := m.LoadEntityRevision("entity1", revs[1])
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), e.GetNumber())
+
+	_, err = m.LoadEntityRevision("entity1", revs[0])
+	assert.Equal(t, ErrUnknownRevision, err)
+
+	// History outlives the entity.
+	assert.Nil(t, m.DeleteEntity("entity1"))
+	h, err = m.EntityHistory("entity1")
+	assert.Nil(t, err)
+	assert.Len(t, h, 2)
+
+	// Changes within a transaction are kept too.
+	tx := m.Begin()
+	assert.Nil(t, tx.SaveEntity(&types.Entity{ID: proto.String("entity2")}))
+	assert.Nil(t, tx.Commit())
+	h, err = m.EntityHistory("entity2")
+	assert.Nil(t, err)
+	assert.Len(t, h, 1)
+
+	m.SetHistoryDepth(0)
+	assert.Nil(t, m.SaveEntity(&types.Entity{ID: proto.String("entity2"), Number: proto.Int32(2)}))
+	h, err = m.EntityHistory("entity2")
+	assert.Nil(t, err)
+	assert.Len(t, h, 1)
+}
+
+func TestGroupHistory(t *testing.T) {
+	RegisterKV("map", newMapKV)
+	m, err := New("map")
+	assert.Nil(t, err)
+
+	g := &types.Group{Name: proto.String("group1"), Expansions: []string{"INCLUDE:group2"}}
+	assert.Nil(t, m.SaveGroup(g))
+	rev := GroupRevision(g)
+	assert.Nil(t, m.SaveGroup(&types.Group{Name: proto.String("group1")}))
+
+	h, err := m.GroupHistory("group1")
+	assert.Nil(t, err)
+	assert.Len(t, h, 2)
+
+	g, err = m.LoadGroupRevision("group1", rev)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"INCLUDE:group2"}, g.GetExpansions())
+
+	h, err = m.GroupHistory("group2")
+	assert.Nil(t, err)
+	assert.Len(t, h, 0)
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"path"
+
+	"github.com/blevesearch/bleve"
+)
+
+// IndexReport summarizes the changes that ValidateIndex made to the
+// search index.
+type IndexReport struct {
+	Checked   int
+	Reindexed int
+	Removed   int
+}
+
+// PersistIndex keeps the search index on disk in dir rather than in
+// memory, so that it survives a restart.  Any index already in dir is
+// opened and may be stale, so ValidateIndex should be called before
+// the index is used.
+func (db *DB) PersistIndex(dir string) error {
+	return db.Index.open(dir)
+}
+
+// ValidateIndex brings the search index up to date with the KV store.
+// Entities and groups that have changed since they were indexed are
+// indexed again, and documents for entities and groups that no longer
+// exist are removed.  Documents that are current are left alone, so
+// validating an index that is up to date is much cheaper than
+// building it again.
+func (db *DB) ValidateIndex() (IndexReport, error) {
+	r := IndexReport{}
+
+	ids, err := db.DiscoverEntityIDs()
+	if err != nil {
+		return r, err
+	}
+	seen := make(map[string]bool, len(ids))
+	for _, k := range ids {
+		e, err := db.LoadEntity(path.Base(k))
+		if err != nil {
+			return r, err
+		}
+		seen[e.GetID()] = true
+		r.Checked++
+		if db.Index.current(db.Index.eIndex, e.GetID(), EntityRevision(e)) {
+			continue
+		}
+		if err := db.Index.IndexEntity(e); err != nil {
+			return r, err
+		}
+		r.Reindexed++
+	}
+	n, err := prune(db.Index.eIndex, seen)
+	r.Removed += n
+	if err != nil {
+		return r, err
+	}
+
+	names, err := db.DiscoverGroupNames()
+	if err != nil {
+		return r, err
+	}
+	seen = make(map[string]bool, len(names))
+	for _, k := range names {
+		g, err := db.LoadGroup(path.Base(k))
+		if err != nil {
+			return r, err
+		}
+		seen[g.GetName()] = true
+		r.Checked++
+		if db.Index.current(db.Index.gIndex, g.GetName(), GroupRevision(g)) {
+			continue
+		}
+		if err := db.Index.IndexGroup(g); err != nil {
+			return r, err
+		}
+		r.Reindexed++
+	}
+	n, err = prune(db.Index.gIndex, seen)
+	r.Removed += n
+	return r, err
+}
+
+// prune removes every document from an index that is not in keep,
+// and returns the number of documents that were removed.
+func prune(idx bleve.Index, keep map[string]bool) (int, error) {
+	ids, err := docIDs(idx)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, id := range ids {
+		if keep[id] {
+			continue
+		}
+		if err := deleteDoc(idx, id); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
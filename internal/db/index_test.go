@@ -0,0 +1,63 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+
+	types "github.com/netauth/protocol"
+)
+
+func TestValidateIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "index")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	RegisterKV("map", newMapKV)
+	m, err := New("map")
+	assert.Nil(t, err)
+	assert.Nil(t, m.PersistIndex(dir))
+
+	assert.Nil(t, m.SaveEntity(&types.Entity{ID: proto.String("entity1")}))
+	assert.Nil(t, m.SaveEntity(&types.Entity{ID: proto.String("entity2")}))
+	assert.Nil(t, m.SaveGroup(&types.Group{Name: proto.String("group1")}))
+
+	r, err := m.ValidateIndex()
+	assert.Nil(t, err)
+	assert.Equal(t, IndexReport{Checked: 3, Reindexed: 3}, r)
+
+	// Nothing has changed, so nothing is indexed again.
+	r, err = m.ValidateIndex()
+	assert.Nil(t, err)
+	assert.Equal(t, IndexReport{Checked: 3}, r)
+
+	// Changes made without the index seeing them are found.
+	assert.Nil(t, m.SaveEntity(&types.Entity{ID: proto.String("entity1"), Number: proto.Int32(1)}))
+	assert.Nil(t, m.DeleteEntity("entity2"))
+	r, err = m.ValidateIndex()
+	assert.Nil(t, err)
+	assert.Equal(t, IndexReport{Checked: 2, Reindexed: 1, Removed: 1}, r)
+
+	ids, err := m.Index.SearchEntities(SearchRequest{Expression: "ID:entity2"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(ids))
+
+	// The index outlives the server, but is validated against
+	// whatever is in the KV store when it is opened again.
+	assert.Nil(t, m.Index.Close())
+	m, err = New("map")
+	assert.Nil(t, err)
+	assert.Nil(t, m.PersistIndex(dir))
+
+	ids, err = m.Index.SearchEntities(SearchRequest{Expression: "ID:entity1"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"entity1"}, ids)
+
+	r, err = m.ValidateIndex()
+	assert.Nil(t, err)
+	assert.Equal(t, IndexReport{Removed: 2}, r)
+	assert.Nil(t, m.Index.Close())
+}
@@ -6,6 +6,7 @@ package db
 
 import (
 	"errors"
+	"strings"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/hashicorp/go-hclog"
@@ -28,9 +29,28 @@ type mockKV struct {
 func newMockKV(hclog.Logger) (KVStore, error) {
 	x := &mockKV{}
 	x.On("SetEventFunc", mock.Anything).Return()
+	x.allowChangeLog()
 	return x, nil
 }
 
+// allowChangeLog permits the change log to be written without each
+// test needing to expect it.
+func (mkv *mockKV) allowChangeLog() {
+	mkv.On("Get", changeLogSeqKey).Return([]byte{}, ErrNoValue).Maybe()
+	mkv.On("Put", changeLogSeqKey, mock.Anything).Return(nil).Maybe()
+	mkv.On("Put", mock.MatchedBy(isChangeKey), mock.Anything).Return(nil).Maybe()
+	mkv.On("Keys", mock.MatchedBy(isHistoryKey)).Return([]string{}, nil).Maybe()
+	mkv.On("Put", mock.MatchedBy(isHistoryKey), mock.Anything).Return(nil).Maybe()
+}
+
+func isChangeKey(k string) bool {
+	return strings.HasPrefix(k, changeLogPrefix)
+}
+
+func isHistoryKey(k string) bool {
+	return strings.HasPrefix(k, entityHistoryPrefix) || strings.HasPrefix(k, groupHistoryPrefix)
+}
+
 func newMockKVError(hclog.Logger) (KVStore, error) {
 	return nil, errors.New("Initialization error")
 }
@@ -3,6 +3,7 @@
 package memory
 
 import (
+	"bytes"
 	"path"
 	"strings"
 	"sync"
@@ -58,18 +59,7 @@ func (kv *KV) Put(k string, v []byte) error {
 	kv.m[k] = v
 	kv.Unlock()
 
-	switch {
-	case strings.HasPrefix(k, "/entities"):
-		kv.eF(db.Event{
-			Type: db.EventEntityUpdate,
-			PK:   path.Base(k),
-		})
-	case strings.HasPrefix(k, "/groups"):
-		kv.eF(db.Event{
-			Type: db.EventGroupUpdate,
-			PK:   path.Base(k),
-		})
-	}
+	kv.fireEventForKey(k, false)
 	return nil
 }
 
@@ -93,18 +83,7 @@ func (kv *KV) Del(k string) error {
 	kv.Unlock()
 	kv.l.Trace("DEL", "key", k)
 
-	switch {
-	case strings.HasPrefix(k, "/entities"):
-		kv.eF(db.Event{
-			Type: db.EventEntityDestroy,
-			PK:   path.Base(k),
-		})
-	case strings.HasPrefix(k, "/groups"):
-		kv.eF(db.Event{
-			Type: db.EventGroupDestroy,
-			PK:   path.Base(k),
-		})
-	}
+	kv.fireEventForKey(k, true)
 	return nil
 }
 
@@ -132,5 +111,121 @@ func (kv *KV) Close() error { return nil }
 
 // Capabilities is used to interrogate a KV store for capabilities.
 func (kv *KV) Capabilities() []db.KVCapability {
-	return []db.KVCapability{db.KVMutable}
+	return []db.KVCapability{db.KVMutable, db.KVTransactional, db.KVCompareAndSwap}
+}
+
+// CompareAndSwap replaces the value at k with new only if the current
+// value is old.
+func (kv *KV) CompareAndSwap(k string, old, new []byte) error {
+	kv.Lock()
+	cur, exists := kv.m[k]
+	if exists != (old != nil) || !bytes.Equal(cur, old) {
+		kv.Unlock()
+		kv.l.Trace("CAS conflict", "key", k)
+		return db.ErrConflict
+	}
+	if new == nil {
+		delete(kv.m, k)
+	} else {
+		kv.m[k] = new
+	}
+	kv.Unlock()
+	kv.l.Trace("CAS", "key", k, "value", new)
+
+	kv.fireEventForKey(k, new == nil)
+	return nil
+}
+
+// Begin returns a transaction that buffers mutations until it is
+// committed.
+func (kv *KV) Begin() (db.KVTxn, error) {
+	return &txn{kv: kv}, nil
+}
+
+// txn is an in-memory transaction.  Since the entire store is held
+// behind a single lock, a commit is trivially atomic.
+type txn struct {
+	kv     *KV
+	ops    []db.TxnOp
+	closed bool
+}
+
+// Put stages a value to be stored on commit.
+func (t *txn) Put(k string, v []byte) error {
+	if t.closed {
+		return db.ErrTxnClosed
+	}
+	t.ops = append(t.ops, db.TxnOp{Key: k, Value: v})
+	return nil
+}
+
+// Del stages a key to be removed on commit.
+func (t *txn) Del(k string) error {
+	if t.closed {
+		return db.ErrTxnClosed
+	}
+	t.ops = append(t.ops, db.TxnOp{Key: k, Delete: true})
+	return nil
+}
+
+// Commit applies all staged mutations under a single lock and then
+// fires the events for each of them.
+func (t *txn) Commit() error {
+	if t.closed {
+		return db.ErrTxnClosed
+	}
+	t.closed = true
+
+	t.kv.Lock()
+	for _, op := range t.ops {
+		if op.Delete {
+			delete(t.kv.m, op.Key)
+			continue
+		}
+		t.kv.m[op.Key] = op.Value
+	}
+	t.kv.Unlock()
+	t.kv.l.Trace("COMMIT", "mutations", len(t.ops))
+
+	for _, op := range t.ops {
+		t.kv.fireEventForKey(op.Key, op.Delete)
+	}
+	return nil
+}
+
+// Abort discards the transaction.
+func (t *txn) Abort() error {
+	if t.closed {
+		return db.ErrTxnClosed
+	}
+	t.closed = true
+	t.ops = nil
+	return nil
+}
+
+// fireEventForKey maps from a key to an entity or group and fires an
+// appropriate event for the given key.
+func (kv *KV) fireEventForKey(k string, del bool) {
+	switch {
+	case strings.HasPrefix(k, "/entities") && del:
+		kv.eF(db.Event{
+			Type: db.EventEntityDestroy,
+			PK:   path.Base(k),
+		})
+	case strings.HasPrefix(k, "/entities"):
+		kv.eF(db.Event{
+			Type: db.EventEntityUpdate,
+			PK:   path.Base(k),
+		})
+	case strings.HasPrefix(k, "/groups") && del:
+		kv.eF(db.Event{
+			Type: db.EventGroupDestroy,
+			PK:   path.Base(k),
+		})
+	case strings.HasPrefix(k, "/groups"):
+		kv.eF(db.Event{
+			Type: db.EventGroupUpdate,
+			PK:   path.Base(k),
+		})
+	}
 }
@@ -94,5 +94,76 @@ func TestCapabilities(t *testing.T) {
 	kv, _ := NewKV(hclog.NewNullLogger())
 	kv.SetEventFunc(func(db.Event) {})
 
-	assert.Equal(t, []db.KVCapability{db.KVMutable}, kv.Capabilities())
+	assert.Equal(t, []db.KVCapability{db.KVMutable, db.KVTransactional, db.KVCompareAndSwap}, kv.Capabilities())
+}
+
+func TestTxnCommit(t *testing.T) {
+	kv, _ := NewKV(hclog.NewNullLogger())
+	events := []db.Event{}
+	kv.SetEventFunc(func(e db.Event) { events = append(events, e) })
+
+	kv.(*KV).m["/groups/group1"] = []byte("old data")
+
+	txn, err := kv.(db.KVTransactor).Begin()
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Put("/entities/entity1", []byte("some data")))
+	assert.Nil(t, txn.Del("/groups/group1"))
+
+	// Nothing is visible and nothing has fired until commit.
+	_, err = kv.Get("/entities/entity1")
+	assert.Equal(t, db.ErrNoValue, err)
+	assert.Equal(t, 0, len(events))
+
+	assert.Nil(t, txn.Commit())
+	assert.Equal(t, []byte("some data"), kv.(*KV).m["/entities/entity1"])
+	_, exists := kv.(*KV).m["/groups/group1"]
+	assert.False(t, exists)
+	assert.Equal(t, []db.Event{
+		{Type: db.EventEntityUpdate, PK: "entity1"},
+		{Type: db.EventGroupDestroy, PK: "group1"},
+	}, events)
+
+	assert.Equal(t, db.ErrTxnClosed, txn.Commit())
+	assert.Equal(t, db.ErrTxnClosed, txn.Put("/entities/entity2", nil))
+}
+
+func TestTxnAbort(t *testing.T) {
+	kv, _ := NewKV(hclog.NewNullLogger())
+	kv.SetEventFunc(func(db.Event) { t.Error("Event fired for aborted transaction") })
+
+	txn, err := kv.(db.KVTransactor).Begin()
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Put("/entities/entity1", []byte("some data")))
+	assert.Nil(t, txn.Abort())
+
+	_, exists := kv.(*KV).m["/entities/entity1"]
+	assert.False(t, exists)
+	assert.Equal(t, db.ErrTxnClosed, txn.Abort())
+	assert.Equal(t, db.ErrTxnClosed, txn.Del("/entities/entity1"))
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	kv, _ := NewKV(hclog.NewNullLogger())
+	events := []db.Event{}
+	kv.SetEventFunc(func(e db.Event) { events = append(events, e) })
+	cas := kv.(db.KVSwapper)
+
+	// A nil old value asserts the key does not exist.
+	assert.Nil(t, cas.CompareAndSwap("/entities/entity1", nil, []byte("v1")))
+	assert.Equal(t, db.ErrConflict, cas.CompareAndSwap("/entities/entity1", nil, []byte("v2")))
+
+	assert.Equal(t, db.ErrConflict, cas.CompareAndSwap("/entities/entity1", []byte("v0"), []byte("v2")))
+	assert.Nil(t, cas.CompareAndSwap("/entities/entity1", []byte("v1"), []byte("v2")))
+	assert.Equal(t, []byte("v2"), kv.(*KV).m["/entities/entity1"])
+
+	// A nil new value deletes the key.
+	assert.Nil(t, cas.CompareAndSwap("/entities/entity1", []byte("v2"), nil))
+	_, exists := kv.(*KV).m["/entities/entity1"]
+	assert.False(t, exists)
+
+	assert.Equal(t, []db.Event{
+		{Type: db.EventEntityUpdate, PK: "entity1"},
+		{Type: db.EventEntityUpdate, PK: "entity1"},
+		{Type: db.EventEntityDestroy, PK: "entity1"},
+	}, events)
 }
@@ -0,0 +1,565 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+
+	types "github.com/netauth/protocol"
+)
+
+const (
+	// entityNumberPrefix and groupNumberPrefix are the locations
+	// in the KVStore where the state of each number pool is
+	// kept.
+	entityNumberPrefix = "/entitynumbers/"
+	groupNumberPrefix  = "/groupnumbers/"
+
+	// DefaultNumberPool is the name of the pool that is used when
+	// no pools are configured.
+	DefaultNumberPool = "default"
+)
+
+// The policies for numbers that have been released.
+const (
+	// ReuseNever never hands out a number a second time.  This
+	// is the default, and matches the behavior of a server
+	// without number pools.
+	ReuseNever = "never"
+
+	// ReuseFreed hands out the lowest released number in a pool
+	// before any number that has never been used.
+	ReuseFreed = "freed"
+)
+
+// A NumberRange is an inclusive range of numbers.
+type NumberRange struct {
+	Min int32
+	Max int32
+}
+
+// Contains returns true if n is within the range.
+func (r NumberRange) Contains(n int32) bool {
+	return n >= r.Min && n <= r.Max
+}
+
+func (r NumberRange) String() string {
+	if r.Min == r.Max {
+		return strconv.Itoa(int(r.Min))
+	}
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+// A NumberPool is a named range from which numbers are allocated.
+type NumberPool struct {
+	Name string
+	NumberRange
+}
+
+func (p NumberPool) String() string {
+	return p.Name + ":" + p.NumberRange.String()
+}
+
+// NumberConfig controls how numbers are allocated for either entities
+// or groups.  The first pool is used when no pool is requested.
+// Reserved numbers are never allocated, even if they fall within a
+// pool.
+type NumberConfig struct {
+	Pools    []NumberPool
+	Reserved []NumberRange
+	Reuse    string
+}
+
+// poolState is the format the state of a pool is stored in.  Next is
+// the lowest number that has never been allocated, and is wide
+// enough to point past the end of a pool that ends at the largest
+// number.  Free holds released numbers, and Taken holds numbers at or
+// above Next that were assigned explicitly, both in ascending order.
+type poolState struct {
+	Next  int64
+	Free  []int32 `json:",omitempty"`
+	Taken []int32 `json:",omitempty"`
+}
+
+// ParseNumberRange parses a range written as "min-max", or a single
+// number.
+func ParseNumberRange(s string) (NumberRange, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), "-", 2)
+	min, err := strconv.ParseInt(parts[0], 10, 32)
+	if err != nil {
+		return NumberRange{}, fmt.Errorf("%q: %v", s, ErrBadNumberConfig)
+	}
+	max := min
+	if len(parts) == 2 {
+		max, err = strconv.ParseInt(parts[1], 10, 32)
+		if err != nil {
+			return NumberRange{}, fmt.Errorf("%q: %v", s, ErrBadNumberConfig)
+		}
+	}
+	r := NumberRange{Min: int32(min), Max: int32(max)}
+	if r.Min < 0 || r.Min > r.Max {
+		return NumberRange{}, fmt.Errorf("%q: %v", s, ErrBadNumberConfig)
+	}
+	return r, nil
+}
+
+// ParseNumberPool parses a pool written as "name:min-max".
+func ParseNumberPool(s string) (NumberPool, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return NumberPool{}, fmt.Errorf("%q: %v", s, ErrBadNumberConfig)
+	}
+	r, err := ParseNumberRange(parts[1])
+	if err != nil {
+		return NumberPool{}, err
+	}
+	return NumberPool{Name: parts[0], NumberRange: r}, nil
+}
+
+// defaultNumberConfig allocates every number from 1 upwards, which is
+// how numbers were allocated before pools existed.
+func defaultNumberConfig() NumberConfig {
+	return NumberConfig{
+		Pools: []NumberPool{{Name: DefaultNumberPool, NumberRange: NumberRange{Min: 1, Max: math.MaxInt32}}},
+		Reuse: ReuseNever,
+	}
+}
+
+// SetEntityNumbers configures how entity numbers are allocated.
+func (db *DB) SetEntityNumbers(c NumberConfig) error {
+	return db.setNumbers(entityNumberPrefix, c)
+}
+
+// SetGroupNumbers configures how group numbers are allocated.
+func (db *DB) SetGroupNumbers(c NumberConfig) error {
+	return db.setNumbers(groupNumberPrefix, c)
+}
+
+// EntityNumbers returns the configuration for entity numbers.
+func (db *DB) EntityNumbers() NumberConfig {
+	return db.numberConfig(entityNumberPrefix)
+}
+
+// GroupNumbers returns the configuration for group numbers.
+func (db *DB) GroupNumbers() NumberConfig {
+	return db.numberConfig(groupNumberPrefix)
+}
+
+func (db *DB) setNumbers(prefix string, c NumberConfig) error {
+	if len(c.Pools) == 0 {
+		c.Pools = defaultNumberConfig().Pools
+	}
+	if c.Reuse == "" {
+		c.Reuse = ReuseNever
+	}
+	if c.Reuse != ReuseNever && c.Reuse != ReuseFreed {
+		return ErrUnknownReusePolicy
+	}
+
+	sorted := append([]NumberPool{}, c.Pools...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Min < sorted[j].Min })
+	names := make(map[string]bool, len(sorted))
+	for i, p := range sorted {
+		if p.Name == "" || p.Min < 0 || p.Min > p.Max || names[p.Name] {
+			return fmt.Errorf("%s: %v", p, ErrBadNumberConfig)
+		}
+		names[p.Name] = true
+		if i > 0 && sorted[i-1].Max >= p.Min {
+			return fmt.Errorf("%s overlaps %s: %v", p, sorted[i-1], ErrBadNumberConfig)
+		}
+	}
+
+	db.numMu.Lock()
+	defer db.numMu.Unlock()
+	if db.numbers == nil {
+		db.numbers = make(map[string]NumberConfig)
+	}
+	db.numbers[prefix] = c
+	return nil
+}
+
+func (db *DB) numberConfig(prefix string) NumberConfig {
+	db.numMu.Lock()
+	defer db.numMu.Unlock()
+	if c, ok := db.numbers[prefix]; ok {
+		return c
+	}
+	return defaultNumberConfig()
+}
+
+// NextEntityNumber allocates a number for a new entity from the
+// default pool.
+func (db *DB) NextEntityNumber() (int32, error) {
+	return db.AllocateEntityNumber("")
+}
+
+// NextGroupNumber allocates a number for a new group from the
+// default pool.
+func (db *DB) NextGroupNumber() (int32, error) {
+	return db.AllocateGroupNumber("")
+}
+
+// AllocateEntityNumber allocates a number for a new entity from the
+// named pool, or the default pool if the name is empty.
+func (db *DB) AllocateEntityNumber(pool string) (int32, error) {
+	return db.allocate(nil, entityNumberPrefix, pool)
+}
+
+// AllocateGroupNumber allocates a number for a new group from the
+// named pool, or the default pool if the name is empty.
+func (db *DB) AllocateGroupNumber(pool string) (int32, error) {
+	return db.allocate(nil, groupNumberPrefix, pool)
+}
+
+// ClaimEntityNumber records that a number was assigned to an entity
+// explicitly, so that it will not also be allocated.  Numbers outside
+// of every pool are ignored.
+func (db *DB) ClaimEntityNumber(n int32) error {
+	return db.claim(nil, entityNumberPrefix, n)
+}
+
+// ClaimGroupNumber records that a number was assigned to a group
+// explicitly.
+func (db *DB) ClaimGroupNumber(n int32) error {
+	return db.claim(nil, groupNumberPrefix, n)
+}
+
+// ReleaseEntityNumber returns the number of a destroyed entity to its
+// pool.  Whether it is allocated again depends on the reuse policy.
+func (db *DB) ReleaseEntityNumber(n int32) error {
+	return db.release(nil, entityNumberPrefix, n)
+}
+
+// ReleaseGroupNumber returns the number of a destroyed group to its
+// pool.
+func (db *DB) ReleaseGroupNumber(n int32) error {
+	return db.release(nil, groupNumberPrefix, n)
+}
+
+// NextEntityNumber allocates a number for a new entity from the
+// default pool.  The allocation is only kept if the transaction
+// commits.
+func (t *Txn) NextEntityNumber() (int32, error) {
+	return t.AllocateEntityNumber("")
+}
+
+// NextGroupNumber allocates a number for a new group from the
+// default pool within the transaction.
+func (t *Txn) NextGroupNumber() (int32, error) {
+	return t.AllocateGroupNumber("")
+}
+
+// AllocateEntityNumber allocates a number for a new entity from the
+// named pool within the transaction.
+func (t *Txn) AllocateEntityNumber(pool string) (int32, error) {
+	return t.db.allocate(t, entityNumberPrefix, pool)
+}
+
+// AllocateGroupNumber allocates a number for a new group from the
+// named pool within the transaction.
+func (t *Txn) AllocateGroupNumber(pool string) (int32, error) {
+	return t.db.allocate(t, groupNumberPrefix, pool)
+}
+
+// ClaimEntityNumber records within the transaction that a number was
+// assigned to an entity explicitly.
+func (t *Txn) ClaimEntityNumber(n int32) error {
+	return t.db.claim(t, entityNumberPrefix, n)
+}
+
+// ClaimGroupNumber records within the transaction that a number was
+// assigned to a group explicitly.
+func (t *Txn) ClaimGroupNumber(n int32) error {
+	return t.db.claim(t, groupNumberPrefix, n)
+}
+
+// ReleaseEntityNumber returns the number of a destroyed entity to its
+// pool within the transaction.
+func (t *Txn) ReleaseEntityNumber(n int32) error {
+	return t.db.release(t, entityNumberPrefix, n)
+}
+
+// ReleaseGroupNumber returns the number of a destroyed group to its
+// pool within the transaction.
+func (t *Txn) ReleaseGroupNumber(n int32) error {
+	return t.db.release(t, groupNumberPrefix, n)
+}
+
+func (db *DB) allocate(tx *Txn, prefix, name string) (int32, error) {
+	c := db.numberConfig(prefix)
+	pool, ok := c.Pools[0], name == ""
+	for _, p := range c.Pools {
+		if p.Name == name {
+			pool, ok = p, true
+		}
+	}
+	if !ok {
+		return 0, ErrUnknownNumberPool
+	}
+
+	db.numMu.Lock()
+	defer db.numMu.Unlock()
+
+	st, err := db.loadPool(tx, prefix, pool)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int32
+	for {
+		if c.Reuse == ReuseFreed && len(st.Free) > 0 {
+			n, st.Free = st.Free[0], st.Free[1:]
+			if _, ok := reservedRange(c.Reserved, n); ok {
+				continue
+			}
+			break
+		}
+
+		if st.Next > int64(pool.Max) {
+			return 0, ErrNumberPoolExhausted
+		}
+		n = int32(st.Next)
+		st.Next++
+		// Numbers below n were skipped over by a jump past a
+		// reserved range, and can no longer be handed out.
+		for len(st.Taken) > 0 && st.Taken[0] < n {
+			st.Taken = st.Taken[1:]
+		}
+		if len(st.Taken) > 0 && st.Taken[0] == n {
+			st.Taken = st.Taken[1:]
+			continue
+		}
+		if r, ok := reservedRange(c.Reserved, n); ok {
+			st.Next = int64(r.Max) + 1
+			continue
+		}
+		break
+	}
+
+	if err := db.savePool(tx, prefix, pool.Name, st); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (db *DB) claim(tx *Txn, prefix string, n int32) error {
+	c := db.numberConfig(prefix)
+	pool, ok := poolFor(c.Pools, n)
+	if !ok {
+		return nil
+	}
+
+	db.numMu.Lock()
+	defer db.numMu.Unlock()
+
+	st, err := db.loadPool(tx, prefix, pool)
+	if err != nil {
+		return err
+	}
+	switch {
+	case int64(n) == st.Next:
+		st.Next++
+	case int64(n) > st.Next:
+		st.Taken = insertNumber(st.Taken, n)
+	default:
+		st.Free = removeNumber(st.Free, n)
+	}
+	return db.savePool(tx, prefix, pool.Name, st)
+}
+
+func (db *DB) release(tx *Txn, prefix string, n int32) error {
+	c := db.numberConfig(prefix)
+	if c.Reuse != ReuseFreed {
+		return nil
+	}
+	pool, ok := poolFor(c.Pools, n)
+	if !ok {
+		return nil
+	}
+	if _, ok := reservedRange(c.Reserved, n); ok {
+		return nil
+	}
+
+	db.numMu.Lock()
+	defer db.numMu.Unlock()
+
+	st, err := db.loadPool(tx, prefix, pool)
+	if err != nil {
+		return err
+	}
+	if int64(n) >= st.Next {
+		// The number was claimed explicitly ahead of the
+		// allocator, and need no longer be skipped.
+		st.Taken = removeNumber(st.Taken, n)
+	} else {
+		st.Free = insertNumber(st.Free, n)
+	}
+	return db.savePool(tx, prefix, pool.Name, st)
+}
+
+// loadPool loads the state of a pool.  A pool that has no state yet
+// is initialized to follow the largest number already in the pool,
+// which is the only time that the records are read.
+func (db *DB) loadPool(tx *Txn, prefix string, pool NumberPool) (poolState, error) {
+	k := prefix + pool.Name
+	st := poolState{}
+	b, err := db.get(tx, k)
+	switch err {
+	case nil:
+		if err := json.Unmarshal(b, &st); err != nil {
+			db.log.Warn("Error unmarshaling number pool", "pool", k, "error", err)
+			return st, ErrInternalError
+		}
+		// The pool may have been moved since it was last
+		// used.
+		if st.Next < int64(pool.Min) {
+			st.Next = int64(pool.Min)
+		}
+		return st, nil
+	case ErrNoValue:
+	default:
+		db.log.Warn("Error loading number pool", "pool", k, "error", err)
+		return st, ErrInternalError
+	}
+
+	st.Next = int64(pool.Min)
+	numbers, err := db.scanNumbers(prefix)
+	if err != nil {
+		return st, err
+	}
+	for _, n := range numbers {
+		if pool.Contains(n) && int64(n) >= st.Next {
+			st.Next = int64(n) + 1
+		}
+	}
+	db.log.Debug("Initialized number pool", "pool", k, "next", st.Next)
+	return st, nil
+}
+
+func (db *DB) savePool(tx *Txn, prefix, name string, st poolState) error {
+	k := prefix + name
+	b, err := json.Marshal(st)
+	if err != nil {
+		return ErrInternalError
+	}
+	if tx != nil {
+		tx.stage(TxnOp{Key: k, Value: b})
+		return nil
+	}
+
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	if err := db.kv.Put(k, b); err != nil {
+		db.log.Warn("Error storing number pool", "pool", k, "error", err)
+		return ErrInternalError
+	}
+	return nil
+}
+
+// scanNumbers returns the numbers of every entity or group.  The
+// records are read directly from the KVStore so that an open
+// transaction does not depend on all of them.
+func (db *DB) scanNumbers(prefix string) ([]int32, error) {
+	pattern := "/entities/*"
+	if prefix == groupNumberPrefix {
+		pattern = "/groups/*"
+	}
+	keys, err := db.kv.Keys(pattern)
+	if err != nil {
+		db.log.Warn("Error listing keys for number pool", "pattern", pattern, "error", err)
+		return nil, ErrInternalError
+	}
+
+	out := make([]int32, 0, len(keys))
+	for _, k := range keys {
+		b, err := db.kv.Get(k)
+		switch err {
+		case nil:
+		case ErrNoValue:
+			continue
+		default:
+			db.log.Warn("Error reading key for number pool", "key", k, "error", err)
+			return nil, ErrInternalError
+		}
+
+		var m interface {
+			proto.Message
+			GetNumber() int32
+		}
+		if path.Dir(k) == "/entities" {
+			m = &types.Entity{}
+		} else {
+			m = &types.Group{}
+		}
+		if err := proto.Unmarshal(b, m); err != nil {
+			db.log.Warn("Error unmarshaling record for number pool", "key", k, "error", err)
+			return nil, ErrInternalError
+		}
+		out = append(out, m.GetNumber())
+	}
+	return out, nil
+}
+
+// ResetNumberPools discards the state of every number pool in a
+// KVStore, so that each pool is initialized again from the records
+// the next time it is used.  This must be done whenever records are
+// written without going through a DB.
+func ResetNumberPools(kv KVStore) error {
+	for _, prefix := range []string{entityNumberPrefix, groupNumberPrefix} {
+		keys, err := kv.Keys(prefix + "*")
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := kv.Del(k); err != nil && err != ErrNoValue {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func poolFor(pools []NumberPool, n int32) (NumberPool, bool) {
+	for _, p := range pools {
+		if p.Contains(n) {
+			return p, true
+		}
+	}
+	return NumberPool{}, false
+}
+
+func reservedRange(reserved []NumberRange, n int32) (NumberRange, bool) {
+	for _, r := range reserved {
+		if r.Contains(n) {
+			return r, true
+		}
+	}
+	return NumberRange{}, false
+}
+
+// insertNumber adds n to a sorted list if it is not already there.
+func insertNumber(list []int32, n int32) []int32 {
+	i := sort.Search(len(list), func(i int) bool { return list[i] >= n })
+	if i < len(list) && list[i] == n {
+		return list
+	}
+	list = append(list, 0)
+	copy(list[i+1:], list[i:])
+	list[i] = n
+	return list
+}
+
+// removeNumber removes n from a sorted list.
+func removeNumber(list []int32, n int32) []int32 {
+	i := sort.Search(len(list), func(i int) bool { return list[i] >= n })
+	if i == len(list) || list[i] != n {
+		return list
+	}
+	return append(list[:i], list[i+1:]...)
+}
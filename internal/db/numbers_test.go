@@ -0,0 +1,174 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+
+	types "github.com/netauth/protocol"
+)
+
+func TestParseNumberPool(t *testing.T) {
+	p, err := ParseNumberPool("humans:10000-59999")
+	assert.Nil(t, err)
+	assert.Equal(t, NumberPool{Name: "humans", NumberRange: NumberRange{Min: 10000, Max: 59999}}, p)
+	assert.Equal(t, "humans:10000-59999", p.String())
+
+	r, err := ParseNumberRange("65534")
+	assert.Nil(t, err)
+	assert.Equal(t, NumberRange{Min: 65534, Max: 65534}, r)
+
+	for _, s := range []string{"humans", ":1-2", "humans:2-1", "humans:a-b", "-1"} {
+		_, err := ParseNumberPool(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestSetNumbers(t *testing.T) {
+	RegisterKV("map", newMapKV)
+	m, err := New("map")
+	assert.Nil(t, err)
+
+	assert.Equal(t, DefaultNumberPool, m.EntityNumbers().Pools[0].Name)
+
+	err = m.SetEntityNumbers(NumberConfig{Reuse: "sometimes"})
+	assert.Equal(t, ErrUnknownReusePolicy, err)
+
+	err = m.SetEntityNumbers(NumberConfig{Pools: []NumberPool{
+		{Name: "a", NumberRange: NumberRange{Min: 10, Max: 20}},
+		{Name: "b", NumberRange: NumberRange{Min: 20, Max: 30}},
+	}})
+	assert.NotNil(t, err)
+}
+
+func TestAllocateNumbers(t *testing.T) {
+	RegisterKV("map", newMapKV)
+	m, err := New("map")
+	assert.Nil(t, err)
+
+	assert.Nil(t, m.SetEntityNumbers(NumberConfig{
+		Pools: []NumberPool{
+			{Name: "humans", NumberRange: NumberRange{Min: 10000, Max: 59999}},
+			{Name: "service", NumberRange: NumberRange{Min: 900, Max: 902}},
+		},
+		Reserved: []NumberRange{{Min: 10001, Max: 10002}},
+	}))
+
+	// Existing records are only read to initialize the pool.
+	assert.Nil(t, m.SaveEntity(&types.Entity{ID: proto.String("existing"), Number: proto.Int32(900)}))
+
+	n, err := m.AllocateEntityNumber("")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(10000), n)
+
+	// Reserved numbers are skipped.
+	n, err = m.NextEntityNumber()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(10003), n)
+
+	// Explicitly assigned numbers are skipped.
+	assert.Nil(t, m.ClaimEntityNumber(10004))
+	n, err = m.NextEntityNumber()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(10005), n)
+
+	n, err = m.AllocateEntityNumber("service")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(901), n)
+
+	// A record written after initialization does not change the
+	// pool.
+	assert.Nil(t, m.SaveEntity(&types.Entity{ID: proto.String("late"), Number: proto.Int32(902)}))
+	n, err = m.AllocateEntityNumber("service")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(902), n)
+
+	_, err = m.AllocateEntityNumber("service")
+	assert.Equal(t, ErrNumberPoolExhausted, err)
+
+	_, err = m.AllocateEntityNumber("robots")
+	assert.Equal(t, ErrUnknownNumberPool, err)
+
+	// Without reuse released numbers are gone for good.
+	assert.Nil(t, m.ReleaseEntityNumber(10000))
+	n, err = m.NextEntityNumber()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(10006), n)
+
+	// Allocations in an aborted transaction are discarded.
+	tx := m.Begin()
+	n, err = tx.NextEntityNumber()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(10007), n)
+	assert.Nil(t, tx.Abort())
+	n, err = m.NextEntityNumber()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(10007), n)
+
+	// Once the pool state is gone it is rebuilt from the records.
+	assert.Nil(t, ResetNumberPools(m.kv))
+	_, err = m.AllocateEntityNumber("service")
+	assert.Equal(t, ErrNumberPoolExhausted, err)
+}
+
+func TestReuseNumbers(t *testing.T) {
+	RegisterKV("map", newMapKV)
+	m, err := New("map")
+	assert.Nil(t, err)
+
+	assert.Nil(t, m.SetGroupNumbers(NumberConfig{
+		Pools:    []NumberPool{{Name: "groups", NumberRange: NumberRange{Min: 100, Max: 199}}},
+		Reserved: []NumberRange{{Min: 101, Max: 101}},
+		Reuse:    ReuseFreed,
+	}))
+
+	for _, want := range []int32{100, 102, 103} {
+		n, err := m.NextGroupNumber()
+		assert.Nil(t, err)
+		assert.Equal(t, want, n)
+	}
+
+	// The lowest released number is used first, and reserved
+	// or out of pool numbers are never released.
+	assert.Nil(t, m.ReleaseGroupNumber(103))
+	assert.Nil(t, m.ReleaseGroupNumber(100))
+	assert.Nil(t, m.ReleaseGroupNumber(101))
+	assert.Nil(t, m.ReleaseGroupNumber(5))
+	for _, want := range []int32{100, 103, 104} {
+		n, err := m.NextGroupNumber()
+		assert.Nil(t, err)
+		assert.Equal(t, want, n)
+	}
+
+	// Claiming a released number stops it being reused.
+	assert.Nil(t, m.ReleaseGroupNumber(102))
+	assert.Nil(t, m.ClaimGroupNumber(102))
+	n, err := m.NextGroupNumber()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(105), n)
+}
+
+func TestAllocateNumbersTakenInReserved(t *testing.T) {
+	RegisterKV("map", newMapKV)
+	m, err := New("map")
+	assert.Nil(t, err)
+
+	assert.Nil(t, m.SetGroupNumbers(NumberConfig{
+		Pools:    []NumberPool{{Name: "groups", NumberRange: NumberRange{Min: 100, Max: 399}}},
+		Reserved: []NumberRange{{Min: 100, Max: 199}},
+	}))
+
+	// A number taken inside the reserved range must not stop
+	// later taken numbers from being skipped.
+	assert.Nil(t, m.ClaimGroupNumber(150))
+	assert.Nil(t, m.ClaimGroupNumber(300))
+	for want := int32(200); want < 300; want++ {
+		n, err := m.NextGroupNumber()
+		assert.Nil(t, err)
+		assert.Equal(t, want, n)
+	}
+	n, err := m.NextGroupNumber()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(301), n)
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// project copies the named fields of in to out, which must be of the
+// same type.  Fields are named by their path in the JSON form of the
+// value, which is the same as the name used to search for them, so
+// "meta.Shell" names the shell of an entity.  Fields that don't exist
+// are ignored.
+func project(in, out interface{}, fields []string) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	doc := make(map[string]interface{})
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+
+	kept := make(map[string]interface{})
+	for _, f := range fields {
+		copyPath(doc, kept, strings.Split(f, "."))
+	}
+
+	b, err = json.Marshal(kept)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
+
+// copyPath copies the value at path from src to dst, creating the
+// objects that contain it in dst as needed.
+func copyPath(src, dst map[string]interface{}, path []string) {
+	v, ok := src[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		dst[path[0]] = v
+		return
+	}
+	sub, ok := v.(map[string]interface{})
+	if !ok {
+		return
+	}
+	next, ok := dst[path[0]].(map[string]interface{})
+	if !ok {
+		next = make(map[string]interface{})
+		dst[path[0]] = next
+	}
+	copyPath(sub, next, path[1:])
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"strings"
+)
+
+// memberOfField is the pseudo-field that filters entities by their
+// effective group membership.
+const memberOfField = "memberOf:"
+
+// parsedQuery is a search expression with the parts that the index
+// can't answer on its own taken out of it.
+type parsedQuery struct {
+	expr        string
+	memberOf    []string
+	notMemberOf []string
+}
+
+// parseQuery extends the bleve query string syntax with the AND, OR,
+// and NOT keywords and with memberOf clauses.  Clauses joined by AND
+// are required, NOT excludes the clause that follows it, and OR is
+// the same as not joining the clauses at all.  A memberOf clause is
+// always required unless it is excluded.  Expressions that use none
+// of these are returned unchanged.
+func parseQuery(expr string) parsedQuery {
+	tokens := tokenize(expr)
+
+	extended := false
+	for _, t := range tokens {
+		if t == "AND" || t == "OR" || t == "NOT" || strings.HasPrefix(strings.TrimLeft(t, "+-"), memberOfField) {
+			extended = true
+			break
+		}
+	}
+	if !extended {
+		return parsedQuery{expr: expr}
+	}
+
+	var clauses []string
+	pq := parsedQuery{}
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "OR":
+		case "AND":
+			if n := len(clauses); n > 0 {
+				clauses[n-1] = require(clauses[n-1])
+			}
+			if i+1 < len(tokens) && tokens[i+1] != "NOT" {
+				tokens[i+1] = require(tokens[i+1])
+			}
+		case "NOT":
+			if i+1 < len(tokens) {
+				tokens[i+1] = "-" + strings.TrimLeft(tokens[i+1], "+-")
+			}
+		default:
+			clauses = append(clauses, tokens[i])
+		}
+	}
+
+	var rest []string
+	for _, c := range clauses {
+		bare := strings.TrimLeft(c, "+-")
+		if !strings.HasPrefix(bare, memberOfField) {
+			rest = append(rest, c)
+			continue
+		}
+		group := strings.Trim(strings.TrimPrefix(bare, memberOfField), "\"")
+		if strings.HasPrefix(c, "-") {
+			pq.notMemberOf = append(pq.notMemberOf, group)
+		} else {
+			pq.memberOf = append(pq.memberOf, group)
+		}
+	}
+	pq.expr = strings.Join(rest, " ")
+	return pq
+}
+
+// require marks a clause as required unless it is already required
+// or excluded.
+func require(c string) string {
+	if strings.HasPrefix(c, "+") || strings.HasPrefix(c, "-") {
+		return c
+	}
+	return "+" + c
+}
+
+// tokenize splits an expression on whitespace that is not inside
+// quotes.  A field name that is separated from its value is joined
+// back to it.
+func tokenize(expr string) []string {
+	var tokens []string
+	var cur strings.Builder
+	quoted := false
+	for _, r := range expr {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			cur.WriteRune(r)
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			if cur.Len() > 0 {
+				tokens = append(tokens, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if cur.Len() > 0 {
+		tokens = append(tokens, cur.String())
+	}
+
+	var out []string
+	for _, t := range tokens {
+		if n := len(out); n > 0 && strings.HasSuffix(out[n-1], ":") {
+			out[n-1] += t
+			continue
+		}
+		out = append(out, t)
+	}
+	return out
+}
@@ -0,0 +1,29 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	cases := []struct {
+		expr string
+		want parsedQuery
+	}{
+		{"meta.Shell:korn ID:entity*", parsedQuery{expr: "meta.Shell:korn ID:entity*"}},
+		{"a AND b", parsedQuery{expr: "+a +b"}},
+		{"a OR b", parsedQuery{expr: "a b"}},
+		{"a AND NOT b", parsedQuery{expr: "+a -b"}},
+		{"NOT a b", parsedQuery{expr: "-a b"}},
+		{"kv.department:eng AND memberOf:admins", parsedQuery{expr: "+kv.department:eng", memberOf: []string{"admins"}}},
+		{"memberOf: \"admins\" -memberOf:banned", parsedQuery{memberOf: []string{"admins"}, notMemberOf: []string{"banned"}}},
+		{"NOT memberOf:banned", parsedQuery{notMemberOf: []string{"banned"}}},
+		{"DisplayName:\"The AND Group\" AND Name:group1", parsedQuery{expr: "+DisplayName:\"The AND Group\" +Name:group1"}},
+	}
+
+	for i, c := range cases {
+		if got := parseQuery(c.expr); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%d: Got %#v; Want %#v", i, got, c.want)
+		}
+	}
+}
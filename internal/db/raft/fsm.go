@@ -0,0 +1,212 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/netauth/netauth/internal/db"
+)
+
+// A command is a single entry in the replicated log.  Either Ops is a
+// batch of mutations that must all be applied, or Swap is a single
+// compare and swap.  The batch is only applied if every one of Checks
+// holds.
+type command struct {
+	Ops    []db.TxnOp
+	Checks []check `json:",omitempty"`
+	Swap   *swap   `json:",omitempty"`
+}
+
+// check asserts the value of a key when a batch is applied, with a
+// nil Value asserting that the key does not exist.
+type check struct {
+	Key   string
+	Value []byte
+}
+
+// swap carries the arguments to KVSwapper.CompareAndSwap.  The
+// distinction between a nil and an empty value is preserved by the
+// JSON encoding.
+type swap struct {
+	Key string
+	Old []byte
+	New []byte
+}
+
+func encodeCommand(c command) ([]byte, error) {
+	return json.Marshal(c)
+}
+
+func decodeCommand(b []byte) (command, error) {
+	c := command{}
+	err := json.Unmarshal(b, &c)
+	return c, err
+}
+
+// fsm applies the replicated log to a local store.  The local store
+// fires events as it is mutated, so every replica notifies its own
+// subscribers as the log is applied.
+type fsm struct {
+	local db.KVStore
+}
+
+// Apply is called by raft once a log entry is committed.  The value
+// returned is either nil or the error that resulted from applying
+// the command, and is handed back to the node that proposed it.
+func (f *fsm) Apply(l *raft.Log) interface{} {
+	c, err := decodeCommand(l.Data)
+	if err != nil {
+		return err
+	}
+
+	if c.Swap != nil {
+		return f.swap(c.Swap)
+	}
+
+	if err := f.check(c.Checks); err != nil {
+		return err
+	}
+
+	if len(c.Ops) > 1 {
+		if t, ok := f.local.(db.KVTransactor); ok {
+			return f.batch(t, c.Ops)
+		}
+	}
+
+	for _, op := range c.Ops {
+		if op.Delete {
+			err = f.local.Del(op.Key)
+		} else {
+			err = f.local.Put(op.Key, op.Value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fsm) batch(t db.KVTransactor, ops []db.TxnOp) error {
+	txn, err := t.Begin()
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		if op.Delete {
+			err = txn.Del(op.Key)
+		} else {
+			err = txn.Put(op.Key, op.Value)
+		}
+		if err != nil {
+			txn.Abort()
+			return err
+		}
+	}
+	return txn.Commit()
+}
+
+// check returns ErrConflict if any of the checks does not hold
+// against the local store.  Since the log is applied in the same
+// order on every node they all reach the same answer.
+func (f *fsm) check(checks []check) error {
+	for _, c := range checks {
+		cur, err := f.local.Get(c.Key)
+		if err != nil && err != db.ErrNoValue {
+			return err
+		}
+		if (err == nil) != (c.Value != nil) || !bytes.Equal(cur, c.Value) {
+			return db.ErrConflict
+		}
+	}
+	return nil
+}
+
+// swap performs a compare and swap against the local store.  If the
+// local store can't do this itself it is emulated, which is safe
+// since the log is only ever applied from a single goroutine.
+func (f *fsm) swap(s *swap) error {
+	if cas, ok := f.local.(db.KVSwapper); ok {
+		return cas.CompareAndSwap(s.Key, s.Old, s.New)
+	}
+
+	cur, err := f.local.Get(s.Key)
+	if err != nil && err != db.ErrNoValue {
+		return err
+	}
+	if (err == nil) != (s.Old != nil) || !bytes.Equal(cur, s.Old) {
+		return db.ErrConflict
+	}
+	if s.New == nil {
+		return f.local.Del(s.Key)
+	}
+	return f.local.Put(s.Key, s.New)
+}
+
+// Snapshot captures the entire keyspace of the local store.
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	keys, err := f.local.Keys("/*/*")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &snapshot{data: make(map[string][]byte, len(keys))}
+	for _, k := range keys {
+		v, err := f.local.Get(k)
+		if err != nil {
+			return nil, err
+		}
+		s.data[k] = v
+	}
+	return s, nil
+}
+
+// Restore replaces the contents of the local store with a snapshot.
+// Keys that are not in the snapshot are removed.
+func (f *fsm) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	data := make(map[string][]byte)
+	if err := json.NewDecoder(rc).Decode(&data); err != nil {
+		return err
+	}
+
+	keys, err := f.local.Keys("/*/*")
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if _, ok := data[k]; ok {
+			continue
+		}
+		if err := f.local.Del(k); err != nil && err != db.ErrNoValue {
+			return err
+		}
+	}
+
+	for k, v := range data {
+		if err := f.local.Put(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshot is a point in time copy of the keyspace.
+type snapshot struct {
+	data map[string][]byte
+}
+
+// Persist writes the snapshot to the sink provided by raft.
+func (s *snapshot) Persist(sink raft.SnapshotSink) error {
+	if err := json.NewEncoder(sink).Encode(s.data); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+// Release is called when raft is done with the snapshot.
+func (s *snapshot) Release() {}
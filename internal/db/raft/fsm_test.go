@@ -0,0 +1,112 @@
+package raft
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/db/memory"
+)
+
+func newTestFSM(t *testing.T) *fsm {
+	kv, err := memory.NewKV(hclog.NewNullLogger())
+	assert.Nil(t, err)
+	kv.SetEventFunc(func(db.Event) {})
+	return &fsm{local: kv}
+}
+
+func applyCommand(t *testing.T, f *fsm, c command) interface{} {
+	b, err := encodeCommand(c)
+	assert.Nil(t, err)
+	return f.Apply(&raft.Log{Data: b})
+}
+
+func TestCommandRoundTrip(t *testing.T) {
+	c := command{Swap: &swap{Key: "/entities/entity1", Old: nil, New: []byte{}}}
+	b, err := encodeCommand(c)
+	assert.Nil(t, err)
+	out, err := decodeCommand(b)
+	assert.Nil(t, err)
+	assert.Nil(t, out.Swap.Old)
+	assert.NotNil(t, out.Swap.New)
+
+	_, err = decodeCommand([]byte("not json"))
+	assert.NotNil(t, err)
+}
+
+func TestFSMApply(t *testing.T) {
+	f := newTestFSM(t)
+
+	assert.Nil(t, applyCommand(t, f, command{Ops: []db.TxnOp{
+		{Key: "/entities/entity1", Value: []byte("entity1")},
+		{Key: "/groups/group1", Value: []byte("group1")},
+	}}))
+	v, err := f.local.Get("/groups/group1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("group1"), v)
+
+	assert.Nil(t, applyCommand(t, f, command{Ops: []db.TxnOp{{Key: "/groups/group1", Delete: true}}}))
+	_, err = f.local.Get("/groups/group1")
+	assert.Equal(t, db.ErrNoValue, err)
+
+	assert.Equal(t, db.ErrConflict, applyCommand(t, f, command{Swap: &swap{Key: "/entities/entity1", Old: []byte("bogus"), New: []byte("new")}}))
+	assert.Nil(t, applyCommand(t, f, command{Swap: &swap{Key: "/entities/entity1", Old: []byte("entity1"), New: []byte("new")}}))
+	v, err = f.local.Get("/entities/entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), v)
+
+	assert.NotNil(t, f.Apply(&raft.Log{Data: []byte("not json")}))
+}
+
+func TestFSMChecks(t *testing.T) {
+	f := newTestFSM(t)
+	f.local.Put("/entities/entity1", []byte("entity1"))
+
+	// A batch whose checks don't hold is not applied.
+	ops := []db.TxnOp{{Key: "/entities/entity1", Value: []byte("new")}, {Key: "/groups/group1", Value: []byte("group1")}}
+	assert.Equal(t, db.ErrConflict, applyCommand(t, f, command{Ops: ops, Checks: []check{{Key: "/entities/entity1", Value: []byte("bogus")}}}))
+	assert.Equal(t, db.ErrConflict, applyCommand(t, f, command{Ops: ops, Checks: []check{{Key: "/entities/entity1"}}}))
+	assert.Equal(t, db.ErrConflict, applyCommand(t, f, command{Ops: ops, Checks: []check{{Key: "/groups/group1", Value: []byte{}}}}))
+	_, err := f.local.Get("/groups/group1")
+	assert.Equal(t, db.ErrNoValue, err)
+
+	checks := []check{{Key: "/entities/entity1", Value: []byte("entity1")}, {Key: "/groups/group1"}}
+	assert.Nil(t, applyCommand(t, f, command{Ops: ops, Checks: checks}))
+	v, err := f.local.Get("/entities/entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), v)
+}
+
+func TestFSMSnapshotRestore(t *testing.T) {
+	f := newTestFSM(t)
+	f.local.Put("/entities/entity1", []byte("entity1"))
+	f.local.Put("/groups/group1", []byte("group1"))
+
+	s, err := f.Snapshot()
+	assert.Nil(t, err)
+	buf := new(bytes.Buffer)
+	assert.Nil(t, s.(*snapshot).Persist(&bufferSink{buf}))
+	s.Release()
+
+	f2 := newTestFSM(t)
+	f2.local.Put("/entities/entity2", []byte("entity2"))
+	assert.Nil(t, f2.Restore(ioutil.NopCloser(buf)))
+
+	keys, err := f2.local.Keys("/*/*")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"/entities/entity1", "/groups/group1"}, keys)
+}
+
+// bufferSink is a raft.SnapshotSink that writes to a buffer.
+type bufferSink struct {
+	*bytes.Buffer
+}
+
+func (bufferSink) ID() string    { return "test" }
+func (bufferSink) Cancel() error { return nil }
+func (bufferSink) Close() error  { return nil }
@@ -0,0 +1,443 @@
+// Package raft implements a key/value store that replicates all
+// writes to a set of peers using the raft consensus protocol.  Each
+// node keeps a full copy of the data in a local store, such as
+// bitcask, which serves reads and is used as the state machine.
+// Writes may be made on any node and are forwarded to the current
+// leader, which allows every netauthd in the cluster to be write
+// capable.
+//
+// The cluster is configured with the following keys:
+//
+//	db.raft.id        - Unique ID of this node, defaults to the hostname
+//	db.raft.bind      - Address to listen on for raft and forwarding
+//	db.raft.advertise - Address other nodes should use, defaults to bind
+//	db.raft.peers     - List of all nodes in the form id=host:port
+//	db.raft.store     - Local store to replicate into, defaults to bitcask
+//	db.raft.timeout   - How long to wait for a write to be committed
+//	db.raft.ca        - Certificate that peers are verified against
+//	db.raft.secret    - Secret that every node must prove it knows
+//
+// Every node must be started with the same list of peers.  The
+// cluster is bootstrapped automatically the first time it starts.
+//
+// Unless the server has TLS disabled, nodes connect to each other
+// with mutual TLS using the server's certificate and key.  Each node
+// must present a certificate signed by db.raft.ca, which defaults to
+// the server's own certificate, and that is valid for client as well
+// as server authentication.  A node will not start if TLS is disabled
+// and no secret is configured, since anyone who can reach it could
+// then write to the cluster.
+package raft
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+	"github.com/spf13/viper"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/startup"
+)
+
+var (
+	// ErrBadPeer is returned if a peer is not in the form
+	// id=host:port.
+	ErrBadPeer = errors.New("peers must be in the form id=host:port")
+
+	// ErrInsecureTransport is returned if a node would accept
+	// connections from its peers without verifying them.
+	ErrInsecureTransport = errors.New("raft requires TLS or a shared secret")
+
+	// ErrBadCA is returned if the certificate that peers are
+	// verified against can't be parsed.
+	ErrBadCA = errors.New("the raft CA certificate is invalid")
+)
+
+func init() {
+	startup.RegisterCallback(cb)
+}
+
+func cb() {
+	host, _ := os.Hostname()
+	viper.SetDefault("db.raft.id", host)
+	viper.SetDefault("db.raft.bind", "localhost:1730")
+	viper.SetDefault("db.raft.store", "bitcask")
+	viper.SetDefault("db.raft.timeout", 5*time.Second)
+
+	db.RegisterKV("raft", New)
+}
+
+// KV is a replicated key/value store.
+type KV struct {
+	r     *raft.Raft
+	mux   *mux
+	local db.KVStore
+	l     hclog.Logger
+
+	timeout time.Duration
+}
+
+// config contains everything needed to start a node.  It is separate
+// from viper so that tests can run several nodes in one process.
+type config struct {
+	ID        string
+	Advertise net.Addr
+	Peers     []raft.Server
+	Timeout   time.Duration
+	TLS       *tls.Config
+	Secret    string
+
+	Local  db.KVStore
+	Logs   raft.LogStore
+	Stable raft.StableStore
+	Snaps  raft.SnapshotStore
+}
+
+// New creates a new node from the server configuration and joins it
+// to the cluster.
+func New(l hclog.Logger) (db.KVStore, error) {
+	l = l.Named("raft")
+
+	storeName := viper.GetString("db.raft.store")
+	if storeName == "raft" {
+		return nil, errors.New("raft cannot replicate into itself")
+	}
+	local, err := db.NewKV(storeName, l)
+	if err != nil {
+		return nil, err
+	}
+
+	peers, err := parsePeers(viper.GetStringSlice("db.raft.peers"))
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(viper.GetString("core.home"), "raft")
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	bolt, err := raftboltdb.NewBoltStore(filepath.Join(dir, "raft.db"))
+	if err != nil {
+		return nil, err
+	}
+	snaps, err := raft.NewFileSnapshotStoreWithLogger(dir, 2, l)
+	if err != nil {
+		return nil, err
+	}
+
+	tc, err := tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("tcp", viper.GetString("db.raft.bind"))
+	if err != nil {
+		return nil, err
+	}
+
+	var advertise net.Addr
+	if a := viper.GetString("db.raft.advertise"); a != "" {
+		advertise, err = net.ResolveTCPAddr("tcp", a)
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+
+	c := config{
+		ID:        viper.GetString("db.raft.id"),
+		Advertise: advertise,
+		Peers:     peers,
+		Timeout:   viper.GetDuration("db.raft.timeout"),
+		TLS:       tc,
+		Secret:    viper.GetString("db.raft.secret"),
+
+		Local:  local,
+		Logs:   bolt,
+		Stable: bolt,
+		Snaps:  snaps,
+	}
+	return newKV(c, ln, l)
+}
+
+func newKV(c config, ln net.Listener, l hclog.Logger) (*KV, error) {
+	if c.TLS == nil && c.Secret == "" {
+		ln.Close()
+		return nil, ErrInsecureTransport
+	}
+	if c.TLS == nil {
+		l.Warn("Raft is running without TLS, replicated data is not encrypted")
+	}
+
+	x := &KV{
+		mux:     newMux(ln, c.Advertise, c.TLS, c.Secret, l),
+		local:   c.Local,
+		l:       l,
+		timeout: c.Timeout,
+	}
+	x.mux.fwd.Register(&Forwarder{kv: x})
+
+	trans := raft.NewNetworkTransportWithLogger(x.mux, 3, c.Timeout, l)
+
+	rc := raft.DefaultConfig()
+	rc.LocalID = raft.ServerID(c.ID)
+	rc.Logger = l
+
+	r, err := raft.NewRaft(rc, &fsm{local: c.Local}, c.Logs, c.Stable, c.Snaps, trans)
+	if err != nil {
+		x.mux.Close()
+		return nil, err
+	}
+	x.r = r
+	go x.mux.serve()
+
+	// Bootstrapping is only possible on a node that has never
+	// been part of a cluster, so every node can attempt it with
+	// the same configuration every time it starts.
+	peers := c.Peers
+	if len(peers) == 0 {
+		peers = []raft.Server{{
+			ID:      rc.LocalID,
+			Address: trans.LocalAddr(),
+		}}
+	}
+	switch err := r.BootstrapCluster(raft.Configuration{Servers: peers}).Error(); err {
+	case nil:
+		l.Info("Bootstrapped new cluster", "peers", len(peers))
+	case raft.ErrCantBootstrap:
+	default:
+		x.Close()
+		return nil, err
+	}
+
+	l.Debug("Initialization Complete", "id", c.ID, "address", trans.LocalAddr())
+	return x, nil
+}
+
+// tlsConfig returns the configuration for mutual TLS between nodes,
+// or nil if the server has TLS disabled.
+func tlsConfig() (*tls.Config, error) {
+	if viper.GetBool("tls.PWN_ME") {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(confPath("tls.certificate"), confPath("tls.key"))
+	if err != nil {
+		return nil, err
+	}
+	caKey := "db.raft.ca"
+	if viper.GetString(caKey) == "" {
+		caKey = "tls.certificate"
+	}
+	ca, err := ioutil.ReadFile(confPath(caKey))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, ErrBadCA
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
+// confPath returns the file named by a key, relative to core.conf if
+// it isn't absolute.
+func confPath(key string) string {
+	f := viper.GetString(key)
+	if !filepath.IsAbs(f) {
+		f = filepath.Join(viper.GetString("core.conf"), f)
+	}
+	return f
+}
+
+// parsePeers converts a list of id=host:port strings into the servers
+// for a raft configuration.
+func parsePeers(in []string) ([]raft.Server, error) {
+	out := []raft.Server{}
+	for _, p := range in {
+		parts := strings.SplitN(p, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, ErrBadPeer
+		}
+		out = append(out, raft.Server{
+			Suffrage: raft.Voter,
+			ID:       raft.ServerID(parts[0]),
+			Address:  raft.ServerAddress(parts[1]),
+		})
+	}
+	return out, nil
+}
+
+// SetEventFunc passes the event function to the local store.  Since
+// the local store is mutated on every node as the log is applied,
+// events fire on every node.
+func (kv *KV) SetEventFunc(ef func(db.Event)) {
+	kv.local.SetEventFunc(ef)
+}
+
+// Put replicates a value to all nodes.
+func (kv *KV) Put(k string, v []byte) error {
+	return kv.apply(command{Ops: []db.TxnOp{{Key: k, Value: v}}})
+}
+
+// Get returns a value from the local store.  On a follower this may
+// briefly lag behind the leader.
+func (kv *KV) Get(k string) ([]byte, error) {
+	return kv.local.Get(k)
+}
+
+// Del replicates the removal of a key to all nodes.
+func (kv *KV) Del(k string) error {
+	return kv.apply(command{Ops: []db.TxnOp{{Key: k, Delete: true}}})
+}
+
+// Keys returns the keys from the local store.
+func (kv *KV) Keys(f string) ([]string, error) {
+	return kv.local.Keys(f)
+}
+
+// Close leaves the cluster and closes the local store.
+func (kv *KV) Close() error {
+	if kv.r != nil {
+		if err := kv.r.Shutdown().Error(); err != nil {
+			kv.l.Warn("Error shutting down raft", "error", err)
+		}
+	}
+	kv.mux.Close()
+	return kv.local.Close()
+}
+
+// Capabilities returns that the replicated store is mutable from any
+// node.  Transactions and compare and swap are applied as single log
+// entries, so they are atomic on every node, and the checks made by a
+// transaction are made as it is applied.
+func (kv *KV) Capabilities() []db.KVCapability {
+	return []db.KVCapability{db.KVMutable, db.KVTransactional, db.KVCompareAndSwap, db.KVCheckedTxn}
+}
+
+// Leader returns true if this node is the leader of the cluster.
+func (kv *KV) Leader() bool {
+	return kv.r.State() == raft.Leader
+}
+
+// CompareAndSwap replicates a compare and swap.  The comparison is
+// made when the log entry is applied, so it is consistent across all
+// nodes.
+func (kv *KV) CompareAndSwap(k string, old, new []byte) error {
+	return kv.apply(command{Swap: &swap{Key: k, Old: old, New: new}})
+}
+
+// Begin returns a transaction which is replicated as a single log
+// entry when it is committed.
+func (kv *KV) Begin() (db.KVTxn, error) {
+	return &txn{kv: kv}, nil
+}
+
+// txn buffers mutations until Commit is called.
+type txn struct {
+	kv     *KV
+	ops    []db.TxnOp
+	checks []check
+	closed bool
+}
+
+// Put stages a value to be stored on commit.
+func (t *txn) Put(k string, v []byte) error {
+	if t.closed {
+		return db.ErrTxnClosed
+	}
+	t.ops = append(t.ops, db.TxnOp{Key: k, Value: v})
+	return nil
+}
+
+// Del stages a key to be removed on commit.
+func (t *txn) Del(k string) error {
+	if t.closed {
+		return db.ErrTxnClosed
+	}
+	t.ops = append(t.ops, db.TxnOp{Key: k, Delete: true})
+	return nil
+}
+
+// Check asserts the value of a key, which is checked by every node
+// as the transaction is applied.  If the value has changed by then,
+// because another node committed first, nothing is applied and
+// Commit returns ErrConflict.
+func (t *txn) Check(k string, v []byte) error {
+	if t.closed {
+		return db.ErrTxnClosed
+	}
+	t.checks = append(t.checks, check{Key: k, Value: v})
+	return nil
+}
+
+// Commit replicates all staged mutations.
+func (t *txn) Commit() error {
+	if t.closed {
+		return db.ErrTxnClosed
+	}
+	t.closed = true
+	return t.kv.apply(command{Ops: t.ops, Checks: t.checks})
+}
+
+// Abort discards the transaction.
+func (t *txn) Abort() error {
+	if t.closed {
+		return db.ErrTxnClosed
+	}
+	t.closed = true
+	t.ops = nil
+	return nil
+}
+
+// apply proposes a command to the cluster, forwarding it to the
+// leader if this node is not the leader.
+func (kv *KV) apply(c command) error {
+	b, err := encodeCommand(c)
+	if err != nil {
+		return err
+	}
+
+	if kv.r.State() == raft.Leader {
+		err := kv.applyLocal(b)
+		if err != raft.ErrNotLeader && err != raft.ErrLeadershipLost {
+			return err
+		}
+	}
+
+	leader := kv.r.Leader()
+	if leader == "" {
+		return ErrNoLeader
+	}
+	kv.l.Trace("Forwarding mutation", "leader", leader)
+	return kv.mux.forward(string(leader), b, kv.timeout)
+}
+
+// applyLocal proposes a command on this node, which must be the
+// leader, and waits for it to be applied.
+func (kv *KV) applyLocal(b []byte) error {
+	f := kv.r.Apply(b, kv.timeout)
+	if err := f.Error(); err != nil {
+		return err
+	}
+	if err, ok := f.Response().(error); ok {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+package raft
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/db/memory"
+)
+
+// startCluster starts n nodes on loopback, each with an in-memory
+// local store, and waits for a leader to be elected.  The nodes
+// authenticate each other with a shared secret.
+func startCluster(t *testing.T, n int, ef func(db.Event)) []*KV {
+	return startSecureCluster(t, n, ef, nil, "secret")
+}
+
+// startSecureCluster is startCluster with the transport security
+// provided by the caller.
+func startSecureCluster(t *testing.T, n int, ef func(db.Event), tc *tls.Config, secret string) []*KV {
+	lns := make([]net.Listener, n)
+	peers := make([]raft.Server, n)
+	for i := range lns {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lns[i] = ln
+		peers[i] = raft.Server{
+			Suffrage: raft.Voter,
+			ID:       raft.ServerID(ln.Addr().String()),
+			Address:  raft.ServerAddress(ln.Addr().String()),
+		}
+	}
+
+	nodes := make([]*KV, n)
+	for i, ln := range lns {
+		local, err := memory.NewKV(hclog.NewNullLogger())
+		if err != nil {
+			t.Fatal(err)
+		}
+		snaps := raft.NewInmemSnapshotStore()
+		logs := raft.NewInmemStore()
+		c := config{
+			ID:      string(peers[i].ID),
+			Peers:   peers,
+			Timeout: 5 * time.Second,
+			TLS:     tc,
+			Secret:  secret,
+			Local:   local,
+			Logs:    logs,
+			Stable:  logs,
+			Snaps:   snaps,
+		}
+		kv, err := newKV(c, ln, hclog.NewNullLogger())
+		if err != nil {
+			t.Fatal(err)
+		}
+		kv.SetEventFunc(ef)
+		nodes[i] = kv
+	}
+
+	for i := 0; i < 100; i++ {
+		for _, kv := range nodes {
+			if kv.r.State() == raft.Leader {
+				return nodes
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	stopCluster(nodes)
+	t.Fatal("No leader elected")
+	return nil
+}
+
+func stopCluster(nodes []*KV) {
+	for _, kv := range nodes {
+		kv.Close()
+	}
+}
+
+func follower(nodes []*KV) *KV {
+	for _, kv := range nodes {
+		if kv.r.State() != raft.Leader {
+			return kv
+		}
+	}
+	return nil
+}
+
+func eventually(t *testing.T, f func() bool) {
+	for i := 0; i < 50; i++ {
+		if f() {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Error("Condition not met in time")
+}
+
+func TestReplication(t *testing.T) {
+	var mu sync.Mutex
+	events := 0
+	ef := func(db.Event) {
+		mu.Lock()
+		events++
+		mu.Unlock()
+	}
+
+	nodes := startCluster(t, 3, ef)
+	defer stopCluster(nodes)
+	f := follower(nodes)
+	assert.False(t, f.Leader())
+
+	// Writes to a follower are forwarded to the leader.
+	assert.Nil(t, f.Put("/entities/entity1", []byte("entity1")))
+	for _, kv := range nodes {
+		kv := kv
+		eventually(t, func() bool {
+			v, err := kv.Get("/entities/entity1")
+			return err == nil && string(v) == "entity1"
+		})
+	}
+	eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return events == len(nodes)
+	})
+
+	assert.Equal(t, db.ErrConflict, f.CompareAndSwap("/entities/entity1", []byte("bogus"), []byte("new")))
+	assert.Nil(t, f.CompareAndSwap("/entities/entity1", []byte("entity1"), nil))
+
+	txn, err := f.Begin()
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Put("/groups/group1", []byte("group1")))
+	assert.Nil(t, txn.Put("/groups/group2", []byte("group2")))
+	assert.Nil(t, txn.Commit())
+	assert.Equal(t, db.ErrTxnClosed, txn.Commit())
+
+	// A transaction built from a value that another node has
+	// since changed is refused.
+	txn, err = f.Begin()
+	assert.Nil(t, err)
+	assert.Nil(t, txn.(db.KVChecker).Check("/groups/group1", []byte("stale")))
+	assert.Nil(t, txn.Put("/groups/group3", []byte("group3")))
+	assert.Equal(t, db.ErrConflict, txn.Commit())
+	assert.Equal(t, db.ErrTxnClosed, txn.(db.KVChecker).Check("/groups/group1", nil))
+
+	for _, kv := range nodes {
+		kv := kv
+		eventually(t, func() bool {
+			keys, err := kv.Keys("/*/*")
+			return err == nil && len(keys) == 2
+		})
+	}
+}
+
+func TestTLSCluster(t *testing.T) {
+	tc := testTLSConfig(t)
+	nodes := startSecureCluster(t, 3, func(db.Event) {}, tc, "")
+	defer stopCluster(nodes)
+
+	// Writes on a follower are forwarded over TLS as well.
+	assert.Nil(t, follower(nodes).Put("/entities/foo", []byte("foo")))
+	for _, kv := range nodes {
+		kv := kv
+		eventually(t, func() bool {
+			_, err := kv.Get("/entities/foo")
+			return err == nil
+		})
+	}
+
+	// A client without a certificate is turned away.
+	c, err := tls.Dial("tcp", nodes[0].mux.Addr().String(), &tls.Config{RootCAs: tc.RootCAs})
+	if err == nil {
+		c.Write([]byte{connForward})
+		_, err = c.Read(make([]byte, 1))
+		c.Close()
+	}
+	assert.NotNil(t, err)
+}
+
+func TestInsecureTransport(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = newKV(config{}, ln, hclog.NewNullLogger())
+	assert.Equal(t, ErrInsecureTransport, err)
+}
+
+func TestSharedSecret(t *testing.T) {
+	nodes := startCluster(t, 1, func(db.Event) {})
+	defer stopCluster(nodes)
+	addr := nodes[0].mux.Addr().String()
+
+	put := func(k string) []byte {
+		b, err := encodeCommand(command{Ops: []db.TxnOp{{Key: k, Value: []byte(k)}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	// Nodes that don't know the secret can't forward writes.
+	for _, s := range []string{"wrong", ""} {
+		bad := &mux{}
+		if s != "" {
+			bad.secret = []byte(s)
+		}
+		assert.NotNil(t, bad.forward(addr, put("/entities/bad"), time.Second), s)
+	}
+	_, err := nodes[0].Get("/entities/bad")
+	assert.Equal(t, db.ErrNoValue, err)
+
+	good := &mux{secret: []byte("secret")}
+	assert.Nil(t, good.forward(addr, put("/entities/good"), time.Second))
+	_, err = nodes[0].Get("/entities/good")
+	assert.Nil(t, err)
+}
+
+// testTLSConfig returns a configuration with a self signed
+// certificate for loopback that is used by every node.
+func testTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "raft"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+}
+
+func TestParsePeers(t *testing.T) {
+	peers, err := parsePeers([]string{"node1=10.0.0.1:1730", "node2=10.0.0.2:1730"})
+	assert.Nil(t, err)
+	assert.Equal(t, raft.ServerID("node2"), peers[1].ID)
+	assert.Equal(t, raft.ServerAddress("10.0.0.2:1730"), peers[1].Address)
+
+	for _, p := range []string{"node1", "=10.0.0.1:1730", "node1="} {
+		_, err := parsePeers([]string{p})
+		assert.Equal(t, ErrBadPeer, err)
+	}
+}
+
+func TestErrorFromString(t *testing.T) {
+	assert.Nil(t, errorFromString(""))
+	assert.Equal(t, db.ErrConflict, errorFromString(db.ErrConflict.Error()))
+	assert.Equal(t, "other", errorFromString("other").Error())
+}
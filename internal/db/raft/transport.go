@@ -0,0 +1,324 @@
+package raft
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+
+	"github.com/netauth/netauth/internal/db"
+)
+
+// Both raft's own traffic and forwarded mutations share a single
+// listener.  The first byte written on each connection says which of
+// the two it is.
+const (
+	connRaft byte = iota + 1
+	connForward
+)
+
+// nonceSize is the length of the nonces exchanged when nodes prove to
+// each other that they know the shared secret.
+const nonceSize = 32
+
+var (
+	// ErrNoLeader is returned when a mutation is requested but
+	// the cluster does not currently have a leader to forward it
+	// to.
+	ErrNoLeader = errors.New("the cluster has no leader")
+
+	// ErrNotLeader is returned when a forwarded mutation arrives
+	// at a node that is not the leader.
+	ErrNotLeader = errors.New("this node is not the leader")
+
+	// ErrBadSecret is returned when the other end of a
+	// connection can't prove that it knows the shared secret.
+	ErrBadSecret = errors.New("the peer does not know the shared secret")
+
+	// errMuxClosed is returned from Accept once the mux has been
+	// closed.
+	errMuxClosed = errors.New("listener closed")
+)
+
+// mux splits incoming connections between raft and the forwarding
+// server.  It satisfies raft.StreamLayer for the raft half.  If tls is
+// set every connection uses it in both directions, and if secret is
+// set both ends of every connection must prove that they know it
+// before anything else is sent.
+type mux struct {
+	ln        net.Listener
+	advertise net.Addr
+	tls       *tls.Config
+	secret    []byte
+	l         hclog.Logger
+
+	raftConns chan net.Conn
+	fwd       *rpc.Server
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newMux(ln net.Listener, advertise net.Addr, tc *tls.Config, secret string, l hclog.Logger) *mux {
+	if advertise == nil {
+		advertise = ln.Addr()
+	}
+	if tc != nil {
+		ln = tls.NewListener(ln, tc)
+	}
+	m := &mux{
+		ln:        ln,
+		advertise: advertise,
+		tls:       tc,
+		l:         l,
+		raftConns: make(chan net.Conn),
+		fwd:       rpc.NewServer(),
+		closed:    make(chan struct{}),
+	}
+	if secret != "" {
+		m.secret = []byte(secret)
+	}
+	return m
+}
+
+// serve accepts connections until the listener is closed.
+func (m *mux) serve() {
+	for {
+		c, err := m.ln.Accept()
+		if err != nil {
+			select {
+			case <-m.closed:
+			default:
+				m.l.Warn("Error accepting connection", "error", err)
+			}
+			return
+		}
+		go m.handle(c)
+	}
+}
+
+func (m *mux) handle(c net.Conn) {
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	t, err := m.greeted(c)
+	if err != nil {
+		m.l.Warn("Rejected connection", "remote", c.RemoteAddr(), "error", err)
+		c.Close()
+		return
+	}
+	c.SetDeadline(time.Time{})
+
+	switch t {
+	case connRaft:
+		select {
+		case m.raftConns <- c:
+		case <-m.closed:
+			c.Close()
+		}
+	case connForward:
+		m.fwd.ServeConn(c)
+	default:
+		m.l.Warn("Unknown connection type", "remote", c.RemoteAddr(), "type", t)
+		c.Close()
+	}
+}
+
+// Accept returns the next raft connection.
+func (m *mux) Accept() (net.Conn, error) {
+	select {
+	case c := <-m.raftConns:
+		return c, nil
+	case <-m.closed:
+		return nil, errMuxClosed
+	}
+}
+
+// Close shuts down the listener for both raft and forwarding.
+func (m *mux) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		close(m.closed)
+		err = m.ln.Close()
+	})
+	return err
+}
+
+// Addr returns the address that other nodes should use to reach this
+// one.
+func (m *mux) Addr() net.Addr {
+	return m.advertise
+}
+
+// Dial opens a raft connection to another node.
+func (m *mux) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	return m.dial(string(address), connRaft, timeout)
+}
+
+func (m *mux) dial(address string, t byte, timeout time.Duration) (net.Conn, error) {
+	d := &net.Dialer{Timeout: timeout}
+	var c net.Conn
+	var err error
+	if m.tls != nil {
+		c, err = tls.DialWithDialer(d, "tcp", address, m.tls)
+	} else {
+		c, err = d.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.SetDeadline(time.Now().Add(timeout))
+	if err := m.greet(c, t); err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.SetDeadline(time.Time{})
+	return c, nil
+}
+
+// greet sends the connection type.  If there is a shared secret it
+// then checks that the other node knows it, and proves that this one
+// does too.
+func (m *mux) greet(c net.Conn, t byte) error {
+	if m.secret == nil {
+		_, err := c.Write([]byte{t})
+		return err
+	}
+
+	nc, err := nonce()
+	if err != nil {
+		return err
+	}
+	if _, err := c.Write(append([]byte{t}, nc...)); err != nil {
+		return err
+	}
+	b := make([]byte, nonceSize+sha256.Size)
+	if _, err := io.ReadFull(c, b); err != nil {
+		return err
+	}
+	ns := b[:nonceSize]
+	if !hmac.Equal(b[nonceSize:], m.mac(nc, ns)) {
+		return ErrBadSecret
+	}
+	_, err = c.Write(m.mac(ns, nc))
+	return err
+}
+
+// greeted is the other end of greet.  It returns the connection type
+// once the other node has proven that it knows the shared secret.
+func (m *mux) greeted(c net.Conn) (byte, error) {
+	b := make([]byte, 1)
+	if m.secret != nil {
+		b = make([]byte, 1+nonceSize)
+	}
+	if _, err := io.ReadFull(c, b); err != nil {
+		return 0, err
+	}
+	if m.secret == nil {
+		return b[0], nil
+	}
+
+	nc := b[1:]
+	ns, err := nonce()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := c.Write(append(ns, m.mac(nc, ns)...)); err != nil {
+		return 0, err
+	}
+	proof := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(c, proof); err != nil {
+		return 0, err
+	}
+	if !hmac.Equal(proof, m.mac(ns, nc)) {
+		return 0, ErrBadSecret
+	}
+	return b[0], nil
+}
+
+// mac returns the MAC of two nonces under the shared secret.  Each
+// end signs the other's nonce first, so neither can be made to
+// produce the proof that it expects to receive.
+func (m *mux) mac(a, b []byte) []byte {
+	h := hmac.New(sha256.New, m.secret)
+	h.Write(a)
+	h.Write(b)
+	return h.Sum(nil)
+}
+
+func nonce() ([]byte, error) {
+	b := make([]byte, nonceSize)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Forwarder is the net/rpc receiver that accepts mutations from
+// followers.  It is only exported to satisfy net/rpc.
+type Forwarder struct {
+	kv *KV
+}
+
+// ForwardReply carries the result of applying a forwarded command.
+// Errors from the store are returned here rather than as an RPC
+// error so that well known errors can be reconstructed.
+type ForwardReply struct {
+	Err string
+}
+
+// Apply applies a command that was forwarded by a follower.
+func (f *Forwarder) Apply(cmd []byte, reply *ForwardReply) error {
+	if f.kv.r.State() != raft.Leader {
+		reply.Err = ErrNotLeader.Error()
+		return nil
+	}
+	if err := f.kv.applyLocal(cmd); err != nil {
+		reply.Err = err.Error()
+	}
+	return nil
+}
+
+// forward sends a command to the leader to be applied.
+func (m *mux) forward(leader string, cmd []byte, timeout time.Duration) error {
+	c, err := m.dial(leader, connForward, timeout)
+	if err != nil {
+		return err
+	}
+	client := rpc.NewClient(c)
+	defer client.Close()
+
+	reply := ForwardReply{}
+	call := client.Go("Forwarder.Apply", cmd, &reply, nil)
+	select {
+	case <-call.Done:
+	case <-time.After(timeout):
+		return raft.ErrEnqueueTimeout
+	}
+	if call.Error != nil {
+		return call.Error
+	}
+	return errorFromString(reply.Err)
+}
+
+// errorFromString recovers the errors that callers are expected to
+// compare against.
+func errorFromString(s string) error {
+	if s == "" {
+		return nil
+	}
+	for _, err := range []error{db.ErrConflict, db.ErrNoValue, ErrNotLeader} {
+		if s == err.Error() {
+			return err
+		}
+	}
+	return errors.New(s)
+}
@@ -0,0 +1,152 @@
+package db
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+
+	"github.com/golang/protobuf/proto"
+
+	types "github.com/netauth/protocol"
+)
+
+// txRead is a value that was read from the KVStore during a
+// transaction.  found is false if the key did not exist.
+type txRead struct {
+	value []byte
+	found bool
+}
+
+// EntityRevision returns the revision of an entity.  The revision is
+// derived from the content of the entity, and so any change to the
+// entity will change its revision.
+func EntityRevision(e *types.Entity) string {
+	b, err := proto.Marshal(e)
+	if err != nil {
+		return ""
+	}
+	return revision(b)
+}
+
+// GroupRevision returns the revision of a group.  The revision is
+// derived from the content of the group, and so any change to the
+// group will change its revision.
+func GroupRevision(g *types.Group) string {
+	b, err := proto.Marshal(g)
+	if err != nil {
+		return ""
+	}
+	return revision(b)
+}
+
+func revision(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+// ExpectEntityRevision asserts that when the entity is next loaded
+// within the transaction it will have the given revision.  If it
+// does not the load will fail with ErrConflict.
+func (t *Txn) ExpectEntityRevision(ID, rev string) {
+	t.expect[path.Join("/entities", ID)] = rev
+}
+
+// ExpectGroupRevision asserts that when the group is next loaded
+// within the transaction it will have the given revision.  If it
+// does not the load will fail with ErrConflict.
+func (t *Txn) ExpectGroupRevision(name, rev string) {
+	t.expect[path.Join("/groups", name)] = rev
+}
+
+// checkExpected consumes the expected revision for a key, if there
+// is one, and compares it to the revision that was actually loaded.
+func (t *Txn) checkExpected(k, rev string) error {
+	want, ok := t.expect[k]
+	if !ok {
+		return nil
+	}
+	delete(t.expect, k)
+	if want != rev {
+		t.db.log.Debug("Revision mismatch", "key", k, "want", want, "have", rev)
+		return ErrConflict
+	}
+	return nil
+}
+
+// swappable checks if the KVStore both advertises and implements
+// support for compare and swap.
+func (db *DB) swappable() bool {
+	if _, ok := db.kv.(KVSwapper); !ok {
+		return false
+	}
+	for _, c := range db.kv.Capabilities() {
+		if c == KVCompareAndSwap {
+			return true
+		}
+	}
+	return false
+}
+
+// commitSwap applies a single mutation as a compare and swap against
+// the value that was originally read.
+func (db *DB) commitSwap(op TxnOp, r txRead) error {
+	var old, new []byte
+	if r.found {
+		old = r.value
+		if old == nil {
+			old = []byte{}
+		}
+	}
+	if !op.Delete {
+		new = op.Value
+		if new == nil {
+			new = []byte{}
+		}
+	}
+
+	switch err := db.kv.(KVSwapper).CompareAndSwap(op.Key, old, new); err {
+	case nil:
+		db.log.Trace("Transaction committed", "mutations", 1)
+		db.recordChanges([]TxnOp{op})
+		return nil
+	case ErrConflict:
+		db.log.Debug("Conflicting write", "key", op.Key)
+		return ErrConflict
+	default:
+		db.log.Warn("Error applying mutation", "key", op.Key, "error", err)
+		return ErrInternalError
+	}
+}
+
+// stale returns ErrConflict if the key was read in the transaction
+// and has changed since.  This allows a save to fail early rather
+// than waiting for the transaction to be committed.
+func (t *Txn) stale(k string) error {
+	r, ok := t.reads[k]
+	if !ok {
+		return nil
+	}
+	return t.db.verifyReads(map[string]txRead{k: r})
+}
+
+// verifyReads checks that none of the keys that were read have
+// changed since.  Checking every read rather than only the keys that
+// are about to be written means that a transaction can't act on a
+// decision made from values that have since changed.  This is only
+// safe against other writers using the same DB, since they are
+// serialized by the write lock.
+func (db *DB) verifyReads(reads map[string]txRead) error {
+	for k, r := range reads {
+		b, err := db.kv.Get(k)
+		if err != nil && err != ErrNoValue {
+			db.log.Warn("Error verifying read", "key", k, "error", err)
+			return ErrInternalError
+		}
+		if (err == nil) != r.found || !bytes.Equal(b, r.value) {
+			db.log.Debug("Conflicting write", "key", k)
+			return ErrConflict
+		}
+	}
+	return nil
+}
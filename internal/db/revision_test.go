@@ -0,0 +1,146 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	types "github.com/netauth/protocol"
+)
+
+type mockSwapKV struct {
+	mockKV
+}
+
+func newMockSwapKV(hclog.Logger) (KVStore, error) {
+	x := &mockSwapKV{}
+	x.On("SetEventFunc", mock.Anything).Return()
+	x.On("Capabilities").Return([]KVCapability{KVMutable, KVCompareAndSwap})
+	x.allowChangeLog()
+	x.On("CompareAndSwap", changeLogSeqKey, mock.Anything, mock.Anything).Return(nil).Maybe()
+	return x, nil
+}
+
+func (mkv *mockSwapKV) CompareAndSwap(k string, old, new []byte) error {
+	return mkv.Called(k, old, new).Error(0)
+}
+
+func TestRevisions(t *testing.T) {
+	e1 := &types.Entity{ID: proto.String("entity1"), Number: proto.Int32(1)}
+	e2 := &types.Entity{ID: proto.String("entity1"), Number: proto.Int32(2)}
+	assert.Equal(t, EntityRevision(e1), EntityRevision(proto.Clone(e1).(*types.Entity)))
+	assert.NotEqual(t, EntityRevision(e1), EntityRevision(e2))
+
+	g1 := &types.Group{Name: proto.String("group1"), Number: proto.Int32(1)}
+	g2 := &types.Group{Name: proto.String("group1"), Number: proto.Int32(2)}
+	assert.Equal(t, GroupRevision(g1), GroupRevision(proto.Clone(g1).(*types.Group)))
+	assert.NotEqual(t, GroupRevision(g1), GroupRevision(g2))
+}
+
+func TestExpectRevision(t *testing.T) {
+	RegisterKV("mock", newMockKV)
+	m, err := New("mock")
+	assert.Nil(t, err)
+
+	m.kv.(*mockKV).On("Get", "/entities/entity1").Return(goodEntityBytes1, nil)
+	m.kv.(*mockKV).On("Get", "/groups/group1").Return(goodGroupBytes1, nil)
+
+	e, err := m.LoadEntity("entity1")
+	assert.Nil(t, err)
+	g, err := m.LoadGroup("group1")
+	assert.Nil(t, err)
+
+	tx := m.Begin()
+	tx.ExpectEntityRevision("entity1", "bogus")
+	tx.ExpectGroupRevision("group1", GroupRevision(g))
+
+	// Loads outside of the transaction neither check nor consume
+	// its expectations.
+	_, err = m.LoadEntity("entity1")
+	assert.Nil(t, err)
+
+	_, err = tx.LoadEntity("entity1")
+	assert.Equal(t, ErrConflict, err)
+	_, err = tx.LoadGroup("group1")
+	assert.Nil(t, err)
+	assert.Nil(t, tx.Abort())
+
+	tx = m.Begin()
+	tx.ExpectEntityRevision("entity1", EntityRevision(e))
+	_, err = tx.LoadEntity("entity1")
+	assert.Nil(t, err)
+	assert.Nil(t, tx.Abort())
+}
+
+func TestTxnVerifyReads(t *testing.T) {
+	RegisterKV("mock", newMockKV)
+	m, err := New("mock")
+	assert.Nil(t, err)
+
+	// The entity changes between being loaded and being saved.
+	m.kv.(*mockKV).On("Get", "/entities/entity1").Return(goodEntityBytes1, nil).Once()
+	m.kv.(*mockKV).On("Get", "/entities/entity1").Return(goodEntityBytes2, nil).Once()
+
+	tx := m.Begin()
+	e, err := tx.LoadEntity("entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, ErrConflict, tx.SaveEntity(e))
+	assert.Nil(t, tx.Abort())
+
+	// The entity changes between being saved and being
+	// committed.
+	m.kv.(*mockKV).On("Get", "/entities/entity1").Return(goodEntityBytes1, nil).Twice()
+	m.kv.(*mockKV).On("Get", "/entities/entity1").Return(goodEntityBytes2, nil).Once()
+
+	tx = m.Begin()
+	e, err = tx.LoadEntity("entity1")
+	assert.Nil(t, err)
+	assert.Nil(t, tx.SaveEntity(e))
+	assert.Equal(t, ErrConflict, tx.Commit())
+	m.kv.(*mockKV).AssertNotCalled(t, "Put", mock.Anything, mock.Anything)
+
+	// A key that didn't exist must still not exist.
+	m.kv.(*mockKV).On("Get", "/groups/group1").Return([]byte{}, ErrNoValue).Twice()
+	m.kv.(*mockKV).On("Get", "/groups/group1").Return(goodGroupBytes1, nil).Once()
+
+	tx = m.Begin()
+	_, err = tx.LoadGroup("group1")
+	assert.Equal(t, ErrUnknownGroup, err)
+	assert.Nil(t, tx.SaveGroup(&types.Group{Name: proto.String("group1")}))
+	assert.Nil(t, tx.SaveEntity(&types.Entity{ID: proto.String("entity1")}))
+	assert.Equal(t, ErrConflict, tx.Commit())
+}
+
+func TestTxnCommitSwap(t *testing.T) {
+	RegisterKV("mockSwap", newMockSwapKV)
+	m, err := New("mockSwap")
+	assert.Nil(t, err)
+
+	m.kv.(*mockSwapKV).On("Get", "/entities/entity1").Return(goodEntityBytes1, nil)
+	m.kv.(*mockSwapKV).On("Get", "/groups/group1").Return([]byte{}, ErrNoValue)
+	m.kv.(*mockSwapKV).On("CompareAndSwap", "/entities/entity1", goodEntityBytes1, mock.Anything).Return(nil).Once()
+	m.kv.(*mockSwapKV).On("CompareAndSwap", "/entities/entity1", goodEntityBytes1, []byte(nil)).Return(ErrConflict).Once()
+	m.kv.(*mockSwapKV).On("CompareAndSwap", "/groups/group1", []byte(nil), mock.Anything).Return(ErrInternalError).Once()
+
+	tx := m.Begin()
+	e, err := tx.LoadEntity("entity1")
+	assert.Nil(t, err)
+	e.Number = proto.Int32(42)
+	assert.Nil(t, tx.SaveEntity(e))
+	assert.Nil(t, tx.Commit())
+
+	tx = m.Begin()
+	assert.Nil(t, tx.DeleteEntity("entity1"))
+	assert.Equal(t, ErrConflict, tx.Commit())
+
+	tx = m.Begin()
+	_, err = tx.LoadGroup("group1")
+	assert.Equal(t, ErrUnknownGroup, err)
+	assert.Nil(t, tx.SaveGroup(&types.Group{Name: proto.String("group1")}))
+	assert.Equal(t, ErrInternalError, tx.Commit())
+
+	m.kv.(*mockSwapKV).AssertExpectations(t)
+}
@@ -0,0 +1,163 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	pb "github.com/netauth/protocol"
+)
+
+// A Rule is a search expression that is evaluated against a single
+// entity rather than looked up in the index.  Rules define the
+// members of dynamic groups, and as they don't use the index they can
+// be evaluated as soon as an entity changes.
+type Rule struct {
+	expr    string
+	clauses []ruleClause
+}
+
+type ruleClause struct {
+	path    []string
+	pattern string
+	negate  bool
+}
+
+// ParseRule parses a rule.  A rule is a list of clauses of the form
+// field:value, all of which must match.  Fields are named in the same
+// way as for a search, and the KV data and untyped metadata of the
+// entity are available as kv.<key> and untyped.<key>.  A clause is
+// negated with a '-' prefix or with NOT, and the value may contain
+// '*' as a wildcard.  Values are compared without regard to case.
+func ParseRule(expr string) (*Rule, error) {
+	r := &Rule{expr: expr}
+	negate := false
+	for _, t := range tokenize(expr) {
+		switch t {
+		case "AND":
+			continue
+		case "NOT":
+			negate = true
+			continue
+		case "OR":
+			return nil, ErrBadRule
+		}
+
+		c := ruleClause{negate: negate}
+		negate = false
+		if strings.HasPrefix(t, "-") {
+			c.negate = !c.negate
+		}
+		t = strings.TrimLeft(t, "+-")
+
+		parts := strings.SplitN(t, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[0] == "secret" {
+			return nil, ErrBadRule
+		}
+		c.path = strings.Split(parts[0], ".")
+		c.pattern = strings.ToLower(strings.Trim(parts[1], "\""))
+		r.clauses = append(r.clauses, c)
+	}
+	if len(r.clauses) == 0 || negate {
+		return nil, ErrBadRule
+	}
+	return r, nil
+}
+
+// String returns the expression that the rule was parsed from.
+func (r *Rule) String() string {
+	return r.expr
+}
+
+// Matches returns true if every clause of the rule matches the
+// entity.
+func (r *Rule) Matches(e *pb.Entity) bool {
+	doc := ruleDocument(e)
+	for _, c := range r.clauses {
+		if matchPath(doc, c.path, c.pattern) == c.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// ruleDocument returns the fields of an entity that a rule can
+// match.
+func ruleDocument(e *pb.Entity) map[string]interface{} {
+	doc := make(map[string]interface{})
+	if b, err := json.Marshal(e); err == nil {
+		json.Unmarshal(b, &doc)
+	}
+	delete(doc, "secret")
+
+	kv := make(map[string]interface{})
+	for k, v := range kvValues(e.GetMeta().GetKV()) {
+		kv[k] = v
+	}
+	doc["kv"] = kv
+
+	untyped := make(map[string]interface{})
+	for k, v := range untypedValues(e.GetMeta().GetUntypedMeta()) {
+		untyped[k] = v
+	}
+	doc["untyped"] = untyped
+	return doc
+}
+
+// matchPath returns true if any value at path matches the pattern.
+func matchPath(v interface{}, path []string, pattern string) bool {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		if len(path) == 0 {
+			return false
+		}
+		return matchPath(x[path[0]], path[1:], pattern)
+	case []interface{}:
+		for i := range x {
+			if matchPath(x[i], path, pattern) {
+				return true
+			}
+		}
+		return false
+	case []string:
+		for i := range x {
+			if matchPath(x[i], path, pattern) {
+				return true
+			}
+		}
+		return false
+	case nil:
+		return false
+	}
+	if len(path) > 0 {
+		return false
+	}
+
+	s := fmt.Sprint(v)
+	if f, ok := v.(float64); ok {
+		s = strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return globMatch(pattern, strings.ToLower(s))
+}
+
+// globMatch matches s against a pattern in which '*' matches any
+// run of characters.
+func globMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, p := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, p)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(p):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
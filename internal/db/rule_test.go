@@ -0,0 +1,69 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestParseRule(t *testing.T) {
+	for _, expr := range []string{"", "meta.Shell", "a:b OR c:d", "a:b NOT", "secret:*", ":b"} {
+		if _, err := ParseRule(expr); err != ErrBadRule {
+			t.Errorf("%q: Got %v; Want %v", expr, err, ErrBadRule)
+		}
+	}
+
+	r, err := ParseRule("kv.location:berlin")
+	if err != nil || r.String() != "kv.location:berlin" {
+		t.Errorf("Got %v %v; Want kv.location:berlin", r, err)
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	e := &pb.Entity{
+		ID:     proto.String("entity1"),
+		Number: proto.Int32(10000),
+		Secret: proto.String("secret"),
+		Meta: &pb.EntityMeta{
+			Shell:       proto.String("/bin/zsh"),
+			Locked:      proto.Bool(false),
+			Groups:      []string{"group1"},
+			UntypedMeta: []string{"office{0}:NYC", "office{1}:berlin"},
+			KV: []*pb.KVData{{
+				Key:    proto.String("location"),
+				Values: []*pb.KVValue{{Value: proto.String("Berlin")}},
+			}},
+		},
+	}
+
+	cases := []struct {
+		expr string
+		want bool
+	}{
+		{"kv.location:berlin", true},
+		{"kv.location:paris", false},
+		{"kv.location:berlin -meta.Locked:true", true},
+		{"kv.location:berlin AND NOT meta.Locked:false", false},
+		{"meta.Shell:*zsh", true},
+		{"meta.Shell:/bin/*", true},
+		{"meta.Shell:*ba*", false},
+		{"Number:10000", true},
+		{"untyped.office:nyc", true},
+		{"untyped.office:paris", false},
+		{"meta.Groups:group1", true},
+		{"kv.department:*", false},
+		{"-kv.department:*", true},
+	}
+
+	for i, c := range cases {
+		r, err := ParseRule(c.expr)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if got := r.Matches(e); got != c.want {
+			t.Errorf("%d: Got %v; Want %v", i, got, c.want)
+		}
+	}
+}
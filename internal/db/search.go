@@ -1,12 +1,26 @@
 package db
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
 	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search/query"
 	"github.com/hashicorp/go-hclog"
 
 	pb "github.com/netauth/protocol"
 )
 
+// maxSearchResults is the most results that are returned by a single
+// search or page.
+const maxSearchResults = 16000
+
 // Index holds the methods to search entities and groups with
 // blevesearch.  This is meant to be embedded into a db implementation
 // to transparently give it the search functions.
@@ -16,6 +30,15 @@ type Index struct {
 
 	eLoader loadEntityFunc
 	gLoader loadGroupFunc
+	members membersFunc
+
+	// The keys of the KV data and untyped metadata that are
+	// indexed, and a fingerprint of them that is stored with the
+	// revision of each document so that changing the keys makes
+	// a persistent index stale.
+	kvKeys      map[string]bool
+	untypedKeys map[string]bool
+	keysRev     string
 
 	l hclog.Logger
 }
@@ -25,31 +48,16 @@ type Index struct {
 // in general new mappings shouldn't be added without a very good
 // reason.
 func NewIndex(l hclog.Logger) *Index {
-	// Setup the mapping for entities and turn off certain sub
-	// keys that shouldn't be indexed.
-	eMapping := bleve.NewIndexMapping()
-	eDocMap := bleve.NewDocumentMapping()
-	eDocMap.AddSubDocumentMapping("secret", bleve.NewDocumentDisabledMapping())
-	eDocMap.AddSubDocumentMapping("meta.Keys", bleve.NewDocumentDisabledMapping())
-	eDocMap.AddSubDocumentMapping("meta.UntypedMeta", bleve.NewDocumentDisabledMapping())
-	eMapping.AddDocumentMapping("_default", eDocMap)
-
 	// The only real way to throw an error in here is if a mapping
 	// is invalid, or if this were on disk if the backing boltdb
 	// couldn't be allocated.  Since this is fully in memory and
 	// uses a hard-coded mapping, there is no concievable way for
 	// an error to be returned here.  The same is true of the
 	// group mapping below.
-	eIndex, _ := bleve.NewMemOnly(eMapping)
+	eIndex, _ := bleve.NewMemOnly(entityMapping())
 	eIndex.SetName("EntityIndex")
 
-	// Setup the mapping for groups and turn off certain sub keys
-	// that shouldn't be indexed.
-	gMapping := bleve.NewIndexMapping()
-	gDocMap := bleve.NewDocumentMapping()
-	gDocMap.AddSubDocumentMapping("untypedmeta", bleve.NewDocumentDisabledMapping())
-	gMapping.AddDocumentMapping("_default", gDocMap)
-	gIndex, _ := bleve.NewMemOnly(gMapping)
+	gIndex, _ := bleve.NewMemOnly(groupMapping())
 	gIndex.SetName("GroupIndex")
 
 	// Return the prepared struct
@@ -60,6 +68,70 @@ func NewIndex(l hclog.Logger) *Index {
 	}
 }
 
+// entityMapping returns the mapping for entities, which turns off
+// certain sub keys that shouldn't be indexed.
+func entityMapping() mapping.IndexMapping {
+	eMapping := bleve.NewIndexMapping()
+	eDocMap := bleve.NewDocumentMapping()
+	eDocMap.AddSubDocumentMapping("secret", bleve.NewDocumentDisabledMapping())
+	eDocMap.AddSubDocumentMapping("meta.Keys", bleve.NewDocumentDisabledMapping())
+	eDocMap.AddSubDocumentMapping("meta.UntypedMeta", bleve.NewDocumentDisabledMapping())
+	eMapping.AddDocumentMapping("_default", eDocMap)
+	return eMapping
+}
+
+// groupMapping returns the mapping for groups, which turns off
+// certain sub keys that shouldn't be indexed.
+func groupMapping() mapping.IndexMapping {
+	gMapping := bleve.NewIndexMapping()
+	gDocMap := bleve.NewDocumentMapping()
+	gDocMap.AddSubDocumentMapping("untypedmeta", bleve.NewDocumentDisabledMapping())
+	gMapping.AddDocumentMapping("_default", gDocMap)
+	return gMapping
+}
+
+// open replaces the in-memory indexes with indexes that are kept in
+// dir, creating them if they don't exist yet.  An index that already
+// existed may be stale, and must be validated before it is searched.
+func (s *Index) open(dir string) error {
+	eIndex, err := openOrCreateIndex(filepath.Join(dir, "entities.bleve"), entityMapping())
+	if err != nil {
+		return err
+	}
+	gIndex, err := openOrCreateIndex(filepath.Join(dir, "groups.bleve"), groupMapping())
+	if err != nil {
+		eIndex.Close()
+		return err
+	}
+	eIndex.SetName("EntityIndex")
+	gIndex.SetName("GroupIndex")
+
+	s.eIndex.Close()
+	s.gIndex.Close()
+	s.eIndex = eIndex
+	s.gIndex = gIndex
+	s.l.Debug("Using persistent index", "directory", dir)
+	return nil
+}
+
+func openOrCreateIndex(p string, m mapping.IndexMapping) (bleve.Index, error) {
+	idx, err := bleve.Open(p)
+	if err == bleve.ErrorIndexPathDoesNotExist {
+		return bleve.New(p, m)
+	}
+	return idx, err
+}
+
+// Close closes both indexes.  Closing an index that is kept on disk
+// is required to release the lock that is held on it.
+func (s *Index) Close() error {
+	eErr := s.eIndex.Close()
+	if err := s.gIndex.Close(); err != nil {
+		return err
+	}
+	return eErr
+}
+
 // ConfigureCallback is used to set the references to the loaders
 // which are later used by the callback to fetch entities and groups
 // for indexing.
@@ -69,6 +141,38 @@ func (s *Index) ConfigureCallback(el loadEntityFunc, gl loadGroupFunc) {
 	s.l.Trace("IndexCallback is now configured")
 }
 
+// ConfigureMembership sets the function that is used to find the
+// entities that are members of a group, which allows searches to be
+// filtered with memberOf.
+func (s *Index) ConfigureMembership(mf func(string) []string) {
+	s.members = mf
+}
+
+// SetIndexedKeys sets the keys of the KV data and untyped metadata
+// that are indexed, which are otherwise not searchable.  They can be
+// searched as kv.<key> and untyped.<key>.  Changing the keys does not
+// index existing documents again, so this should be called before any
+// documents are indexed.
+func (s *Index) SetIndexedKeys(kv, untyped []string) {
+	s.kvKeys = make(map[string]bool, len(kv))
+	for _, k := range kv {
+		s.kvKeys[k] = true
+	}
+	s.untypedKeys = make(map[string]bool, len(untyped))
+	for _, k := range untyped {
+		s.untypedKeys[k] = true
+	}
+
+	s.keysRev = ""
+	if len(kv) > 0 || len(untyped) > 0 {
+		kv = append([]string{}, kv...)
+		untyped = append([]string{}, untyped...)
+		sort.Strings(kv)
+		sort.Strings(untyped)
+		s.keysRev = "/" + revision([]byte(strings.Join(kv, ",")+"\x00"+strings.Join(untyped, ",")))
+	}
+}
+
 // IndexCallback is meant to be plugged into the event system and is
 // subsequently capable of maintaining the index based on events being
 // fired during save and as files change on disk.
@@ -87,9 +191,12 @@ func (s *Index) IndexCallback(e Event) {
 			s.l.Warn("Could not reindex entity", "entity", e.PK, "error", err)
 			return
 		}
+		if s.current(s.eIndex, e.PK, EntityRevision(ent)) {
+			return
+		}
 		s.IndexEntity(ent)
 	case EventEntityDestroy:
-		s.eIndex.Delete(e.PK)
+		s.DeleteEntity(&pb.Entity{ID: &e.PK})
 	case EventGroupCreate:
 		fallthrough
 	case EventGroupUpdate:
@@ -98,80 +205,305 @@ func (s *Index) IndexCallback(e Event) {
 			s.l.Warn("Could not reindex group", "group", e.PK, "error", err)
 			return
 		}
+		if s.current(s.gIndex, e.PK, GroupRevision(grp)) {
+			return
+		}
 		s.IndexGroup(grp)
 	case EventGroupDestroy:
-		s.gIndex.Delete(e.PK)
+		s.DeleteGroup(&pb.Group{Name: &e.PK})
+	}
+}
+
+// current returns true if the document with the given ID was indexed
+// at the given revision, in which case it doesn't need to be indexed
+// again.
+func (s *Index) current(idx bleve.Index, id, rev string) bool {
+	b, err := idx.GetInternal(revisionKey(id))
+	return err == nil && string(b) == rev+s.keysRev
+}
+
+// revisionKey is the key in the internal storage of an index that
+// holds the revision that a document was indexed at.
+func revisionKey(id string) []byte {
+	return []byte("revision/" + id)
+}
+
+// docIDs returns the IDs of every document in an index.
+func docIDs(idx bleve.Index) ([]string, error) {
+	n, err := idx.DocCount()
+	if err != nil {
+		return nil, err
+	}
+	req := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), int(n), 0, false)
+	result, err := idx.Search(req)
+	if err != nil {
+		return nil, err
 	}
+	return extractDocIDs(result), nil
 }
 
 // SearchEntities searches the index for entities matching the
 // qualities specified in the request.
 func (s *Index) SearchEntities(r SearchRequest) ([]string, error) {
-	if r.Expression == "" {
-		return nil, ErrBadSearch
-	}
-
-	req := createSearchRequest(r)
+	ids, _, err := s.search(s.eIndex, r, true)
+	return ids, err
+}
 
-	// This can only fail if the query is malformed, since the
-	// worst that can happen is the query is empty, this can't
-	// return an error.
-	result, _ := s.eIndex.Search(req)
-	slice := extractDocIDs(result)
-	return slice, nil
+// SearchEntityPage searches for entities in the same way as
+// SearchEntities, and also returns the token for the next page of
+// results, which is empty if there are no more.
+func (s *Index) SearchEntityPage(r SearchRequest) ([]string, string, error) {
+	return s.search(s.eIndex, r, true)
 }
 
 // SearchGroups searches the index for groups matching the qualities
 // specified in the request.
 func (s *Index) SearchGroups(r SearchRequest) ([]string, error) {
+	ids, _, err := s.search(s.gIndex, r, false)
+	return ids, err
+}
+
+// SearchGroupPage searches for groups in the same way as
+// SearchGroups, and also returns the token for the next page of
+// results, which is empty if there are no more.
+func (s *Index) SearchGroupPage(r SearchRequest) ([]string, string, error) {
+	return s.search(s.gIndex, r, false)
+}
+
+// search runs a search against one of the indexes.  Only entities
+// have memberships, so memberOf may only be used if hasMembers is
+// set.
+func (s *Index) search(idx bleve.Index, r SearchRequest, hasMembers bool) ([]string, string, error) {
 	if r.Expression == "" {
-		return nil, ErrBadSearch
+		return nil, "", ErrBadSearch
 	}
 
-	req := createSearchRequest(r)
+	pq := parseQuery(r.Expression)
+	if len(pq.memberOf)+len(pq.notMemberOf) > 0 && (!hasMembers || s.members == nil) {
+		return nil, "", ErrBadSearch
+	}
+
+	req, err := createSearchRequest(r, s.buildQuery(pq))
+	if err != nil {
+		return nil, "", err
+	}
 
 	// This can only fail if the query is malformed, since the
 	// worst that can happen is the query is empty, this can't
 	// return an error.
-	result, _ := s.gIndex.Search(req)
+	result, _ := idx.Search(req)
 	slice := extractDocIDs(result)
-	return slice, nil
+
+	next := ""
+	if r.PageSize > 0 && result != nil && uint64(req.From+len(slice)) < result.Total {
+		next = pageToken(r, req.From+len(slice))
+	}
+	return slice, next, nil
+}
+
+// buildQuery converts a parsed expression to a query.  Membership
+// can't be indexed since it changes without the entity changing, so
+// it is resolved when the search is made and matched by ID.
+func (s *Index) buildQuery(pq parsedQuery) query.Query {
+	var q query.Query = bleve.NewMatchAllQuery()
+	if pq.expr != "" {
+		q = bleve.NewQueryStringQuery(pq.expr)
+	}
+	if len(pq.memberOf)+len(pq.notMemberOf) == 0 {
+		return q
+	}
+
+	bq := bleve.NewBooleanQuery()
+	bq.AddMust(q)
+	for _, g := range pq.memberOf {
+		bq.AddMust(bleve.NewDocIDQuery(s.members(g)))
+	}
+	for _, g := range pq.notMemberOf {
+		bq.AddMustNot(bleve.NewDocIDQuery(s.members(g)))
+	}
+	return bq
 }
 
 // IndexEntity adds or updates an entity in the index.
 func (s *Index) IndexEntity(e *pb.Entity) error {
 	s.l.Trace("Indexing Entity", "entity", e.GetID())
-	return s.eIndex.Index(e.GetID(), e)
+	doc, err := s.document(e, e.GetMeta().GetKV(), e.GetMeta().GetUntypedMeta())
+	if err != nil {
+		return err
+	}
+	return indexDoc(s.eIndex, e.GetID(), EntityRevision(e)+s.keysRev, doc)
 }
 
 // DeleteEntity removes an entity from the index
 func (s *Index) DeleteEntity(e *pb.Entity) error {
 	s.l.Trace("Removing Entity", "entity", e.GetID())
-	return s.eIndex.Delete(e.GetID())
+	return deleteDoc(s.eIndex, e.GetID())
 }
 
 // IndexGroup adds or updates a group in the index.
 func (s *Index) IndexGroup(g *pb.Group) error {
 	s.l.Trace("Indexing Group", "group", g.GetName())
-	return s.gIndex.Index(g.GetName(), g)
+	doc, err := s.document(g, g.GetKV(), g.GetUntypedMeta())
+	if err != nil {
+		return err
+	}
+	return indexDoc(s.gIndex, g.GetName(), GroupRevision(g)+s.keysRev, doc)
 }
 
 // DeleteGroup removes a group from the index.
 func (s *Index) DeleteGroup(g *pb.Group) error {
 	s.l.Trace("Removing Group", "group", g.GetName())
-	return s.gIndex.Delete(g.GetName())
+	return deleteDoc(s.gIndex, g.GetName())
+}
+
+// document returns the document that is indexed for an entity or
+// group.  The fields of the document are the same as those of the
+// entity or group, and the values of the selected KV and untyped
+// metadata keys are added to it as kv.<key> and untyped.<key>.
+func (s *Index) document(v interface{}, kv []*pb.KVData, untyped []string) (interface{}, error) {
+	if len(s.kvKeys) == 0 && len(s.untypedKeys) == 0 {
+		return v, nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	doc := make(map[string]interface{})
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+
+	kvFields := make(map[string]interface{})
+	for k, v := range kvValues(kv) {
+		if s.kvKeys[k] {
+			kvFields[k] = v
+		}
+	}
+	if len(kvFields) > 0 {
+		doc["kv"] = kvFields
+	}
+
+	untypedFields := make(map[string]interface{})
+	for k, v := range untypedValues(untyped) {
+		if s.untypedKeys[k] {
+			untypedFields[k] = v
+		}
+	}
+	if len(untypedFields) > 0 {
+		doc["untyped"] = untypedFields
+	}
+	return doc, nil
+}
+
+// indexDoc indexes a document together with its revision, so that a
+// persistent index can later tell whether the document is stale.
+func indexDoc(idx bleve.Index, id, rev string, doc interface{}) error {
+	b := idx.NewBatch()
+	if err := b.Index(id, doc); err != nil {
+		return err
+	}
+	b.SetInternal(revisionKey(id), []byte(rev))
+	return idx.Batch(b)
+}
+
+func deleteDoc(idx bleve.Index, id string) error {
+	b := idx.NewBatch()
+	b.Delete(id)
+	b.DeleteInternal(revisionKey(id))
+	return idx.Batch(b)
+}
+
+// kvValues returns the values of KV data by key.
+func kvValues(kv []*pb.KVData) map[string][]string {
+	out := make(map[string][]string, len(kv))
+	for _, d := range kv {
+		for _, v := range d.GetValues() {
+			out[d.GetKey()] = append(out[d.GetKey()], v.GetValue())
+		}
+	}
+	return out
+}
+
+// untypedValues returns the values of untyped metadata by key.  Keys
+// may carry an index as in key{0}, which isn't part of the key.
+func untypedValues(untyped []string) map[string][]string {
+	out := make(map[string][]string)
+	for _, m := range untyped {
+		parts := strings.SplitN(m, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := parts[0]
+		if i := strings.Index(key, "{"); i >= 0 {
+			key = key[:i]
+		}
+		out[key] = append(out[key], parts[1])
+	}
+	return out
 }
 
 // createSearchRequest is a helper function which converts between a
 // db.SearchRequest and a bleve.SearchRequest.
-func createSearchRequest(r SearchRequest) *bleve.SearchRequest {
-	q := bleve.NewQueryStringQuery(r.Expression)
+func createSearchRequest(r SearchRequest, q query.Query) (*bleve.SearchRequest, error) {
+	from, err := pageOffset(r)
+	if err != nil {
+		return nil, err
+	}
 
 	// This will bite someone someday, by creating a near
 	// impossible to reason about bug where the entities returned
 	// in a search keep changing, but today is not that day.
-	sr := bleve.NewSearchRequestOptions(q, 16000, 0, false)
-	return sr
+	// Searches that want more results than this should ask for
+	// them a page at a time.
+	size := maxSearchResults
+	if r.PageSize > 0 && r.PageSize < size {
+		size = r.PageSize
+	}
+
+	sr := bleve.NewSearchRequestOptions(q, size, from, false)
+
+	// Ties are broken by the document ID so that the order is
+	// the same for every page.
+	order := []string{"-_score"}
+	if len(r.Sort) > 0 {
+		order = append([]string{}, r.Sort...)
+	}
+	sr.SortBy(append(order, "_id"))
+	return sr, nil
+}
+
+// pageToken returns the token for the page of results that starts at
+// offset.  The token is only valid for the same expression and sort
+// order.
+func pageToken(r SearchRequest, offset int) string {
+	t := fmt.Sprintf("%d:%s", offset, searchFingerprint(r))
+	return base64.RawURLEncoding.EncodeToString([]byte(t))
+}
+
+// pageOffset returns the offset of the first result that the request
+// asks for.
+func pageOffset(r SearchRequest) (int, error) {
+	if r.PageToken == "" {
+		return 0, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(r.PageToken)
+	if err != nil {
+		return 0, ErrBadPageToken
+	}
+	parts := strings.SplitN(string(b), ":", 2)
+	if len(parts) != 2 || parts[1] != searchFingerprint(r) {
+		return 0, ErrBadPageToken
+	}
+	offset, err := strconv.Atoi(parts[0])
+	if err != nil || offset < 0 {
+		return 0, ErrBadPageToken
+	}
+	return offset, nil
+}
+
+func searchFingerprint(r SearchRequest) string {
+	return revision([]byte(r.Expression + "\x00" + strings.Join(r.Sort, ",")))
 }
 
 // extractDocIDs converts between a bleve.SearchResult and a []string
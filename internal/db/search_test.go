@@ -1,6 +1,7 @@
 package db
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/golang/protobuf/proto"
@@ -281,8 +282,163 @@ func TestSearchGroupsBadRequest(t *testing.T) {
 	}
 }
 
+func TestSearchEntityPages(t *testing.T) {
+	si := NewIndex(hclog.NewNullLogger())
+
+	for i, id := range []string{"entity3", "entity1", "entity5", "entity2", "entity4"} {
+		e := &pb.Entity{ID: proto.String(id), Number: proto.Int32(int32(10 - i))}
+		if err := si.IndexEntity(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		sort []string
+		want []string
+	}{
+		{[]string{"_id"}, []string{"entity1", "entity2", "entity3", "entity4", "entity5"}},
+		{[]string{"-_id"}, []string{"entity5", "entity4", "entity3", "entity2", "entity1"}},
+		{[]string{"Number"}, []string{"entity4", "entity2", "entity5", "entity1", "entity3"}},
+	}
+
+	for i, c := range cases {
+		r := SearchRequest{Expression: "ID:entity*", PageSize: 2, Sort: c.sort}
+		var got []string
+		pages := 0
+		for {
+			ids, next, err := si.SearchEntityPage(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, ids...)
+			pages++
+			if next == "" {
+				break
+			}
+			r.PageToken = next
+		}
+		if pages != 3 || strings.Join(got, ",") != strings.Join(c.want, ",") {
+			t.Errorf("%d: Got %v in %d pages; Want %v in 3 pages", i, got, pages, c.want)
+		}
+	}
+
+	// A token can't be used to continue a different search.
+	_, next, err := si.SearchEntityPage(SearchRequest{Expression: "ID:entity*", PageSize: 2})
+	if err != nil || next == "" {
+		t.Fatal(next, err)
+	}
+	for _, r := range []SearchRequest{
+		{Expression: "ID:entity1", PageToken: next},
+		{Expression: "ID:entity*", PageToken: next, Sort: []string{"Number"}},
+		{Expression: "ID:entity*", PageToken: "garbage"},
+	} {
+		if _, _, err := si.SearchEntityPage(r); err != ErrBadPageToken {
+			t.Errorf("Got %v; Want %v", err, ErrBadPageToken)
+		}
+	}
+}
+
+func TestSearchGroupPages(t *testing.T) {
+	si := NewIndex(hclog.NewNullLogger())
+
+	for _, name := range []string{"group1", "group2", "group3"} {
+		if err := si.IndexGroup(&pb.Group{Name: proto.String(name)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids, next, err := si.SearchGroupPage(SearchRequest{Expression: "Name:group*", PageSize: 2})
+	if err != nil || len(ids) != 2 || next == "" {
+		t.Fatal(ids, next, err)
+	}
+	ids, next, err = si.SearchGroupPage(SearchRequest{Expression: "Name:group*", PageSize: 2, PageToken: next})
+	if err != nil || len(ids) != 1 || next != "" {
+		t.Fatal(ids, next, err)
+	}
+
+	// Without a page size every result is returned at once.
+	ids, next, err = si.SearchGroupPage(SearchRequest{Expression: "Name:group*"})
+	if err != nil || len(ids) != 3 || next != "" {
+		t.Fatal(ids, next, err)
+	}
+}
+
 func TestExtractDocIDsNullResult(t *testing.T) {
 	if res := extractDocIDs(nil); res != nil {
 		t.Error("Got a non-nil response from a nil result")
 	}
 }
+
+func TestSearchIndexedKeys(t *testing.T) {
+	si := NewIndex(hclog.NewNullLogger())
+	si.SetIndexedKeys([]string{"department"}, []string{"office"})
+
+	kv := func(k, v string) []*pb.KVData {
+		return []*pb.KVData{{Key: proto.String(k), Values: []*pb.KVValue{{Value: proto.String(v)}}}}
+	}
+	entities := []*pb.Entity{
+		{ID: proto.String("entity1"), Meta: &pb.EntityMeta{KV: kv("department", "eng"), UntypedMeta: []string{"office{0}:nyc"}}},
+		{ID: proto.String("entity2"), Meta: &pb.EntityMeta{KV: kv("department", "eng"), Shell: proto.String("/bin/zsh")}},
+		{ID: proto.String("entity3"), Meta: &pb.EntityMeta{KV: kv("badge", "eng"), UntypedMeta: []string{"desk:nyc"}}},
+	}
+	for _, e := range entities {
+		if err := si.IndexEntity(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := si.IndexGroup(&pb.Group{Name: proto.String("group1"), KV: kv("department", "ops")}); err != nil {
+		t.Fatal(err)
+	}
+
+	si.ConfigureMembership(func(g string) []string {
+		if g == "admins" {
+			return []string{"entity2", "entity3"}
+		}
+		return nil
+	})
+
+	cases := []struct {
+		expr string
+		want []string
+	}{
+		{"kv.department:eng", []string{"entity1", "entity2"}},
+		{"kv.badge:eng", nil},
+		{"untyped.office:nyc", []string{"entity1"}},
+		{"untyped.desk:nyc", nil},
+		{"kv.department:eng AND memberOf:admins", []string{"entity2"}},
+		{"memberOf:admins AND NOT kv.department:eng", []string{"entity3"}},
+		{"kv.department:eng AND NOT memberOf:admins", []string{"entity1"}},
+		{"memberOf:admins", []string{"entity2", "entity3"}},
+		{"kv.department:eng AND meta.Shell:zsh", []string{"entity2"}},
+	}
+	for i, c := range cases {
+		got, err := si.SearchEntities(SearchRequest{Expression: c.expr, Sort: []string{"_id"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, ",") != strings.Join(c.want, ",") {
+			t.Errorf("%d: Got %v; Want %v", i, got, c.want)
+		}
+	}
+
+	got, err := si.SearchGroups(SearchRequest{Expression: "kv.department:ops"})
+	if err != nil || len(got) != 1 {
+		t.Errorf("Got %v %v; Want [group1]", got, err)
+	}
+
+	// Groups don't have members, so they can't be filtered by
+	// membership.
+	if _, err := si.SearchGroups(SearchRequest{Expression: "memberOf:admins"}); err != ErrBadSearch {
+		t.Errorf("Got %v; Want %v", err, ErrBadSearch)
+	}
+
+	// Documents indexed with different keys are stale.
+	rev := EntityRevision(entities[0])
+	if !si.current(si.eIndex, "entity1", rev) {
+		t.Error("Document is stale with unchanged keys")
+	}
+	si.SetIndexedKeys([]string{"department"}, nil)
+	if si.current(si.eIndex, "entity1", rev) {
+		t.Error("Document is current after keys changed")
+	}
+}
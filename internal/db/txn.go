@@ -0,0 +1,375 @@
+package db
+
+import (
+	"encoding/json"
+	"path"
+	"sort"
+)
+
+// A Txn is a transaction on the database.  Saves and deletes made
+// through a Txn are buffered in it, and loads made through it will
+// observe the buffered values.  Nothing is visible to other users of
+// the DB until the Txn is committed.  A Txn must not be used by more
+// than one goroutine at a time.
+type Txn struct {
+	db *DB
+
+	// ops holds the mutations that have been requested, and
+	// reads holds the values that were read from the KVStore so
+	// that they can be checked for changes on commit.  expect
+	// holds the revisions that the caller expects the values it
+	// loads to have.
+	ops    []TxnOp
+	reads  map[string]txRead
+	expect map[string]string
+	closed bool
+}
+
+// Begin opens a transaction on the database.  Any number of
+// transactions may be open at once, and they only contend with each
+// other when they commit.
+func (db *DB) Begin() *Txn {
+	db.log.Trace("Transaction opened")
+	return &Txn{
+		db:     db,
+		reads:  make(map[string]txRead),
+		expect: make(map[string]string),
+	}
+}
+
+// Commit applies all mutations buffered in the transaction.  If more
+// than one object was changed and the KVStore is transactional then
+// the mutations are applied atomically, otherwise they are applied in
+// order and the first error will roll back those already applied.
+//
+// If the transaction writes anything then every key that was read
+// during it must not have changed in the meantime, if one has then
+// ErrConflict is returned and nothing is written.  A transaction that
+// only reads has nothing to commit.
+func (t *Txn) Commit() error {
+	if t.closed {
+		return ErrTxnClosed
+	}
+	t.closed = true
+	if len(t.ops) == 0 {
+		t.db.log.Trace("Transaction committed without changes")
+		return nil
+	}
+	return t.db.commit(t.ops, t.reads)
+}
+
+// Changes returns the changes that the transaction makes to entities
+// and groups, with one change for each object in the order that it
+// was first changed.  Objects that are saved as they were read are
+// left out.  Once the transaction has committed these are the changes
+// that were applied.
+func (t *Txn) Changes() []Change {
+	// Only the last mutation of each key is kept, as that is
+	// the one that decides its value.
+	idx := make(map[string]int)
+	var ops []TxnOp
+	for _, op := range t.ops {
+		if i, ok := idx[op.Key]; ok {
+			ops[i] = op
+			continue
+		}
+		idx[op.Key] = len(ops)
+		ops = append(ops, op)
+	}
+	return changesFor(dropUnchanged(ops, t.reads))
+}
+
+// Abort discards all mutations buffered in the transaction.
+func (t *Txn) Abort() error {
+	if t.closed {
+		return ErrTxnClosed
+	}
+	t.closed = true
+	t.ops = nil
+
+	t.db.log.Trace("Transaction aborted")
+	return nil
+}
+
+// commit applies a set of mutations that were made in a transaction.
+// The write lock is held throughout so that the reads can't change
+// between being verified and the mutations being applied.
+func (db *DB) commit(ops []TxnOp, reads map[string]txRead) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	// A single mutation against a key that was read can be
+	// handed to the KVStore as a compare and swap, which
+	// protects against writers that aren't using this DB.  The
+	// other reads are checked here first.  A store that can check
+	// every read itself is always given a batch instead.
+	if len(ops) == 1 && db.swappable() && !db.checked() {
+		if r, ok := reads[ops[0].Key]; ok && !unchanged(ops[0], r) {
+			others := make(map[string]txRead, len(reads))
+			for k, v := range reads {
+				if k != ops[0].Key {
+					others[k] = v
+				}
+			}
+			if err := db.verifyReads(others); err != nil {
+				return err
+			}
+			return db.commitSwap(ops[0], r)
+		}
+	}
+
+	if err := db.verifyReads(reads); err != nil {
+		return err
+	}
+
+	// Objects that are saved as they were read are left alone so
+	// that they don't appear in the change log.
+	ops = dropUnchanged(ops, reads)
+	if len(ops) == 0 {
+		db.log.Trace("Transaction committed without changes")
+		return nil
+	}
+
+	// Batches are used whenever the store supports them so that
+	// the change log is written atomically with the changes, or
+	// so that the store can check the reads.
+	if db.transactional() && (len(ops) > 1 || len(changesFor(ops)) > 0 || db.checked()) {
+		return db.commitBatch(ops, reads)
+	}
+
+	// Without a transactional store the mutations are applied
+	// one at a time.  The original values are kept so that if
+	// any of them fails the ones that were already applied can
+	// be put back.
+	var undo []TxnOp
+	if len(ops) > 1 {
+		var err error
+		if undo, err = db.undoOps(ops); err != nil {
+			return err
+		}
+	}
+	for i, op := range ops {
+		if err := db.applyOp(op); err != nil {
+			db.log.Warn("Error applying mutation", "key", op.Key, "error", err)
+			if undo != nil {
+				db.rollback(undo[:i])
+			}
+			return ErrInternalError
+		}
+	}
+	db.log.Trace("Transaction committed", "mutations", len(ops))
+	db.recordChanges(ops)
+	return nil
+}
+
+// undoOps returns the mutations that will restore each of the keys
+// in ops to its current value.
+func (db *DB) undoOps(ops []TxnOp) ([]TxnOp, error) {
+	undo := make([]TxnOp, len(ops))
+	for i, op := range ops {
+		b, err := db.kv.Get(op.Key)
+		switch err {
+		case nil:
+			undo[i] = TxnOp{Key: op.Key, Value: b}
+		case ErrNoValue:
+			undo[i] = TxnOp{Key: op.Key, Delete: true}
+		default:
+			db.log.Warn("Error reading value before mutation", "key", op.Key, "error", err)
+			return nil, ErrInternalError
+		}
+	}
+	return undo, nil
+}
+
+// rollback applies the undo mutations in reverse order.  Errors are
+// logged since there is nothing more that can be done about them.
+func (db *DB) rollback(undo []TxnOp) {
+	for i := len(undo) - 1; i >= 0; i-- {
+		if err := db.applyOp(undo[i]); err != nil {
+			db.log.Error("Error rolling back mutation", "key", undo[i].Key, "error", err)
+		}
+	}
+	db.log.Debug("Transaction rolled back", "mutations", len(undo))
+}
+
+// applyOp applies a single mutation directly to the KVStore.
+// Deleting a key that does not exist is not an error.
+func (db *DB) applyOp(op TxnOp) error {
+	var err error
+	if op.Delete {
+		err = db.kv.Del(op.Key)
+	} else {
+		err = db.kv.Put(op.Key, op.Value)
+	}
+	if err == ErrNoValue {
+		return nil
+	}
+	return err
+}
+
+// commitBatch hands a set of mutations to the KVStore's own
+// transaction mechanism, along with the change log entries that
+// record them.  If the KVStore can check the reads when it applies
+// the batch then they are passed along, which protects against
+// writers that aren't using this DB.
+func (db *DB) commitBatch(ops []TxnOp, reads map[string]txRead) error {
+	records, err := db.changeOps(ops)
+	if err != nil {
+		db.log.Warn("Error allocating change log sequence", "error", err)
+		return ErrInternalError
+	}
+	ops = append(ops[:len(ops):len(ops)], records...)
+
+	txn, err := db.kv.(KVTransactor).Begin()
+	if err != nil {
+		db.log.Warn("Error beginning transaction", "error", err)
+		return ErrInternalError
+	}
+
+	if c, ok := txn.(KVChecker); ok && db.checked() {
+		for k, r := range reads {
+			var v []byte
+			if r.found {
+				v = r.value
+				if v == nil {
+					v = []byte{}
+				}
+			}
+			if err := c.Check(k, v); err != nil {
+				db.log.Warn("Error staging read", "key", k, "error", err)
+				txn.Abort()
+				return ErrInternalError
+			}
+		}
+	}
+
+	for _, op := range ops {
+		if op.Delete {
+			err = txn.Del(op.Key)
+		} else {
+			err = txn.Put(op.Key, op.Value)
+		}
+		if err != nil {
+			db.log.Warn("Error staging mutation", "key", op.Key, "error", err)
+			txn.Abort()
+			return ErrInternalError
+		}
+	}
+
+	switch err := txn.Commit(); err {
+	case nil:
+	case ErrConflict:
+		db.log.Debug("Conflicting transaction")
+		return ErrConflict
+	default:
+		db.log.Warn("Error committing transaction", "error", err)
+		return ErrInternalError
+	}
+	db.log.Trace("Transaction committed atomically", "mutations", len(ops))
+	db.maybePrune(records)
+	return nil
+}
+
+// transactional checks if the KVStore both advertises and implements
+// support for transactions.
+func (db *DB) transactional() bool {
+	if _, ok := db.kv.(KVTransactor); !ok {
+		return false
+	}
+	for _, c := range db.kv.Capabilities() {
+		if c == KVTransactional {
+			return true
+		}
+	}
+	return false
+}
+
+// checked checks if the KVStore advertises that its transactions can
+// check the values that were read.
+func (db *DB) checked() bool {
+	if !db.transactional() {
+		return false
+	}
+	for _, c := range db.kv.Capabilities() {
+		if c == KVCheckedTxn {
+			return true
+		}
+	}
+	return false
+}
+
+// stage records a mutation in the transaction.
+func (t *Txn) stage(op TxnOp) {
+	t.ops = append(t.ops, op)
+}
+
+// get returns the value for a key.  If tx is not nil then any
+// mutations that have been staged in it are taken into account, and
+// values that are read from the KVStore are remembered so that they
+// can be checked for changes when the transaction is committed.
+func (db *DB) get(tx *Txn, k string) ([]byte, error) {
+	if tx == nil {
+		return db.kv.Get(k)
+	}
+	for i := len(tx.ops) - 1; i >= 0; i-- {
+		if tx.ops[i].Key != k {
+			continue
+		}
+		if tx.ops[i].Delete {
+			return nil, ErrNoValue
+		}
+		return tx.ops[i].Value, nil
+	}
+
+	b, err := db.kv.Get(k)
+	if err == nil || err == ErrNoValue {
+		if _, ok := tx.reads[k]; !ok {
+			tx.reads[k] = txRead{value: b, found: err == nil}
+		}
+	}
+	return b, err
+}
+
+// keys returns the keys matching a pattern, adding those that have
+// been created in the transaction and removing those that have been
+// deleted.
+func (t *Txn) keys(pattern string) ([]string, error) {
+	keys, err := t.db.kv.Keys(pattern)
+	if err != nil {
+		return nil, err
+	}
+	live := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		live[k] = true
+	}
+	for _, op := range t.ops {
+		if ok, _ := path.Match(pattern, op.Key); ok {
+			live[op.Key] = !op.Delete
+		}
+	}
+	out := make([]string, 0, len(live))
+	for k, ok := range live {
+		if ok {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// EncodeJournal serializes a set of transaction operations so that
+// they can be written down by a KVStore prior to being applied.  This
+// allows stores without native transactions to recover an
+// interrupted transaction on startup.
+func EncodeJournal(ops []TxnOp) ([]byte, error) {
+	return json.Marshal(ops)
+}
+
+// DecodeJournal is the inverse of EncodeJournal.
+func DecodeJournal(b []byte) ([]TxnOp, error) {
+	ops := []TxnOp{}
+	if err := json.Unmarshal(b, &ops); err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
@@ -0,0 +1,340 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	types "github.com/netauth/protocol"
+)
+
+type mockTxnKV struct {
+	mockKV
+}
+
+func newMockTxnKV(hclog.Logger) (KVStore, error) {
+	x := &mockTxnKV{}
+	x.On("SetEventFunc", mock.Anything).Return()
+	x.On("Capabilities").Return([]KVCapability{KVMutable, KVTransactional})
+	x.allowChangeLog()
+	return x, nil
+}
+
+func (mkv *mockTxnKV) Begin() (KVTxn, error) {
+	args := mkv.Called()
+	return args.Get(0).(KVTxn), args.Error(1)
+}
+
+type mockTxn struct {
+	mock.Mock
+}
+
+func (t *mockTxn) Put(k string, v []byte) error   { return t.Called(k, v).Error(0) }
+func (t *mockTxn) Del(k string) error             { return t.Called(k).Error(0) }
+func (t *mockTxn) Commit() error                  { return t.Called().Error(0) }
+func (t *mockTxn) Abort() error                   { return t.Called().Error(0) }
+func (t *mockTxn) Check(k string, v []byte) error { return t.Called(k, v).Error(0) }
+
+func newMockCheckedTxnKV(hclog.Logger) (KVStore, error) {
+	x := &mockTxnKV{}
+	x.On("SetEventFunc", mock.Anything).Return()
+	x.On("Capabilities").Return([]KVCapability{KVMutable, KVTransactional, KVCheckedTxn})
+	x.allowChangeLog()
+	return x, nil
+}
+
+func TestTxnDirectCommit(t *testing.T) {
+	RegisterKV("mock", newMockKV)
+	m, err := New("mock")
+	assert.Nil(t, err)
+
+	m.kv.(*mockKV).On("Put", "/entities/entity1", mock.Anything).Return(nil)
+	m.kv.(*mockKV).On("Get", "/entities/entity1").Return(goodEntityBytes1, nil)
+
+	tx := m.Begin()
+	assert.Nil(t, tx.SaveEntity(&types.Entity{ID: proto.String("entity1")}))
+
+	// Nothing has been written yet.
+	m.kv.(*mockKV).AssertNotCalled(t, "Put", "/entities/entity1", mock.Anything)
+
+	assert.Nil(t, tx.Commit())
+	m.kv.(*mockKV).AssertCalled(t, "Put", "/entities/entity1", mock.Anything)
+
+	assert.Equal(t, ErrTxnClosed, tx.Commit())
+	assert.Equal(t, ErrTxnClosed, tx.Abort())
+}
+
+func TestTxnDirectCommitError(t *testing.T) {
+	RegisterKV("mock", newMockKV)
+	m, err := New("mock")
+	assert.Nil(t, err)
+
+	m.kv.(*mockKV).On("Put", "/groups/bad", mock.Anything).Return(errors.New("something internal"))
+
+	tx := m.Begin()
+	assert.Nil(t, tx.SaveGroup(&types.Group{Name: proto.String("bad")}))
+	assert.Equal(t, ErrInternalError, tx.Commit())
+}
+
+func TestTxnDirectCommitRollback(t *testing.T) {
+	RegisterKV("mock", newMockKV)
+	m, err := New("mock")
+	assert.Nil(t, err)
+
+	m.kv.(*mockKV).On("Get", "/entities/entity1").Return(goodEntityBytes1, nil)
+	m.kv.(*mockKV).On("Get", "/entities/entity2").Return([]byte{}, ErrNoValue)
+	m.kv.(*mockKV).On("Get", "/groups/bad").Return([]byte{}, ErrNoValue)
+	m.kv.(*mockKV).On("Put", "/entities/entity1", goodEntityBytes1).Return(nil)
+	m.kv.(*mockKV).On("Put", "/entities/entity1", mock.Anything).Return(nil)
+	m.kv.(*mockKV).On("Put", "/entities/entity2", mock.Anything).Return(nil)
+	m.kv.(*mockKV).On("Del", "/entities/entity2").Return(nil)
+	m.kv.(*mockKV).On("Put", "/groups/bad", mock.Anything).Return(errors.New("something internal"))
+
+	tx := m.Begin()
+	assert.Nil(t, tx.SaveEntity(&types.Entity{ID: proto.String("entity1"), Number: proto.Int32(42)}))
+	assert.Nil(t, tx.SaveEntity(&types.Entity{ID: proto.String("entity2")}))
+	assert.Nil(t, tx.SaveGroup(&types.Group{Name: proto.String("bad")}))
+	assert.Equal(t, ErrInternalError, tx.Commit())
+
+	// The mutations that were applied before the failure are
+	// undone.
+	m.kv.(*mockKV).AssertCalled(t, "Put", "/entities/entity1", goodEntityBytes1)
+	m.kv.(*mockKV).AssertCalled(t, "Del", "/entities/entity2")
+}
+
+func TestTxnReadYourWrites(t *testing.T) {
+	RegisterKV("mock", newMockKV)
+	m, err := New("mock")
+	assert.Nil(t, err)
+
+	m.kv.(*mockKV).On("Get", "/entities/entity1").Return(goodEntityBytes1, nil)
+	m.kv.(*mockKV).On("Get", "/groups/missing").Return([]byte{}, ErrNoValue)
+
+	tx := m.Begin()
+	assert.Nil(t, tx.SaveEntity(&types.Entity{ID: proto.String("entity1"), Number: proto.Int32(42)}))
+	e, err := tx.LoadEntity("entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(42), e.GetNumber())
+
+	assert.Nil(t, tx.DeleteEntity("entity1"))
+	_, err = tx.LoadEntity("entity1")
+	assert.Equal(t, ErrUnknownEntity, err)
+
+	assert.Equal(t, ErrUnknownGroup, tx.DeleteGroup("missing"))
+	assert.Nil(t, tx.Abort())
+
+	e, err = m.LoadEntity("entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), e.GetNumber())
+}
+
+func TestTxnChanges(t *testing.T) {
+	RegisterKV("mock", newMockKV)
+	m, err := New("mock")
+	assert.Nil(t, err)
+
+	m.kv.(*mockKV).On("Get", "/groups/group1").Return(goodGroupBytes1, nil)
+
+	tx := m.Begin()
+	assert.Nil(t, tx.SaveEntity(&types.Entity{ID: proto.String("entity1")}))
+	assert.Nil(t, tx.Bucket("foo").Put("bar", []byte("baz")))
+	assert.Nil(t, tx.DeleteGroup("group1"))
+	assert.Nil(t, tx.SaveEntity(&types.Entity{ID: proto.String("entity2")}))
+	assert.Nil(t, tx.DeleteEntity("entity1"))
+
+	// Each object appears once, as it was last changed, and
+	// anything that isn't an entity or group is left out.
+	var got []Event
+	for _, c := range tx.Changes() {
+		got = append(got, Event{Type: c.Type, PK: c.PK})
+	}
+	want := []Event{
+		{Type: EventEntityDestroy, PK: "entity1"},
+		{Type: EventGroupDestroy, PK: "group1"},
+		{Type: EventEntityUpdate, PK: "entity2"},
+	}
+	assert.Equal(t, want, got)
+	assert.Nil(t, tx.Abort())
+}
+
+func TestTxnIsolation(t *testing.T) {
+	RegisterKV("map", newMapKV)
+	m, err := New("map")
+	assert.Nil(t, err)
+
+	tx := m.Begin()
+	assert.Nil(t, tx.SaveEntity(&types.Entity{ID: proto.String("entity1")}))
+
+	// Uncommitted values are not visible outside of the
+	// transaction, and writes made outside of it are not
+	// absorbed into it.
+	_, err = m.LoadEntity("entity1")
+	assert.Equal(t, ErrUnknownEntity, err)
+	assert.Nil(t, m.SaveGroup(&types.Group{Name: proto.String("group1")}))
+	assert.Nil(t, tx.Abort())
+
+	_, err = m.LoadEntity("entity1")
+	assert.Equal(t, ErrUnknownEntity, err)
+	_, err = m.LoadGroup("group1")
+	assert.Nil(t, err)
+
+	// Discovery within the transaction sees its own creates and
+	// deletes.
+	tx = m.Begin()
+	assert.Nil(t, tx.SaveEntity(&types.Entity{ID: proto.String("entity2")}))
+	assert.Nil(t, tx.DeleteGroup("group1"))
+	ids, err := tx.DiscoverEntityIDs()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/entities/entity2"}, ids)
+	names, err := tx.DiscoverGroupNames()
+	assert.Nil(t, err)
+	assert.Empty(t, names)
+	assert.Nil(t, tx.Commit())
+}
+
+func TestTxnConcurrent(t *testing.T) {
+	RegisterKV("map", newMapKV)
+	m, err := New("map")
+	assert.Nil(t, err)
+	assert.Nil(t, m.SaveEntity(&types.Entity{ID: proto.String("entity1")}))
+
+	// Transactions can be open at the same time, and the first
+	// to commit a change to something they both read wins.
+	tx1 := m.Begin()
+	tx2 := m.Begin()
+	e1, err := tx1.LoadEntity("entity1")
+	assert.Nil(t, err)
+	e2, err := tx2.LoadEntity("entity1")
+	assert.Nil(t, err)
+	e1.Number = proto.Int32(1)
+	e2.Number = proto.Int32(2)
+	assert.Nil(t, tx1.SaveEntity(e1))
+	assert.Nil(t, tx2.SaveEntity(e2))
+	assert.Nil(t, tx1.Commit())
+	assert.Equal(t, ErrConflict, tx2.Commit())
+
+	// A read that only informed the write conflicts too.
+	tx1 = m.Begin()
+	tx2 = m.Begin()
+	_, err = tx1.LoadGroup("group1")
+	assert.Equal(t, ErrUnknownGroup, err)
+	assert.Nil(t, tx1.SaveEntity(&types.Entity{ID: proto.String("entity2")}))
+	assert.Nil(t, tx2.SaveGroup(&types.Group{Name: proto.String("group1")}))
+	assert.Nil(t, tx2.Commit())
+	assert.Equal(t, ErrConflict, tx1.Commit())
+
+	// Transactions that only read have nothing to check.
+	tx1 = m.Begin()
+	_, err = tx1.LoadEntity("entity1")
+	assert.Nil(t, err)
+	assert.Nil(t, m.SaveEntity(&types.Entity{ID: proto.String("entity1")}))
+	assert.Nil(t, tx1.Commit())
+}
+
+func TestTxnBatchCommit(t *testing.T) {
+	RegisterKV("mockTxn", newMockTxnKV)
+	m, err := New("mockTxn")
+	assert.Nil(t, err)
+
+	txn := new(mockTxn)
+	txn.On("Put", "/entities/entity1", mock.Anything).Return(nil)
+	txn.On("Put", "/groups/group1", mock.Anything).Return(nil)
+	txn.On("Put", changeKey(1), mock.Anything).Return(nil)
+	txn.On("Put", changeKey(2), mock.Anything).Return(nil)
+	txn.On("Put", historyKey(entityHistoryPrefix, "entity1", 1), mock.Anything).Return(nil)
+	txn.On("Put", historyKey(groupHistoryPrefix, "group1", 2), mock.Anything).Return(nil)
+	txn.On("Commit").Return(nil)
+	m.kv.(*mockTxnKV).On("Begin").Return(txn, nil)
+
+	tx := m.Begin()
+	assert.Nil(t, tx.SaveEntity(&types.Entity{ID: proto.String("entity1")}))
+	assert.Nil(t, tx.SaveGroup(&types.Group{Name: proto.String("group1")}))
+	assert.Nil(t, tx.Commit())
+
+	txn.AssertExpectations(t)
+	m.kv.(*mockTxnKV).AssertNotCalled(t, "Put", "/entities/entity1", mock.Anything)
+	m.kv.(*mockTxnKV).AssertNotCalled(t, "Put", "/groups/group1", mock.Anything)
+}
+
+func TestTxnBatchChecks(t *testing.T) {
+	RegisterKV("mockCheckedTxn", newMockCheckedTxnKV)
+	m, err := New("mockCheckedTxn")
+	assert.Nil(t, err)
+
+	m.kv.(*mockTxnKV).On("Get", "/entities/entity1").Return(goodEntityBytes1, nil)
+	m.kv.(*mockTxnKV).On("Get", "/groups/group1").Return([]byte{}, ErrNoValue)
+
+	txn := new(mockTxn)
+	txn.On("Put", mock.Anything, mock.Anything).Return(nil)
+	txn.On("Check", "/entities/entity1", goodEntityBytes1).Return(nil)
+	txn.On("Check", "/groups/group1", []byte(nil)).Return(nil)
+	txn.On("Commit").Return(ErrConflict)
+	m.kv.(*mockTxnKV).On("Begin").Return(txn, nil)
+
+	// Everything that was read is handed to the store to check,
+	// and its refusal is a conflict.
+	tx := m.Begin()
+	e, err := tx.LoadEntity("entity1")
+	assert.Nil(t, err)
+	_, err = tx.LoadGroup("group1")
+	assert.Equal(t, ErrUnknownGroup, err)
+	e.Number = proto.Int32(42)
+	assert.Nil(t, tx.SaveEntity(e))
+	assert.Equal(t, ErrConflict, tx.Commit())
+	txn.AssertExpectations(t)
+}
+
+func TestTxnBatchCommitErrors(t *testing.T) {
+	RegisterKV("mockTxn", newMockTxnKV)
+
+	m, err := New("mockTxn")
+	assert.Nil(t, err)
+	m.kv.(*mockTxnKV).On("Begin").Return(new(mockTxn), errors.New("begin error"))
+	tx := m.Begin()
+	tx.SaveEntity(&types.Entity{ID: proto.String("entity1")})
+	tx.SaveEntity(&types.Entity{ID: proto.String("entity2")})
+	assert.Equal(t, ErrInternalError, tx.Commit())
+
+	m, err = New("mockTxn")
+	assert.Nil(t, err)
+	txn := new(mockTxn)
+	txn.On("Put", "/entities/entity1", mock.Anything).Return(errors.New("stage error"))
+	txn.On("Abort").Return(nil)
+	m.kv.(*mockTxnKV).On("Begin").Return(txn, nil)
+	tx = m.Begin()
+	tx.SaveEntity(&types.Entity{ID: proto.String("entity1")})
+	tx.SaveEntity(&types.Entity{ID: proto.String("entity2")})
+	assert.Equal(t, ErrInternalError, tx.Commit())
+	txn.AssertCalled(t, "Abort")
+
+	m, err = New("mockTxn")
+	assert.Nil(t, err)
+	txn = new(mockTxn)
+	txn.On("Put", mock.Anything, mock.Anything).Return(nil)
+	txn.On("Commit").Return(errors.New("commit error"))
+	m.kv.(*mockTxnKV).On("Begin").Return(txn, nil)
+	tx = m.Begin()
+	tx.SaveEntity(&types.Entity{ID: proto.String("entity1")})
+	tx.SaveEntity(&types.Entity{ID: proto.String("entity2")})
+	assert.Equal(t, ErrInternalError, tx.Commit())
+}
+
+func TestJournalRoundTrip(t *testing.T) {
+	ops := []TxnOp{
+		{Key: "/entities/entity1", Value: []byte("some data")},
+		{Key: "/groups/group1", Delete: true},
+	}
+
+	b, err := EncodeJournal(ops)
+	assert.Nil(t, err)
+	res, err := DecodeJournal(b)
+	assert.Nil(t, err)
+	assert.Equal(t, ops, res)
+
+	_, err = DecodeJournal([]byte("{not json"))
+	assert.NotNil(t, err)
+}
@@ -1,6 +1,9 @@
 package db
 
 import (
+	"sync"
+	"time"
+
 	"github.com/hashicorp/go-hclog"
 
 	types "github.com/netauth/protocol"
@@ -28,9 +31,31 @@ type KVStore interface {
 // A DB is a collection of methods satisfying tree.DB, and which read
 // and write data to a KVStore
 type DB struct {
-	log hclog.Logger
-	kv  KVStore
-	cbs map[string]Callback
+	log     hclog.Logger
+	kv      KVStore
+	backend string
+	cbs     map[string]Callback
+
+	// writeMu is held while mutations are applied to the KVStore,
+	// so that a transaction's reads can't change between being
+	// verified and the transaction being applied.
+	writeMu sync.Mutex
+
+	// seqMu serializes allocation of change log sequence
+	// numbers.  logMu guards the retention configuration, the
+	// count of changes since the log was last pruned, and the
+	// number of revisions kept for each object.
+	seqMu         sync.Mutex
+	logMu         sync.Mutex
+	logMaxAge     time.Duration
+	logMaxSize    int
+	logSincePrune int
+	historyDepth  int
+
+	// numMu serializes allocation of numbers, and guards the
+	// configuration of the number pools.
+	numMu   sync.Mutex
+	numbers map[string]NumberConfig
 
 	*Index
 }
@@ -46,8 +71,80 @@ const (
 	// necessarily mean that the KV isn't mutable, only that it
 	// would prefer you not.
 	KVMutable KVCapability = iota
+
+	// KVTransactional signifies that the key/value store is able
+	// to apply a batch of mutations atomically.  Stores that
+	// advertise this capability must also satisfy the
+	// KVTransactor interface.
+	KVTransactional
+
+	// KVCompareAndSwap signifies that the key/value store is
+	// able to conditionally replace a value only if it has not
+	// changed.  Stores that advertise this capability must also
+	// satisfy the KVSwapper interface.
+	KVCompareAndSwap
+
+	// KVCheckedTxn signifies that the transactions of the
+	// key/value store can be made conditional on the values that
+	// were read to produce them.  Stores that advertise this
+	// capability must return transactions that satisfy the
+	// KVChecker interface.
+	KVCheckedTxn
 )
 
+// A KVSwapper is a KVStore that can perform a compare and swap.
+// CompareAndSwap must replace the value at k with new only if the
+// current value is exactly old, and must return ErrConflict if it is
+// not.  An old value of nil asserts that the key does not exist, and
+// a new value of nil requests that the key be deleted.
+type KVSwapper interface {
+	CompareAndSwap(k string, old, new []byte) error
+}
+
+// A KVTransactor is a KVStore that can begin transactions.  The
+// transaction returned must not make any changes visible until it
+// has been committed.
+type KVTransactor interface {
+	Begin() (KVTxn, error)
+}
+
+// A KVTxn is a batch of mutations that are either applied to the
+// KVStore in their entirety or not at all.  Events must only be fired
+// for the mutations in a transaction after Commit has succeeded.
+type KVTxn interface {
+	Put(string, []byte) error
+	Del(string) error
+
+	Commit() error
+	Abort() error
+}
+
+// A KVChecker is a KVTxn that can be made conditional.  Check asserts
+// that the value at k is exactly v, with a v of nil asserting that the
+// key does not exist.  If any assertion does not hold when the
+// transaction is applied then Commit must return ErrConflict and
+// apply nothing.  This allows stores that are written from more than
+// one server to refuse a transaction that was built from values that
+// have since changed.
+type KVChecker interface {
+	Check(k string, v []byte) error
+}
+
+// A KVLeader is a KVStore that is shared by a cluster of servers, of
+// which only the leader should do work that must only be done once,
+// such as removing expired memberships.
+type KVLeader interface {
+	Leader() bool
+}
+
+// TxnOp is a single mutation within a transaction.  It is exported
+// so that stores may share a common format for transaction journals.
+type TxnOp struct {
+	Key    string
+	Value  []byte
+	Delete bool
+}
+
 // Callback is a function type registered by an external customer that
 // is interested in some change that might happen in the storage
 // system.  These are returned with a DBEvent populated of whether or
@@ -86,6 +183,24 @@ const (
 // provide a more optimized searching experience.
 type SearchRequest struct {
 	Expression string
+
+	// PageSize limits the number of results that are returned,
+	// zero returns every result.  PageToken continues a search
+	// from the end of the page that returned it, and must be used
+	// with the same expression and sort order.
+	PageSize  int
+	PageToken string
+
+	// Sort lists the fields that results are ordered by, and a
+	// field prefixed with '-' is sorted in descending order.
+	// Without it results are ordered by how well they match.
+	Sort []string
+
+	// Fields lists the fields that are returned for each result,
+	// named in the same way as in the expression.  The ID of an
+	// entity or name of a group is always returned.  If Fields is
+	// empty every field is returned.
+	Fields []string
 }
 
 // These allow the index to get limited access to the db itself.  You
@@ -94,3 +209,7 @@ type SearchRequest struct {
 // is not allowed.
 type loadEntityFunc func(string) (*types.Entity, error)
 type loadGroupFunc func(string) (*types.Group, error)
+
+// membersFunc returns the IDs of the entities that are members of a
+// group, either directly or through its expansions.
+type membersFunc func(string) []string
@@ -0,0 +1,347 @@
+// Package fsck checks that the entities and groups in a database are
+// consistent with each other, and repairs the problems that it finds
+// where that can be done safely.
+package fsck
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	types "github.com/netauth/protocol"
+)
+
+// The kinds of problem that may be found.
+const (
+	KindUnparseable       = "unparseable"
+	KindDanglingMember    = "dangling-membership"
+	KindDanglingExpansion = "dangling-expansion"
+	KindMissingManager    = "missing-manager"
+	KindDuplicateNumber   = "duplicate-number"
+	KindCycle             = "cycle"
+)
+
+// DB is the subset of the database that is needed to check and
+// repair it.
+type DB interface {
+	DiscoverEntityIDs() ([]string, error)
+	LoadEntity(string) (*types.Entity, error)
+	SaveEntity(*types.Entity) error
+	NextEntityNumber() (int32, error)
+
+	DiscoverGroupNames() ([]string, error)
+	LoadGroup(string) (*types.Group, error)
+	SaveGroup(*types.Group) error
+	NextGroupNumber() (int32, error)
+}
+
+// A Problem is a single inconsistency.  Target names the entity or
+// group with the problem.  Repair describes the change that Repair
+// would make, and is empty if the problem must be fixed by hand.
+type Problem struct {
+	Kind   string
+	Target string
+	Detail string
+	Repair string
+
+	fix func(DB) error
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s: %s", p.Kind, p.Target, p.Detail)
+}
+
+// Check loads every entity and group and returns the problems that
+// were found, in a stable order.
+func Check(d DB) ([]Problem, error) {
+	c := checker{
+		entities: make(map[string]*types.Entity),
+		groups:   make(map[string]*types.Group),
+	}
+	if err := c.load(d); err != nil {
+		return nil, err
+	}
+
+	c.checkMemberships()
+	c.checkGroups()
+	c.checkNumbers()
+	c.checkCycles()
+	return c.problems, nil
+}
+
+// Repair fixes the problems that can be fixed, and returns the number
+// that were.  Repairs are made directly in the database and do not
+// pass through the tree.
+func Repair(d DB, problems []Problem) (int, error) {
+	n := 0
+	for _, p := range problems {
+		if p.fix == nil {
+			continue
+		}
+		if err := p.fix(d); err != nil {
+			return n, fmt.Errorf("%s: %v", p, err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+type checker struct {
+	entityIDs  []string
+	groupNames []string
+	entities   map[string]*types.Entity
+	groups     map[string]*types.Group
+
+	problems []Problem
+}
+
+func (c *checker) report(p Problem) {
+	c.problems = append(c.problems, p)
+}
+
+// load reads every record.  Records that can't be loaded are
+// reported and otherwise ignored.
+func (c *checker) load(d DB) error {
+	ids, err := d.DiscoverEntityIDs()
+	if err != nil {
+		return err
+	}
+	sort.Strings(ids)
+	for _, k := range ids {
+		id := path.Base(k)
+		e, err := d.LoadEntity(id)
+		if err != nil {
+			c.report(Problem{Kind: KindUnparseable, Target: "entity " + id, Detail: err.Error()})
+			continue
+		}
+		c.entityIDs = append(c.entityIDs, id)
+		c.entities[id] = e
+	}
+
+	names, err := d.DiscoverGroupNames()
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		name := path.Base(k)
+		g, err := d.LoadGroup(name)
+		if err != nil {
+			c.report(Problem{Kind: KindUnparseable, Target: "group " + name, Detail: err.Error()})
+			continue
+		}
+		c.groupNames = append(c.groupNames, name)
+		c.groups[name] = g
+	}
+	return nil
+}
+
+// checkMemberships finds entities that are direct members of groups
+// that don't exist.
+func (c *checker) checkMemberships() {
+	for _, id := range c.entityIDs {
+		for _, g := range c.entities[id].GetMeta().GetGroups() {
+			if _, ok := c.groups[g]; ok {
+				continue
+			}
+			id, g := id, g
+			c.report(Problem{
+				Kind:   KindDanglingMember,
+				Target: "entity " + id,
+				Detail: fmt.Sprintf("member of group %s which does not exist", g),
+				Repair: fmt.Sprintf("remove membership of %s", g),
+				fix: func(d DB) error {
+					e, err := d.LoadEntity(id)
+					if err != nil {
+						return err
+					}
+					e.Meta.Groups = remove(e.GetMeta().GetGroups(), g)
+					return d.SaveEntity(e)
+				},
+			})
+		}
+	}
+}
+
+// checkGroups finds expansions and managing groups that refer to
+// groups that don't exist.
+func (c *checker) checkGroups() {
+	for _, name := range c.groupNames {
+		name, g := name, c.groups[name]
+		for _, exp := range g.GetExpansions() {
+			parts := strings.SplitN(exp, ":", 2)
+			if len(parts) == 2 {
+				if _, ok := c.groups[parts[1]]; ok {
+					continue
+				}
+			}
+			exp := exp
+			c.report(Problem{
+				Kind:   KindDanglingExpansion,
+				Target: "group " + name,
+				Detail: fmt.Sprintf("expansion %s does not refer to a group that exists", exp),
+				Repair: fmt.Sprintf("remove expansion %s", exp),
+				fix:    dropExpansion(name, exp),
+			})
+		}
+
+		m := g.GetManagedBy()
+		if m == "" {
+			continue
+		}
+		if _, ok := c.groups[m]; ok {
+			continue
+		}
+		c.report(Problem{
+			Kind:   KindMissingManager,
+			Target: "group " + name,
+			Detail: fmt.Sprintf("managed by group %s which does not exist", m),
+			Repair: "clear the managing group",
+			fix: func(d DB) error {
+				g, err := d.LoadGroup(name)
+				if err != nil {
+					return err
+				}
+				g.ManagedBy = nil
+				return d.SaveGroup(g)
+			},
+		})
+	}
+}
+
+// checkNumbers finds entities and groups that share a number.  The
+// first by name keeps the number and the rest are renumbered.
+func (c *checker) checkNumbers() {
+	seen := make(map[int32]string)
+	for _, id := range c.entityIDs {
+		e := c.entities[id]
+		if e.Number == nil {
+			continue
+		}
+		first, ok := seen[e.GetNumber()]
+		if !ok {
+			seen[e.GetNumber()] = id
+			continue
+		}
+		id := id
+		c.report(Problem{
+			Kind:   KindDuplicateNumber,
+			Target: "entity " + id,
+			Detail: fmt.Sprintf("number %d is also used by entity %s", e.GetNumber(), first),
+			Repair: "assign the next free number",
+			fix: func(d DB) error {
+				e, err := d.LoadEntity(id)
+				if err != nil {
+					return err
+				}
+				n, err := d.NextEntityNumber()
+				if err != nil {
+					return err
+				}
+				e.Number = &n
+				return d.SaveEntity(e)
+			},
+		})
+	}
+
+	seen = make(map[int32]string)
+	for _, name := range c.groupNames {
+		g := c.groups[name]
+		if g.Number == nil {
+			continue
+		}
+		first, ok := seen[g.GetNumber()]
+		if !ok {
+			seen[g.GetNumber()] = name
+			continue
+		}
+		name := name
+		c.report(Problem{
+			Kind:   KindDuplicateNumber,
+			Target: "group " + name,
+			Detail: fmt.Sprintf("number %d is also used by group %s", g.GetNumber(), first),
+			Repair: "assign the next free number",
+			fix: func(d DB) error {
+				g, err := d.LoadGroup(name)
+				if err != nil {
+					return err
+				}
+				n, err := d.NextGroupNumber()
+				if err != nil {
+					return err
+				}
+				g.Number = &n
+				return d.SaveGroup(g)
+			},
+		})
+	}
+}
+
+// checkCycles walks the expansions of every group and reports each
+// expansion that leads back to a group that is still being walked.
+// Removing every reported expansion breaks every cycle.
+func (c *checker) checkCycles() {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int)
+
+	var walk func(string)
+	walk = func(name string) {
+		state[name] = visiting
+		for _, exp := range c.groups[name].GetExpansions() {
+			parts := strings.SplitN(exp, ":", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			child := parts[1]
+			if _, ok := c.groups[child]; !ok {
+				continue
+			}
+			switch state[child] {
+			case unvisited:
+				walk(child)
+			case visiting:
+				c.report(Problem{
+					Kind:   KindCycle,
+					Target: "group " + name,
+					Detail: fmt.Sprintf("expansion %s closes a cycle through group %s", exp, child),
+					Repair: fmt.Sprintf("remove expansion %s", exp),
+					fix:    dropExpansion(name, exp),
+				})
+			}
+		}
+		state[name] = done
+	}
+
+	for _, name := range c.groupNames {
+		if state[name] == unvisited {
+			walk(name)
+		}
+	}
+}
+
+func dropExpansion(name, exp string) func(DB) error {
+	return func(d DB) error {
+		g, err := d.LoadGroup(name)
+		if err != nil {
+			return err
+		}
+		g.Expansions = remove(g.GetExpansions(), exp)
+		return d.SaveGroup(g)
+	}
+}
+
+// remove returns the list without any copy of s.
+func remove(list []string, s string) []string {
+	out := []string{}
+	for _, l := range list {
+		if l != s {
+			out = append(out, l)
+		}
+	}
+	return out
+}
@@ -0,0 +1,106 @@
+package fsck
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/db"
+	_ "github.com/netauth/netauth/internal/db/memory"
+	"github.com/netauth/netauth/internal/startup"
+
+	types "github.com/netauth/protocol"
+)
+
+func newDB(t *testing.T) *db.DB {
+	startup.DoCallbacks()
+
+	mdb, err := db.New("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entities := []*types.Entity{
+		{ID: proto.String("entity1"), Number: proto.Int32(1), Meta: &types.EntityMeta{Groups: []string{"group1", "missing"}}},
+		{ID: proto.String("entity2"), Number: proto.Int32(1)},
+		{ID: proto.String("entity3"), Number: proto.Int32(3)},
+	}
+	for _, e := range entities {
+		if err := mdb.SaveEntity(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	groups := []*types.Group{
+		{Name: proto.String("group1"), Number: proto.Int32(1), ManagedBy: proto.String("missing")},
+		{Name: proto.String("group2"), Number: proto.Int32(2), Expansions: []string{"INCLUDE:group3", "EXCLUDE:missing"}},
+		{Name: proto.String("group3"), Number: proto.Int32(2), Expansions: []string{"INCLUDE:group2"}},
+	}
+	for _, g := range groups {
+		if err := mdb.SaveGroup(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return mdb
+}
+
+func TestCheck(t *testing.T) {
+	mdb := newDB(t)
+
+	problems, err := Check(mdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		kind   string
+		target string
+	}{
+		{KindDanglingMember, "entity entity1"},
+		{KindMissingManager, "group group1"},
+		{KindDanglingExpansion, "group group2"},
+		{KindDuplicateNumber, "entity entity2"},
+		{KindDuplicateNumber, "group group3"},
+		{KindCycle, "group group3"},
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("Got %d problems; Want %d: %v", len(problems), len(want), problems)
+	}
+	for i, w := range want {
+		if problems[i].Kind != w.kind || problems[i].Target != w.target {
+			t.Errorf("%d: Got %s; Want %s: %s", i, problems[i], w.kind, w.target)
+		}
+	}
+}
+
+func TestRepair(t *testing.T) {
+	mdb := newDB(t)
+
+	problems, err := Check(mdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := Repair(mdb, problems)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(problems) {
+		t.Errorf("Repaired %d; Want %d", n, len(problems))
+	}
+
+	problems, err = Check(mdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("Problems remain after repair: %v", problems)
+	}
+
+	e, err := mdb.LoadEntity("entity2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.GetNumber() != 4 {
+		t.Errorf("Got number %d; Want 4", e.GetNumber())
+	}
+}
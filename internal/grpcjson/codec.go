@@ -0,0 +1,45 @@
+// Package grpcjson provides a gRPC codec that encodes messages as
+// JSON.  This allows services that are internal to NetAuth, and so
+// not part of the protocol definitions, to be served on the same
+// gRPC server without needing generated code.
+package grpcjson
+
+import (
+	"encoding/json"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+// Name is the content subtype that selects this codec.
+const Name = "netauth-json"
+
+func init() {
+	encoding.RegisterCodec(Codec{})
+}
+
+// Codec satisfies encoding.Codec using encoding/json.  Any value that
+// can be marshaled to JSON may be used as a message.
+type Codec struct{}
+
+// Marshal encodes a message.
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes a message.
+func (Codec) Unmarshal(b []byte, v interface{}) error {
+	return json.Unmarshal(b, v)
+}
+
+// Name returns the name the codec is registered under.
+func (Codec) Name() string {
+	return Name
+}
+
+// CallOption returns the option that clients must pass when calling
+// a service that uses this codec.  The server will respond using the
+// same codec.
+func CallOption() grpc.CallOption {
+	return grpc.CallContentSubtype(Name)
+}
@@ -0,0 +1,26 @@
+package grpcjson
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/encoding"
+)
+
+func TestCodec(t *testing.T) {
+	assert.NotNil(t, encoding.GetCodec(Name))
+
+	type msg struct {
+		ID    string
+		Value []byte
+	}
+	in := msg{ID: "entity1", Value: []byte{0, 1, 2}}
+
+	c := Codec{}
+	b, err := c.Marshal(in)
+	assert.Nil(t, err)
+
+	out := msg{}
+	assert.Nil(t, c.Unmarshal(b, &out))
+	assert.Equal(t, in, out)
+}
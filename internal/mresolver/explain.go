@@ -0,0 +1,119 @@
+package mresolver
+
+import (
+	"time"
+
+	"github.com/the-maldridge/bsfilter"
+)
+
+// An Explanation shows how the membership of an entity in a group
+// was resolved.  The entity is a member if it is in the group itself
+// or in any included group, and is not in any excluded group.
+type Explanation struct {
+	Group string
+
+	// Member is true if the entity is a member of the group once
+	// all of its expansions have been applied.
+	Member bool
+
+	// Direct is true if the entity is a direct member of the
+	// group, Dynamic is true if it matches the rule of a dynamic
+	// group, and Expired is true if it was a direct member but
+	// the membership has expired.
+	Direct  bool
+	Dynamic bool
+	Expired bool
+
+	// Include explains the included groups that have something
+	// to do with the entity, and Exclude explains the excluded
+	// groups that the entity is a member of.
+	Include []Explanation
+	Exclude []Explanation
+}
+
+// Explain returns an explanation of why an entity is or isn't a
+// member of a group.
+func (mr *MResolver) Explain(entity, group string) (Explanation, error) {
+	mr.uMutex.RLock()
+	now := mr.now()
+	direct := mr.atom.dd[entity]
+	dynamic := mr.atom.dy[entity]
+	until := mr.atom.ex[entity]
+	mr.uMutex.RUnlock()
+
+	mr.gMutex.RLock()
+	defer mr.gMutex.RUnlock()
+	if _, ok := mr.atom.gc[group]; !ok {
+		return Explanation{}, ErrInsufficientKnowledge
+	}
+
+	x := explainer{
+		mr:       mr,
+		direct:   direct,
+		dynamic:  dynamic,
+		until:    until,
+		now:      now,
+		visiting: make(map[string]bool),
+	}
+	return x.explain(group), nil
+}
+
+// explainer holds the memberships of the entity being explained.
+type explainer struct {
+	mr *MResolver
+
+	direct  bsfilter.ValueSet
+	dynamic bsfilter.ValueSet
+	until   map[string]time.Time
+	now     time.Time
+
+	// Groups on the path being explained, which guards against
+	// cycles that weren't caught when they were created.
+	visiting map[string]bool
+}
+
+// explain recursively explains one group.  The caller must hold
+// gMutex.
+func (x *explainer) explain(group string) Explanation {
+	e := Explanation{Group: group}
+	if _, ok := x.direct[group]; ok {
+		t, ok := x.until[group]
+		e.Expired = ok && !x.now.Before(t)
+		e.Direct = !e.Expired
+	}
+	_, e.Dynamic = x.dynamic[group]
+	e.Member = e.Direct || e.Dynamic
+
+	g, ok := x.mr.atom.gc[group]
+	if !ok || x.visiting[group] {
+		return e
+	}
+	x.visiting[group] = true
+	defer delete(x.visiting, group)
+
+	for _, name := range g.include {
+		sub := x.explain(name)
+		if sub.Member {
+			e.Member = true
+		}
+		if sub.relevant() {
+			e.Include = append(e.Include, sub)
+		}
+	}
+	for _, name := range g.exclude {
+		sub := x.explain(name)
+		if sub.Member {
+			e.Exclude = append(e.Exclude, sub)
+		}
+	}
+	if len(e.Exclude) > 0 {
+		e.Member = false
+	}
+	return e
+}
+
+// relevant returns true if the explanation has anything to say about
+// the entity.
+func (e Explanation) relevant() bool {
+	return e.Member || e.Direct || e.Dynamic || e.Expired || len(e.Include) > 0 || len(e.Exclude) > 0
+}
@@ -0,0 +1,70 @@
+package mresolver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplain(t *testing.T) {
+	x := New()
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	x.now = func() time.Time { return now }
+
+	x.SyncGroup("group1", nil, nil)
+	x.SyncGroup("group2", []string{"group1"}, nil)
+	x.SyncGroup("group3", nil, nil)
+	x.SyncGroup("group4", []string{"group2", "group3"}, nil)
+	x.SyncGroup("group5", []string{"group2"}, []string{"group3"})
+	x.SyncExpiringGroups("entity1", []string{"group1", "group3"}, map[string]time.Time{
+		"group3": now.Add(time.Hour),
+	})
+	x.SyncDynamicGroups("entity2", []string{"group3"})
+
+	_, err := x.Explain("entity1", "does-not-exist")
+	assert.Equal(t, ErrInsufficientKnowledge, err)
+
+	// Membership through an included group.
+	e, err := x.Explain("entity1", "group4")
+	assert.Nil(t, err)
+	assert.Equal(t, Explanation{
+		Group:  "group4",
+		Member: true,
+		Include: []Explanation{
+			{
+				Group:   "group2",
+				Member:  true,
+				Include: []Explanation{{Group: "group1", Member: true, Direct: true}},
+			},
+			{Group: "group3", Member: true, Direct: true},
+		},
+	}, e)
+
+	// Membership removed by an excluded group.
+	e, err = x.Explain("entity1", "group5")
+	assert.Nil(t, err)
+	assert.Equal(t, false, e.Member)
+	assert.Equal(t, []Explanation{{Group: "group3", Member: true, Direct: true}}, e.Exclude)
+
+	// Dynamic membership.
+	e, err = x.Explain("entity2", "group3")
+	assert.Nil(t, err)
+	assert.Equal(t, Explanation{Group: "group3", Member: true, Dynamic: true}, e)
+
+	// Not a member, and nothing to explain.
+	e, err = x.Explain("entity2", "group2")
+	assert.Nil(t, err)
+	assert.Equal(t, Explanation{Group: "group2"}, e)
+
+	// Once the membership expires the exclusion no longer applies.
+	now = now.Add(time.Hour)
+	e, err = x.Explain("entity1", "group5")
+	assert.Nil(t, err)
+	assert.Equal(t, true, e.Member)
+	assert.Nil(t, e.Exclude)
+
+	e, err = x.Explain("entity1", "group3")
+	assert.Nil(t, err)
+	assert.Equal(t, Explanation{Group: "group3", Expired: true}, e)
+}
@@ -1,20 +1,43 @@
 package mresolver
 
 import (
+	"sort"
+	"time"
+
 	"github.com/the-maldridge/bsfilter"
 )
 
 // SyncDirectGroups updates the list of groups in the resolver for a
 // given entity with whatever the list actually is now.
 func (mr *MResolver) SyncDirectGroups(entity string, groups []string) {
-	list := make(map[string]struct{}, len(groups))
-	for i := range groups {
-		list[groups[i]] = struct{}{}
+	mr.SyncExpiringGroups(entity, groups, nil)
+}
+
+// SyncExpiringGroups updates the list of groups in the resolver for a
+// given entity, along with the times at which some of them expire.
+// A membership is not honoured at or after the time that it expires.
+func (mr *MResolver) SyncExpiringGroups(entity string, groups []string, until map[string]time.Time) {
+	mr.uMutex.Lock()
+	mr.atom.dd[entity] = valueSet(groups)
+	mr.atom.dm[entity] = union(mr.atom.dd[entity], mr.atom.dy[entity])
+	if len(until) > 0 {
+		mr.atom.ex[entity] = until
+	} else {
+		delete(mr.atom.ex, entity)
 	}
+	mr.uMutex.Unlock()
+	mr.l.Trace("Synced direct groups", "entity", entity, "groups", groups, "until", until)
+}
+
+// SyncDynamicGroups updates the list of dynamic groups whose rules
+// match a given entity.  The entity is a member of these groups in
+// the same way as if it were a direct member of them.
+func (mr *MResolver) SyncDynamicGroups(entity string, groups []string) {
 	mr.uMutex.Lock()
-	mr.atom.dm[entity] = list
+	mr.atom.dy[entity] = valueSet(groups)
+	mr.atom.dm[entity] = union(mr.atom.dd[entity], mr.atom.dy[entity])
 	mr.uMutex.Unlock()
-	mr.l.Trace("Synced direct groups", "entity", entity, "groups", groups)
+	mr.l.Trace("Synced dynamic groups", "entity", entity, "groups", groups)
 }
 
 // RemoveEntity removes an entity from the map, this is meant to
@@ -22,9 +45,31 @@ func (mr *MResolver) SyncDirectGroups(entity string, groups []string) {
 func (mr *MResolver) RemoveEntity(entity string) {
 	mr.uMutex.Lock()
 	delete(mr.atom.dm, entity)
+	delete(mr.atom.dd, entity)
+	delete(mr.atom.dy, entity)
+	delete(mr.atom.ex, entity)
 	mr.uMutex.Unlock()
 }
 
+func valueSet(groups []string) bsfilter.ValueSet {
+	set := make(map[string]struct{}, len(groups))
+	for i := range groups {
+		set[groups[i]] = struct{}{}
+	}
+	return set
+}
+
+func union(a, b bsfilter.ValueSet) bsfilter.ValueSet {
+	set := make(map[string]struct{}, len(a)+len(b))
+	for g := range a {
+		set[g] = struct{}{}
+	}
+	for g := range b {
+		set[g] = struct{}{}
+	}
+	return set
+}
+
 // SyncGroup provides the resolver with current infomation about a
 // given group.  Information here strictly overwrites other
 // information in the system, and may trigger a cascading membership
@@ -194,17 +239,77 @@ func (mr *MResolver) MembersOfGroup(group string) []string {
 	if !ok {
 		return []string{}
 	}
-	return exp.FilterValues(mr.atom.dm)
+
+	mr.uMutex.RLock()
+	defer mr.uMutex.RUnlock()
+	if len(mr.atom.ex) == 0 {
+		return exp.FilterValues(mr.atom.dm)
+	}
+	now := mr.now()
+	vals := make(map[string]bsfilter.ValueSet, len(mr.atom.dm))
+	for entity := range mr.atom.dm {
+		vals[entity] = mr.current(entity, now)
+	}
+	return exp.FilterValues(vals)
 }
 
 // GroupsForEntity returns a string slice of groups that include a
 // given entity.
 func (mr *MResolver) GroupsForEntity(entity string) []string {
 	mr.uMutex.RLock()
-	vset, ok := mr.atom.dm[entity]
+	_, ok := mr.atom.dm[entity]
+	vset := mr.current(entity, mr.now())
 	mr.uMutex.RUnlock()
 	if !ok {
 		return []string{}
 	}
 	return mr.atom.gs.Filter(vset)
 }
+
+// GroupsIncluding returns the groups that include a given group,
+// either directly or through other groups that they include.  The
+// members of the group are members of all of these groups unless
+// they are excluded again.
+func (mr *MResolver) GroupsIncluding(group string) []string {
+	mr.gMutex.RLock()
+	defer mr.gMutex.RUnlock()
+
+	seen := map[string]bool{group: true}
+	out := []string{}
+	for next := []string{group}; len(next) > 0; {
+		g := next[0]
+		next = next[1:]
+		for name, rg := range mr.atom.gc {
+			if seen[name] {
+				continue
+			}
+			for _, i := range rg.include {
+				if i == g {
+					seen[name] = true
+					out = append(out, name)
+					next = append(next, name)
+					break
+				}
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// current returns the memberships of an entity without the ones that
+// have expired by now.  The caller must hold uMutex.
+func (mr *MResolver) current(entity string, now time.Time) bsfilter.ValueSet {
+	until := mr.atom.ex[entity]
+	if len(until) == 0 {
+		return mr.atom.dm[entity]
+	}
+	direct := make(map[string]struct{}, len(mr.atom.dd[entity]))
+	for g := range mr.atom.dd[entity] {
+		if t, ok := until[g]; ok && !now.Before(t) {
+			continue
+		}
+		direct[g] = struct{}{}
+	}
+	return union(direct, mr.atom.dy[entity])
+}
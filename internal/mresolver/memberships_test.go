@@ -2,6 +2,7 @@ package mresolver
 
 import (
 	"testing"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/stretchr/testify/assert"
@@ -68,6 +69,60 @@ func TestSyncDirectGroups(t *testing.T) {
 	assert.Equal(t, 0, len(x.atom.dm))
 }
 
+func TestSyncDynamicGroups(t *testing.T) {
+	x := New()
+	x.SyncDirectGroups("entity1", []string{"group1"})
+	x.SyncDynamicGroups("entity1", []string{"dynamic1", "group1"})
+	assert.Equal(t, 2, len(x.atom.dm["entity1"]))
+
+	// Syncing direct groups keeps the dynamic ones.
+	x.SyncDirectGroups("entity1", nil)
+	assert.Equal(t, 2, len(x.atom.dm["entity1"]))
+	x.SyncDynamicGroups("entity1", nil)
+	assert.Equal(t, 0, len(x.atom.dm["entity1"]))
+
+	// A dynamic group can be expanded into another group.
+	x.SyncDynamicGroups("entity2", []string{"dynamic1"})
+	x.SyncGroup("dynamic1", nil, nil)
+	x.SyncGroup("group2", []string{"dynamic1"}, nil)
+	assert.Equal(t, []string{"entity2"}, x.MembersOfGroup("group2"))
+	assert.ElementsMatch(t, []string{"dynamic1", "group2"}, x.GroupsForEntity("entity2"))
+
+	x.RemoveEntity("entity2")
+	_, ok := x.atom.dy["entity2"]
+	assert.Equal(t, false, ok)
+}
+
+func TestSyncExpiringGroups(t *testing.T) {
+	x := New()
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	x.now = func() time.Time { return now }
+
+	x.SyncGroup("group1", nil, nil)
+	x.SyncGroup("group2", nil, nil)
+	x.SyncGroup("group3", []string{"group2"}, nil)
+	x.SyncExpiringGroups("entity1", []string{"group1", "group2"}, map[string]time.Time{
+		"group2": now.Add(time.Hour),
+	})
+	x.SyncDirectGroups("entity2", []string{"group2"})
+
+	assert.ElementsMatch(t, []string{"group1", "group2", "group3"}, x.GroupsForEntity("entity1"))
+	assert.ElementsMatch(t, []string{"entity1", "entity2"}, x.MembersOfGroup("group3"))
+
+	// Once the membership expires it isn't honoured, even though
+	// it hasn't been removed yet.
+	now = now.Add(time.Hour)
+	assert.ElementsMatch(t, []string{"group1"}, x.GroupsForEntity("entity1"))
+	assert.ElementsMatch(t, []string{"entity2"}, x.MembersOfGroup("group3"))
+
+	// A dynamic membership in the same group doesn't expire.
+	x.SyncDynamicGroups("entity1", []string{"group2"})
+	assert.ElementsMatch(t, []string{"group1", "group2", "group3"}, x.GroupsForEntity("entity1"))
+
+	x.RemoveEntity("entity1")
+	assert.Equal(t, 0, len(x.atom.ex))
+}
+
 func TestSyncGroup(t *testing.T) {
 	x := New()
 	l := hclog.New(&hclog.LoggerOptions{Name: "debug"})
@@ -150,6 +205,15 @@ func TestMembersOfGroup(t *testing.T) {
 	assert.Equal(t, []string{}, x.MembersOfGroup("does-not-exist"))
 }
 
+func TestGroupsIncluding(t *testing.T) {
+	x := New()
+	testAtom(x)
+
+	assert.Equal(t, []string{"group2", "group4", "group5"}, x.GroupsIncluding("group1"))
+	assert.Equal(t, []string{"group5"}, x.GroupsIncluding("group2"))
+	assert.Equal(t, []string{}, x.GroupsIncluding("group0"))
+}
+
 func TestGroupsForEntity(t *testing.T) {
 	x := New()
 	testAtom(x)
@@ -1,6 +1,8 @@
 package mresolver
 
 import (
+	"time"
+
 	"github.com/hashicorp/go-hclog"
 
 	"github.com/the-maldridge/bsfilter"
@@ -9,9 +11,13 @@ import (
 // New sets up a new resolver.
 func New() *MResolver {
 	return &MResolver{
-		l: hclog.NewNullLogger(),
+		l:   hclog.NewNullLogger(),
+		now: time.Now,
 		atom: resolverAtom{
 			dm: make(map[string]bsfilter.ValueSet),
+			dd: make(map[string]bsfilter.ValueSet),
+			dy: make(map[string]bsfilter.ValueSet),
+			ex: make(map[string]map[string]time.Time),
 			gc: make(map[string]*resolvableGroup),
 			gr: make(map[string]*bsfilter.Expression),
 			gt: make(map[string][]bsfilter.Symbol),
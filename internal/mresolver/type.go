@@ -2,6 +2,7 @@ package mresolver
 
 import (
 	"sync"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 
@@ -18,10 +19,17 @@ type MResolver struct {
 	gMutex sync.RWMutex
 
 	atom resolverAtom
+
+	// now is the time against which memberships are checked for
+	// expiry.
+	now func() time.Time
 }
 
 type resolverAtom struct {
-	dm map[string]bsfilter.ValueSet    // Cache of direct memberships
+	dm map[string]bsfilter.ValueSet    // Cache of direct and dynamic memberships
+	dd map[string]bsfilter.ValueSet    // Cache of only direct memberships
+	dy map[string]bsfilter.ValueSet    // Cache of only dynamic memberships
+	ex map[string]map[string]time.Time // Cache of when direct memberships expire
 	gc map[string]*resolvableGroup     // Cache of groups and rules
 	gr map[string]*bsfilter.Expression // Resolved expressions
 	gt map[string][]bsfilter.Symbol    // Cache of subexpressions
@@ -0,0 +1,128 @@
+package mresolver
+
+import (
+	"sort"
+)
+
+// A Hypothesis is a set of changes to the direct memberships of an
+// entity and to the expansions of groups, which GroupsForEntityIf
+// evaluates without applying them.
+type Hypothesis struct {
+	// Join and Leave are the groups that the entity would be
+	// added to and removed from directly.  Leaving a group does
+	// not remove a dynamic membership in it.
+	Join  []string
+	Leave []string
+
+	// Expansions are applied in order to the expansions of the
+	// groups that they name.
+	Expansions []Expansion
+}
+
+// An Expansion is a change to the expansions of a group.  Mode is
+// INCLUDE or EXCLUDE to add an expansion of Child to Parent, or DROP
+// to remove it.
+type Expansion struct {
+	Parent string
+	Child  string
+	Mode   string
+}
+
+// GroupsForEntityIf returns the groups that an entity would be a
+// member of if the changes in the hypothesis were made.  The groups
+// are sorted by name.
+func (mr *MResolver) GroupsForEntityIf(entity string, h Hypothesis) []string {
+	mr.uMutex.RLock()
+	direct := union(mr.current(entity, mr.now()), nil)
+	for _, g := range h.Leave {
+		if _, ok := mr.atom.dy[entity][g]; !ok {
+			delete(direct, g)
+		}
+	}
+	mr.uMutex.RUnlock()
+	for _, g := range h.Join {
+		direct[g] = struct{}{}
+	}
+
+	mr.gMutex.RLock()
+	groups := make(map[string]*resolvableGroup, len(mr.atom.gc))
+	for name, g := range mr.atom.gc {
+		groups[name] = g
+	}
+	mr.gMutex.RUnlock()
+
+	for _, x := range h.Expansions {
+		g := resolvableGroup{self: x.Parent}
+		if old, ok := groups[x.Parent]; ok {
+			g.include = without(old.include, x.Child)
+			g.exclude = without(old.exclude, x.Child)
+		}
+		switch x.Mode {
+		case "INCLUDE":
+			g.include = append(g.include, x.Child)
+		case "EXCLUDE":
+			g.exclude = append(g.exclude, x.Child)
+		}
+		groups[x.Parent] = &g
+	}
+
+	ev := evaluator{
+		groups:   groups,
+		direct:   direct,
+		memo:     make(map[string]bool),
+		visiting: make(map[string]bool),
+	}
+	var out []string
+	for name := range groups {
+		if ev.member(name) {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// evaluator decides the memberships of a single entity by walking the
+// expansions of the groups.
+type evaluator struct {
+	groups   map[string]*resolvableGroup
+	direct   map[string]struct{}
+	memo     map[string]bool
+	visiting map[string]bool
+}
+
+func (ev *evaluator) member(group string) bool {
+	if m, ok := ev.memo[group]; ok {
+		return m
+	}
+	_, m := ev.direct[group]
+	g, ok := ev.groups[group]
+	if !ok || ev.visiting[group] {
+		return m
+	}
+	ev.visiting[group] = true
+	for _, name := range g.include {
+		if ev.member(name) {
+			m = true
+		}
+	}
+	for _, name := range g.exclude {
+		if ev.member(name) {
+			m = false
+		}
+	}
+	delete(ev.visiting, group)
+	ev.memo[group] = m
+	return m
+}
+
+// without returns a copy of names with name removed.
+func without(names []string, name string) []string {
+	var out []string
+	for _, n := range names {
+		if n != name {
+			out = append(out, n)
+		}
+	}
+	return out
+}
@@ -0,0 +1,40 @@
+package mresolver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupsForEntityIf(t *testing.T) {
+	x := New()
+	x.SyncGroup("group1", nil, nil)
+	x.SyncGroup("group2", []string{"group1"}, nil)
+	x.SyncGroup("group3", nil, nil)
+	x.SyncGroup("group4", []string{"group2"}, []string{"group3"})
+	x.SyncDirectGroups("entity1", []string{"group1"})
+	x.SyncDynamicGroups("entity1", []string{"group3"})
+
+	// No changes gives the same answer as GroupsForEntity.
+	assert.ElementsMatch(t, x.GroupsForEntity("entity1"), x.GroupsForEntityIf("entity1", Hypothesis{}))
+
+	// Leaving a group doesn't remove a dynamic membership.
+	assert.Equal(t, []string{"group3"}, x.GroupsForEntityIf("entity1", Hypothesis{
+		Leave: []string{"group1", "group3"},
+	}))
+
+	// Dropping the exclusion lets the entity into group4.
+	assert.Equal(t, []string{"group1", "group2", "group3", "group4"}, x.GroupsForEntityIf("entity1", Hypothesis{
+		Expansions: []Expansion{{Parent: "group4", Child: "group3", Mode: "DROP"}},
+	}))
+
+	// Joining a group and adding an expansion.
+	assert.Equal(t, []string{"group1", "group2", "group3", "group5"}, x.GroupsForEntityIf("entity2", Hypothesis{
+		Join:       []string{"group1", "group3"},
+		Expansions: []Expansion{{Parent: "group5", Child: "group2", Mode: "INCLUDE"}},
+	}))
+
+	// Nothing was actually changed.
+	assert.ElementsMatch(t, []string{"group1", "group2", "group3"}, x.GroupsForEntity("entity1"))
+	assert.ElementsMatch(t, []string{}, x.GroupsForEntity("entity2"))
+}
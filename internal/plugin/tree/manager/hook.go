@@ -7,6 +7,7 @@ import (
 	"github.com/golang/protobuf/proto"
 
 	"github.com/netauth/netauth/internal/plugin/tree/common"
+	"github.com/netauth/netauth/internal/tree"
 
 	pb "github.com/netauth/protocol"
 )
@@ -32,7 +33,7 @@ func (h EntityHook) Priority() int {
 // Run invokes each registered plugin in a non-deterministic order.
 // The only order that is guaranteed by this interface is that the
 // actions will be called in the same place in the chain each time.
-func (h EntityHook) Run(e, de *pb.Entity) error {
+func (h EntityHook) Run(_ tree.Txn, e, de *pb.Entity) error {
 	opts := common.PluginOpts{
 		Action:     h.action,
 		Entity:     e,
@@ -74,7 +75,7 @@ func (h GroupHook) Priority() int {
 // Run invokes each registered plugin in a non-deterministic order.
 // The only order that is guaranteed by this interface is that the
 // actions will be called in the same place in the chain each time.
-func (h GroupHook) Run(g, dg *pb.Group) error {
+func (h GroupHook) Run(_ tree.Txn, g, dg *pb.Group) error {
 	opts := common.PluginOpts{
 		Action:    h.action,
 		Group:     g,
@@ -0,0 +1,351 @@
+// Package policy decides whether requests to the server are allowed
+// by rules that an operator declares, rather than by capabilities
+// alone.  Each rule names the methods that it applies to and the
+// attributes of the caller and target that it matches, and either
+// allows or denies the request.  The rules are evaluated in order and
+// the first that matches decides; if none match the capabilities of
+// the caller decide as they always have.
+package policy
+
+import (
+	"errors"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/viper"
+
+	pb "github.com/netauth/protocol"
+)
+
+// The effects that a rule can have.
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// The modes that the engine can run in.  In DryRun mode the rules are
+// evaluated and their decisions logged, but not enforced, so that new
+// rules can be rolled out safely.
+const (
+	Enforce = "enforce"
+	DryRun  = "dry-run"
+)
+
+var (
+	// ErrBadRule is returned if a rule can't be evaluated.
+	ErrBadRule = errors.New("the policy rule is invalid")
+
+	// ErrBadMode is returned if the mode is not one of Enforce
+	// or DryRun.
+	ErrBadMode = errors.New("the policy mode is invalid")
+)
+
+// A Rule allows or denies the requests that it matches.  A request
+// matches if its method matches any of Methods, which may contain
+// the wildcards understood by path.Match, and it matches Self, Caller,
+// and Target.
+//
+// Self is "self" to match only requests where the caller is the
+// target entity, "other" to match only those where it isn't, or empty
+// to match either.
+type Rule struct {
+	Name    string
+	Methods []string
+	Effect  string
+	Self    string
+	Caller  Match
+	Target  Match
+}
+
+// A Match selects entities and groups by their attributes.  An empty
+// Match selects everything.
+//
+// An entity is selected if it is a member of any of Groups, and has
+// all of KV.  A group is selected if it is one of Groups, and has all
+// of KV.  Each KV item is written key:value, and a value of * selects
+// any value.
+type Match struct {
+	Groups []string
+	KV     []string
+}
+
+// Request is what the engine knows about a request.  Caller is the
+// entity that holds the token, Entity the entity that the request
+// acts on, and Group the group that it acts on.  Either or both of
+// Entity and Group may be empty.
+type Request struct {
+	Method string
+	Caller string
+	Entity string
+	Group  string
+}
+
+// A Decision is the result of evaluating the rules.  Effect is Allow
+// or Deny, or empty if no rule matched, and Rule is the name of the
+// rule that decided.
+type Decision struct {
+	Rule   string
+	Effect string
+}
+
+// Source provides the attributes of entities and groups.
+type Source interface {
+	FetchEntity(string) (*pb.Entity, error)
+	FetchGroup(string) (*pb.Group, error)
+	GetMemberships(*pb.Entity) []string
+}
+
+// Engine evaluates the rules against requests.
+type Engine struct {
+	src   Source
+	rules []Rule
+	mode  string
+}
+
+// New returns an Engine with the rules in policy.rules followed by
+// those in the file named by policy.file, which is relative to
+// core.conf and may be in any format that the server config can be.
+// The engine runs in policy.mode.  If no rules are declared New
+// returns nil, which is an engine that never decides anything.
+func New(src Source, l hclog.Logger) (*Engine, error) {
+	var rules []Rule
+	if err := viper.UnmarshalKey("policy.rules", &rules); err != nil {
+		return nil, err
+	}
+	if f := viper.GetString("policy.file"); f != "" {
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(viper.GetString("core.conf"), f)
+		}
+		fr, err := Load(f)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, fr...)
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	mode := viper.GetString("policy.mode")
+	if mode == "" {
+		mode = Enforce
+	}
+	return NewEngine(src, l, rules, mode)
+}
+
+// NewEngine returns an Engine with the provided rules, which are
+// checked before they are used.
+func NewEngine(src Source, l hclog.Logger, rules []Rule, mode string) (*Engine, error) {
+	if mode != Enforce && mode != DryRun {
+		return nil, ErrBadMode
+	}
+	for _, r := range rules {
+		if err := r.check(); err != nil {
+			l.Error("Invalid policy rule", "rule", r.Name, "error", err)
+			return nil, err
+		}
+	}
+	return &Engine{
+		src:   src,
+		rules: rules,
+		mode:  mode,
+	}, nil
+}
+
+// Load reads the rules from a policy file.  The rules are a list
+// under the key rules.
+func Load(f string) ([]Rule, error) {
+	v := viper.New()
+	v.SetConfigFile(f)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	if err := v.UnmarshalKey("rules", &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// DryRun returns true if the decisions of the engine are logged but
+// not enforced.  A nil Engine is never in dry run mode.
+func (e *Engine) DryRun() bool {
+	return e != nil && e.mode == DryRun
+}
+
+// Decide evaluates the rules against a request.  A nil Engine
+// decides nothing.
+func (e *Engine) Decide(r Request) Decision {
+	if e == nil {
+		return Decision{}
+	}
+
+	a := attributes{src: e.src}
+	for _, rule := range e.rules {
+		if rule.matches(r, &a) {
+			return Decision{Rule: rule.Name, Effect: rule.Effect}
+		}
+	}
+	return Decision{}
+}
+
+// check returns an error if the rule can't be evaluated.
+func (r Rule) check() error {
+	if r.Effect != Allow && r.Effect != Deny {
+		return ErrBadRule
+	}
+	if len(r.Methods) == 0 {
+		return ErrBadRule
+	}
+	for _, m := range r.Methods {
+		if _, err := path.Match(m, ""); err != nil {
+			return ErrBadRule
+		}
+	}
+	switch r.Self {
+	case "", "self", "other":
+	default:
+		return ErrBadRule
+	}
+	for _, m := range []Match{r.Caller, r.Target} {
+		for _, kv := range m.KV {
+			if !strings.Contains(kv, ":") {
+				return ErrBadRule
+			}
+		}
+	}
+	return nil
+}
+
+// matches returns true if the rule matches the request.
+func (r Rule) matches(req Request, a *attributes) bool {
+	if !r.matchesMethod(req.Method) {
+		return false
+	}
+
+	self := req.Entity != "" && req.Entity == req.Caller
+	switch {
+	case r.Self == "self" && !self:
+		return false
+	case r.Self == "other" && self:
+		return false
+	}
+
+	if !a.entityMatches(req.Caller, r.Caller) {
+		return false
+	}
+	if req.Entity != "" && !a.entityMatches(req.Entity, r.Target) {
+		return false
+	}
+	if req.Group != "" && !a.groupMatches(req.Group, r.Target) {
+		return false
+	}
+	if req.Entity == "" && req.Group == "" && !r.Target.empty() {
+		return false
+	}
+	return true
+}
+
+func (r Rule) matchesMethod(method string) bool {
+	for _, m := range r.Methods {
+		if ok, _ := path.Match(m, method); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (m Match) empty() bool {
+	return len(m.Groups) == 0 && len(m.KV) == 0
+}
+
+// attributes loads the entities and groups that a request refers to,
+// once each.
+type attributes struct {
+	src      Source
+	entities map[string]*pb.Entity
+	groups   map[string]*pb.Group
+	members  map[string][]string
+}
+
+func (a *attributes) entityMatches(id string, m Match) bool {
+	if m.empty() {
+		return true
+	}
+	if a.entities == nil {
+		a.entities = make(map[string]*pb.Entity)
+		a.members = make(map[string][]string)
+	}
+	e, ok := a.entities[id]
+	if !ok {
+		e, _ = a.src.FetchEntity(id)
+		a.entities[id] = e
+		if e != nil {
+			a.members[id] = a.src.GetMemberships(e)
+		}
+	}
+	if e == nil {
+		return false
+	}
+	return anyOf(m.Groups, a.members[id]) && hasKV(e.GetMeta().GetKV(), m.KV)
+}
+
+func (a *attributes) groupMatches(name string, m Match) bool {
+	if m.empty() {
+		return true
+	}
+	if a.groups == nil {
+		a.groups = make(map[string]*pb.Group)
+	}
+	g, ok := a.groups[name]
+	if !ok {
+		g, _ = a.src.FetchGroup(name)
+		a.groups[name] = g
+	}
+	if g == nil {
+		return false
+	}
+	return anyOf(m.Groups, []string{name}) && hasKV(g.GetKV(), m.KV)
+}
+
+// anyOf returns true if want is empty or any of want is in have.
+func anyOf(want, have []string) bool {
+	if len(want) == 0 {
+		return true
+	}
+	for _, w := range want {
+		for _, h := range have {
+			if w == h {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasKV returns true if the KV data has every key:value in want.
+func hasKV(kv []*pb.KVData, want []string) bool {
+	for _, w := range want {
+		parts := strings.SplitN(w, ":", 2)
+		if !hasValue(kv, parts[0], parts[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasValue(kv []*pb.KVData, key, value string) bool {
+	for _, d := range kv {
+		if d.GetKey() != key {
+			continue
+		}
+		for _, v := range d.GetValues() {
+			if value == "*" || v.GetValue() == value {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+package policy
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/go-hclog"
+
+	pb "github.com/netauth/protocol"
+)
+
+type fakeSource struct{}
+
+func (fakeSource) FetchEntity(id string) (*pb.Entity, error) {
+	switch id {
+	case "alice":
+		return &pb.Entity{
+			ID: proto.String("alice"),
+			Meta: &pb.EntityMeta{
+				KV: []*pb.KVData{{
+					Key:    proto.String("team"),
+					Values: []*pb.KVValue{{Value: proto.String("infra")}},
+				}},
+			},
+		}, nil
+	case "bob":
+		return &pb.Entity{ID: proto.String("bob")}, nil
+	}
+	return nil, errors.New("unknown entity")
+}
+
+func (fakeSource) FetchGroup(name string) (*pb.Group, error) {
+	switch name {
+	case "infra", "ops":
+		return &pb.Group{Name: proto.String(name)}, nil
+	}
+	return nil, errors.New("unknown group")
+}
+
+func (fakeSource) GetMemberships(e *pb.Entity) []string {
+	if e.GetID() == "alice" {
+		return []string{"infra"}
+	}
+	return nil
+}
+
+func TestDecide(t *testing.T) {
+	rules := []Rule{
+		{
+			Name:    "no-destroy",
+			Methods: []string{"*Destroy"},
+			Effect:  Deny,
+		},
+		{
+			Name:    "self-keys",
+			Methods: []string{"EntityKeys"},
+			Effect:  Allow,
+			Self:    "self",
+		},
+		{
+			Name:    "infra-members",
+			Methods: []string{"GroupAddMember", "GroupDelMember"},
+			Effect:  Allow,
+			Caller:  Match{KV: []string{"team:infra"}},
+			Target:  Match{Groups: []string{"infra"}},
+		},
+		{
+			Name:    "infra-entities",
+			Methods: []string{"Entity*"},
+			Effect:  Allow,
+			Self:    "other",
+			Caller:  Match{Groups: []string{"infra"}},
+			Target:  Match{KV: []string{"team:*"}},
+		},
+	}
+	e, err := NewEngine(fakeSource{}, hclog.NewNullLogger(), rules, Enforce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		req  Request
+		want Decision
+	}{
+		{Request{Method: "EntityDestroy", Caller: "alice", Entity: "bob"}, Decision{"no-destroy", Deny}},
+		{Request{Method: "GroupDestroy", Caller: "alice", Group: "ops"}, Decision{"no-destroy", Deny}},
+		{Request{Method: "EntityKeys", Caller: "bob", Entity: "bob"}, Decision{"self-keys", Allow}},
+		{Request{Method: "EntityKeys", Caller: "bob", Entity: "alice"}, Decision{}},
+		{Request{Method: "GroupAddMember", Caller: "alice", Entity: "bob", Group: "infra"}, Decision{}},
+		{Request{Method: "GroupAddMember", Caller: "alice", Group: "infra"}, Decision{"infra-members", Allow}},
+		{Request{Method: "GroupAddMember", Caller: "alice", Group: "ops"}, Decision{}},
+		{Request{Method: "GroupAddMember", Caller: "bob", Group: "infra"}, Decision{}},
+		{Request{Method: "EntityLock", Caller: "alice", Entity: "alice"}, Decision{}},
+		{Request{Method: "EntityLock", Caller: "alice", Entity: "bob"}, Decision{}},
+		{Request{Method: "EntityCreate", Caller: "alice"}, Decision{}},
+		{Request{Method: "GroupCreate", Caller: "alice"}, Decision{}},
+	}
+
+	for i, c := range cases {
+		if got := e.Decide(c.req); got != c.want {
+			t.Errorf("%d: Got %v; Want %v", i, got, c.want)
+		}
+	}
+}
+
+func TestDecideNil(t *testing.T) {
+	var e *Engine
+	if got := e.Decide(Request{Method: "EntityCreate"}); got != (Decision{}) {
+		t.Errorf("Got %v; Want no decision", got)
+	}
+	if e.DryRun() {
+		t.Error("Nil engine is in dry run mode")
+	}
+}
+
+func TestNewEngine(t *testing.T) {
+	cases := []struct {
+		rule    Rule
+		mode    string
+		wantErr error
+	}{
+		{Rule{Methods: []string{"*"}, Effect: Allow}, Enforce, nil},
+		{Rule{Methods: []string{"*"}, Effect: Deny}, DryRun, nil},
+		{Rule{Methods: []string{"*"}, Effect: Allow}, "audit", ErrBadMode},
+		{Rule{Methods: []string{"*"}, Effect: "maybe"}, Enforce, ErrBadRule},
+		{Rule{Effect: Allow}, Enforce, ErrBadRule},
+		{Rule{Methods: []string{"["}, Effect: Allow}, Enforce, ErrBadRule},
+		{Rule{Methods: []string{"*"}, Effect: Allow, Self: "others"}, Enforce, ErrBadRule},
+		{Rule{Methods: []string{"*"}, Effect: Allow, Target: Match{KV: []string{"team"}}}, Enforce, ErrBadRule},
+	}
+
+	for i, c := range cases {
+		if _, err := NewEngine(fakeSource{}, hclog.NewNullLogger(), []Rule{c.rule}, c.mode); err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "policy.yaml")
+	policy := `rules:
+  - name: infra-members
+    methods: [GroupAddMember, GroupDelMember]
+    effect: allow
+    caller:
+      kv: ["Team:infra"]
+    target:
+      groups: [infra]
+`
+	if err := ioutil.WriteFile(f, []byte(policy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := Load(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 {
+		t.Fatalf("Got %d rules; Want 1", len(rules))
+	}
+	r := rules[0]
+	if r.Name != "infra-members" || r.Effect != Allow || len(r.Methods) != 2 {
+		t.Errorf("Bad rule: %+v", r)
+	}
+	if len(r.Caller.KV) != 1 || r.Caller.KV[0] != "Team:infra" {
+		t.Errorf("Bad caller: %+v", r.Caller)
+	}
+	if len(r.Target.Groups) != 1 || r.Target.Groups[0] != "infra" {
+		t.Errorf("Bad target: %+v", r.Target)
+	}
+
+	if _, err := Load(filepath.Join(dir, "does-not-exist.yaml")); err == nil {
+		t.Error("Missing file loaded without error")
+	}
+}
@@ -0,0 +1,272 @@
+package replica
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/health"
+
+	types "github.com/netauth/protocol"
+)
+
+func init() {
+	viper.SetDefault("replica.maxlag", 30*time.Second)
+}
+
+// ReplicaDB is the subset of the database that is needed to apply
+// changes from the master.
+type ReplicaDB interface {
+	DiscoverEntityIDs() ([]string, error)
+	SaveEntity(*types.Entity) error
+	DeleteEntity(string) error
+	DiscoverGroupNames() ([]string, error)
+	SaveGroup(*types.Group) error
+	DeleteGroup(string) error
+}
+
+// Follower keeps a database in sync with a master.
+type Follower struct {
+	db  ReplicaDB
+	cc  *grpc.ClientConn
+	log hclog.Logger
+
+	secret string
+	maxLag time.Duration
+
+	mu        sync.Mutex
+	connected bool
+	synced    bool
+	behind    time.Duration
+	received  time.Time
+}
+
+// NewFollower returns a Follower that will apply changes from the
+// master at the other end of cc to the provided database.  The
+// secret is taken from replica.secret, and the replica is considered
+// unhealthy once it is more than replica.maxlag behind.
+func NewFollower(d ReplicaDB, cc *grpc.ClientConn, l hclog.Logger) *Follower {
+	return &Follower{
+		db:     d,
+		cc:     cc,
+		log:    l.Named("replica"),
+		secret: viper.GetString("replica.secret"),
+		maxLag: viper.GetDuration("replica.maxlag"),
+	}
+}
+
+// RegisterCheck registers the replica's health check, which reports
+// the replication lag.
+func (f *Follower) RegisterCheck() {
+	health.RegisterCheck("replica", f.Check)
+}
+
+// Run follows the master until the context is cancelled.  If the
+// connection is lost it is retried with a backoff, and the replica
+// resynchronizes fully once it is reconnected.
+func (f *Follower) Run(ctx context.Context) {
+	backoff := time.Second
+	for {
+		synced, err := f.follow(ctx)
+		f.setConnected(false)
+		if ctx.Err() != nil {
+			return
+		}
+		if synced {
+			backoff = time.Second
+		}
+		f.log.Warn("Lost connection to master", "error", err, "retry", backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// follow subscribes to the master and applies changes until the
+// stream ends.  The returned bool reports whether the initial sync
+// completed.
+func (f *Follower) follow(ctx context.Context) (bool, error) {
+	ctx = metadata.AppendToOutgoingContext(ctx, secretKey, f.secret)
+	stream, err := subscribe(ctx, f.cc, &SubscribeRequest{})
+	if err != nil {
+		return false, err
+	}
+	f.setConnected(true)
+
+	// Everything received before KindSynced is recorded so that
+	// anything the master no longer has can be removed.
+	entities := make(map[string]struct{})
+	groups := make(map[string]struct{})
+	synced := false
+
+	for {
+		c := new(Change)
+		if err := stream.RecvMsg(c); err != nil {
+			return synced, err
+		}
+
+		switch c.Kind {
+		case KindEntity:
+			if !synced {
+				entities[c.ID] = struct{}{}
+			}
+			err = f.applyEntity(c)
+		case KindGroup:
+			if !synced {
+				groups[c.ID] = struct{}{}
+			}
+			err = f.applyGroup(c)
+		case KindSynced:
+			err = f.prune(entities, groups)
+			entities, groups = nil, nil
+			synced = true
+			f.log.Info("Synchronized with master")
+		}
+		if err != nil {
+			return synced, err
+		}
+		if synced {
+			f.mark(c)
+		}
+	}
+}
+
+func (f *Follower) applyEntity(c *Change) error {
+	if c.Value == nil {
+		if err := f.db.DeleteEntity(c.ID); err != nil && err != db.ErrUnknownEntity {
+			return err
+		}
+		f.log.Debug("Entity removed", "entity", c.ID)
+		return nil
+	}
+
+	e := &types.Entity{}
+	if err := proto.Unmarshal(c.Value, e); err != nil {
+		return err
+	}
+	f.log.Debug("Entity updated", "entity", c.ID)
+	return f.db.SaveEntity(e)
+}
+
+func (f *Follower) applyGroup(c *Change) error {
+	if c.Value == nil {
+		if err := f.db.DeleteGroup(c.ID); err != nil && err != db.ErrUnknownGroup {
+			return err
+		}
+		f.log.Debug("Group removed", "group", c.ID)
+		return nil
+	}
+
+	g := &types.Group{}
+	if err := proto.Unmarshal(c.Value, g); err != nil {
+		return err
+	}
+	f.log.Debug("Group updated", "group", c.ID)
+	return f.db.SaveGroup(g)
+}
+
+// prune removes any entity or group that was not sent during the
+// initial sync.
+func (f *Follower) prune(entities, groups map[string]struct{}) error {
+	ids, err := f.db.DiscoverEntityIDs()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if _, ok := entities[path.Base(id)]; ok {
+			continue
+		}
+		if err := f.db.DeleteEntity(path.Base(id)); err != nil && err != db.ErrUnknownEntity {
+			return err
+		}
+		f.log.Debug("Entity pruned", "entity", path.Base(id))
+	}
+
+	names, err := f.db.DiscoverGroupNames()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, ok := groups[path.Base(name)]; ok {
+			continue
+		}
+		if err := f.db.DeleteGroup(path.Base(name)); err != nil && err != db.ErrUnknownGroup {
+			return err
+		}
+		f.log.Debug("Group pruned", "group", path.Base(name))
+	}
+	return nil
+}
+
+func (f *Follower) setConnected(c bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.connected = c
+	if !c {
+		f.synced = false
+	}
+}
+
+// mark records how far behind the master the replica was when the
+// most recently applied change arrived.  Both times in a change are
+// from the master's clock, so the difference between them is not
+// affected by any skew between the master's clock and this one.
+func (f *Follower) mark(c *Change) {
+	var behind time.Duration
+	if !c.Queued.IsZero() && c.Time.After(c.Queued) {
+		behind = c.Time.Sub(c.Queued)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.synced = true
+	f.behind = behind
+	f.received = time.Now()
+}
+
+// Lag returns how far behind the master the replica is, and false if
+// the replica is not currently synchronized with the master.  The lag
+// is how long the most recent change waited on the master before it
+// was sent, plus the time since it arrived, so an idle master will
+// show up to one heartbeat interval of lag.
+func (f *Follower) Lag() (time.Duration, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.connected || !f.synced {
+		return 0, false
+	}
+	return f.behind + time.Since(f.received), true
+}
+
+// Check reports the replica's health.  The replica is healthy if it
+// is synchronized with the master and within the configured lag.
+func (f *Follower) Check() health.SubsystemStatus {
+	status := health.SubsystemStatus{Name: "replica"}
+
+	lag, ok := f.Lag()
+	switch {
+	case !ok:
+		status.Status = "Not synchronized with master"
+	case lag > f.maxLag:
+		status.Status = fmt.Sprintf("Replication lag %s exceeds %s", lag.Round(time.Millisecond), f.maxLag)
+	default:
+		status.OK = true
+		status.Status = fmt.Sprintf("Replication lag %s", lag.Round(time.Millisecond))
+	}
+	return status
+}
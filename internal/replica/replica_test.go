@@ -0,0 +1,169 @@
+package replica
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/netauth/netauth/internal/db"
+	_ "github.com/netauth/netauth/internal/db/memory"
+	"github.com/netauth/netauth/internal/startup"
+
+	types "github.com/netauth/protocol"
+)
+
+func init() {
+	startup.DoCallbacks()
+}
+
+// newMaster starts a replication source for a fresh database and
+// returns the database, the source, and a connection to it.
+func newMaster(t *testing.T) (*db.DB, *Source, *grpc.ClientConn, func()) {
+	viper.Set("replication.secret", "secret")
+	viper.Set("replication.heartbeat", 50*time.Millisecond)
+
+	mdb, err := db.New("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := NewSource(mdb, hclog.NewNullLogger())
+	mdb.RegisterCallback("replication", src.Notify)
+
+	ln := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer()
+	src.Register(srv)
+	go srv.Serve(ln)
+
+	cc, err := grpc.Dial("bufconn",
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return ln.Dial() }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mdb, src, cc, func() {
+		cc.Close()
+		srv.Stop()
+	}
+}
+
+func eventually(t *testing.T, f func() bool) {
+	for i := 0; i < 50; i++ {
+		if f() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Error("Condition not met in time")
+}
+
+func TestFollow(t *testing.T) {
+	mdb, _, cc, stop := newMaster(t)
+	defer stop()
+
+	assert.Nil(t, mdb.SaveEntity(&types.Entity{ID: proto.String("entity1"), Number: proto.Int32(1)}))
+	assert.Nil(t, mdb.SaveGroup(&types.Group{Name: proto.String("group1"), Number: proto.Int32(1)}))
+
+	// The replica has an entity that the master does not, which
+	// must be removed by the initial sync.
+	rdb, err := db.New("memory")
+	assert.Nil(t, err)
+	assert.Nil(t, rdb.SaveEntity(&types.Entity{ID: proto.String("stale")}))
+
+	viper.Set("replica.secret", "secret")
+	viper.Set("replica.maxlag", time.Second)
+	f := NewFollower(rdb, cc, hclog.NewNullLogger())
+	assert.False(t, f.Check().OK)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.Run(ctx)
+
+	eventually(t, func() bool { return f.Check().OK })
+	_, err = rdb.LoadEntity("stale")
+	assert.Equal(t, db.ErrUnknownEntity, err)
+	g, err := rdb.LoadGroup("group1")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), g.GetNumber())
+
+	// Changes on the master are streamed to the replica.
+	assert.Nil(t, mdb.SaveEntity(&types.Entity{ID: proto.String("entity1"), Number: proto.Int32(2)}))
+	eventually(t, func() bool {
+		e, err := rdb.LoadEntity("entity1")
+		return err == nil && e.GetNumber() == 2
+	})
+
+	assert.Nil(t, mdb.DeleteGroup("group1"))
+	eventually(t, func() bool {
+		_, err := rdb.LoadGroup("group1")
+		return err == db.ErrUnknownGroup
+	})
+
+	lag, ok := f.Lag()
+	assert.True(t, ok)
+	assert.True(t, lag < time.Second)
+}
+
+func TestSubscribeBadSecret(t *testing.T) {
+	_, _, cc, stop := newMaster(t)
+	defer stop()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), secretKey, "wrong")
+	stream, err := subscribe(ctx, cc, &SubscribeRequest{})
+	assert.Nil(t, err)
+	err = stream.RecvMsg(new(Change))
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestCheckLag(t *testing.T) {
+	f := &Follower{maxLag: time.Second}
+	assert.False(t, f.Check().OK)
+
+	// The lag only depends on the master's clock, which here is
+	// an hour ahead of this one.
+	master := time.Now().Add(time.Hour)
+	f.setConnected(true)
+	f.mark(&Change{Time: master, Queued: master.Add(-time.Minute)})
+	assert.False(t, f.Check().OK)
+
+	f.mark(&Change{Time: master, Queued: master})
+	assert.True(t, f.Check().OK)
+
+	f.setConnected(false)
+	_, ok := f.Lag()
+	assert.False(t, ok)
+}
+
+func TestSourceShutdown(t *testing.T) {
+	_, src, cc, stop := newMaster(t)
+	defer stop()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), secretKey, "secret")
+	stream, err := subscribe(ctx, cc, &SubscribeRequest{})
+	assert.Nil(t, err)
+	c := new(Change)
+	assert.Nil(t, stream.RecvMsg(c))
+	assert.Equal(t, KindSynced, c.Kind)
+
+	src.Shutdown()
+	for err == nil {
+		err = stream.RecvMsg(c)
+	}
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	// Once shut down no new replicas are accepted.
+	stream, err = subscribe(ctx, cc, &SubscribeRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, codes.Unavailable, status.Code(stream.RecvMsg(c)))
+}
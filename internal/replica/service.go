@@ -0,0 +1,108 @@
+// Package replica allows a server to follow a master and keep a full
+// copy of its entities and groups.  The master runs a Source which
+// streams the current state of every entity and group followed by
+// each change as it happens.  The replica runs a Follower which
+// applies that stream to its own database and reports how far behind
+// the master it is.
+package replica
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/netauth/netauth/internal/grpcjson"
+)
+
+// serviceName is the name of the replication service as seen on the
+// wire.  It is not part of the NetAuth protocol and is only intended
+// to be spoken between servers.
+const serviceName = "netauth.replica.Replication"
+
+// secretKey is the metadata key that carries the shared secret from
+// the replica to the master.
+const secretKey = "replication-secret"
+
+// A Kind describes what a Change contains.
+type Kind int
+
+// The kinds of change that can be sent to a replica.
+const (
+	// KindEntity carries an entity, or the removal of one.
+	KindEntity Kind = iota
+
+	// KindGroup carries a group, or the removal of one.
+	KindGroup
+
+	// KindSynced is sent once every entity and group has been
+	// sent to a new subscriber.  Anything the replica has that
+	// it did not receive before this point no longer exists on
+	// the master.
+	KindSynced
+
+	// KindHeartbeat is sent periodically when there are no
+	// other changes so that the replica can tell an idle master
+	// from a lost one.
+	KindHeartbeat
+)
+
+// SubscribeRequest is sent by a replica to begin following the
+// master.
+type SubscribeRequest struct{}
+
+// Change is a single message in the replication stream.  Value holds
+// the marshaled entity or group, and is nil if it has been removed.
+// Time is the time on the master when the change was sent, and
+// Queued is the time on the master when the change happened, so
+// that the replica can tell how far behind it is without comparing
+// its own clock to the master's.
+type Change struct {
+	Kind   Kind
+	ID     string
+	Value  []byte
+	Time   time.Time
+	Queued time.Time
+}
+
+// replicationServer is the interface the Source satisfies to be
+// registered against the service description.
+type replicationServer interface {
+	Subscribe(*SubscribeRequest, grpc.ServerStream) error
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*replicationServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       subscribeHandler,
+			ServerStreams: true,
+		},
+	},
+}
+
+func subscribeHandler(srv interface{}, stream grpc.ServerStream) error {
+	r := new(SubscribeRequest)
+	if err := stream.RecvMsg(r); err != nil {
+		return err
+	}
+	return srv.(replicationServer).Subscribe(r, stream)
+}
+
+// subscribe opens the replication stream on the master.
+func subscribe(ctx context.Context, cc *grpc.ClientConn, r *SubscribeRequest) (grpc.ClientStream, error) {
+	stream, err := cc.NewStream(ctx, &serviceDesc.Streams[0], "/"+serviceName+"/Subscribe", grpcjson.CallOption())
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(r); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
@@ -0,0 +1,267 @@
+package replica
+
+import (
+	"crypto/subtle"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/netauth/netauth/internal/db"
+
+	types "github.com/netauth/protocol"
+)
+
+// subscriberBuffer is the number of events that may be waiting for a
+// replica before it is considered to have fallen behind.
+const subscriberBuffer = 1024
+
+func init() {
+	viper.SetDefault("replication.heartbeat", 5*time.Second)
+}
+
+// SourceDB is the subset of the database that is needed to serve
+// replicas.
+type SourceDB interface {
+	DiscoverEntityIDs() ([]string, error)
+	LoadEntity(string) (*types.Entity, error)
+	DiscoverGroupNames() ([]string, error)
+	LoadGroup(string) (*types.Group, error)
+}
+
+// Source serves the replication stream from a master.  Its Notify
+// method must be registered as a database callback so that it can
+// pass changes on to replicas.
+type Source struct {
+	db  SourceDB
+	log hclog.Logger
+
+	secret    string
+	heartbeat time.Duration
+
+	mu     sync.Mutex
+	subs   map[chan queued]struct{}
+	closed bool
+}
+
+// queued is an event waiting to be sent to a replica, and the time
+// it happened.
+type queued struct {
+	db.Event
+	at time.Time
+}
+
+// NewSource returns a Source serving the contents of the provided
+// database.  Replicas must present the secret configured in
+// replication.secret.
+func NewSource(d SourceDB, l hclog.Logger) *Source {
+	return &Source{
+		db:        d,
+		log:       l.Named("replication"),
+		secret:    viper.GetString("replication.secret"),
+		heartbeat: viper.GetDuration("replication.heartbeat"),
+		subs:      make(map[chan queued]struct{}),
+	}
+}
+
+// Register binds the replication service to a gRPC server.
+func (s *Source) Register(srv *grpc.Server) {
+	srv.RegisterService(&serviceDesc, s)
+}
+
+// Notify passes an event to every connected replica.  A replica that
+// has too many events waiting is disconnected rather than blocking
+// the database, and will resynchronize when it reconnects.
+func (s *Source) Notify(e db.Event) {
+	q := queued{Event: e, at: time.Now()}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.subs {
+		select {
+		case ch <- q:
+		default:
+			s.log.Warn("Replica has fallen behind, disconnecting")
+			delete(s.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Shutdown disconnects all replicas.  This must be called before the
+// gRPC server is stopped gracefully, since otherwise the streams to
+// replicas would never finish.
+func (s *Source) Shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for ch := range s.subs {
+		delete(s.subs, ch)
+		close(ch)
+	}
+}
+
+func (s *Source) subscribe() chan queued {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	ch := make(chan queued, subscriberBuffer)
+	s.subs[ch] = struct{}{}
+	return ch
+}
+
+func (s *Source) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func (s *Source) unsubscribe(ch chan queued) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subs[ch]; ok {
+		delete(s.subs, ch)
+		close(ch)
+	}
+}
+
+// Subscribe sends every entity and group to the replica, and then
+// streams changes until the replica disconnects.
+func (s *Source) Subscribe(r *SubscribeRequest, stream grpc.ServerStream) error {
+	if !s.authorized(stream) {
+		s.log.Warn("Replica presented an incorrect secret")
+		return status.Error(codes.PermissionDenied, "replication secret is incorrect")
+	}
+
+	// Subscribing before the initial sync ensures nothing that
+	// changes during the sync is missed.  Anything sent twice is
+	// harmless since each change carries the complete value.
+	ch := s.subscribe()
+	if ch == nil {
+		return status.Error(codes.Unavailable, "server is shutting down")
+	}
+	defer s.unsubscribe(ch)
+
+	if err := s.sendAll(stream); err != nil {
+		return err
+	}
+	s.log.Info("Replica synchronized")
+
+	t := time.NewTicker(s.heartbeat)
+	defer t.Stop()
+	for {
+		select {
+		case <-stream.Context().Done():
+			s.log.Info("Replica disconnected")
+			return nil
+		case q, ok := <-ch:
+			if !ok && s.isClosed() {
+				return status.Error(codes.Unavailable, "server is shutting down")
+			}
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "replica fell behind")
+			}
+			if err := s.send(stream, q.Event, q.at); err != nil {
+				return err
+			}
+		case <-t.C:
+			now := time.Now()
+			if err := stream.SendMsg(&Change{Kind: KindHeartbeat, Time: now, Queued: now}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// authorized checks the secret presented by the replica.  If no
+// secret is configured then no replica may connect.
+func (s *Source) authorized(stream grpc.ServerStream) bool {
+	md, ok := metadata.FromIncomingContext(stream.Context())
+	if !ok || s.secret == "" {
+		return false
+	}
+	for _, v := range md.Get(secretKey) {
+		if subtle.ConstantTimeCompare([]byte(v), []byte(s.secret)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// sendAll sends the current state of every entity and group.
+func (s *Source) sendAll(stream grpc.ServerStream) error {
+	ids, err := s.db.DiscoverEntityIDs()
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	for _, id := range ids {
+		e := db.Event{Type: db.EventEntityUpdate, PK: path.Base(id)}
+		if err := s.send(stream, e, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	names, err := s.db.DiscoverGroupNames()
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	for _, name := range names {
+		e := db.Event{Type: db.EventGroupUpdate, PK: path.Base(name)}
+		if err := s.send(stream, e, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	return stream.SendMsg(&Change{Kind: KindSynced, Time: now, Queued: now})
+}
+
+// send converts an event that happened at the given time into a
+// Change carrying the current value of the entity or group and sends
+// it to the replica.  Anything that no longer exists is sent as
+// removed.
+func (s *Source) send(stream grpc.ServerStream, e db.Event, at time.Time) error {
+	c := &Change{ID: e.PK, Time: time.Now(), Queued: at}
+
+	var m proto.Message
+	var err error
+	switch e.Type {
+	case db.EventEntityCreate, db.EventEntityUpdate:
+		c.Kind = KindEntity
+		m, err = s.db.LoadEntity(e.PK)
+	case db.EventEntityDestroy:
+		c.Kind = KindEntity
+	case db.EventGroupCreate, db.EventGroupUpdate:
+		c.Kind = KindGroup
+		m, err = s.db.LoadGroup(e.PK)
+	case db.EventGroupDestroy:
+		c.Kind = KindGroup
+	}
+	switch err {
+	case nil:
+	case db.ErrUnknownEntity, db.ErrUnknownGroup:
+		m = nil
+	default:
+		s.log.Warn("Error loading change", "id", e.PK, "error", err)
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	if m != nil {
+		b, err := proto.Marshal(m)
+		if err != nil {
+			s.log.Warn("Error marshaling change", "id", e.PK, "error", err)
+			return status.Error(codes.Internal, err.Error())
+		}
+		c.Value = b
+	}
+	return stream.SendMsg(c)
+}
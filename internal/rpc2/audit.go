@@ -0,0 +1,78 @@
+package rpc2
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/netauth/netauth/internal/auditlog"
+	"github.com/netauth/netauth/pkg/audit"
+
+	types "github.com/netauth/protocol"
+)
+
+var (
+	// ErrAuditDisabled is returned when the audit log is queried
+	// but the server has no sink that can be read back.
+	ErrAuditDisabled = status.Errorf(codes.FailedPrecondition, "The audit log is not enabled or cannot be queried on this server")
+)
+
+// as returns the Manager that a request should use to make changes.
+// If auditing is enabled the changes are attributed to the holder of
+// the token in the context, and to the client and service that made
+// the request.  The context must be the one returned when the token
+// was checked, as the claims are not parsed again here.
+func (s *Server) as(ctx context.Context) Manager {
+	if s.withActor == nil {
+		return s.Manager
+	}
+
+	c := getTokenClaims(ctx)
+	a := audit.Actor{
+		Entity:  c.EntityID,
+		Client:  getClientName(ctx),
+		Service: getServiceName(ctx),
+	}
+	for _, cap := range c.Capabilities {
+		a.Capabilities = append(a.Capabilities, cap.String())
+	}
+	return s.withActor(a)
+}
+
+// AuditQuery returns the changes recorded in the audit log that match
+// the query.  Since the audit log reveals who has changed what, it
+// may only be queried with GLOBAL_ROOT.
+func (s *Server) AuditQuery(ctx context.Context, q *audit.Query) (*audit.QueryResult, error) {
+	var err error
+	ctx, err = s.checkToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.isAuthorized(ctx, types.Capability_GLOBAL_ROOT); err != nil {
+		return nil, err
+	}
+
+	records, err := s.QueryAudit(q)
+	switch err {
+	case nil:
+	case auditlog.ErrNotQueryable:
+		return nil, ErrAuditDisabled
+	default:
+		s.log.Warn("Error querying audit log",
+			"client", getClientName(ctx),
+			"service", getServiceName(ctx),
+			"error", err,
+		)
+		return nil, ErrInternal
+	}
+
+	s.log.Info("Audit log queried",
+		"target", q.Target,
+		"actor", q.Actor,
+		"authority", getTokenClaims(ctx).EntityID,
+		"client", getClientName(ctx),
+		"service", getServiceName(ctx),
+	)
+	return &audit.QueryResult{Records: records}, nil
+}
@@ -0,0 +1,59 @@
+package rpc2
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/netauth/netauth/internal/auditlog"
+	_ "github.com/netauth/netauth/internal/auditlog/kv"
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/tree"
+	"github.com/netauth/netauth/pkg/audit"
+
+	types "github.com/netauth/protocol"
+	pb "github.com/netauth/protocol/v2"
+)
+
+func TestAuditQuery(t *testing.T) {
+	s, d, m := newServerWithRefs(t)
+	initTree(t, m)
+
+	// Without a log there is nothing to query.
+	if _, err := s.AuditQuery(PrivilegedContext, &audit.Query{}); err != ErrAuditDisabled {
+		t.Errorf("Got %v; Want %v", err, ErrAuditDisabled)
+	}
+
+	l, err := auditlog.New([]string{"kv"}, auditlog.Refs{DB: d.(*db.DB)}, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm := m.(*tree.Manager)
+	tm.SetAuditLog(l)
+	s.withActor = func(a audit.Actor) Manager { return tm.WithActor(a) }
+
+	req := pb.EntityRequest{Entity: &types.Entity{ID: proto.String("entity1")}}
+	if _, err := s.EntityLock(PrivilegedContext, &req); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.AuditQuery(UnprivilegedContext, &audit.Query{}); err != ErrRequestorUnqualified {
+		t.Errorf("Got %v; Want %v", err, ErrRequestorUnqualified)
+	}
+
+	res, err := s.AuditQuery(PrivilegedContext, &audit.Query{Target: "entity1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Records) != 1 {
+		t.Fatalf("Got %d records; Want 1", len(res.Records))
+	}
+	r := res.Records[0]
+	if r.Chain != "LOCK" || r.Actor.Entity != "valid" || r.Actor.Client != "BOGUS_CLIENT" {
+		t.Errorf("Bad record: %+v", r)
+	}
+	if len(r.Actor.Capabilities) != 1 || r.Actor.Capabilities[0] != "GLOBAL_ROOT" {
+		t.Errorf("Bad capabilities: %v", r.Actor.Capabilities)
+	}
+}
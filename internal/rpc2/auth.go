@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/netauth/netauth/internal/token"
+	"github.com/netauth/netauth/internal/tree"
 
 	types "github.com/netauth/protocol"
 	pb "github.com/netauth/protocol/v2"
@@ -124,8 +125,11 @@ func (s *Server) AuthChangeSecret(ctx context.Context, r *pb.AuthRequest) (*pb.E
 			)
 			return &pb.Empty{}, ErrUnauthenticated
 		}
+		if err := s.authorize(ctx, &target{entity: e.GetID()}, nil); err != nil {
+			return &pb.Empty{}, err
+		}
 	} else {
-		if err := s.isAuthorized(ctx, types.Capability_CHANGE_ENTITY_SECRET); err != nil {
+		if err := s.isAuthorizedFor(ctx, types.Capability_CHANGE_ENTITY_SECRET, &target{entity: e.GetID()}); err != nil {
 			s.log.Info("Permission Denied for AuthChangeSecret",
 				"modself", false,
 				"entity", e.GetID(),
@@ -138,7 +142,18 @@ func (s *Server) AuthChangeSecret(ctx context.Context, r *pb.AuthRequest) (*pb.E
 	}
 
 	// Set the secret
-	if err := s.SetSecret(e.GetID(), r.GetSecret()); err != nil {
+	err = s.as(ctx).SetSecret(e.GetID(), r.GetSecret())
+	if perr, ok := err.(*tree.SecretPolicyError); ok {
+		s.log.Info("Secret rejected by password policy",
+			"entity", e.GetID(),
+			"reasons", perr.Reasons,
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, errSecretPolicy(perr)
+	}
+	if err != nil {
 		s.log.Warn("Secret Manipulation Error",
 			"entity", e.GetID(),
 			"service", getServiceName(ctx),
@@ -5,6 +5,9 @@ import (
 	"testing"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/netauth/netauth/internal/token/null"
 
@@ -223,3 +226,31 @@ func TestAuthChangeSecret(t *testing.T) {
 		}
 	}
 }
+
+func TestAuthChangeSecretPolicy(t *testing.T) {
+	viper.Set("password.length.min", 8)
+	defer viper.Reset()
+
+	s := newServer(t)
+	if err := s.CreateEntity("entity1", -1, "initial secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	r := pb.AuthRequest{
+		Entity: &types.Entity{ID: proto.String("entity1")},
+		Secret: proto.String("short"),
+	}
+	_, err := s.AuthChangeSecret(PrivilegedContext, &r)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Got %v; Want InvalidArgument", err)
+	}
+	want := "The secret does not meet the password policy: it is shorter than 8 characters"
+	if got := status.Convert(err).Message(); got != want {
+		t.Errorf("Got %q; Want %q", got, want)
+	}
+
+	r.Secret = proto.String("long enough")
+	if _, err := s.AuthChangeSecret(PrivilegedContext, &r); err != nil {
+		t.Errorf("Got %v; Want nil", err)
+	}
+}
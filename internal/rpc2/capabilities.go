@@ -0,0 +1,119 @@
+package rpc2
+
+import (
+	"context"
+	"strings"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/mresolver"
+	"github.com/netauth/netauth/internal/tree"
+	"github.com/netauth/netauth/pkg/capabilities"
+
+	types "github.com/netauth/protocol"
+)
+
+// EntityCapabilities returns the capabilities that an entity holds
+// and where it holds them from.  If changes are proposed in the
+// request the capabilities that the entity would hold after them are
+// returned as well, but nothing is changed.  As with EntityInfo this
+// does not require authentication.
+func (s *Server) EntityCapabilities(ctx context.Context, r *capabilities.Request) (*capabilities.Result, error) {
+	p, err := proposal(r.WhatIf)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &capabilities.Result{}
+	res.Capabilities, err = s.grants(ctx, r.Entity, nil)
+	if err != nil {
+		return nil, err
+	}
+	if p != nil {
+		res.WhatIf, err = s.grants(ctx, r.Entity, p)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.log.Info("Dumped Capabilities",
+		"entity", r.Entity,
+		"whatif", len(r.WhatIf),
+		"service", getServiceName(ctx),
+		"client", getClientName(ctx),
+	)
+	return res, nil
+}
+
+// grants fetches the capabilities of an entity from the tree and
+// converts them to those returned to the client.
+func (s *Server) grants(ctx context.Context, entity string, p *tree.Proposal) ([]*capabilities.Grant, error) {
+	grants, err := s.EffectiveCapabilities(entity, p)
+	switch err {
+	case db.ErrUnknownEntity, db.ErrUnknownGroup:
+		s.log.Warn("Capabilities requested for something that does not exist!",
+			"entity", entity,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+			"error", err,
+		)
+		return nil, ErrDoesNotExist
+	case nil:
+		break
+	default:
+		s.log.Warn("Error getting capabilities",
+			"entity", entity,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+			"error", err,
+		)
+		return nil, ErrInternal
+	}
+
+	out := make([]*capabilities.Grant, len(grants))
+	for i, g := range grants {
+		out[i] = &capabilities.Grant{
+			Capability: g.Capability.String(),
+			Direct:     g.Direct,
+			Groups:     g.Groups,
+		}
+	}
+	return out, nil
+}
+
+// proposal converts the changes in a request to a proposal that the
+// tree can evaluate.  If there are no changes it returns nil.
+func proposal(changes []*capabilities.Change) (*tree.Proposal, error) {
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	p := &tree.Proposal{}
+	for _, c := range changes {
+		mode := strings.ToUpper(c.Mode)
+		switch {
+		case c.Kind == capabilities.KindMembership && c.Group != "" && mode == "ADD":
+			p.Membership.Join = append(p.Membership.Join, c.Group)
+		case c.Kind == capabilities.KindMembership && c.Group != "" && mode == "DROP":
+			p.Membership.Leave = append(p.Membership.Leave, c.Group)
+		case c.Kind == capabilities.KindExpansion && c.Group != "" && c.Child != "" && (mode == "INCLUDE" || mode == "EXCLUDE" || mode == "DROP"):
+			p.Membership.Expansions = append(p.Membership.Expansions, mresolver.Expansion{
+				Parent: c.Group,
+				Child:  c.Child,
+				Mode:   mode,
+			})
+		case c.Kind == capabilities.KindCapability && (mode == "ADD" || mode == "DROP"):
+			v, ok := types.Capability_value[strings.ToUpper(c.Capability)]
+			if !ok {
+				return nil, ErrMalformedRequest
+			}
+			p.Capabilities = append(p.Capabilities, tree.CapabilityChange{
+				Group:      c.Group,
+				Capability: types.Capability(v),
+				Drop:       mode == "DROP",
+			})
+		default:
+			return nil, ErrMalformedRequest
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,129 @@
+package rpc2
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/netauth/netauth/pkg/capabilities"
+
+	types "github.com/netauth/protocol"
+)
+
+func TestEntityCapabilities(t *testing.T) {
+	cases := []struct {
+		req        capabilities.Request
+		wantErr    error
+		wantCaps   string
+		wantWhatIf string
+	}{
+		{
+			req:      capabilities.Request{Entity: "entity1"},
+			wantErr:  nil,
+			wantCaps: "LOCK_ENTITY:group1",
+		},
+		{
+			req:      capabilities.Request{Entity: "admin"},
+			wantErr:  nil,
+			wantCaps: "GLOBAL_ROOT:direct",
+		},
+		{
+			req: capabilities.Request{
+				Entity: "entity1",
+				WhatIf: []*capabilities.Change{
+					{Kind: capabilities.KindMembership, Mode: "DROP", Group: "group1"},
+				},
+			},
+			wantErr:    nil,
+			wantCaps:   "LOCK_ENTITY:group1",
+			wantWhatIf: "",
+		},
+		{
+			req: capabilities.Request{
+				Entity: "unprivileged",
+				WhatIf: []*capabilities.Change{
+					{Kind: capabilities.KindMembership, Mode: "ADD", Group: "group2"},
+					{Kind: capabilities.KindExpansion, Mode: "INCLUDE", Group: "group1", Child: "group2"},
+					{Kind: capabilities.KindCapability, Mode: "ADD", Capability: "create_group"},
+				},
+			},
+			wantErr:    nil,
+			wantCaps:   "",
+			wantWhatIf: "CREATE_GROUP:direct LOCK_ENTITY:group1",
+		},
+		{
+			req: capabilities.Request{
+				Entity: "entity1",
+				WhatIf: []*capabilities.Change{
+					{Kind: capabilities.KindCapability, Mode: "DROP", Group: "group1", Capability: "LOCK_ENTITY"},
+				},
+			},
+			wantErr:    nil,
+			wantCaps:   "LOCK_ENTITY:group1",
+			wantWhatIf: "",
+		},
+		{
+			req:     capabilities.Request{Entity: "does-not-exist"},
+			wantErr: ErrDoesNotExist,
+		},
+		{
+			req: capabilities.Request{
+				Entity: "entity1",
+				WhatIf: []*capabilities.Change{
+					{Kind: capabilities.KindMembership, Mode: "ADD", Group: "does-not-exist"},
+				},
+			},
+			wantErr: ErrDoesNotExist,
+		},
+		{
+			req: capabilities.Request{
+				Entity: "entity1",
+				WhatIf: []*capabilities.Change{
+					{Kind: capabilities.KindCapability, Mode: "ADD", Capability: "NOT_A_CAPABILITY"},
+				},
+			},
+			wantErr: ErrMalformedRequest,
+		},
+		{
+			req: capabilities.Request{
+				Entity: "entity1",
+				WhatIf: []*capabilities.Change{
+					{Kind: capabilities.KindExpansion, Mode: "ADD", Group: "group1", Child: "group2"},
+				},
+			},
+			wantErr: ErrMalformedRequest,
+		},
+	}
+
+	for i, c := range cases {
+		s := newServer(t)
+		initTree(t, s.Manager)
+		s.SetGroupCapability2("group1", types.Capability_LOCK_ENTITY.Enum())
+
+		res, err := s.EntityCapabilities(context.Background(), &c.req)
+		if err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+		if c.wantErr != nil {
+			continue
+		}
+		if got := grantString(res.Capabilities); got != c.wantCaps {
+			t.Errorf("%d: Got %v; Want %v", i, got, c.wantCaps)
+		}
+		if got := grantString(res.WhatIf); got != c.wantWhatIf {
+			t.Errorf("%d: Got %v; Want %v", i, got, c.wantWhatIf)
+		}
+	}
+}
+
+func grantString(grants []*capabilities.Grant) string {
+	var out []string
+	for _, g := range grants {
+		sources := g.Groups
+		if g.Direct {
+			sources = append([]string{"direct"}, sources...)
+		}
+		out = append(out, g.Capability+":"+strings.Join(sources, ","))
+	}
+	return strings.Join(out, " ")
+}
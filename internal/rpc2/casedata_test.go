@@ -13,4 +13,7 @@ var (
 	UnprivilegedContext    = metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", null.ValidEmptyToken))
 	UnauthenticatedContext = metadata.NewIncomingContext(context.Background(), nil)
 	InvalidAuthContext     = metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", null.InvalidToken))
+	StaleRevisionContext   = metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", null.ValidToken, "revision", "stale"))
+	ServicePoolContext     = metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", null.ValidToken, "number-pool", "service"))
+	UnknownPoolContext     = metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", null.ValidToken, "number-pool", "robots"))
 )
@@ -14,12 +14,24 @@ import (
 // correct token is held, which must contain either CREATE_ENTITY or
 // GLOBAL_ROOT permissions.
 func (s *Server) EntityCreate(ctx context.Context, r *pb.EntityRequest) (*pb.Empty, error) {
-	if err := s.mutablePrequisitesMet(ctx, types.Capability_CREATE_ENTITY); err != nil {
+	ctx, err := s.mutablePrequisitesMetFor(ctx, types.Capability_CREATE_ENTITY, &target{})
+	if err != nil {
 		return &pb.Empty{}, err
 	}
 
 	e := r.GetEntity()
-	switch err := s.CreateEntity(e.GetID(), e.GetNumber(), e.GetSecret()); err {
+	err = s.as(ctx).CreateEntityInPool(e.GetID(), getNumberPool(ctx), e.GetNumber(), e.GetSecret())
+	if perr, ok := err.(*tree.SecretPolicyError); ok {
+		s.log.Info("Secret rejected by password policy",
+			"entity", e.GetID(),
+			"reasons", perr.Reasons,
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, errSecretPolicy(perr)
+	}
+	switch err {
 	case tree.ErrDuplicateEntityID, tree.ErrDuplicateNumber:
 		s.log.Warn("Attempt to create duplicate entity",
 			"entity", e.GetID(),
@@ -29,6 +41,24 @@ func (s *Server) EntityCreate(ctx context.Context, r *pb.EntityRequest) (*pb.Emp
 			"error", err,
 		)
 		return &pb.Empty{}, ErrExists
+	case db.ErrUnknownNumberPool:
+		s.log.Warn("Attempt to allocate from an unknown pool",
+			"entity", e.GetID(),
+			"pool", getNumberPool(ctx),
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, ErrMalformedRequest
+	case db.ErrNumberPoolExhausted:
+		s.log.Error("Number pool exhausted",
+			"entity", e.GetID(),
+			"pool", getNumberPool(ctx),
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, ErrExhausted
 	case nil:
 		s.log.Info("Entity Created",
 			"entity", e.GetID(),
@@ -54,14 +84,20 @@ func (s *Server) EntityCreate(ctx context.Context, r *pb.EntityRequest) (*pb.Emp
 // in the typed data fields.  This method does not update keys,
 // groups, untyped metadata, or capabilities.  To call this method you
 // must be in possession of a token with MODIFY_ENTITY_META
-// capabilities.
+// capabilities, unless every field being changed is one that
+// entities may change on themselves and the token is the entity's
+// own.
 func (s *Server) EntityUpdate(ctx context.Context, r *pb.EntityRequest) (*pb.Empty, error) {
-	if err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_ENTITY_META); err != nil {
+	de := r.GetData()
+	ctx, err := s.selfPrequisitesMet(ctx, types.Capability_MODIFY_ENTITY_META, de.GetID(), s.self.allowsMeta(de.GetMeta()))
+	if err != nil {
+		return &pb.Empty{}, err
+	}
+	if err := s.scopeChangeAuthorized(ctx, de.GetMeta().GetKV()...); err != nil {
 		return &pb.Empty{}, err
 	}
 
-	de := r.GetData()
-	switch err := s.UpdateEntityMeta(de.GetID(), de.GetMeta()); err {
+	switch err := s.as(ctx).UpdateEntityMeta(de.GetID(), de.GetMeta(), getRevision(ctx)); err {
 	case db.ErrUnknownEntity:
 		s.log.Warn("Entity does not exist!",
 			"method", "EntityUpdate",
@@ -70,6 +106,22 @@ func (s *Server) EntityUpdate(ctx context.Context, r *pb.EntityRequest) (*pb.Emp
 			"client", getClientName(ctx),
 		)
 		return &pb.Empty{}, ErrDoesNotExist
+	case db.ErrConflict:
+		s.log.Info("Entity revision conflict",
+			"entity", de.GetID(),
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+			"error", err,
+		)
+		return &pb.Empty{}, ErrConflict
+	case tree.ErrBadScope:
+		s.log.Warn("Bad scoped capability",
+			"entity", de.GetID(),
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, ErrMalformedRequest
 
 	case nil:
 		s.log.Info("Entity Updated",
@@ -97,7 +149,7 @@ func (s *Server) EntityUpdate(ctx context.Context, r *pb.EntityRequest) (*pb.Emp
 func (s *Server) EntityInfo(ctx context.Context, r *pb.EntityRequest) (*pb.ListOfEntities, error) {
 	e := r.GetEntity()
 
-	switch ent, err := s.FetchEntity(e.GetID()); err {
+	switch ent, rev, err := s.FetchEntityRevision(e.GetID()); err {
 	case db.ErrUnknownEntity:
 		s.log.Warn("Entity does not exist!",
 			"method", "EntityUpdate",
@@ -112,6 +164,7 @@ func (s *Server) EntityInfo(ctx context.Context, r *pb.EntityRequest) (*pb.ListO
 			"service", getServiceName(ctx),
 			"client", getClientName(ctx),
 		)
+		setRevision(ctx, rev)
 		return &pb.ListOfEntities{Entities: []*types.Entity{ent}}, nil
 	default:
 		s.log.Warn("Error fetching entity",
@@ -125,12 +178,29 @@ func (s *Server) EntityInfo(ctx context.Context, r *pb.EntityRequest) (*pb.ListO
 }
 
 // EntitySearch searches all entities and returns the entities that
-// had been found.
+// had been found.  Clients may ask for the results a page at a time,
+// in a given order, and with only some fields, by sending the
+// page-size, page-token, sort, and fields metadata.  The token for the
+// next page is returned in the next-page-token header.
 func (s *Server) EntitySearch(ctx context.Context, r *pb.SearchRequest) (*pb.ListOfEntities, error) {
 	expr := r.GetExpression()
 
-	res, err := s.SearchEntities(db.SearchRequest{Expression: expr})
+	req, err := getSearchRequest(ctx, expr)
 	if err != nil {
+		return &pb.ListOfEntities{}, err
+	}
+
+	res, next, err := s.SearchEntityPage(req)
+	switch err {
+	case nil:
+	case db.ErrBadPageToken:
+		s.log.Warn("Search continued with a bad page token",
+			"expr", expr,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.ListOfEntities{}, ErrMalformedRequest
+	default:
 		s.log.Warn("Search Error",
 			"expr", expr,
 			"service", getServiceName(ctx),
@@ -140,6 +210,7 @@ func (s *Server) EntitySearch(ctx context.Context, r *pb.SearchRequest) (*pb.Lis
 		return &pb.ListOfEntities{}, ErrInternal
 	}
 
+	setNextPageToken(ctx, next)
 	return &pb.ListOfEntities{Entities: res}, nil
 }
 
@@ -169,14 +240,14 @@ func (s *Server) EntityUM(ctx context.Context, r *pb.KVRequest) (*pb.ListOfStrin
 		if err != nil {
 			return &pb.ListOfStrings{}, err
 		}
-		if err := s.isAuthorized(ctx, types.Capability_MODIFY_ENTITY_META); err != nil {
+		if err := s.isAuthorizedFor(ctx, types.Capability_MODIFY_ENTITY_META, &target{entity: r.GetTarget()}); err != nil {
 			return &pb.ListOfStrings{}, err
 		}
 	}
 
 	// At this point, we're either in a read-only query, or in a
 	// write one that has been authorized.
-	meta, err := s.ManageUntypedEntityMeta(r.GetTarget(), r.GetAction().String(), r.GetKey(), r.GetValue())
+	meta, err := s.as(ctx).ManageUntypedEntityMeta(r.GetTarget(), r.GetAction().String(), r.GetKey(), r.GetValue())
 	switch err {
 	case db.ErrUnknownEntity:
 		s.log.Warn("Entity does not exist!",
@@ -248,11 +319,15 @@ func (s *Server) EntityKVGet(ctx context.Context, r *pb.KV2Request) (*pb.ListOfK
 // EntityKVAdd takes the input KV2 data and adds it to an entity if an
 // only if it does not conflict with an existing key.
 func (s *Server) EntityKVAdd(ctx context.Context, r *pb.KV2Request) (*pb.Empty, error) {
-	if err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_ENTITY_META); err != nil {
+	ctx, err := s.selfPrequisitesMet(ctx, types.Capability_MODIFY_ENTITY_META, r.GetTarget(), s.self.allowsKV(r.GetData()))
+	if err != nil {
+		return &pb.Empty{}, err
+	}
+	if err := s.scopeChangeAuthorized(ctx, r.GetData()); err != nil {
 		return &pb.Empty{}, err
 	}
 
-	err := s.Manager.EntityKVAdd(r.GetTarget(), []*types.KVData{r.GetData()})
+	err = s.as(ctx).EntityKVAdd(r.GetTarget(), []*types.KVData{r.GetData()})
 	switch err {
 	case db.ErrUnknownEntity:
 		s.log.Warn("Entity does not exist!",
@@ -271,6 +346,21 @@ func (s *Server) EntityKVAdd(ctx context.Context, r *pb.KV2Request) (*pb.Empty,
 			"error", err,
 		)
 		return &pb.Empty{}, ErrExists
+	case tree.ErrBadScope:
+		s.log.Warn("Bad scoped capability",
+			"entity", r.GetTarget(),
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, ErrMalformedRequest
+	case tree.ErrReservedKey:
+		s.log.Warn("Attempt to change a reserved key",
+			"entity", r.GetTarget(),
+			"key", r.GetData().GetKey(),
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, ErrMalformedRequest
 	case nil:
 		s.log.Info("Entity KV Updated",
 			"entity", r.GetTarget(),
@@ -294,11 +384,15 @@ func (s *Server) EntityKVAdd(ctx context.Context, r *pb.KV2Request) (*pb.Empty,
 // EntityKVDel removes an existing key from an entity.  If the key is
 // not present an error will be returned.
 func (s *Server) EntityKVDel(ctx context.Context, r *pb.KV2Request) (*pb.Empty, error) {
-	if err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_ENTITY_META); err != nil {
+	ctx, err := s.selfPrequisitesMet(ctx, types.Capability_MODIFY_ENTITY_META, r.GetTarget(), s.self.allowsKV(r.GetData()))
+	if err != nil {
+		return &pb.Empty{}, err
+	}
+	if err := s.scopeChangeAuthorized(ctx, r.GetData()); err != nil {
 		return &pb.Empty{}, err
 	}
 
-	err := s.Manager.EntityKVDel(r.GetTarget(), []*types.KVData{r.GetData()})
+	err = s.as(ctx).EntityKVDel(r.GetTarget(), []*types.KVData{r.GetData()})
 	switch err {
 	case db.ErrUnknownEntity:
 		s.log.Warn("Entity does not exist!",
@@ -316,6 +410,14 @@ func (s *Server) EntityKVDel(ctx context.Context, r *pb.KV2Request) (*pb.Empty,
 			"client", getClientName(ctx),
 		)
 		return &pb.Empty{}, ErrDoesNotExist
+	case tree.ErrReservedKey:
+		s.log.Warn("Attempt to change a reserved key",
+			"entity", r.GetTarget(),
+			"key", r.GetData().GetKey(),
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, ErrMalformedRequest
 	case nil:
 		s.log.Info("Entity KV Data Dumped",
 			"entity", r.GetTarget(),
@@ -340,11 +442,15 @@ func (s *Server) EntityKVDel(ctx context.Context, r *pb.KV2Request) (*pb.Empty,
 // The key must already exist on the entity or an error will be
 // returned.
 func (s *Server) EntityKVReplace(ctx context.Context, r *pb.KV2Request) (*pb.Empty, error) {
-	if err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_ENTITY_META); err != nil {
+	ctx, err := s.selfPrequisitesMet(ctx, types.Capability_MODIFY_ENTITY_META, r.GetTarget(), s.self.allowsKV(r.GetData()))
+	if err != nil {
+		return &pb.Empty{}, err
+	}
+	if err := s.scopeChangeAuthorized(ctx, r.GetData()); err != nil {
 		return &pb.Empty{}, err
 	}
 
-	err := s.Manager.EntityKVReplace(r.GetTarget(), []*types.KVData{r.GetData()})
+	err = s.as(ctx).EntityKVReplace(r.GetTarget(), []*types.KVData{r.GetData()})
 	switch err {
 	case db.ErrUnknownEntity:
 		s.log.Warn("Entity does not exist!",
@@ -362,6 +468,21 @@ func (s *Server) EntityKVReplace(ctx context.Context, r *pb.KV2Request) (*pb.Emp
 			"client", getClientName(ctx),
 		)
 		return &pb.Empty{}, ErrDoesNotExist
+	case tree.ErrBadScope:
+		s.log.Warn("Bad scoped capability",
+			"entity", r.GetTarget(),
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, ErrMalformedRequest
+	case tree.ErrReservedKey:
+		s.log.Warn("Attempt to change a reserved key",
+			"entity", r.GetTarget(),
+			"key", r.GetData().GetKey(),
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, ErrMalformedRequest
 	case nil:
 		s.log.Info("Entity KV Data Updated",
 			"entity", r.GetTarget(),
@@ -383,6 +504,8 @@ func (s *Server) EntityKVReplace(ctx context.Context, r *pb.KV2Request) (*pb.Emp
 }
 
 // EntityKeys handles updates and reads to keys for entities.
+// Entities may change their own keys unless server.selfservice.fields
+// leaves them out.
 func (s *Server) EntityKeys(ctx context.Context, r *pb.KVRequest) (*pb.ListOfStrings, error) {
 	if r.GetAction() != pb.Action_READ &&
 		r.GetAction() != pb.Action_ADD &&
@@ -391,30 +514,16 @@ func (s *Server) EntityKeys(ctx context.Context, r *pb.KVRequest) (*pb.ListOfStr
 	}
 
 	if r.GetAction() != pb.Action_READ {
-		if s.readonly {
-			s.log.Warn("Mutable request in read-only mode!",
-				"method", "EntityUM",
-				"client", getClientName(ctx),
-				"service", getServiceName(ctx),
-			)
-			return &pb.ListOfStrings{}, ErrReadOnly
-		}
-
-		// Token validation and authorization
 		var err error
-		ctx, err = s.checkToken(ctx)
+		ctx, err = s.selfPrequisitesMet(ctx, types.Capability_MODIFY_ENTITY_KEYS, r.GetTarget(), s.self.allowsKeys())
 		if err != nil {
 			return &pb.ListOfStrings{}, err
 		}
-		err = s.isAuthorized(ctx, types.Capability_MODIFY_ENTITY_KEYS)
-		if err != nil && getTokenClaims(ctx).EntityID != r.GetTarget() {
-			return &pb.ListOfStrings{}, err
-		}
 	}
 
 	// At this point, we're either in a read-only query, or in a
 	// write one that has been authorized.
-	keys, err := s.UpdateEntityKeys(r.GetTarget(), r.GetAction().String(), r.GetKey(), r.GetValue())
+	keys, err := s.as(ctx).UpdateEntityKeys(r.GetTarget(), r.GetAction().String(), r.GetKey(), r.GetValue())
 	switch err {
 	case db.ErrUnknownEntity:
 		s.log.Warn("Entity does not exist!",
@@ -448,12 +557,24 @@ func (s *Server) EntityKeys(ctx context.Context, r *pb.KVRequest) (*pb.ListOfStr
 // generally discouraged, but if you must then this function will do
 // it.
 func (s *Server) EntityDestroy(ctx context.Context, r *pb.EntityRequest) (*pb.Empty, error) {
-	if err := s.mutablePrequisitesMet(ctx, types.Capability_DESTROY_ENTITY); err != nil {
+	e := r.GetEntity()
+	ctx, err := s.mutablePrequisitesMetFor(ctx, types.Capability_DESTROY_ENTITY, &target{entity: e.GetID()})
+	if err != nil {
 		return &pb.Empty{}, err
 	}
 
-	e := r.GetEntity()
-	switch err := s.DestroyEntity(e.GetID()); err {
+	err = s.as(ctx).DestroyEntity(e.GetID())
+	if rerr, ok := err.(*tree.ReferenceError); ok {
+		s.log.Warn("Entity is still referenced",
+			"entity", e.GetID(),
+			"references", rerr.References,
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, errReferenced(rerr)
+	}
+	switch err {
 	case db.ErrUnknownEntity:
 		s.log.Warn("Entity does not exist!",
 			"method", "EntityDestroy",
@@ -485,12 +606,13 @@ func (s *Server) EntityDestroy(ctx context.Context, r *pb.EntityRequest) (*pb.Em
 
 // EntityLock sets the lock flag on an entity.
 func (s *Server) EntityLock(ctx context.Context, r *pb.EntityRequest) (*pb.Empty, error) {
-	if err := s.mutablePrequisitesMet(ctx, types.Capability_LOCK_ENTITY); err != nil {
+	e := r.GetEntity()
+	ctx, err := s.mutablePrequisitesMetFor(ctx, types.Capability_LOCK_ENTITY, &target{entity: e.GetID()})
+	if err != nil {
 		return &pb.Empty{}, err
 	}
 
-	e := r.GetEntity()
-	switch err := s.LockEntity(e.GetID()); err {
+	switch err := s.as(ctx).LockEntity(e.GetID()); err {
 	case db.ErrUnknownEntity:
 		s.log.Warn("Entity does not exist!",
 			"method", "EntityLock",
@@ -522,12 +644,13 @@ func (s *Server) EntityLock(ctx context.Context, r *pb.EntityRequest) (*pb.Empty
 
 // EntityUnlock clears the lock flag on an entity.
 func (s *Server) EntityUnlock(ctx context.Context, r *pb.EntityRequest) (*pb.Empty, error) {
-	if err := s.mutablePrequisitesMet(ctx, types.Capability_UNLOCK_ENTITY); err != nil {
+	e := r.GetEntity()
+	ctx, err := s.mutablePrequisitesMetFor(ctx, types.Capability_UNLOCK_ENTITY, &target{entity: e.GetID()})
+	if err != nil {
 		return &pb.Empty{}, err
 	}
 
-	e := r.GetEntity()
-	switch err := s.UnlockEntity(e.GetID()); err {
+	switch err := s.as(ctx).UnlockEntity(e.GetID()); err {
 	case db.ErrUnknownEntity:
 		s.log.Warn("Entity does not exist!",
 			"method", "EntityUnlock",
@@ -8,6 +8,8 @@ import (
 
 	"github.com/golang/protobuf/proto"
 	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/tree"
+	"google.golang.org/grpc/metadata"
 
 	types "github.com/netauth/protocol"
 	pb "github.com/netauth/protocol/v2"
@@ -101,6 +103,43 @@ func TestEntityCreate(t *testing.T) {
 	}
 }
 
+func TestEntityCreatePool(t *testing.T) {
+	cases := []struct {
+		ctx        context.Context
+		ID         string
+		wantNumber int32
+		wantErr    error
+	}{
+		{PrivilegedContext, "pool1", 10000, nil},
+		{ServicePoolContext, "pool2", 900, nil},
+		{ServicePoolContext, "pool3", 0, ErrExhausted},
+		{UnknownPoolContext, "pool4", 0, ErrMalformedRequest},
+	}
+
+	s, d, m := newServerWithRefs(t)
+	err := d.(*db.DB).SetEntityNumbers(db.NumberConfig{Pools: []db.NumberPool{
+		{Name: "humans", NumberRange: db.NumberRange{Min: 10000, Max: 59999}},
+		{Name: "service", NumberRange: db.NumberRange{Min: 900, Max: 900}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, c := range cases {
+		req := pb.EntityRequest{Entity: &types.Entity{ID: proto.String(c.ID), Number: proto.Int32(-1)}}
+		if _, err := s.EntityCreate(c.ctx, &req); err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+		if c.wantErr != nil {
+			continue
+		}
+		e, err := m.FetchEntity(c.ID)
+		if err != nil || e.GetNumber() != c.wantNumber {
+			t.Errorf("%d: Got %d %v; Want %d", i, e.GetNumber(), err, c.wantNumber)
+		}
+	}
+}
+
 func TestEntityUpdate(t *testing.T) {
 	cases := []struct {
 		ctx      context.Context
@@ -192,6 +231,20 @@ func TestEntityUpdate(t *testing.T) {
 			readonly: false,
 			wantErr:  ErrInternal,
 		},
+		{
+			// Fails, entity is not at the requested revision
+			ctx: StaleRevisionContext,
+			req: pb.EntityRequest{
+				Data: &types.Entity{
+					ID: proto.String("entity1"),
+					Meta: &types.EntityMeta{
+						DisplayName: proto.String("First Entity"),
+					},
+				},
+			},
+			readonly: false,
+			wantErr:  ErrConflict,
+		},
 	}
 
 	for i, c := range cases {
@@ -282,6 +335,72 @@ func TestEntitySearch(t *testing.T) {
 	}
 }
 
+func TestEntitySearchMembership(t *testing.T) {
+	cases := []struct {
+		expr    string
+		wantIDs []string
+	}{
+		{"memberOf:group1", []string{"entity1"}},
+		{"ID:* AND NOT memberOf:group1", []string{"admin", "unprivileged"}},
+		{"memberOf:group2", nil},
+	}
+
+	for i, c := range cases {
+		s := newServer(t)
+		initTree(t, s.Manager)
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("sort", "_id"))
+		res, err := s.EntitySearch(ctx, &pb.SearchRequest{Expression: proto.String(c.expr)})
+		if err != nil {
+			t.Errorf("%d: Got %v; Want nil", i, err)
+		}
+		var ids []string
+		for _, e := range res.GetEntities() {
+			ids = append(ids, e.GetID())
+		}
+		assert.Equal(t, c.wantIDs, ids, "%d", i)
+	}
+}
+
+func TestEntitySearchOptions(t *testing.T) {
+	cases := []struct {
+		md      metadata.MD
+		wantIDs []string
+		wantErr error
+	}{
+		{metadata.Pairs("sort", "_id"), []string{"admin", "entity1", "unprivileged"}, nil},
+		{metadata.Pairs("sort", "-_id", "page-size", "2"), []string{"unprivileged", "entity1"}, nil},
+		{metadata.Pairs("page-size", "lots"), nil, ErrMalformedRequest},
+		{metadata.Pairs("page-token", "garbage"), nil, ErrMalformedRequest},
+	}
+
+	for i, c := range cases {
+		s := newServer(t)
+		initTree(t, s.Manager)
+		ctx := metadata.NewIncomingContext(context.Background(), c.md)
+		res, err := s.EntitySearch(ctx, &pb.SearchRequest{Expression: proto.String("ID:*")})
+		if err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+		var ids []string
+		for _, e := range res.GetEntities() {
+			ids = append(ids, e.GetID())
+		}
+		assert.Equal(t, c.wantIDs, ids, "%d", i)
+	}
+
+	// Only the fields asked for are returned.
+	s := newServer(t)
+	initTree(t, s.Manager)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("fields", "Number"))
+	res, err := s.EntitySearch(ctx, &pb.SearchRequest{Expression: proto.String("ID:entity1")})
+	if err != nil || len(res.GetEntities()) != 1 {
+		t.Fatal(res, err)
+	}
+	if e := res.GetEntities()[0]; e.GetID() != "entity1" || e.Number == nil || e.Meta != nil {
+		t.Errorf("Got %v; Want only ID and Number", e)
+	}
+}
+
 func TestEntityUM(t *testing.T) {
 	cases := []struct {
 		ctx      context.Context
@@ -489,6 +608,17 @@ func TestEntityKVAdd(t *testing.T) {
 			},
 			wantErr: ErrExists,
 		},
+		{
+			ro:  false,
+			ctx: PrivilegedContext,
+			req: &pb.KV2Request{
+				Target: proto.String("entity1"),
+				Data: &types.KVData{
+					Key: proto.String(tree.MembershipExpiryKey),
+				},
+			},
+			wantErr: ErrMalformedRequest,
+		},
 		{
 			ro:      false,
 			ctx:     UnprivilegedContext,
@@ -549,6 +679,17 @@ func TestEntityKVDel(t *testing.T) {
 			},
 			wantErr: nil,
 		},
+		{
+			ro:  false,
+			ctx: PrivilegedContext,
+			req: &pb.KV2Request{
+				Target: proto.String("entity1"),
+				Data: &types.KVData{
+					Key: proto.String(tree.MembershipExpiryKey),
+				},
+			},
+			wantErr: ErrMalformedRequest,
+		},
 		{
 			ro:      false,
 			ctx:     UnprivilegedContext,
@@ -1,8 +1,12 @@
 package rpc2
 
 import (
+	"strings"
+
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+
+	"github.com/netauth/netauth/internal/tree"
 )
 
 var (
@@ -49,4 +53,32 @@ var (
 	// entity or group that does not exist, or when an expansion
 	// that doesn't exist is modified.
 	ErrDoesNotExist = status.Errorf(codes.NotFound, "The requested resource does not exist")
+
+	// ErrConflict is returned when a conditional update is
+	// requested against a revision that is no longer current.
+	// The client should fetch the resource again and retry.
+	ErrConflict = status.Errorf(codes.Aborted, "The resource has been modified, fetch it again and retry")
+
+	// ErrExhausted is returned when a number can't be allocated
+	// because every number in the requested pool is in use.
+	ErrExhausted = status.Errorf(codes.ResourceExhausted, "No numbers remain in the requested pool")
+
+	// ErrDeniedByPolicy is returned if the authorization policy
+	// denies a request, whatever authority the requestor holds.
+	ErrDeniedByPolicy = status.Errorf(codes.PermissionDenied, "The request is denied by the authorization policy")
 )
+
+// errReferenced is returned when an entity or group can't be
+// destroyed because it is still referenced.  Unlike the errors above
+// the message lists the references, so that the caller knows what to
+// remove first.
+func errReferenced(err *tree.ReferenceError) error {
+	return status.Errorf(codes.FailedPrecondition, "The resource is still referenced by: %s", strings.Join(err.References, ", "))
+}
+
+// errSecretPolicy is returned when a secret is rejected by the
+// password policy.  The message lists the reasons so that the person
+// choosing the secret knows what to change.
+func errSecretPolicy(err *tree.SecretPolicyError) error {
+	return status.Errorf(codes.InvalidArgument, "The secret does not meet the password policy: %s", strings.Join(err.Reasons, ", "))
+}
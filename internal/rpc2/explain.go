@@ -0,0 +1,64 @@
+package rpc2
+
+import (
+	"context"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/mresolver"
+	"github.com/netauth/netauth/pkg/explain"
+)
+
+// EntityExplain explains why an entity is or isn't a member of a
+// group.  As with EntityGroups this does not require authentication.
+func (s *Server) EntityExplain(ctx context.Context, r *explain.Request) (*explain.Result, error) {
+	x, err := s.ExplainMembership(r.Entity, r.Group)
+	switch err {
+	case db.ErrUnknownEntity, db.ErrUnknownGroup:
+		s.log.Warn("Explain target does not exist!",
+			"entity", r.Entity,
+			"group", r.Group,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return nil, ErrDoesNotExist
+	case nil:
+		break
+	default:
+		s.log.Warn("Error explaining membership",
+			"entity", r.Entity,
+			"group", r.Group,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+			"error", err,
+		)
+		return nil, ErrInternal
+	}
+
+	s.log.Info("Explained Membership",
+		"entity", r.Entity,
+		"group", r.Group,
+		"member", x.Member,
+		"service", getServiceName(ctx),
+		"client", getClientName(ctx),
+	)
+	return &explain.Result{Explanation: explanation(x)}, nil
+}
+
+// explanation converts an explanation from the resolver to the one
+// returned to the client.
+func explanation(x mresolver.Explanation) *explain.Explanation {
+	out := &explain.Explanation{
+		Group:   x.Group,
+		Member:  x.Member,
+		Direct:  x.Direct,
+		Dynamic: x.Dynamic,
+		Expired: x.Expired,
+	}
+	for _, i := range x.Include {
+		out.Include = append(out.Include, explanation(i))
+	}
+	for _, e := range x.Exclude {
+		out.Exclude = append(out.Exclude, explanation(e))
+	}
+	return out
+}
@@ -0,0 +1,80 @@
+package rpc2
+
+import (
+	"context"
+	"testing"
+
+	"github.com/netauth/netauth/pkg/explain"
+
+	pb "github.com/netauth/protocol/v2"
+)
+
+func TestEntityExplain(t *testing.T) {
+	cases := []struct {
+		ctx        context.Context
+		req        explain.Request
+		wantErr    error
+		wantMember bool
+	}{
+		{
+			ctx:        context.Background(),
+			req:        explain.Request{Entity: "entity1", Group: "group1"},
+			wantErr:    nil,
+			wantMember: true,
+		},
+		{
+			ctx:        context.Background(),
+			req:        explain.Request{Entity: "entity1", Group: "group2"},
+			wantErr:    nil,
+			wantMember: true,
+		},
+		{
+			ctx:        context.Background(),
+			req:        explain.Request{Entity: "unprivileged", Group: "group2"},
+			wantErr:    nil,
+			wantMember: false,
+		},
+		{
+			ctx:     context.Background(),
+			req:     explain.Request{Entity: "does-not-exist", Group: "group1"},
+			wantErr: ErrDoesNotExist,
+		},
+		{
+			ctx:     context.Background(),
+			req:     explain.Request{Entity: "entity1", Group: "does-not-exist"},
+			wantErr: ErrDoesNotExist,
+		},
+	}
+
+	for i, c := range cases {
+		s := newServer(t)
+		initTree(t, s.Manager)
+		s.ModifyGroupRule("group2", "group1", pb.RuleAction_INCLUDE)
+
+		res, err := s.EntityExplain(c.ctx, &c.req)
+		if err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+		if c.wantErr != nil {
+			continue
+		}
+		if res.Explanation.Member != c.wantMember {
+			t.Errorf("%d: Got %v; Want %v", i, res.Explanation.Member, c.wantMember)
+		}
+	}
+}
+
+func TestEntityExplainPath(t *testing.T) {
+	s := newServer(t)
+	initTree(t, s.Manager)
+	s.ModifyGroupRule("group2", "group1", pb.RuleAction_INCLUDE)
+
+	res, err := s.EntityExplain(context.Background(), &explain.Request{Entity: "entity1", Group: "group2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := res.Explanation
+	if x.Direct || len(x.Include) != 1 || x.Include[0].Group != "group1" || !x.Include[0].Direct {
+		t.Errorf("Got %+v; Want membership through group1", x)
+	}
+}
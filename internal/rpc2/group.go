@@ -16,11 +16,12 @@ import (
 func (s *Server) GroupCreate(ctx context.Context, r *pb.GroupRequest) (*pb.Empty, error) {
 	g := r.GetGroup()
 
-	if err := s.mutablePrequisitesMet(ctx, types.Capability_CREATE_GROUP); err != nil {
+	ctx, err := s.mutablePrequisitesMetFor(ctx, types.Capability_CREATE_GROUP, &target{parent: g.GetManagedBy()})
+	if err != nil {
 		return &pb.Empty{}, err
 	}
 
-	switch err := s.CreateGroup(g.GetName(), g.GetDisplayName(), g.GetManagedBy(), g.GetNumber()); err {
+	switch err := s.as(ctx).CreateGroupInPool(g.GetName(), g.GetDisplayName(), g.GetManagedBy(), getNumberPool(ctx), g.GetNumber()); err {
 	case tree.ErrDuplicateGroupName, tree.ErrDuplicateNumber:
 		s.log.Warn("Attempt to create duplicate group",
 			"group", g.GetName(),
@@ -30,6 +31,24 @@ func (s *Server) GroupCreate(ctx context.Context, r *pb.GroupRequest) (*pb.Empty
 			"error", err,
 		)
 		return &pb.Empty{}, ErrExists
+	case db.ErrUnknownNumberPool:
+		s.log.Warn("Attempt to allocate from an unknown pool",
+			"group", g.GetName(),
+			"pool", getNumberPool(ctx),
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, ErrMalformedRequest
+	case db.ErrNumberPoolExhausted:
+		s.log.Error("Number pool exhausted",
+			"group", g.GetName(),
+			"pool", getNumberPool(ctx),
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, ErrExhausted
 	case nil:
 		s.log.Info("Group Created",
 			"group", g.GetName(),
@@ -55,12 +74,23 @@ func (s *Server) GroupCreate(ctx context.Context, r *pb.GroupRequest) (*pb.Empty
 // untyped metadata.
 func (s *Server) GroupUpdate(ctx context.Context, r *pb.GroupRequest) (*pb.Empty, error) {
 	g := r.GetGroup()
-	err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_GROUP_META)
-	if err != nil && !s.manageByMembership(getTokenClaims(ctx).EntityID, g) {
+
+	// A group that is updated to hold capabilities can't be
+	// updated with a scoped capability, as that would grant
+	// capabilities from within the scope.
+	var t *target
+	if len(g.GetCapabilities()) == 0 {
+		t = &target{group: g.GetName()}
+	}
+	ctx, err := s.mutablePrequisitesMetFor(ctx, types.Capability_MODIFY_GROUP_META, t)
+	if err != nil && (err == ErrDeniedByPolicy || !s.manageByMembership(getTokenClaims(ctx).EntityID, g)) {
+		return &pb.Empty{}, err
+	}
+	if err := s.scopeChangeAuthorized(ctx, g.GetKV()...); err != nil {
 		return &pb.Empty{}, err
 	}
 
-	switch err := s.UpdateGroupMeta(g.GetName(), g); err {
+	switch err := s.as(ctx).UpdateGroupMeta(g.GetName(), g, getRevision(ctx)); err {
 	case db.ErrUnknownGroup:
 		s.log.Warn("Unable to load group",
 			"group", g.GetName(),
@@ -70,6 +100,22 @@ func (s *Server) GroupUpdate(ctx context.Context, r *pb.GroupRequest) (*pb.Empty
 			"error", err,
 		)
 		return &pb.Empty{}, ErrDoesNotExist
+	case db.ErrConflict:
+		s.log.Info("Group revision conflict",
+			"group", g.GetName(),
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+			"error", err,
+		)
+		return &pb.Empty{}, ErrConflict
+	case tree.ErrBadScope:
+		s.log.Warn("Bad scoped capability",
+			"group", g.GetName(),
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, ErrMalformedRequest
 	case nil:
 		s.log.Info("Group Updated",
 			"group", g.GetName(),
@@ -96,7 +142,7 @@ func (s *Server) GroupUpdate(ctx context.Context, r *pb.GroupRequest) (*pb.Empty
 func (s *Server) GroupInfo(ctx context.Context, r *pb.GroupRequest) (*pb.ListOfGroups, error) {
 	g := r.GetGroup()
 
-	switch grp, err := s.FetchGroup(g.GetName()); err {
+	switch grp, rev, err := s.FetchGroupRevision(g.GetName()); err {
 	case db.ErrUnknownGroup:
 		s.log.Warn("Unknown Group",
 			"group", g.GetName(),
@@ -112,6 +158,7 @@ func (s *Server) GroupInfo(ctx context.Context, r *pb.GroupRequest) (*pb.ListOfG
 			"client", getClientName(ctx),
 			"error", err,
 		)
+		setRevision(ctx, rev)
 		return &pb.ListOfGroups{Groups: []*types.Group{grp}}, nil
 	default:
 		s.log.Warn("Error Loading Group",
@@ -134,16 +181,17 @@ func (s *Server) GroupUM(ctx context.Context, r *pb.KVRequest) (*pb.ListOfString
 	}
 
 	if r.GetAction() != pb.Action_READ {
-		err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_GROUP_META)
+		var err error
+		ctx, err = s.mutablePrequisitesMetFor(ctx, types.Capability_MODIFY_GROUP_META, &target{group: r.GetTarget()})
 		g := types.Group{Name: proto.String(r.GetTarget())}
-		if err != nil && !s.manageByMembership(getTokenClaims(ctx).EntityID, &g) {
+		if err != nil && (err == ErrDeniedByPolicy || !s.manageByMembership(getTokenClaims(ctx).EntityID, &g)) {
 			return &pb.ListOfStrings{}, err
 		}
 	}
 
 	// At this point, we're either in a read-only query, or in a
 	// write one that has been authorized.
-	meta, err := s.ManageUntypedGroupMeta(r.GetTarget(), r.GetAction().String(), r.GetKey(), r.GetValue())
+	meta, err := s.as(ctx).ManageUntypedGroupMeta(r.GetTarget(), r.GetAction().String(), r.GetKey(), r.GetValue())
 	switch err {
 	case db.ErrUnknownGroup:
 		s.log.Warn("Group does not exist!",
@@ -217,11 +265,15 @@ func (s *Server) GroupKVGet(ctx context.Context, r *pb.KV2Request) (*pb.ListOfKV
 // GroupKVAdd takes the input KV2 data and adds it to an group if an
 // only if it does not conflict with an existing key.
 func (s *Server) GroupKVAdd(ctx context.Context, r *pb.KV2Request) (*pb.Empty, error) {
-	if err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_GROUP_META); err != nil {
+	ctx, err := s.mutablePrequisitesMetFor(ctx, types.Capability_MODIFY_GROUP_META, &target{group: r.GetTarget()})
+	if err != nil {
+		return &pb.Empty{}, err
+	}
+	if err := s.scopeChangeAuthorized(ctx, r.GetData()); err != nil {
 		return &pb.Empty{}, err
 	}
 
-	err := s.Manager.GroupKVAdd(r.GetTarget(), []*types.KVData{r.GetData()})
+	err = s.as(ctx).GroupKVAdd(r.GetTarget(), []*types.KVData{r.GetData()})
 	switch err {
 	case db.ErrUnknownGroup:
 		s.log.Warn("Group does not exist!",
@@ -231,6 +283,28 @@ func (s *Server) GroupKVAdd(ctx context.Context, r *pb.KV2Request) (*pb.Empty, e
 			"client", getClientName(ctx),
 		)
 		return &pb.Empty{}, ErrDoesNotExist
+	case db.ErrBadRule:
+		s.log.Warn("Bad dynamic group rule",
+			"group", r.GetTarget(),
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, ErrMalformedRequest
+	case tree.ErrBadScope:
+		s.log.Warn("Bad scoped capability",
+			"group", r.GetTarget(),
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, ErrMalformedRequest
+	case tree.ErrReservedKey:
+		s.log.Warn("Attempt to change a reserved key",
+			"group", r.GetTarget(),
+			"key", r.GetData().GetKey(),
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, ErrMalformedRequest
 	case nil:
 		s.log.Info("Group KV Updated Dumped",
 			"group", r.GetTarget(),
@@ -254,11 +328,15 @@ func (s *Server) GroupKVAdd(ctx context.Context, r *pb.KV2Request) (*pb.Empty, e
 // GroupKVDel removes an existing key from an group.  If the key is
 // not present an error will be returned.
 func (s *Server) GroupKVDel(ctx context.Context, r *pb.KV2Request) (*pb.Empty, error) {
-	if err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_GROUP_META); err != nil {
+	ctx, err := s.mutablePrequisitesMetFor(ctx, types.Capability_MODIFY_GROUP_META, &target{group: r.GetTarget()})
+	if err != nil {
+		return &pb.Empty{}, err
+	}
+	if err := s.scopeChangeAuthorized(ctx, r.GetData()); err != nil {
 		return &pb.Empty{}, err
 	}
 
-	err := s.Manager.GroupKVDel(r.GetTarget(), []*types.KVData{r.GetData()})
+	err = s.as(ctx).GroupKVDel(r.GetTarget(), []*types.KVData{r.GetData()})
 	switch err {
 	case db.ErrUnknownGroup:
 		s.log.Warn("Group does not exist!",
@@ -268,6 +346,14 @@ func (s *Server) GroupKVDel(ctx context.Context, r *pb.KV2Request) (*pb.Empty, e
 			"client", getClientName(ctx),
 		)
 		return &pb.Empty{}, ErrDoesNotExist
+	case tree.ErrReservedKey:
+		s.log.Warn("Attempt to change a reserved key",
+			"group", r.GetTarget(),
+			"key", r.GetData().GetKey(),
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, ErrMalformedRequest
 	case nil:
 		s.log.Info("Group KV Data Dumped",
 			"group", r.GetTarget(),
@@ -292,11 +378,15 @@ func (s *Server) GroupKVDel(ctx context.Context, r *pb.KV2Request) (*pb.Empty, e
 // The key must already exist on the group or an error will be
 // returned.
 func (s *Server) GroupKVReplace(ctx context.Context, r *pb.KV2Request) (*pb.Empty, error) {
-	if err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_GROUP_META); err != nil {
+	ctx, err := s.mutablePrequisitesMetFor(ctx, types.Capability_MODIFY_GROUP_META, &target{group: r.GetTarget()})
+	if err != nil {
+		return &pb.Empty{}, err
+	}
+	if err := s.scopeChangeAuthorized(ctx, r.GetData()); err != nil {
 		return &pb.Empty{}, err
 	}
 
-	err := s.Manager.GroupKVReplace(r.GetTarget(), []*types.KVData{r.GetData()})
+	err = s.as(ctx).GroupKVReplace(r.GetTarget(), []*types.KVData{r.GetData()})
 	switch err {
 	case db.ErrUnknownGroup:
 		s.log.Warn("Group does not exist!",
@@ -306,6 +396,28 @@ func (s *Server) GroupKVReplace(ctx context.Context, r *pb.KV2Request) (*pb.Empt
 			"client", getClientName(ctx),
 		)
 		return &pb.Empty{}, ErrDoesNotExist
+	case db.ErrBadRule:
+		s.log.Warn("Bad dynamic group rule",
+			"group", r.GetTarget(),
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, ErrMalformedRequest
+	case tree.ErrBadScope:
+		s.log.Warn("Bad scoped capability",
+			"group", r.GetTarget(),
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, ErrMalformedRequest
+	case tree.ErrReservedKey:
+		s.log.Warn("Attempt to change a reserved key",
+			"group", r.GetTarget(),
+			"key", r.GetData().GetKey(),
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, ErrMalformedRequest
 	case nil:
 		s.log.Info("Group KV Data Updated",
 			"group", r.GetTarget(),
@@ -330,12 +442,12 @@ func (s *Server) GroupKVReplace(ctx context.Context, r *pb.KV2Request) (*pb.Empt
 func (s *Server) GroupUpdateRules(ctx context.Context, r *pb.GroupRulesRequest) (*pb.Empty, error) {
 	g := r.GetGroup()
 
-	err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_GROUP_META)
-	if err != nil && !s.manageByMembership(getTokenClaims(ctx).EntityID, g) {
+	ctx, err := s.mutablePrequisitesMetFor(ctx, types.Capability_MODIFY_GROUP_META, &target{group: g.GetName()})
+	if err != nil && (err == ErrDeniedByPolicy || !s.manageByMembership(getTokenClaims(ctx).EntityID, g)) {
 		return &pb.Empty{}, err
 	}
 
-	switch err := s.ModifyGroupRule(r.GetGroup().GetName(), r.GetTarget().GetName(), r.GetRuleAction()); err {
+	switch err := s.as(ctx).ModifyGroupRule(r.GetGroup().GetName(), r.GetTarget().GetName(), r.GetRuleAction()); err {
 	case db.ErrUnknownGroup:
 		s.log.Warn("Group does not exist!",
 			"method", "GroupUpdateRules",
@@ -365,14 +477,20 @@ func (s *Server) GroupUpdateRules(ctx context.Context, r *pb.GroupRulesRequest)
 	}
 }
 
-// GroupAddMember adds an entity directly to a group.
+// GroupAddMember adds an entity directly to a group.  The membership
+// expires at the time sent in the until metadata, if there is one.
 func (s *Server) GroupAddMember(ctx context.Context, r *pb.EntityRequest) (*pb.Empty, error) {
 	e := r.GetEntity()
 
-	preErr := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_GROUP_MEMBERS)
+	until, err := getMembershipExpiry(ctx)
+	if err != nil {
+		return &pb.Empty{}, err
+	}
+
 	for _, g := range e.GetMeta().GetGroups() {
+		ctx, preErr := s.mutablePrequisitesMetFor(ctx, types.Capability_MODIFY_GROUP_MEMBERS, &target{group: g})
 		grp := types.Group{Name: proto.String(g)}
-		if preErr != nil && !s.manageByMembership(getTokenClaims(ctx).EntityID, &grp) {
+		if preErr != nil && (preErr == ErrDeniedByPolicy || !s.manageByMembership(getTokenClaims(ctx).EntityID, &grp)) {
 			s.log.Warn("Insufficient authority to add entity to group",
 				"entity", e.GetID(),
 				"group", g,
@@ -380,7 +498,7 @@ func (s *Server) GroupAddMember(ctx context.Context, r *pb.EntityRequest) (*pb.E
 			)
 			return &pb.Empty{}, preErr
 		}
-		if err := s.AddEntityToGroup(e.GetID(), g); err != nil {
+		if err := s.as(ctx).AddEntityToGroupUntil(e.GetID(), g, until); err != nil {
 			s.log.Warn("Error adding entity to group",
 				"entity", e.GetID(),
 				"group", g,
@@ -399,10 +517,10 @@ func (s *Server) GroupAddMember(ctx context.Context, r *pb.EntityRequest) (*pb.E
 func (s *Server) GroupDelMember(ctx context.Context, r *pb.EntityRequest) (*pb.Empty, error) {
 	e := r.GetEntity()
 
-	preErr := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_GROUP_MEMBERS)
 	for _, g := range e.GetMeta().GetGroups() {
+		ctx, preErr := s.mutablePrequisitesMetFor(ctx, types.Capability_MODIFY_GROUP_MEMBERS, &target{group: g})
 		grp := types.Group{Name: proto.String(g)}
-		if preErr != nil && !s.manageByMembership(getTokenClaims(ctx).EntityID, &grp) {
+		if preErr != nil && (preErr == ErrDeniedByPolicy || !s.manageByMembership(getTokenClaims(ctx).EntityID, &grp)) {
 			s.log.Warn("Insufficient authority to add entity to group",
 				"entity", e.GetID(),
 				"group", g,
@@ -410,7 +528,7 @@ func (s *Server) GroupDelMember(ctx context.Context, r *pb.EntityRequest) (*pb.E
 			)
 			return &pb.Empty{}, preErr
 		}
-		if err := s.RemoveEntityFromGroup(e.GetID(), g); err != nil {
+		if err := s.as(ctx).RemoveEntityFromGroup(e.GetID(), g); err != nil {
 			s.log.Warn("Error adding entity to group",
 				"entity", e.GetID(),
 				"group", g,
@@ -431,11 +549,23 @@ func (s *Server) GroupDelMember(ctx context.Context, r *pb.EntityRequest) (*pb.E
 func (s *Server) GroupDestroy(ctx context.Context, r *pb.GroupRequest) (*pb.Empty, error) {
 	g := r.GetGroup()
 
-	if err := s.mutablePrequisitesMet(ctx, types.Capability_DESTROY_GROUP); err != nil {
+	ctx, err := s.mutablePrequisitesMetFor(ctx, types.Capability_DESTROY_GROUP, &target{group: g.GetName()})
+	if err != nil {
 		return &pb.Empty{}, err
 	}
 
-	switch err := s.DestroyGroup(g.GetName()); err {
+	err = s.as(ctx).DestroyGroup(g.GetName())
+	if rerr, ok := err.(*tree.ReferenceError); ok {
+		s.log.Warn("Group is still referenced",
+			"group", g.GetName(),
+			"references", rerr.References,
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, errReferenced(rerr)
+	}
+	switch err {
 	case db.ErrUnknownGroup:
 		s.log.Warn("Group does not exist!",
 			"method", "GroupDestroy",
@@ -494,12 +624,27 @@ func (s *Server) GroupMembers(ctx context.Context, r *pb.GroupRequest) (*pb.List
 }
 
 // GroupSearch searches for groups and returns a list of all groups
-// matching the criteria specified.
+// matching the criteria specified.  Results may be paged, sorted, and
+// trimmed to some fields in the same way as EntitySearch.
 func (s *Server) GroupSearch(ctx context.Context, r *pb.SearchRequest) (*pb.ListOfGroups, error) {
 	expr := r.GetExpression()
 
-	res, err := s.SearchGroups(db.SearchRequest{Expression: expr})
+	req, err := getSearchRequest(ctx, expr)
 	if err != nil {
+		return &pb.ListOfGroups{}, err
+	}
+
+	res, next, err := s.SearchGroupPage(req)
+	switch err {
+	case nil:
+	case db.ErrBadPageToken:
+		s.log.Warn("Search continued with a bad page token",
+			"expr", expr,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.ListOfGroups{}, ErrMalformedRequest
+	default:
 		s.log.Warn("Search Error",
 			"expr", expr,
 			"service", getServiceName(ctx),
@@ -507,7 +652,8 @@ func (s *Server) GroupSearch(ctx context.Context, r *pb.SearchRequest) (*pb.List
 			"error", err,
 		)
 		return &pb.ListOfGroups{}, ErrInternal
-
 	}
+
+	setNextPageToken(ctx, next)
 	return &pb.ListOfGroups{Groups: res}, nil
 }
@@ -2,16 +2,60 @@ package rpc2
 
 import (
 	"context"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/token/null"
+	"github.com/netauth/netauth/internal/tree"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	types "github.com/netauth/protocol"
 	pb "github.com/netauth/protocol/v2"
 )
 
+func TestGroupCreatePool(t *testing.T) {
+	cases := []struct {
+		ctx        context.Context
+		name       string
+		wantNumber int32
+		wantErr    error
+	}{
+		{PrivilegedContext, "pool1", 1000, nil},
+		{ServicePoolContext, "pool2", 500, nil},
+		{ServicePoolContext, "pool3", 0, ErrExhausted},
+		{UnknownPoolContext, "pool4", 0, ErrMalformedRequest},
+	}
+
+	s, d, m := newServerWithRefs(t)
+	err := d.(*db.DB).SetGroupNumbers(db.NumberConfig{Pools: []db.NumberPool{
+		{Name: "default", NumberRange: db.NumberRange{Min: 1000, Max: 1999}},
+		{Name: "service", NumberRange: db.NumberRange{Min: 500, Max: 500}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, c := range cases {
+		req := pb.GroupRequest{Group: &types.Group{Name: proto.String(c.name), Number: proto.Int32(-1)}}
+		if _, err := s.GroupCreate(c.ctx, &req); err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+		if c.wantErr != nil {
+			continue
+		}
+		g, err := m.FetchGroup(c.name)
+		if err != nil || g.GetNumber() != c.wantNumber {
+			t.Errorf("%d: Got %d %v; Want %d", i, g.GetNumber(), err, c.wantNumber)
+		}
+	}
+}
+
 func TestGroupCreate(t *testing.T) {
 	cases := []struct {
 		ctx      context.Context
@@ -176,6 +220,18 @@ func TestGroupUpdate(t *testing.T) {
 			wantErr:  ErrInternal,
 			readonly: false,
 		},
+		{
+			// Fails, group is not at the requested revision
+			ctx: StaleRevisionContext,
+			req: pb.GroupRequest{
+				Group: &types.Group{
+					Name:        proto.String("group1"),
+					DisplayName: proto.String("First Group"),
+				},
+			},
+			wantErr:  ErrConflict,
+			readonly: false,
+		},
 	}
 
 	for i, c := range cases {
@@ -456,6 +512,18 @@ func TestGroupKVAdd(t *testing.T) {
 			req:     &pb.KV2Request{Target: proto.String("load-error")},
 			wantErr: ErrInternal,
 		},
+		{
+			ro:  false,
+			ctx: PrivilegedContext,
+			req: &pb.KV2Request{
+				Target: proto.String("group1"),
+				Data: &types.KVData{
+					Key:    proto.String(tree.GroupRuleKey),
+					Values: []*types.KVValue{{Value: proto.String("ID:a OR ID:b")}},
+				},
+			},
+			wantErr: ErrMalformedRequest,
+		},
 		{
 			ro:      true,
 			ctx:     PrivilegedContext,
@@ -719,6 +787,65 @@ func TestGroupUpdateRules(t *testing.T) {
 	}
 }
 
+func TestGroupAddMemberUntil(t *testing.T) {
+	until := func(t time.Time) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", null.ValidToken, "until", t.Format(time.RFC3339)))
+	}
+	cases := []struct {
+		ctx        context.Context
+		wantErr    error
+		wantGroups []string
+	}{
+		{until(time.Now().Add(time.Hour)), nil, []string{"group2"}},
+		{until(time.Now().Add(-time.Hour)), nil, nil},
+		{metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", null.ValidToken, "until", "tomorrow")), ErrMalformedRequest, nil},
+	}
+
+	for i, c := range cases {
+		s := newServer(t)
+		initTree(t, s.Manager)
+
+		_, err := s.GroupAddMember(c.ctx, &pb.EntityRequest{
+			Entity: &types.Entity{
+				ID:   proto.String("unprivileged"),
+				Meta: &types.EntityMeta{Groups: []string{"group2"}},
+			},
+		})
+		if err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+
+		// An expired membership isn't honoured even before it
+		// is reaped.
+		e, _ := s.FetchEntity("unprivileged")
+		assert.ElementsMatch(t, c.wantGroups, s.GetMemberships(e), "%d", i)
+	}
+}
+
+func TestReapMemberships(t *testing.T) {
+	s, _, m := newServerWithRefs(t)
+	initTree(t, s.Manager)
+
+	now := time.Now()
+	if err := s.AddEntityToGroupUntil("unprivileged", "group1", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddEntityToGroupUntil("unprivileged", "group2", now); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := m.(*tree.Manager).ReapMemberships(now)
+	if err != nil || n != 1 {
+		t.Errorf("Got %d %v; Want 1 <nil>", n, err)
+	}
+	e, err := s.FetchEntity("unprivileged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"group1"}, e.GetMeta().GetGroups())
+	assert.Equal(t, 1, len(tree.MembershipExpiry(e)))
+}
+
 func TestGroupAddMember(t *testing.T) {
 	cases := []struct {
 		ctx      context.Context
@@ -1022,6 +1149,24 @@ func TestGroupDestroy(t *testing.T) {
 	}
 }
 
+func TestGroupDestroyRestrict(t *testing.T) {
+	s := newServer(t)
+	initTree(t, s.Manager)
+	if err := s.Manager.(*tree.Manager).SetDestroyMode(tree.DestroyRestrict); err != nil {
+		t.Fatal(err)
+	}
+
+	req := pb.GroupRequest{Group: &types.Group{Name: proto.String("group1")}}
+	_, err := s.GroupDestroy(PrivilegedContext, &req)
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("Got %v; Want %v", err, codes.FailedPrecondition)
+	}
+	msg := status.Convert(err).Message()
+	if !strings.Contains(msg, "entity entity1") || !strings.Contains(msg, "group group2") {
+		t.Errorf("References missing from %q", msg)
+	}
+}
+
 func TestGroupMembers(t *testing.T) {
 	cases := []struct {
 		group      string
@@ -1092,3 +1237,100 @@ func TestGroupSearch(t *testing.T) {
 		}
 	}
 }
+
+func TestGroupSearchOptions(t *testing.T) {
+	cases := []struct {
+		md        metadata.MD
+		wantNames []string
+		wantErr   error
+	}{
+		{metadata.Pairs("sort", "-_id", "page-size", "1"), []string{"group2"}, nil},
+		{metadata.Pairs("page-token", "garbage"), nil, ErrMalformedRequest},
+	}
+
+	for i, c := range cases {
+		s := newServer(t)
+		initTree(t, s.Manager)
+		ctx := metadata.NewIncomingContext(context.Background(), c.md)
+		res, err := s.GroupSearch(ctx, &pb.SearchRequest{Expression: proto.String("Name:group*")})
+		if err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+		var names []string
+		for _, g := range res.GetGroups() {
+			names = append(names, g.GetName())
+		}
+		assert.Equal(t, c.wantNames, names, "%d", i)
+	}
+}
+
+func TestDynamicGroup(t *testing.T) {
+	s := newServer(t)
+	initTree(t, s.Manager)
+
+	kv := func(target, key, value string) *pb.KV2Request {
+		return &pb.KV2Request{
+			Target: proto.String(target),
+			Data: &types.KVData{
+				Key:    proto.String(key),
+				Values: []*types.KVValue{{Value: proto.String(value)}},
+			},
+		}
+	}
+	members := func(group string) []string {
+		res, err := s.GroupMembers(PrivilegedContext, &pb.GroupRequest{Group: &types.Group{Name: proto.String(group)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := []string{}
+		for _, e := range res.GetEntities() {
+			out = append(out, e.GetID())
+		}
+		return out
+	}
+
+	for _, id := range []string{"entity1", "unprivileged"} {
+		if _, err := s.EntityKVAdd(PrivilegedContext, kv(id, "location", "berlin")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.GroupKVAdd(PrivilegedContext, kv("group2", tree.GroupRuleKey, "kv.location:berlin -meta.Locked:true")); err != nil {
+		t.Fatal(err)
+	}
+	assert.ElementsMatch(t, []string{"entity1", "unprivileged"}, members("group2"))
+
+	// Membership follows changes to the entities.
+	if _, err := s.EntityLock(PrivilegedContext, &pb.EntityRequest{Entity: &types.Entity{ID: proto.String("unprivileged")}}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"entity1"}, members("group2"))
+
+	// Dynamic groups can be expanded into other groups, and are
+	// reported with the other memberships of an entity.
+	if _, err := s.EntityKVAdd(PrivilegedContext, kv("admin", "location", "berlin")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GroupUpdateRules(PrivilegedContext, &pb.GroupRulesRequest{
+		Group:      &types.Group{Name: proto.String("group1")},
+		Target:     &types.Group{Name: proto.String("group2")},
+		RuleAction: pb.RuleAction_INCLUDE.Enum(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	assert.ElementsMatch(t, []string{"admin", "entity1"}, members("group1"))
+	res, err := s.EntityGroups(PrivilegedContext, &pb.EntityRequest{Entity: &types.Entity{ID: proto.String("admin")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var groups []string
+	for _, g := range res.GetGroups() {
+		groups = append(groups, g.GetName())
+	}
+	assert.ElementsMatch(t, []string{"group1", "group2"}, groups)
+
+	// Removing the rule makes the group static again.
+	if _, err := s.GroupKVDel(PrivilegedContext, kv("group2", tree.GroupRuleKey, "")); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{}, members("group2"))
+}
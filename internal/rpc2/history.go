@@ -0,0 +1,140 @@
+package rpc2
+
+import (
+	"context"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/tree"
+	"github.com/netauth/netauth/pkg/history"
+
+	types "github.com/netauth/protocol"
+)
+
+// HistoryList returns the revisions that are kept for an entity or
+// group.  As with EntityInfo and GroupInfo this does not require
+// authentication, and entity secrets are never returned.
+func (s *Server) HistoryList(ctx context.Context, r *history.Request) (*history.Result, error) {
+	res := &history.Result{}
+
+	var err error
+	switch r.Kind {
+	case history.KindEntity:
+		var h []db.HistoricEntity
+		h, err = s.EntityHistory(r.ID)
+		for _, e := range h {
+			res.Revisions = append(res.Revisions, &history.Revision{
+				Revision: e.Revision,
+				Seq:      e.Seq,
+				Time:     e.Time,
+				Entity:   e.Entity,
+			})
+		}
+	case history.KindGroup:
+		var h []db.HistoricGroup
+		h, err = s.GroupHistory(r.ID)
+		for _, g := range h {
+			res.Revisions = append(res.Revisions, &history.Revision{
+				Revision: g.Revision,
+				Seq:      g.Seq,
+				Time:     g.Time,
+				Group:    g.Group,
+			})
+		}
+	default:
+		return nil, ErrMalformedRequest
+	}
+
+	if err != nil {
+		s.log.Warn("Error loading history",
+			"kind", r.Kind,
+			"id", r.ID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+			"error", err,
+		)
+		return nil, ErrInternal
+	}
+
+	s.log.Info("Dumped History",
+		"kind", r.Kind,
+		"id", r.ID,
+		"service", getServiceName(ctx),
+		"client", getClientName(ctx),
+	)
+	return res, nil
+}
+
+// HistoryRollback restores an entity or group to a previous revision.
+// Since a rollback may restore capabilities and memberships it
+// requires GLOBAL_ROOT.  If a revision is provided in the request
+// metadata the rollback only happens if the object is still at that
+// revision.
+func (s *Server) HistoryRollback(ctx context.Context, r *history.RollbackRequest) (*history.RollbackResult, error) {
+	ctx, err := s.mutablePrequisitesMet(ctx, types.Capability_GLOBAL_ROOT)
+	if err != nil {
+		return nil, err
+	}
+
+	switch r.Kind {
+	case history.KindEntity:
+		err = s.as(ctx).RollbackEntity(r.ID, r.Revision, getRevision(ctx))
+	case history.KindGroup:
+		err = s.as(ctx).RollbackGroup(r.ID, r.Revision, getRevision(ctx))
+	default:
+		return nil, ErrMalformedRequest
+	}
+
+	switch err {
+	case db.ErrUnknownEntity, db.ErrUnknownGroup, db.ErrUnknownRevision:
+		s.log.Warn("Rollback target does not exist!",
+			"kind", r.Kind,
+			"id", r.ID,
+			"revision", r.Revision,
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+			"error", err,
+		)
+		return nil, ErrDoesNotExist
+	case db.ErrConflict:
+		s.log.Warn("Rollback lost a race",
+			"kind", r.Kind,
+			"id", r.ID,
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return nil, ErrConflict
+	case tree.ErrExistingExpansion:
+		s.log.Warn("Rollback would create an expansion cycle",
+			"kind", r.Kind,
+			"id", r.ID,
+			"revision", r.Revision,
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return nil, ErrExists
+	case nil:
+		s.log.Info("Rolled Back",
+			"kind", r.Kind,
+			"id", r.ID,
+			"revision", r.Revision,
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &history.RollbackResult{}, nil
+	default:
+		s.log.Warn("Error rolling back",
+			"kind", r.Kind,
+			"id", r.ID,
+			"revision", r.Revision,
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+			"error", err,
+		)
+		return nil, ErrInternal
+	}
+}
@@ -0,0 +1,117 @@
+package rpc2
+
+import (
+	"context"
+	"testing"
+
+	"github.com/netauth/netauth/pkg/history"
+)
+
+func TestHistoryList(t *testing.T) {
+	cases := []struct {
+		req     history.Request
+		wantErr error
+	}{
+		{
+			req:     history.Request{Kind: history.KindEntity, ID: "entity1"},
+			wantErr: nil,
+		},
+		{
+			req:     history.Request{Kind: history.KindGroup, ID: "group1"},
+			wantErr: nil,
+		},
+		{
+			req:     history.Request{Kind: "bogus", ID: "entity1"},
+			wantErr: ErrMalformedRequest,
+		},
+	}
+
+	for i, c := range cases {
+		s := newServer(t)
+		initTree(t, s.Manager)
+		res, err := s.HistoryList(context.Background(), &c.req)
+		if err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		if len(res.Revisions) == 0 {
+			t.Errorf("%d: No revisions returned", i)
+		}
+		for _, r := range res.Revisions {
+			if r.Entity.GetSecret() != "" && r.Entity.GetSecret() != "<REDACTED>" {
+				t.Errorf("%d: Secret was returned", i)
+			}
+		}
+	}
+}
+
+func TestHistoryRollback(t *testing.T) {
+	cases := []struct {
+		ctx      context.Context
+		req      history.RollbackRequest
+		wantErr  error
+		readonly bool
+	}{
+		{
+			ctx:      PrivilegedContext,
+			req:      history.RollbackRequest{Kind: history.KindEntity, ID: "entity1"},
+			wantErr:  nil,
+			readonly: false,
+		},
+		{
+			ctx:      PrivilegedContext,
+			req:      history.RollbackRequest{Kind: history.KindEntity, ID: "entity1"},
+			wantErr:  ErrReadOnly,
+			readonly: true,
+		},
+		{
+			ctx:      UnprivilegedContext,
+			req:      history.RollbackRequest{Kind: history.KindEntity, ID: "entity1"},
+			wantErr:  ErrRequestorUnqualified,
+			readonly: false,
+		},
+		{
+			ctx:      PrivilegedContext,
+			req:      history.RollbackRequest{Kind: history.KindEntity, ID: "entity1", Revision: "0000000000000000"},
+			wantErr:  ErrDoesNotExist,
+			readonly: false,
+		},
+		{
+			ctx:      PrivilegedContext,
+			req:      history.RollbackRequest{Kind: history.KindGroup, ID: "group1"},
+			wantErr:  nil,
+			readonly: false,
+		},
+		{
+			ctx:      PrivilegedContext,
+			req:      history.RollbackRequest{Kind: "bogus", ID: "entity1"},
+			wantErr:  ErrMalformedRequest,
+			readonly: false,
+		},
+	}
+
+	for i, c := range cases {
+		s := newServer(t)
+		initTree(t, s.Manager)
+		s.readonly = c.readonly
+
+		// Unless a revision is given roll back to the oldest
+		// one that is kept.
+		if c.req.Revision == "" {
+			switch c.req.Kind {
+			case history.KindEntity:
+				h, _ := s.EntityHistory(c.req.ID)
+				c.req.Revision = h[0].Revision
+			case history.KindGroup:
+				h, _ := s.GroupHistory(c.req.ID)
+				c.req.Revision = h[0].Revision
+			}
+		}
+
+		if _, err := s.HistoryRollback(c.ctx, &c.req); err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+	}
+}
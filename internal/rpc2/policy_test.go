@@ -0,0 +1,157 @@
+package rpc2
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/go-hclog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/netauth/netauth/internal/policy"
+
+	types "github.com/netauth/protocol"
+	pb "github.com/netauth/protocol/v2"
+)
+
+// methodStream names the method of a request, which is otherwise
+// only known to a context that came from a real gRPC server.
+type methodStream struct{ method string }
+
+func (m methodStream) Method() string               { return m.method }
+func (m methodStream) SetHeader(metadata.MD) error  { return nil }
+func (m methodStream) SendHeader(metadata.MD) error { return nil }
+func (m methodStream) SetTrailer(metadata.MD) error { return nil }
+
+func withMethod(ctx context.Context, method string) context.Context {
+	return grpc.NewContextWithServerTransportStream(ctx, methodStream{"/netauth.v2.NetAuth2/" + method})
+}
+
+func TestAuthorizationPolicy(t *testing.T) {
+	entity1Context := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "{\"EntityID\":\"entity1\",\"Capabilities\":[]}"))
+
+	rules := []policy.Rule{
+		{
+			Name:    "no-destroy",
+			Methods: []string{"EntityDestroy"},
+			Effect:  policy.Deny,
+		},
+		{
+			Name:    "group1-lock",
+			Methods: []string{"EntityLock"},
+			Effect:  policy.Allow,
+			Self:    "other",
+			Caller:  policy.Match{Groups: []string{"group1"}},
+		},
+		{
+			Name:    "no-own-keys",
+			Methods: []string{"EntityKeys"},
+			Effect:  policy.Deny,
+			Self:    "self",
+		},
+		{
+			Name:    "no-group2-members",
+			Methods: []string{"GroupAddMember"},
+			Effect:  policy.Deny,
+			Target:  policy.Match{Groups: []string{"group2"}},
+		},
+	}
+
+	destroy := func(ctx context.Context) func(*Server) error {
+		return func(s *Server) error {
+			r := pb.EntityRequest{Entity: &types.Entity{ID: proto.String("unprivileged")}}
+			_, err := s.EntityDestroy(withMethod(ctx, "EntityDestroy"), &r)
+			return err
+		}
+	}
+	lock := func(ctx context.Context) func(*Server) error {
+		return func(s *Server) error {
+			r := pb.EntityRequest{Entity: &types.Entity{ID: proto.String("unprivileged")}}
+			_, err := s.EntityLock(withMethod(ctx, "EntityLock"), &r)
+			return err
+		}
+	}
+
+	cases := []struct {
+		mode    string
+		do      func(*Server) error
+		wantErr error
+	}{
+		{
+			// Fails, denied whatever the capabilities
+			mode:    policy.Enforce,
+			do:      destroy(PrivilegedContext),
+			wantErr: ErrDeniedByPolicy,
+		},
+		{
+			// Works, allowed without the capability
+			mode:    policy.Enforce,
+			do:      lock(entity1Context),
+			wantErr: nil,
+		},
+		{
+			// Fails, no rule matches and the capability is
+			// missing
+			mode:    policy.Enforce,
+			do:      lock(UnprivilegedContext),
+			wantErr: ErrRequestorUnqualified,
+		},
+		{
+			// Fails, changing own keys is denied
+			mode: policy.Enforce,
+			do: func(s *Server) error {
+				r := pb.KVRequest{
+					Target: proto.String("entity1"),
+					Action: pb.Action_ADD.Enum(),
+					Key:    proto.String("ssh"),
+					Value:  proto.String("key1"),
+				}
+				_, err := s.EntityKeys(withMethod(entity1Context, "EntityKeys"), &r)
+				return err
+			},
+			wantErr: ErrDeniedByPolicy,
+		},
+		{
+			// Fails, membership of group2 is denied
+			mode: policy.Enforce,
+			do: func(s *Server) error {
+				r := pb.EntityRequest{
+					Entity: &types.Entity{
+						ID:   proto.String("entity1"),
+						Meta: &types.EntityMeta{Groups: []string{"group2"}},
+					},
+				}
+				_, err := s.GroupAddMember(withMethod(PrivilegedContext, "GroupAddMember"), &r)
+				return err
+			},
+			wantErr: ErrDeniedByPolicy,
+		},
+		{
+			// Works, the denial is only logged
+			mode:    policy.DryRun,
+			do:      destroy(PrivilegedContext),
+			wantErr: nil,
+		},
+		{
+			// Fails, the allowance is only logged
+			mode:    policy.DryRun,
+			do:      lock(entity1Context),
+			wantErr: ErrRequestorUnqualified,
+		},
+	}
+
+	for i, c := range cases {
+		s := newServer(t)
+		initTree(t, s.Manager)
+		e, err := policy.NewEngine(s.Manager, hclog.NewNullLogger(), rules, c.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.policy = e
+
+		if err := c.do(s); err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package rpc2
+
+import (
+	"context"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/tree"
+	"github.com/netauth/netauth/pkg/rename"
+
+	types "github.com/netauth/protocol"
+)
+
+// EntityRename changes the ID of an entity.  Since a rename removes
+// the old ID and creates the new one it requires both CREATE_ENTITY
+// and DESTROY_ENTITY.  If a revision is provided in the request
+// metadata the rename only happens if the entity is still at that
+// revision.
+func (s *Server) EntityRename(ctx context.Context, r *rename.Request) (*rename.Result, error) {
+	var err error
+	for _, c := range []types.Capability{types.Capability_CREATE_ENTITY, types.Capability_DESTROY_ENTITY} {
+		ctx, err = s.mutablePrequisitesMetFor(ctx, c, &target{entity: r.ID})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.as(ctx).RenameEntity(r.ID, r.To, getRevision(ctx))
+	return s.renameResult(ctx, "entity", r, err)
+}
+
+// GroupRename changes the name of a group, and rewrites every
+// reference to the group to use the new name.  As with EntityRename
+// it requires both CREATE_GROUP and DESTROY_GROUP, and may be made
+// conditional on the revision of the group.
+func (s *Server) GroupRename(ctx context.Context, r *rename.Request) (*rename.Result, error) {
+	var err error
+	for _, c := range []types.Capability{types.Capability_CREATE_GROUP, types.Capability_DESTROY_GROUP} {
+		ctx, err = s.mutablePrequisitesMetFor(ctx, c, &target{group: r.ID})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.as(ctx).RenameGroup(r.ID, r.To, getRevision(ctx))
+	return s.renameResult(ctx, "group", r, err)
+}
+
+// renameResult logs the outcome of a rename and maps errors from the
+// tree to those returned to the client.
+func (s *Server) renameResult(ctx context.Context, kind string, r *rename.Request, err error) (*rename.Result, error) {
+	switch err {
+	case db.ErrUnknownEntity, db.ErrUnknownGroup:
+		s.log.Warn("Rename target does not exist!",
+			"kind", kind,
+			"id", r.ID,
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return nil, ErrDoesNotExist
+	case tree.ErrDuplicateEntityID, tree.ErrDuplicateGroupName:
+		s.log.Warn("Rename would collide with an existing name",
+			"kind", kind,
+			"id", r.ID,
+			"to", r.To,
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return nil, ErrExists
+	case tree.ErrNoRenameTarget:
+		return nil, ErrMalformedRequest
+	case db.ErrConflict:
+		s.log.Warn("Rename lost a race",
+			"kind", kind,
+			"id", r.ID,
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return nil, ErrConflict
+	case nil:
+		s.log.Info("Renamed",
+			"kind", kind,
+			"id", r.ID,
+			"to", r.To,
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &rename.Result{}, nil
+	default:
+		s.log.Warn("Error renaming",
+			"kind", kind,
+			"id", r.ID,
+			"to", r.To,
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+			"error", err,
+		)
+		return nil, ErrInternal
+	}
+}
@@ -0,0 +1,140 @@
+package rpc2
+
+import (
+	"context"
+	"testing"
+
+	"github.com/netauth/netauth/pkg/rename"
+)
+
+func TestEntityRename(t *testing.T) {
+	cases := []struct {
+		ctx      context.Context
+		req      rename.Request
+		wantErr  error
+		readonly bool
+	}{
+		{
+			ctx:      PrivilegedContext,
+			req:      rename.Request{ID: "entity1", To: "renamed"},
+			wantErr:  nil,
+			readonly: false,
+		},
+		{
+			ctx:      PrivilegedContext,
+			req:      rename.Request{ID: "entity1", To: "renamed"},
+			wantErr:  ErrReadOnly,
+			readonly: true,
+		},
+		{
+			ctx:      UnprivilegedContext,
+			req:      rename.Request{ID: "entity1", To: "renamed"},
+			wantErr:  ErrRequestorUnqualified,
+			readonly: false,
+		},
+		{
+			ctx:      PrivilegedContext,
+			req:      rename.Request{ID: "does-not-exist", To: "renamed"},
+			wantErr:  ErrDoesNotExist,
+			readonly: false,
+		},
+		{
+			ctx:      PrivilegedContext,
+			req:      rename.Request{ID: "entity1", To: "unprivileged"},
+			wantErr:  ErrExists,
+			readonly: false,
+		},
+		{
+			ctx:      PrivilegedContext,
+			req:      rename.Request{ID: "entity1"},
+			wantErr:  ErrMalformedRequest,
+			readonly: false,
+		},
+	}
+
+	for i, c := range cases {
+		s := newServer(t)
+		initTree(t, s.Manager)
+		s.readonly = c.readonly
+
+		if _, err := s.EntityRename(c.ctx, &c.req); err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+		if c.wantErr != nil {
+			continue
+		}
+		if _, err := s.FetchEntity(c.req.To); err != nil {
+			t.Errorf("%d: Renamed entity could not be fetched: %v", i, err)
+		}
+	}
+}
+
+func TestGroupRename(t *testing.T) {
+	cases := []struct {
+		ctx      context.Context
+		req      rename.Request
+		wantErr  error
+		readonly bool
+	}{
+		{
+			ctx:      PrivilegedContext,
+			req:      rename.Request{ID: "group1", To: "renamed"},
+			wantErr:  nil,
+			readonly: false,
+		},
+		{
+			ctx:      PrivilegedContext,
+			req:      rename.Request{ID: "group1", To: "renamed"},
+			wantErr:  ErrReadOnly,
+			readonly: true,
+		},
+		{
+			ctx:      UnprivilegedContext,
+			req:      rename.Request{ID: "group1", To: "renamed"},
+			wantErr:  ErrRequestorUnqualified,
+			readonly: false,
+		},
+		{
+			ctx:      PrivilegedContext,
+			req:      rename.Request{ID: "does-not-exist", To: "renamed"},
+			wantErr:  ErrDoesNotExist,
+			readonly: false,
+		},
+		{
+			ctx:      PrivilegedContext,
+			req:      rename.Request{ID: "group1", To: "group2"},
+			wantErr:  ErrExists,
+			readonly: false,
+		},
+	}
+
+	for i, c := range cases {
+		s := newServer(t)
+		initTree(t, s.Manager)
+		s.readonly = c.readonly
+
+		if _, err := s.GroupRename(c.ctx, &c.req); err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+		if c.wantErr != nil {
+			continue
+		}
+
+		// group2 is managed by group1, and entity1 is a member
+		// of it, both of which follow the rename.
+		g, err := s.FetchGroup("group2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g.GetManagedBy() != c.req.To {
+			t.Errorf("%d: Got %v; Want %v", i, g.GetManagedBy(), c.req.To)
+		}
+		members, err := s.ListMembers(c.req.To)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(members) != 1 || members[0].GetID() != "entity1" {
+			t.Errorf("%d: Got %v; Want [entity1]", i, members)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package rpc2
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/netauth/netauth/internal/tree"
+
+	types "github.com/netauth/protocol"
+	pb "github.com/netauth/protocol/v2"
+)
+
+// initScope adds to the tree a group that holds scoped capabilities
+// over itself, and makes the entity unprivileged a member of it.
+func initScope(t *testing.T, m Manager) {
+	m.CreateGroup("team-infra", "", "", -1)
+	m.CreateGroup("infra-users", "", "team-infra", -1)
+	m.CreateEntity("worker", -1, "secret")
+
+	m.AddEntityToGroup("unprivileged", "team-infra")
+	m.AddEntityToGroup("worker", "infra-users")
+
+	err := m.GroupKVAdd("team-infra", []*types.KVData{{
+		Key: proto.String(tree.ScopeKey),
+		Values: []*types.KVValue{
+			{Value: proto.String("MODIFY_GROUP_MEMBERS:team-infra")},
+			{Value: proto.String("CREATE_ENTITY:team-infra")},
+			{Value: proto.String("CHANGE_ENTITY_SECRET:team-infra")},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScopedCapabilities(t *testing.T) {
+	scopedContext := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "{\"EntityID\":\"unprivileged\",\"Capabilities\":[]}"))
+	groupMetaContext := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "{\"EntityID\":\"valid\",\"Capabilities\":[\"MODIFY_GROUP_META\"]}"))
+
+	scopeKV := func(v string) *types.KVData {
+		return &types.KVData{
+			Key:    proto.String(tree.ScopeKey),
+			Values: []*types.KVValue{{Value: proto.String(v)}},
+		}
+	}
+	addMember := func(ctx context.Context, entity, group string) func(*Server) error {
+		return func(s *Server) error {
+			_, err := s.GroupAddMember(ctx, &pb.EntityRequest{
+				Entity: &types.Entity{
+					ID:   proto.String(entity),
+					Meta: &types.EntityMeta{Groups: []string{group}},
+				},
+			})
+			return err
+		}
+	}
+	changeSecret := func(ctx context.Context, entity string) func(*Server) error {
+		return func(s *Server) error {
+			_, err := s.AuthChangeSecret(ctx, &pb.AuthRequest{
+				Entity: &types.Entity{ID: proto.String(entity)},
+				Secret: proto.String("secret1"),
+			})
+			return err
+		}
+	}
+
+	cases := []struct {
+		do      func(*Server) error
+		wantErr error
+	}{
+		{
+			// Works, group is managed from within the scope
+			do:      addMember(scopedContext, "entity1", "infra-users"),
+			wantErr: nil,
+		},
+		{
+			// Fails, group is outside the scope
+			do:      addMember(scopedContext, "entity1", "group1"),
+			wantErr: ErrRequestorUnqualified,
+		},
+		{
+			// Fails, group holds scoped capabilities
+			do:      addMember(scopedContext, "entity1", "team-infra"),
+			wantErr: ErrRequestorUnqualified,
+		},
+		{
+			// Works, creating an entity has no target
+			do: func(s *Server) error {
+				_, err := s.EntityCreate(scopedContext, &pb.EntityRequest{
+					Entity: &types.Entity{
+						ID:     proto.String("new-worker"),
+						Secret: proto.String("secret"),
+					},
+				})
+				return err
+			},
+			wantErr: nil,
+		},
+		{
+			// Fails, the capability to create groups isn't held
+			do: func(s *Server) error {
+				_, err := s.GroupCreate(scopedContext, &pb.GroupRequest{
+					Group: &types.Group{
+						Name:      proto.String("infra-admins"),
+						ManagedBy: proto.String("team-infra"),
+					},
+				})
+				return err
+			},
+			wantErr: ErrRequestorUnqualified,
+		},
+		{
+			// Works, entity is a member of a group in the scope
+			do:      changeSecret(scopedContext, "worker"),
+			wantErr: nil,
+		},
+		{
+			// Fails, entity is outside the scope
+			do:      changeSecret(scopedContext, "admin"),
+			wantErr: ErrRequestorUnqualified,
+		},
+		{
+			// Fails, changing scopes requires GLOBAL_ROOT
+			do: func(s *Server) error {
+				_, err := s.GroupKVAdd(groupMetaContext, &pb.KV2Request{
+					Target: proto.String("group1"),
+					Data:   scopeKV("MODIFY_GROUP_MEMBERS:group1"),
+				})
+				return err
+			},
+			wantErr: ErrRequestorUnqualified,
+		},
+		{
+			// Fails, GLOBAL_ROOT can't be scoped
+			do: func(s *Server) error {
+				_, err := s.GroupKVAdd(PrivilegedContext, &pb.KV2Request{
+					Target: proto.String("group1"),
+					Data:   scopeKV("GLOBAL_ROOT:group1"),
+				})
+				return err
+			},
+			wantErr: ErrMalformedRequest,
+		},
+	}
+
+	for i, c := range cases {
+		s := newServer(t)
+		initTree(t, s.Manager)
+		initScope(t, s.Manager)
+
+		if err := c.do(s); err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+	}
+}
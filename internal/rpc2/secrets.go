@@ -0,0 +1,48 @@
+package rpc2
+
+import (
+	"context"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/pkg/secrets"
+
+	types "github.com/netauth/protocol"
+)
+
+// SecretHistoryPurge forgets the previous secrets of an entity, so
+// that it may choose any of them again.  Since this undoes a
+// protection on the secret it requires CHANGE_ENTITY_SECRET.
+func (s *Server) SecretHistoryPurge(ctx context.Context, r *secrets.PurgeRequest) (*secrets.PurgeResult, error) {
+	ctx, err := s.mutablePrequisitesMetFor(ctx, types.Capability_CHANGE_ENTITY_SECRET, &target{entity: r.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	switch err := s.as(ctx).PurgeSecretHistory(r.ID); err {
+	case db.ErrUnknownEntity:
+		s.log.Warn("Entity does not exist!",
+			"method", "SecretHistoryPurge",
+			"entity", r.ID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return nil, ErrDoesNotExist
+	case nil:
+		s.log.Info("Secret history purged",
+			"entity", r.ID,
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &secrets.PurgeResult{}, nil
+	default:
+		s.log.Warn("Error purging secret history",
+			"entity", r.ID,
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+			"error", err,
+		)
+		return nil, ErrInternal
+	}
+}
@@ -0,0 +1,94 @@
+package rpc2
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/netauth/netauth/pkg/secrets"
+
+	types "github.com/netauth/protocol"
+	pb "github.com/netauth/protocol/v2"
+)
+
+func TestSecretHistoryPurge(t *testing.T) {
+	cases := []struct {
+		ctx      context.Context
+		req      secrets.PurgeRequest
+		wantErr  error
+		readonly bool
+	}{
+		{
+			ctx:      PrivilegedContext,
+			req:      secrets.PurgeRequest{ID: "entity1"},
+			wantErr:  nil,
+			readonly: false,
+		},
+		{
+			ctx:      PrivilegedContext,
+			req:      secrets.PurgeRequest{ID: "entity1"},
+			wantErr:  ErrReadOnly,
+			readonly: true,
+		},
+		{
+			ctx:      UnprivilegedContext,
+			req:      secrets.PurgeRequest{ID: "entity1"},
+			wantErr:  ErrRequestorUnqualified,
+			readonly: false,
+		},
+		{
+			ctx:      PrivilegedContext,
+			req:      secrets.PurgeRequest{ID: "does-not-exist"},
+			wantErr:  ErrDoesNotExist,
+			readonly: false,
+		},
+	}
+
+	for i, c := range cases {
+		s := newServer(t)
+		initTree(t, s.Manager)
+		s.readonly = c.readonly
+
+		if _, err := s.SecretHistoryPurge(c.ctx, &c.req); err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+	}
+}
+
+func TestSecretHistory(t *testing.T) {
+	viper.Set("password.history", 2)
+	defer viper.Reset()
+
+	s := newServer(t)
+	initTree(t, s.Manager)
+
+	change := func(secret string) error {
+		r := pb.AuthRequest{
+			Entity: &types.Entity{ID: proto.String("entity1")},
+			Secret: proto.String(secret),
+		}
+		_, err := s.AuthChangeSecret(PrivilegedContext, &r)
+		return err
+	}
+
+	if err := change("first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := change("second"); err != nil {
+		t.Fatal(err)
+	}
+	if err := change("first"); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Got %v; Want InvalidArgument", err)
+	}
+
+	if _, err := s.SecretHistoryPurge(PrivilegedContext, &secrets.PurgeRequest{ID: "entity1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := change("first"); err != nil {
+		t.Errorf("Got %v; Want nil", err)
+	}
+}
@@ -0,0 +1,151 @@
+package rpc2
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+
+	types "github.com/netauth/protocol"
+)
+
+// selfServiceFields are the fields that entities may be allowed to
+// change on themselves.  Fields that grant authority, such as
+// capabilities and groups, and the lock can never be changed this
+// way.
+var selfServiceFields = map[string]bool{
+	"gecos":          true,
+	"legalname":      true,
+	"displayname":    true,
+	"home":           true,
+	"shell":          true,
+	"graphicalshell": true,
+	"badgenumber":    true,
+	"keys":           true,
+}
+
+// selfService holds the fields and KV keys that an entity may change
+// on itself without any capability.
+type selfService struct {
+	fields map[string]bool
+	kv     map[string]bool
+}
+
+// newSelfService returns a selfService that allows the named fields
+// and KV keys.  Names of fields that can't be changed this way, and
+// keys in the netauth namespace, which hold data that grants
+// authority or that the server maintains itself, are logged and
+// ignored.
+func newSelfService(fields, kv []string, l hclog.Logger) selfService {
+	ss := selfService{
+		fields: make(map[string]bool, len(fields)),
+		kv:     make(map[string]bool, len(kv)),
+	}
+	for _, f := range fields {
+		f = strings.ToLower(f)
+		if !selfServiceFields[f] {
+			l.Warn("Field cannot be changed by self service", "field", f)
+			continue
+		}
+		ss.fields[f] = true
+	}
+	for _, k := range kv {
+		if strings.HasPrefix(k, "netauth.") {
+			l.Warn("Key cannot be changed by self service", "key", k)
+			continue
+		}
+		ss.kv[k] = true
+	}
+	return ss
+}
+
+// allowsMeta returns true if every field that is set in the metadata
+// is one that an entity may change on itself.
+func (ss selfService) allowsMeta(m *types.EntityMeta) bool {
+	set := map[string]bool{
+		"primarygroup":   m.PrimaryGroup != nil,
+		"gecos":          m.GECOS != nil,
+		"legalname":      m.LegalName != nil,
+		"displayname":    m.DisplayName != nil,
+		"home":           m.Home != nil,
+		"shell":          m.Shell != nil,
+		"graphicalshell": m.GraphicalShell != nil,
+		"badgenumber":    m.BadgeNumber != nil,
+		"locked":         m.Locked != nil,
+		"groups":         len(m.Groups) > 0,
+		"capabilities":   len(m.Capabilities) > 0,
+		"keys":           len(m.Keys) > 0,
+		"untypedmeta":    len(m.UntypedMeta) > 0,
+	}
+	for f, ok := range set {
+		if ok && !ss.fields[f] {
+			return false
+		}
+	}
+	return ss.allowsKV(m.GetKV()...)
+}
+
+// allowsKV returns true if every key in the KV data is one that an
+// entity may change on itself.
+func (ss selfService) allowsKV(kv ...*types.KVData) bool {
+	for _, d := range kv {
+		if !ss.kv[d.GetKey()] {
+			return false
+		}
+	}
+	return true
+}
+
+// allowsKeys returns true if an entity may change its own keys.
+func (ss selfService) allowsKeys() bool {
+	return ss.fields["keys"]
+}
+
+// selfPrequisitesMet checks the same prerequisites as
+// mutablePrequisitesMetFor with the entity id as the target, except
+// that if allowed is true and the token belongs to the entity itself
+// no capability is needed.  The holder of the token is checked again,
+// so that a token which outlives its entity or was issued before the
+// entity was locked can't be used to change it.  As with
+// mutablePrequisitesMetFor the returned context carries the claims.
+func (s *Server) selfPrequisitesMet(ctx context.Context, c types.Capability, id string, allowed bool) (context.Context, error) {
+	if s.readonly {
+		s.log.Warn("Mutable request in read-only mode!",
+			"method", "EntityUM",
+			"client", getClientName(ctx),
+			"service", getServiceName(ctx),
+		)
+		return ctx, ErrReadOnly
+	}
+
+	var err error
+	ctx, err = s.checkToken(ctx)
+	if err != nil {
+		return ctx, err
+	}
+
+	t := &target{entity: id}
+	err = s.capable(ctx, c, t)
+	if err != nil && allowed && s.isSelf(ctx, id) {
+		s.log.Info("Authorized by self service",
+			"entity", id,
+			"client", getClientName(ctx),
+			"service", getServiceName(ctx),
+		)
+		err = nil
+	}
+	return ctx, s.authorize(ctx, t, err)
+}
+
+// isSelf returns true if the token in the context belongs to the
+// entity id, and the entity still exists and isn't locked.
+func (s *Server) isSelf(ctx context.Context, id string) bool {
+	if id == "" || getTokenClaims(ctx).EntityID != id {
+		return false
+	}
+	e, err := s.FetchEntity(id)
+	if err != nil || e.GetMeta().GetLocked() {
+		return false
+	}
+	return true
+}
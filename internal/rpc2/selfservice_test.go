@@ -0,0 +1,141 @@
+package rpc2
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/go-hclog"
+	"google.golang.org/grpc/metadata"
+
+	types "github.com/netauth/protocol"
+	pb "github.com/netauth/protocol/v2"
+)
+
+func TestSelfService(t *testing.T) {
+	entity1Context := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "{\"EntityID\":\"entity1\",\"Capabilities\":[]}"))
+
+	update := func(id string, m *types.EntityMeta) func(*Server) error {
+		return func(s *Server) error {
+			r := pb.EntityRequest{Data: &types.Entity{ID: proto.String(id), Meta: m}}
+			_, err := s.EntityUpdate(entity1Context, &r)
+			return err
+		}
+	}
+	kvAdd := func(id, key string) func(*Server) error {
+		return func(s *Server) error {
+			r := pb.KV2Request{
+				Target: proto.String(id),
+				Data: &types.KVData{
+					Key:    proto.String(key),
+					Values: []*types.KVValue{{Value: proto.String("they/them")}},
+				},
+			}
+			_, err := s.EntityKVAdd(entity1Context, &r)
+			return err
+		}
+	}
+	keys := func(s *Server) error {
+		r := pb.KVRequest{
+			Target: proto.String("entity1"),
+			Action: pb.Action_ADD.Enum(),
+			Key:    proto.String("ssh"),
+			Value:  proto.String("key1"),
+		}
+		_, err := s.EntityKeys(entity1Context, &r)
+		return err
+	}
+
+	cases := []struct {
+		fields  []string
+		kv      []string
+		locked  bool
+		do      func(*Server) error
+		wantErr error
+	}{
+		{
+			// Works, shell is allowed
+			fields:  []string{"shell"},
+			do:      update("entity1", &types.EntityMeta{Shell: proto.String("/bin/zsh")}),
+			wantErr: nil,
+		},
+		{
+			// Fails, display name is not allowed
+			fields:  []string{"shell"},
+			do:      update("entity1", &types.EntityMeta{Shell: proto.String("/bin/zsh"), DisplayName: proto.String("Entity")}),
+			wantErr: ErrRequestorUnqualified,
+		},
+		{
+			// Fails, the lock can't be allowed
+			fields:  []string{"shell", "locked"},
+			do:      update("entity1", &types.EntityMeta{Locked: proto.Bool(false)}),
+			wantErr: ErrRequestorUnqualified,
+		},
+		{
+			// Fails, another entity
+			fields:  []string{"shell"},
+			do:      update("unprivileged", &types.EntityMeta{Shell: proto.String("/bin/zsh")}),
+			wantErr: ErrRequestorUnqualified,
+		},
+		{
+			// Fails, the entity is locked
+			fields:  []string{"shell"},
+			locked:  true,
+			do:      update("entity1", &types.EntityMeta{Shell: proto.String("/bin/zsh")}),
+			wantErr: ErrRequestorUnqualified,
+		},
+		{
+			// Works, the key is allowed
+			kv:      []string{"pronouns"},
+			do:      kvAdd("entity1", "pronouns"),
+			wantErr: nil,
+		},
+		{
+			// Fails, the key is not allowed
+			kv:      []string{"pronouns"},
+			do:      kvAdd("entity1", "title"),
+			wantErr: ErrRequestorUnqualified,
+		},
+		{
+			// Fails, keys in the netauth namespace can't be
+			// allowed
+			kv:      []string{"pronouns", "netauth.until"},
+			do:      kvAdd("entity1", "netauth.until"),
+			wantErr: ErrRequestorUnqualified,
+		},
+		{
+			// Works, keys are allowed
+			fields:  []string{"keys"},
+			do:      keys,
+			wantErr: nil,
+		},
+		{
+			// Fails, keys are not allowed
+			fields:  []string{"shell"},
+			do:      keys,
+			wantErr: ErrRequestorUnqualified,
+		},
+	}
+
+	for i, c := range cases {
+		s := newServer(t)
+		initTree(t, s.Manager)
+		s.self = newSelfService(c.fields, c.kv, hclog.NewNullLogger())
+		if c.locked {
+			if err := s.Manager.LockEntity("entity1"); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := c.do(s); err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+	}
+}
+
+func TestNewSelfService(t *testing.T) {
+	ss := newSelfService(nil, []string{"pronouns", "netauth.scope", "netauth.until"}, hclog.NewNullLogger())
+	if len(ss.kv) != 1 || !ss.kv["pronouns"] {
+		t.Errorf("Got %v; Want only pronouns", ss.kv)
+	}
+}
@@ -7,10 +7,23 @@ import (
 
 // New returns a ready to use server implementation.
 func New(r Refs, l hclog.Logger) *Server {
+	// Entities could always change their own keys, so that stays
+	// the default.
+	fields := []string{"keys"}
+	if viper.IsSet("server.selfservice.fields") {
+		fields = viper.GetStringSlice("server.selfservice.fields")
+	}
+
 	return &Server{
 		Service:  r.TokenService,
 		Manager:  r.Tree,
 		readonly: viper.GetBool("server.readonly"),
 		log:      l.Named("rpc2"),
+		changes:  r.ChangeLog,
+		events:   newNotifier(),
+
+		withActor: r.WithActor,
+		policy:    r.Policy,
+		self:      newSelfService(fields, viper.GetStringSlice("server.selfservice.kv"), l.Named("rpc2")),
 	}
 }
@@ -61,7 +61,7 @@ func newServer(t *testing.T) *Server {
 
 	n := null.New(hclog.NewNullLogger())
 
-	return New(Refs{TokenService: n, Tree: m}, hclog.NewNullLogger())
+	return New(Refs{TokenService: n, Tree: m, ChangeLog: db}, hclog.NewNullLogger())
 }
 
 func newServerWithRefs(t *testing.T) (*Server, tree.DB, Manager) {
@@ -89,7 +89,7 @@ func newServerWithRefs(t *testing.T) (*Server, tree.DB, Manager) {
 
 	n := null.New(hclog.NewNullLogger())
 
-	return New(Refs{TokenService: n, Tree: m}, hclog.NewNullLogger()), db, m
+	return New(Refs{TokenService: n, Tree: m, ChangeLog: db}, hclog.NewNullLogger()), db, m
 }
 
 func initTree(t *testing.T, m Manager) {
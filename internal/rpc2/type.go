@@ -1,10 +1,16 @@
 package rpc2
 
 import (
+	"time"
+
 	"github.com/hashicorp/go-hclog"
 
 	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/mresolver"
+	"github.com/netauth/netauth/internal/policy"
 	"github.com/netauth/netauth/internal/token"
+	"github.com/netauth/netauth/internal/tree"
+	"github.com/netauth/netauth/pkg/audit"
 
 	pb "github.com/netauth/protocol"
 	rpc "github.com/netauth/protocol/v2"
@@ -18,6 +24,24 @@ type Server struct {
 
 	readonly bool
 	log      hclog.Logger
+
+	// changes is the change log that watches read from, and
+	// events wakes them when it grows.
+	changes ChangeLog
+	events  *notifier
+
+	// withActor returns a Manager that attributes changes to an
+	// actor in the audit log.  It is nil if auditing is not
+	// configured.
+	withActor func(audit.Actor) Manager
+
+	// policy decides requests ahead of the capabilities of the
+	// requestor.  It is nil if no policy is configured.
+	policy *policy.Engine
+
+	// self holds what entities may change on themselves without
+	// any capability.
+	self selfService
 }
 
 // Refs is the container that is used to provide references to the RPC
@@ -25,6 +49,25 @@ type Server struct {
 type Refs struct {
 	TokenService token.Service
 	Tree         Manager
+
+	// WithActor is optional, and if provided is used to attribute
+	// each change to the requestor that made it.
+	WithActor func(audit.Actor) Manager
+
+	// Policy is optional, and if provided is applied to every
+	// request that needs authority.
+	Policy *policy.Engine
+
+	// ChangeLog is what watches are served from.
+	ChangeLog ChangeLog
+}
+
+// The ChangeLog provides the ordered record of changes to entities
+// and groups that watches are served from.
+type ChangeLog interface {
+	ChangeLogHead() (uint64, error)
+	ChangeLogPruned() (uint64, error)
+	ChangesSince(uint64, int) ([]db.Change, error)
 }
 
 // The Manager handles backend data and is an equivalent interface to rpc.EntityTree
@@ -36,13 +79,17 @@ type Manager interface {
 	DisableBootstrap()
 
 	CreateEntity(string, int32, string) error
+	CreateEntityInPool(string, string, int32, string) error
 	FetchEntity(string) (*pb.Entity, error)
+	FetchEntityRevision(string) (*pb.Entity, string, error)
 	SearchEntities(db.SearchRequest) ([]*pb.Entity, error)
+	SearchEntityPage(db.SearchRequest) ([]*pb.Entity, string, error)
 	ValidateSecret(string, string) error
 	SetSecret(string, string) error
+	PurgeSecretHistory(string) error
 	LockEntity(string) error
 	UnlockEntity(string) error
-	UpdateEntityMeta(string, *pb.EntityMeta) error
+	UpdateEntityMeta(string, *pb.EntityMeta, string) error
 	EntityKVGet(string, []*pb.KVData) ([]*pb.KVData, error)
 	EntityKVAdd(string, []*pb.KVData) error
 	EntityKVDel(string, []*pb.KVData) error
@@ -52,9 +99,12 @@ type Manager interface {
 	DestroyEntity(string) error
 
 	CreateGroup(string, string, string, int32) error
+	CreateGroupInPool(string, string, string, string, int32) error
 	FetchGroup(string) (*pb.Group, error)
+	FetchGroupRevision(string) (*pb.Group, string, error)
 	SearchGroups(db.SearchRequest) ([]*pb.Group, error)
-	UpdateGroupMeta(string, *pb.Group) error
+	SearchGroupPage(db.SearchRequest) ([]*pb.Group, string, error)
+	UpdateGroupMeta(string, *pb.Group, string) error
 	ManageUntypedGroupMeta(string, string, string, string) ([]string, error)
 	GroupKVGet(string, []*pb.KVData) ([]*pb.KVData, error)
 	GroupKVAdd(string, []*pb.KVData) error
@@ -63,13 +113,30 @@ type Manager interface {
 	DestroyGroup(string) error
 
 	AddEntityToGroup(string, string) error
+	AddEntityToGroupUntil(string, string, time.Time) error
 	RemoveEntityFromGroup(string, string) error
 	ListMembers(string) ([]*pb.Entity, error)
 	GetMemberships(*pb.Entity) []string
+	ExplainMembership(string, string) (mresolver.Explanation, error)
+	EffectiveCapabilities(string, *tree.Proposal) ([]tree.CapabilityGrant, error)
+	EntityScopedCapabilities(string) []tree.ScopedCapability
+	ScopeCovers(string, string) bool
+	GroupInScope(string, string) bool
+	EntityInScope(string, string) bool
 	ModifyGroupRule(string, string, rpc.RuleAction) error
 
 	SetEntityCapability2(string, *pb.Capability) error
 	DropEntityCapability2(string, *pb.Capability) error
 	SetGroupCapability2(string, *pb.Capability) error
 	DropGroupCapability2(string, *pb.Capability) error
+
+	EntityHistory(string) ([]db.HistoricEntity, error)
+	GroupHistory(string) ([]db.HistoricGroup, error)
+	RollbackEntity(string, string, string) error
+	RollbackGroup(string, string, string) error
+
+	RenameEntity(string, string, string) error
+	RenameGroup(string, string, string) error
+
+	QueryAudit(*audit.Query) ([]*audit.Record, error)
 }
@@ -2,11 +2,17 @@ package rpc2
 
 import (
 	"context"
+	"path"
+	"strconv"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/policy"
 	"github.com/netauth/netauth/internal/token"
+	"github.com/netauth/netauth/internal/tree"
 
 	types "github.com/netauth/protocol"
 )
@@ -14,44 +20,20 @@ import (
 type claimsContextKey struct{}
 
 func (s *Server) getCapabilitiesForEntity(id string) []types.Capability {
-	// Get the full fledged entity; we can assert no error here
-	// since the entity was just loaded to perform an
-	// authentication check prior to calling this function.
-	// There's a minimal risk that the entity has vanished, but if
-	// that's the case then this will return empty since the
-	// FetchEntity function will return an empty entity, thus no
-	// authorization attack can be performed via this vector.
-	e, _ := s.FetchEntity(id)
-
-	// First get the capabilities that are provided by the entity
-	// itself.
-	caps := make(map[types.Capability]int)
-	if e.GetMeta() != nil {
-		for _, c := range e.GetMeta().GetCapabilities() {
-			caps[c]++
-		}
-	}
-
-	// Next get the capabilities that are provided by any groups
-	// the entity may be in; include indirects for authentication
-	// queries.  We can assert no error here because the worst
-	// case is a group is returned that is completely empty.  In
-	// this case there will simply be no additional capabilities
-	// granted, and this function can continue without incident.
-	groupNames := s.GetMemberships(e)
-	for _, name := range groupNames {
-		g, _ := s.FetchGroup(name)
-		for _, c := range g.GetCapabilities() {
-			caps[c]++
-		}
-	}
+	// The capabilities are those provided by the entity itself
+	// and by any groups the entity may be in, including
+	// indirects.  We can assert no error here since the entity
+	// was just loaded to perform an authentication check prior
+	// to calling this function.  There's a minimal risk that the
+	// entity has vanished, but if that's the case then this will
+	// return empty, thus no authorization attack can be
+	// performed via this vector.
+	grants, _ := s.EffectiveCapabilities(id, nil)
 
 	// Flatten the capabilities out into a list
-	capabilities := make([]types.Capability, len(caps))
-	i := 0
-	for c := range caps {
-		capabilities[i] = c
-		i++
+	capabilities := make([]types.Capability, len(grants))
+	for i := range grants {
+		capabilities[i] = grants[i].Capability
 	}
 	return capabilities
 }
@@ -90,11 +72,19 @@ func (s *Server) checkToken(ctx context.Context) (context.Context, error) {
 	return ctx, nil
 }
 
-// isAuthorized checks for a specific capability in the claims from
-// the context.  If it is not present, then the client is not
-// sufficientlly empowered by capabilities alone to make the given
-// request, but may be authorized by group membership.
+// isAuthorized checks that the holder of the token in the context
+// may make the request, which requires a specific capability unless
+// the authorization policy decides otherwise.  If it is not
+// authorized, then the client is not sufficientlly empowered by
+// capabilities alone to make the given request, but may be authorized
+// by group membership.
 func (s *Server) isAuthorized(ctx context.Context, reqCap types.Capability) error {
+	return s.isAuthorizedFor(ctx, reqCap, nil)
+}
+
+// hasCapability checks for a specific capability in the claims from
+// the context, without regard to the authorization policy.
+func (s *Server) hasCapability(ctx context.Context, reqCap types.Capability) error {
 	method, ok := grpc.Method(ctx)
 	if !ok {
 		method = "UNKNOWN"
@@ -112,6 +102,132 @@ func (s *Server) isAuthorized(ctx context.Context, reqCap types.Capability) erro
 	return nil
 }
 
+// A target is the entity or group that a request acts on.  A request
+// with a target may be authorized by a capability that is held in a
+// scope which covers the target, as well as by one that is held
+// globally.  A group that is being created is named by parent, the
+// group that will manage it, and a target that names nothing, such as
+// an entity that is being created, is covered by any scope.
+type target struct {
+	entity string
+	group  string
+	parent string
+}
+
+// isAuthorizedFor checks for a capability in the same way as
+// isAuthorized, but if the capability isn't held globally it may also
+// be held in a scope that covers the target.
+func (s *Server) isAuthorizedFor(ctx context.Context, reqCap types.Capability, t *target) error {
+	return s.authorize(ctx, t, s.capable(ctx, reqCap, t))
+}
+
+// capable checks for a capability that is held either globally or in
+// a scope that covers the target.  Scoped capabilities are not
+// carried in the token, and are instead looked up for the holder of
+// the token when they are needed.
+func (s *Server) capable(ctx context.Context, reqCap types.Capability, t *target) error {
+	c := getTokenClaims(ctx)
+	if t != nil && c.EntityID != "" && !c.HasCapability(reqCap) {
+		for _, sc := range s.EntityScopedCapabilities(c.EntityID) {
+			if sc.Capability != reqCap || !s.covers(sc.Scope, t) {
+				continue
+			}
+			s.log.Info("Authorized by scoped capability",
+				"authority", c.EntityID,
+				"capability", sc.String(),
+				"client", getClientName(ctx),
+				"service", getServiceName(ctx),
+			)
+			return nil
+		}
+	}
+	return s.hasCapability(ctx, reqCap)
+}
+
+// authorize is the authorizer that every request which needs
+// authority passes through.  It applies the authorization policy to
+// the decision that the server would otherwise have made, which is
+// err.  A rule that allows the request overrides a missing
+// capability, and a rule that denies it overrides any authority that
+// the requestor holds.  In dry run mode the decision of the policy is
+// logged but err is returned unchanged.
+func (s *Server) authorize(ctx context.Context, t *target, err error) error {
+	if s.policy == nil {
+		return err
+	}
+
+	method, ok := grpc.Method(ctx)
+	if !ok {
+		method = "UNKNOWN"
+	}
+	r := policy.Request{
+		Method: path.Base(method),
+		Caller: getTokenClaims(ctx).EntityID,
+	}
+	if t != nil {
+		r.Entity = t.entity
+		r.Group = t.group
+		if r.Group == "" {
+			r.Group = t.parent
+		}
+	}
+
+	d := s.policy.Decide(r)
+	if d.Effect == "" {
+		return err
+	}
+	var pErr error
+	if d.Effect == policy.Deny {
+		pErr = ErrDeniedByPolicy
+	}
+	s.log.Info("Authorization policy matched",
+		"method", r.Method,
+		"rule", d.Rule,
+		"effect", d.Effect,
+		"authority", r.Caller,
+		"dryrun", s.policy.DryRun(),
+		"changed", (pErr == nil) != (err == nil),
+		"client", getClientName(ctx),
+		"service", getServiceName(ctx),
+	)
+	if s.policy.DryRun() {
+		return err
+	}
+	return pErr
+}
+
+// covers returns true if the scope of a group covers the target.
+func (s *Server) covers(scope string, t *target) bool {
+	switch {
+	case t.group != "":
+		return s.GroupInScope(t.group, scope)
+	case t.entity != "":
+		return s.EntityInScope(t.entity, scope)
+	case t.parent != "":
+		return s.ScopeCovers(t.parent, scope)
+	}
+	return true
+}
+
+// scopeChangeAuthorized checks that a request which would change the
+// scoped capabilities of an entity or group is made with GLOBAL_ROOT.
+// Scoped capabilities grant authority in the same way as capabilities
+// do, so they can't be changed with only the capability to change
+// metadata, and the authorization policy can't allow it either.
+func (s *Server) scopeChangeAuthorized(ctx context.Context, kv ...*types.KVData) error {
+	for _, d := range kv {
+		if d.GetKey() != tree.ScopeKey {
+			continue
+		}
+		ctx, err := s.checkToken(ctx)
+		if err != nil {
+			return err
+		}
+		return s.hasCapability(ctx, types.Capability_GLOBAL_ROOT)
+	}
+	return nil
+}
+
 // getSingleStringFromMetadata is a convenience function that helps to
 // pull individual values from the request metadata.  It asserts that
 // only a single value will be set, and that that value is a string.
@@ -127,6 +243,74 @@ func getSingleStringFromMetadata(ctx context.Context, key string) string {
 	return sl[0]
 }
 
+// getRevision returns the revision that the client expects the
+// resource it is modifying to be at.  If no revision was set the
+// empty string is returned and the modification is unconditional.
+func getRevision(ctx context.Context) string {
+	return getSingleStringFromMetadata(ctx, "revision")
+}
+
+// getNumberPool returns the pool that the client wants the number of
+// a new entity or group allocated from.  If no pool was set the empty
+// string is returned and the default pool is used.
+func getNumberPool(ctx context.Context) string {
+	return getSingleStringFromMetadata(ctx, "number-pool")
+}
+
+// getMembershipExpiry returns the time at which the client wants a
+// new membership to expire.  If no time was set the zero time is
+// returned and the membership doesn't expire.
+func getMembershipExpiry(ctx context.Context) (time.Time, error) {
+	s := getSingleStringFromMetadata(ctx, "until")
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, ErrMalformedRequest
+	}
+	return t, nil
+}
+
+// setRevision returns the revision of a resource to the client in
+// the response headers.  Failing to set the header is not fatal,
+// it just means the client won't be able to make a conditional
+// update.
+func setRevision(ctx context.Context, rev string) {
+	grpc.SetHeader(ctx, metadata.Pairs("revision", rev))
+}
+
+// getSearchRequest builds a search request for the expression with
+// the paging, sorting, and field options that the client sent.  A
+// client that sends none of them gets every result in one response.
+func getSearchRequest(ctx context.Context, expr string) (db.SearchRequest, error) {
+	r := db.SearchRequest{
+		Expression: expr,
+		PageToken:  getSingleStringFromMetadata(ctx, "page-token"),
+	}
+	if s := getSingleStringFromMetadata(ctx, "page-size"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return r, ErrMalformedRequest
+		}
+		r.PageSize = n
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	r.Sort = md.Get("sort")
+	r.Fields = md.Get("fields")
+	return r, nil
+}
+
+// setNextPageToken returns the token for the next page of search
+// results to the client in the response headers.  Nothing is sent
+// after the last page.
+func setNextPageToken(ctx context.Context, token string) {
+	if token == "" {
+		return
+	}
+	grpc.SetHeader(ctx, metadata.Pairs("next-page-token", token))
+}
+
 // getClientName returns the client name.  If no name was set, the
 // string "BOGUS_CLIENT" is returned.
 func getClientName(ctx context.Context) string {
@@ -191,25 +375,30 @@ func (s *Server) manageByMembership(entityID string, g *types.Group) bool {
 
 // mutablePrequisitesAreMet checks for common mutable prerequisites
 // such as the server being in a writeable mode, and the correct
-// capability being present in a valid token.
-func (s *Server) mutablePrequisitesMet(ctx context.Context, c types.Capability) error {
+// capability being present in a valid token.  The returned context
+// carries the claims from the token for the rest of the request.
+func (s *Server) mutablePrequisitesMet(ctx context.Context, c types.Capability) (context.Context, error) {
+	return s.mutablePrequisitesMetFor(ctx, c, nil)
+}
+
+// mutablePrequisitesMetFor checks the same prerequisites as
+// mutablePrequisitesMet, but the capability may also be held in a
+// scope that covers the target.
+func (s *Server) mutablePrequisitesMetFor(ctx context.Context, c types.Capability, t *target) (context.Context, error) {
 	if s.readonly {
 		s.log.Warn("Mutable request in read-only mode!",
 			"method", "EntityUM",
 			"client", getClientName(ctx),
 			"service", getServiceName(ctx),
 		)
-		return ErrReadOnly
+		return ctx, ErrReadOnly
 	}
 
 	// Token validation and authorization
 	var err error
 	ctx, err = s.checkToken(ctx)
 	if err != nil {
-		return err
-	}
-	if err := s.isAuthorized(ctx, c); err != nil {
-		return err
+		return ctx, err
 	}
-	return nil
+	return ctx, s.isAuthorizedFor(ctx, c, t)
 }
@@ -203,6 +203,10 @@ func TestMutablePrequisitesMet(t *testing.T) {
 		initTree(t, s.Manager)
 		s.readonly = c.ro
 
-		assert.Equalf(t, c.wantErr, s.mutablePrequisitesMet(c.ctx, c.cap), "Test Number %d", i)
+		ctx, err := s.mutablePrequisitesMet(c.ctx, c.cap)
+		assert.Equalf(t, c.wantErr, err, "Test Number %d", i)
+		if err == nil && getTokenClaims(ctx).EntityID == "" {
+			t.Errorf("%d: Claims were not returned in the context", i)
+		}
 	}
 }
@@ -0,0 +1,226 @@
+package rpc2
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/pkg/watch"
+)
+
+const (
+	// watchBatch is the number of changes that are read from the
+	// change log at a time.
+	watchBatch = 256
+
+	// watchPoll is how often a waiting watch checks the change
+	// log without having been woken.  Changes that were not made
+	// in a transaction are recorded just after their event is
+	// fired, and changes made by other servers sharing the store
+	// may fire no event here at all.
+	watchPoll = time.Second
+)
+
+var (
+	// ErrCursorExpired is returned when a watch is resumed from a
+	// cursor that is no longer retained by the server, because
+	// the changes after it have been pruned from the change log.
+	// The client must refetch anything it is interested in and
+	// watch again without a cursor.
+	ErrCursorExpired = status.Errorf(codes.OutOfRange, "The cursor has expired, refetch and watch again without a cursor")
+
+	// ErrShuttingDown is returned to open watches when the server
+	// is shutting down.
+	ErrShuttingDown = status.Errorf(codes.Unavailable, "The server is shutting down")
+)
+
+// notifier wakes waiting watches when the database changes.  Watches
+// read what changed from the change log, so the notifier only needs
+// to say that something did.
+type notifier struct {
+	sync.Mutex
+
+	// changed is closed and replaced each time the database
+	// changes, which wakes every waiting watch.
+	changed chan struct{}
+	closed  bool
+}
+
+func newNotifier() *notifier {
+	return &notifier{changed: make(chan struct{})}
+}
+
+// notify wakes any waiting watches.
+func (n *notifier) notify() {
+	n.Lock()
+	defer n.Unlock()
+	if n.closed {
+		return
+	}
+	close(n.changed)
+	n.changed = make(chan struct{})
+}
+
+// close wakes all waiting watches and prevents them from waiting
+// again.
+func (n *notifier) close() {
+	n.Lock()
+	defer n.Unlock()
+	if n.closed {
+		return
+	}
+	n.closed = true
+	close(n.changed)
+}
+
+// wait returns a channel that will be closed the next time the
+// database changes.
+func (n *notifier) wait() (<-chan struct{}, error) {
+	n.Lock()
+	defer n.Unlock()
+	if n.closed {
+		return nil, ErrShuttingDown
+	}
+	return n.changed, nil
+}
+
+// cursor returns the cursor for a change log sequence number.
+func cursor(seq uint64) string {
+	return strconv.FormatUint(seq, 10)
+}
+
+// parseCursor returns the change log sequence number within a cursor.
+// An empty cursor refers to the most recent change.  A cursor beyond
+// the most recent change can't have come from this change log.
+func (s *Server) parseCursor(c string) (uint64, error) {
+	head, err := s.changes.ChangeLogHead()
+	if err != nil {
+		return 0, ErrInternal
+	}
+	if c == "" {
+		return head, nil
+	}
+	seq, err := strconv.ParseUint(c, 10, 64)
+	if err != nil || seq > head {
+		return 0, ErrCursorExpired
+	}
+	return seq, nil
+}
+
+// changesSince returns the watch events for the next batch of changes
+// after seq, the sequence number of the last change read, and whether
+// the batch was full.
+func (s *Server) changesSince(seq uint64) ([]*watch.Event, uint64, bool, error) {
+	changes, err := s.changes.ChangesSince(seq, watchBatch)
+	if err != nil {
+		return nil, seq, false, ErrInternal
+	}
+
+	// The watermark is checked after reading, since changes may
+	// have been pruned while they were being read.
+	pruned, err := s.changes.ChangeLogPruned()
+	if err != nil {
+		return nil, seq, false, ErrInternal
+	}
+	if seq < pruned {
+		return nil, seq, false, ErrCursorExpired
+	}
+
+	out := make([]*watch.Event, 0, len(changes))
+	for _, c := range changes {
+		seq = c.Seq
+		t, ok := eventTypes[c.Type]
+		if !ok {
+			continue
+		}
+		out = append(out, &watch.Event{Cursor: cursor(c.Seq), Type: t, ID: c.PK})
+	}
+	return out, seq, len(changes) == watchBatch, nil
+}
+
+// eventTypes maps the internal database events to those that are
+// sent to watches.
+var eventTypes = map[db.EventType]watch.EventType{
+	db.EventEntityCreate:  watch.EntityCreate,
+	db.EventEntityUpdate:  watch.EntityUpdate,
+	db.EventEntityDestroy: watch.EntityDestroy,
+	db.EventGroupCreate:   watch.GroupCreate,
+	db.EventGroupUpdate:   watch.GroupUpdate,
+	db.EventGroupDestroy:  watch.GroupDestroy,
+}
+
+// Notify wakes watches when the database changes.  It must be
+// registered as a database callback.
+func (s *Server) Notify(e db.Event) {
+	if _, ok := eventTypes[e.Type]; !ok {
+		return
+	}
+	s.events.notify()
+}
+
+// Shutdown ends all open watches.  This must be called before the
+// gRPC server is stopped gracefully, since otherwise the watches
+// would never finish.
+func (s *Server) Shutdown() {
+	s.events.close()
+}
+
+// Watch streams changes to entities and groups until the client goes
+// away.  Watches do not require authentication, since they only
+// reveal what has changed and not how.  Cursors are sequence numbers
+// in the change log, so a watch may be resumed after the server
+// restarts for as long as the change log retains the changes after
+// its cursor.
+func (s *Server) Watch(r *watch.Request, stream watch.ServerStream) error {
+	ctx := stream.Context()
+
+	seq, err := s.parseCursor(r.Cursor)
+	if err != nil {
+		s.log.Debug("Watch requested with expired cursor",
+			"cursor", r.Cursor,
+			"client", getClientName(ctx),
+			"service", getServiceName(ctx),
+		)
+		return err
+	}
+	s.log.Debug("Watch opened",
+		"cursor", r.Cursor,
+		"client", getClientName(ctx),
+		"service", getServiceName(ctx),
+	)
+
+	for {
+		// The channel is taken before reading so that a change
+		// recorded during the read still wakes the watch.
+		changed, err := s.events.wait()
+		if err != nil {
+			return err
+		}
+		events, next, more, err := s.changesSince(seq)
+		if err != nil {
+			return err
+		}
+		seq = next
+		for _, e := range events {
+			if r.Matches(e) {
+				if err := stream.Send(e); err != nil {
+					return err
+				}
+			}
+		}
+		if more {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changed:
+		case <-time.After(watchPoll):
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package rpc2
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/pkg/watch"
+)
+
+type testWatchStream struct {
+	ctx    context.Context
+	events chan *watch.Event
+}
+
+func newTestWatchStream(ctx context.Context) *testWatchStream {
+	return &testWatchStream{ctx: ctx, events: make(chan *watch.Event, 16)}
+}
+
+func (s *testWatchStream) Send(e *watch.Event) error {
+	s.events <- e
+	return nil
+}
+
+func (s *testWatchStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *testWatchStream) next(t *testing.T) *watch.Event {
+	select {
+	case e := <-s.events:
+		return e
+	case <-time.After(3 * watchPoll):
+		t.Fatal("No event received")
+		return nil
+	}
+}
+
+func TestWatch(t *testing.T) {
+	s, tdb, m := newServerWithRefs(t)
+	d := tdb.(*db.DB)
+	d.RegisterCallback("watch", s.Notify)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := newTestWatchStream(ctx)
+	done := make(chan error)
+	go func() {
+		done <- s.Watch(&watch.Request{Types: []watch.EventType{watch.EntityUpdate}}, stream)
+	}()
+
+	// Wait for the watch to be waiting for events.
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Nil(t, m.CreateGroup("group1", "", "", -1))
+	assert.Nil(t, m.CreateEntity("entity1", -1, ""))
+	e := stream.next(t)
+	assert.Equal(t, watch.EntityUpdate, e.Type)
+	assert.Equal(t, "entity1", e.ID)
+
+	cancel()
+	assert.Nil(t, <-done)
+
+	// Resuming from the cursor returns changes that happened
+	// while the watch was closed, even from a server that has
+	// restarted since the cursor was issued.
+	assert.Nil(t, m.DestroyEntity("entity1"))
+	assert.Nil(t, m.CreateEntity("entity2", -1, ""))
+	s = New(Refs{TokenService: s.Service, Tree: m, ChangeLog: d}, hclog.NewNullLogger())
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	stream = newTestWatchStream(ctx)
+	go func() {
+		done <- s.Watch(&watch.Request{Cursor: e.Cursor, IDs: []string{"entity1"}}, stream)
+	}()
+	e = stream.next(t)
+	assert.Equal(t, watch.EntityDestroy, e.Type)
+
+	s.Shutdown()
+	assert.Equal(t, ErrShuttingDown, <-done)
+}
+
+func TestWatchCursorExpired(t *testing.T) {
+	s, tdb, m := newServerWithRefs(t)
+	d := tdb.(*db.DB)
+
+	stream := newTestWatchStream(context.Background())
+	for _, c := range []string{"bogus", "1-1", "5"} {
+		assert.Equal(t, ErrCursorExpired, s.Watch(&watch.Request{Cursor: c}, stream))
+	}
+
+	for _, ID := range []string{"entity1", "entity2", "entity3"} {
+		assert.Nil(t, m.CreateEntity(ID, -1, ""))
+	}
+	d.SetChangeLogRetention(0, 1)
+	assert.Nil(t, d.PruneChangeLog())
+	assert.Equal(t, ErrCursorExpired, s.Watch(&watch.Request{Cursor: "1"}, stream))
+
+	// The change after a cursor that was current when the log was
+	// pruned is still retained.
+	events, seq, more, err := s.changesSince(2)
+	assert.Nil(t, err)
+	assert.False(t, more)
+	assert.Equal(t, uint64(3), seq)
+	assert.Len(t, events, 1)
+	assert.Equal(t, "3", events[0].Cursor)
+}
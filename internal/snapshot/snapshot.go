@@ -0,0 +1,152 @@
+// Package snapshot takes periodic archives of a running server's
+// database.  Each archive is written to its own file in a directory,
+// and only the most recent few are kept.
+package snapshot
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/viper"
+
+	"github.com/netauth/netauth/internal/db"
+)
+
+const (
+	filePrefix = "netauth-"
+	fileSuffix = ".snap.gz"
+)
+
+// Snapshotter is the subset of the database that is needed to take a
+// snapshot.
+type Snapshotter interface {
+	Snapshot() (*db.Archive, error)
+}
+
+// Scheduler writes a snapshot of a database every interval.
+type Scheduler struct {
+	db  Snapshotter
+	log hclog.Logger
+
+	dir      string
+	interval time.Duration
+	keep     int
+}
+
+// New returns a Scheduler for the provided database.  Snapshots are
+// written every db.snapshot.interval to db.snapshot.dir, which
+// defaults to the snapshots directory in core.home, and the newest
+// db.snapshot.keep are kept.
+func New(d Snapshotter, l hclog.Logger) *Scheduler {
+	dir := viper.GetString("db.snapshot.dir")
+	if dir == "" {
+		dir = filepath.Join(viper.GetString("core.home"), "snapshots")
+	}
+	return &Scheduler{
+		db:       d,
+		log:      l.Named("snapshot"),
+		dir:      dir,
+		interval: viper.GetDuration("db.snapshot.interval"),
+		keep:     viper.GetInt("db.snapshot.keep"),
+	}
+}
+
+// Run takes snapshots until the context is cancelled.  Failures are
+// logged and the next snapshot is attempted at the usual time.
+func (s *Scheduler) Run(ctx context.Context) {
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if _, err := s.Take(); err != nil {
+			s.log.Error("Error taking snapshot", "error", err)
+		}
+	}
+}
+
+// Take writes a snapshot immediately and prunes old ones, returning
+// the path that the snapshot was written to.
+func (s *Scheduler) Take() (string, error) {
+	a, err := s.db.Snapshot()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(s.dir, 0750); err != nil {
+		return "", err
+	}
+
+	// The snapshot is written under a temporary name and moved
+	// into place so that a partial file is never mistaken for a
+	// complete one.
+	name := filepath.Join(s.dir, filePrefix+a.Meta.Time.UTC().Format("20060102T150405.000000000Z")+fileSuffix)
+	f, err := ioutil.TempFile(s.dir, ".tmp-")
+	if err != nil {
+		return "", err
+	}
+	if _, err := a.WriteTo(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	s.log.Info("Snapshot written", "file", name, "entities", a.Meta.Entities, "groups", a.Meta.Groups, "seq", a.Meta.Seq)
+
+	s.prune()
+	return name, nil
+}
+
+// List returns the snapshots in the directory, oldest first.
+func (s *Scheduler) List() ([]string, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	out := []string{}
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), filePrefix) && strings.HasSuffix(f.Name(), fileSuffix) {
+			out = append(out, filepath.Join(s.dir, f.Name()))
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// prune removes all but the newest snapshots.  A keep of zero or less
+// keeps every snapshot.
+func (s *Scheduler) prune() {
+	if s.keep <= 0 {
+		return
+	}
+	files, err := s.List()
+	if err != nil {
+		s.log.Warn("Error listing snapshots", "error", err)
+		return
+	}
+	if len(files) <= s.keep {
+		return
+	}
+	for _, f := range files[:len(files)-s.keep] {
+		if err := os.Remove(f); err != nil {
+			s.log.Warn("Error removing old snapshot", "file", f, "error", err)
+			continue
+		}
+		s.log.Debug("Removed old snapshot", "file", f)
+	}
+}
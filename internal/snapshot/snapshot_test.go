@@ -0,0 +1,65 @@
+package snapshot
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/netauth/netauth/internal/db"
+	_ "github.com/netauth/netauth/internal/db/memory"
+	"github.com/netauth/netauth/internal/startup"
+
+	types "github.com/netauth/protocol"
+)
+
+func TestTake(t *testing.T) {
+	startup.DoCallbacks()
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mdb, err := db.New("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.SaveEntity(&types.Entity{ID: proto.String("entity1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Scheduler{db: mdb, log: hclog.NewNullLogger(), dir: dir, keep: 2}
+	names := []string{}
+	for i := 0; i < 3; i++ {
+		name, err := s.Take()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+
+	files, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0] != names[1] || files[1] != names[2] {
+		t.Fatalf("Got %v; Want %v", files, names[1:])
+	}
+
+	f, err := os.Open(files[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	a, err := db.ReadArchive(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Meta.Entities != 1 || a.Meta.Backend != "memory" {
+		t.Errorf("Bad archive: %+v", a.Meta)
+	}
+}
@@ -0,0 +1,142 @@
+package tree
+
+import (
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/auditlog"
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/pkg/audit"
+
+	pb "github.com/netauth/protocol"
+)
+
+// SetAuditLog configures the log that records every change made by a
+// chain.  Changes are only recorded once this has been called.
+func (m *Manager) SetAuditLog(l *auditlog.Log) {
+	m.auditLog = l
+}
+
+// WithActor returns a Manager which attributes the changes that it
+// makes to the provided actor.  The returned Manager shares all state
+// with the original, and is intended to be used for a single
+// request.
+func (m *Manager) WithActor(a audit.Actor) *Manager {
+	x := *m
+	x.actor = &a
+	return &x
+}
+
+// QueryAudit returns the audit records that match the query.
+func (m *Manager) QueryAudit(q *audit.Query) ([]*audit.Record, error) {
+	if m.auditLog == nil {
+		return nil, auditlog.ErrNotQueryable
+	}
+	return m.auditLog.Query(q)
+}
+
+// auditing returns true if chains should capture their changes.
+func (m *Manager) auditing() bool {
+	return m.auditLog != nil && m.db != nil
+}
+
+// auditKey identifies an object changed by a transaction.
+type auditKey struct {
+	kind string
+	id   string
+}
+
+// auditTarget returns the object that a change applies to, and
+// whether the change removed it.
+func auditTarget(c db.Change) (auditKey, bool) {
+	switch c.Type {
+	case db.EventEntityDestroy:
+		return auditKey{audit.KindEntity, c.PK}, true
+	case db.EventGroupUpdate:
+		return auditKey{audit.KindGroup, c.PK}, false
+	case db.EventGroupDestroy:
+		return auditKey{audit.KindGroup, c.PK}, true
+	default:
+		return auditKey{audit.KindEntity, c.PK}, false
+	}
+}
+
+// auditLoad loads an object for comparison, returning nil if it does
+// not exist.
+func (m *Manager) auditLoad(k auditKey) proto.Message {
+	if k.kind == audit.KindGroup {
+		g, err := m.db.LoadGroup(k.id)
+		if err != nil {
+			return nil
+		}
+		return g
+	}
+	e, err := m.db.LoadEntity(k.id)
+	if err != nil {
+		return nil
+	}
+	return e
+}
+
+// auditDecode decodes the value that a change committed, returning
+// nil if it can't be decoded.
+func (m *Manager) auditDecode(k auditKey, b []byte) proto.Message {
+	var msg proto.Message = &pb.Entity{}
+	if k.kind == audit.KindGroup {
+		msg = &pb.Group{}
+	}
+	if err := proto.Unmarshal(b, msg); err != nil {
+		m.log.Warn("Error decoding change for audit", "kind", k.kind, "id", k.id, "error", err)
+		return nil
+	}
+	return msg
+}
+
+// auditBefore loads every object that the transaction is about to
+// change, so that they can be compared once it has committed.  This
+// must be called before the transaction is committed.
+func (m *Manager) auditBefore(txn *db.Txn) map[auditKey]proto.Message {
+	before := make(map[auditKey]proto.Message)
+	for _, c := range txn.Changes() {
+		k, _ := auditTarget(c)
+		before[k] = m.auditLoad(k)
+	}
+	return before
+}
+
+// recordChanges writes an audit record for each object that a chain
+// changed, which includes those changed as a side effect of the
+// chain as well as its target.  The new value of each object is
+// taken from what the transaction committed, since the object may
+// have been changed again by the time it could be loaded.
+func (m *Manager) recordChanges(chain string, txn *db.Txn, before map[auditKey]proto.Message) {
+	for _, c := range txn.Changes() {
+		k, removed := auditTarget(c)
+		var after proto.Message
+		if !removed {
+			after = m.auditDecode(k, c.Value())
+		}
+		m.recordChange(k.kind, chain, k.id, before[k], after)
+	}
+}
+
+// recordChange writes an audit record for a chain that has
+// committed, provided the chain actually changed something.
+func (m *Manager) recordChange(kind, chain, target string, before, after proto.Message) {
+	changes := auditlog.Diff(before, after)
+	if len(changes) == 0 {
+		return
+	}
+	r := &audit.Record{
+		Time:    time.Now(),
+		Chain:   chain,
+		Kind:    kind,
+		Target:  target,
+		Changes: changes,
+	}
+	if m.actor != nil {
+		r.Actor = *m.actor
+	}
+	m.auditLog.Record(r)
+}
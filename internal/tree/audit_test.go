@@ -0,0 +1,63 @@
+package tree
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/netauth/netauth/internal/auditlog"
+	_ "github.com/netauth/netauth/internal/auditlog/kv"
+	"github.com/netauth/netauth/internal/db"
+	_ "github.com/netauth/netauth/internal/db/memory"
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/pkg/audit"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestAuditRecordsCommittedValue(t *testing.T) {
+	startup.DoCallbacks()
+
+	mdb, err := db.New("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := auditlog.New([]string{"kv"}, auditlog.Refs{DB: mdb}, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := Manager{db: mdb, auditLog: l, log: hclog.NewNullLogger()}
+
+	txn := mdb.Begin()
+	if err := txn.SaveEntity(&pb.Entity{ID: proto.String("entity1"), Number: proto.Int32(1)}); err != nil {
+		t.Fatal(err)
+	}
+	before := m.auditBefore(txn)
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A write that lands before the record is made doesn't leak
+	// into it.
+	if err := mdb.SaveEntity(&pb.Entity{ID: proto.String("entity1"), Number: proto.Int32(2)}); err != nil {
+		t.Fatal(err)
+	}
+	m.recordChanges("TEST", txn, before)
+
+	res, err := m.QueryAudit(&audit.Query{Target: "entity1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := audit.FieldChange{Field: "Number", New: "1"}
+	for _, r := range res {
+		for _, c := range r.Changes {
+			if c.Field == want.Field && c != want {
+				t.Errorf("Got %v; Want %v", c, want)
+			}
+		}
+	}
+	if len(res) != 1 {
+		t.Errorf("Got %d records; Want 1", len(res))
+	}
+}
@@ -0,0 +1,127 @@
+package tree
+
+import (
+	"sort"
+
+	"github.com/netauth/netauth/internal/mresolver"
+
+	pb "github.com/netauth/protocol"
+)
+
+// A CapabilityGrant is a capability that an entity holds, and where
+// it holds it from.  Direct is set if the capability is held by the
+// entity itself, and Groups names the groups that grant it.
+type CapabilityGrant struct {
+	Capability pb.Capability
+	Direct     bool
+	Groups     []string
+}
+
+// A Proposal is a set of changes that EffectiveCapabilities evaluates
+// without applying them.  Membership holds the changes to the
+// memberships of the entity and to the expansions of groups, and
+// Capabilities the changes to capabilities.
+type Proposal struct {
+	Membership   mresolver.Hypothesis
+	Capabilities []CapabilityChange
+}
+
+// A CapabilityChange adds or drops a capability.  The capability
+// belongs to Group, or to the entity itself if Group is empty.
+type CapabilityChange struct {
+	Group      string
+	Capability pb.Capability
+	Drop       bool
+}
+
+// EffectiveCapabilities returns the capabilities that an entity
+// holds, either directly or through the groups that it is a member
+// of.  If p is not nil the capabilities are those that the entity
+// would hold if the proposed changes were made, but nothing is
+// changed.  Grants are sorted by the name of the capability.
+func (m *Manager) EffectiveCapabilities(entityID string, p *Proposal) ([]CapabilityGrant, error) {
+	e, err := m.db.LoadEntity(entityID)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		p = &Proposal{}
+	}
+	if err := m.checkProposal(p); err != nil {
+		return nil, err
+	}
+
+	direct := capabilitySet(e.GetMeta().GetCapabilities())
+	for _, c := range p.Capabilities {
+		if c.Group == "" {
+			direct[c.Capability] = !c.Drop
+		}
+	}
+
+	grants := make(map[pb.Capability]*CapabilityGrant)
+	grant := func(c pb.Capability) *CapabilityGrant {
+		if grants[c] == nil {
+			grants[c] = &CapabilityGrant{Capability: c}
+		}
+		return grants[c]
+	}
+	for c, held := range direct {
+		if held {
+			grant(c).Direct = true
+		}
+	}
+
+	for _, name := range m.resolver.GroupsForEntityIf(entityID, p.Membership) {
+		held := make(map[pb.Capability]bool)
+		if g, err := m.db.LoadGroup(name); err == nil {
+			held = capabilitySet(g.GetCapabilities())
+		}
+		for _, c := range p.Capabilities {
+			if c.Group == name {
+				held[c.Capability] = !c.Drop
+			}
+		}
+		for c, ok := range held {
+			if ok {
+				grant(c).Groups = append(grant(c).Groups, name)
+			}
+		}
+	}
+
+	out := make([]CapabilityGrant, 0, len(grants))
+	for _, g := range grants {
+		out = append(out, *g)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Capability.String() < out[j].Capability.String() })
+	return out, nil
+}
+
+// checkProposal checks that the groups named by a proposal exist.
+func (m *Manager) checkProposal(p *Proposal) error {
+	var names []string
+	names = append(names, p.Membership.Join...)
+	names = append(names, p.Membership.Leave...)
+	for _, x := range p.Membership.Expansions {
+		names = append(names, x.Parent, x.Child)
+	}
+	for _, c := range p.Capabilities {
+		if c.Group != "" {
+			names = append(names, c.Group)
+		}
+	}
+	for _, name := range names {
+		if _, err := m.db.LoadGroup(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// capabilitySet returns the capabilities in a list as a set.
+func capabilitySet(caps []pb.Capability) map[pb.Capability]bool {
+	set := make(map[pb.Capability]bool, len(caps))
+	for _, c := range caps {
+		set[c] = true
+	}
+	return set
+}
@@ -6,11 +6,14 @@ var (
 			"fail-on-existing-entity",
 			"set-entity-id",
 			"set-entity-number",
+			"check-entity-secret-if-set",
 			"set-entity-secret",
 			"save-entity",
 		},
 		"DESTROY": {
 			"load-entity",
+			"release-entity-number",
+			"purge-secret-history",
 			"destroy-entity",
 		},
 		"FETCH": {
@@ -26,6 +29,8 @@ var (
 		},
 		"SET-SECRET": {
 			"load-entity",
+			"check-entity-secret",
+			"check-secret-history",
 			"set-entity-secret",
 			"save-entity",
 		},
@@ -62,6 +67,7 @@ var (
 		"MERGE-METADATA": {
 			"load-entity",
 			"ensure-entity-meta",
+			"check-scope",
 			"merge-entity-meta",
 			"save-entity",
 		},
@@ -98,18 +104,23 @@ var (
 		"KV-ADD": {
 			"load-entity",
 			"ensure-entity-meta",
+			"check-reserved-kv",
+			"check-scope",
 			"kv-add",
 			"save-entity",
 		},
 		"KV-DEL": {
 			"load-entity",
 			"ensure-entity-meta",
+			"check-reserved-kv",
 			"kv-del",
 			"save-entity",
 		},
 		"KV-REPLACE": {
 			"load-entity",
 			"ensure-entity-meta",
+			"check-reserved-kv",
+			"check-scope",
 			"kv-replace",
 			"save-entity",
 		},
@@ -125,6 +136,17 @@ var (
 			"del-direct-group",
 			"save-entity",
 		},
+		"ROLLBACK": {
+			"load-entity",
+			"rollback-entity",
+			"save-entity",
+		},
+		"RENAME": {
+			"load-entity",
+			"rename-entity",
+			"rename-secret-history",
+			"save-entity",
+		},
 	}
 
 	defaultGroupChains = map[string][]string{
@@ -138,6 +160,10 @@ var (
 		},
 		"DESTROY": {
 			"load-group",
+			"remove-group-memberships",
+			"prune-group-expansions",
+			"clear-managed-by",
+			"release-group-number",
 			"destroy-group",
 		},
 		"FETCH": {
@@ -145,6 +171,7 @@ var (
 		},
 		"MERGE-METADATA": {
 			"load-group",
+			"check-scope",
 			"merge-group-meta",
 			"save-group",
 		},
@@ -183,18 +210,74 @@ var (
 		},
 		"KV-ADD": {
 			"load-group",
+			"check-reserved-kv",
+			"check-group-rule",
+			"check-scope",
 			"kv-add",
 			"save-group",
 		},
 		"KV-DEL": {
 			"load-group",
+			"check-reserved-kv",
 			"kv-del",
 			"save-group",
 		},
 		"KV-REPLACE": {
 			"load-group",
+			"check-reserved-kv",
+			"check-group-rule",
+			"check-scope",
 			"kv-replace",
 			"save-group",
 		},
+		"ROLLBACK": {
+			"load-group",
+			"check-expansion-cycles",
+			"check-expansion-targets",
+			"rollback-group",
+			"save-group",
+		},
+		"RENAME": {
+			"load-group",
+			"rename-group-references",
+			"rename-group",
+			"save-group",
+		},
+	}
+
+	// The DESTROY chains for each way of handling references to
+	// the object being destroyed.  The cascade chains are the
+	// same as the defaults above.
+	destroyEntityChains = map[string][]string{
+		DestroyCascade: {
+			"load-entity",
+			"release-entity-number",
+			"purge-secret-history",
+			"destroy-entity",
+		},
+		DestroyRestrict: {
+			"load-entity",
+			"check-entity-references",
+			"release-entity-number",
+			"purge-secret-history",
+			"destroy-entity",
+		},
+	}
+
+	destroyGroupChains = map[string][]string{
+		DestroyCascade: {
+			"load-group",
+			"remove-group-memberships",
+			"prune-group-expansions",
+			"clear-managed-by",
+			"release-group-number",
+			"destroy-group",
+		},
+		DestroyRestrict: {
+			"load-group",
+			"check-group-references",
+			"release-group-number",
+			"destroy-group",
+		},
 	}
 )
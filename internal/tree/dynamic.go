@@ -0,0 +1,97 @@
+package tree
+
+import (
+	"path"
+	"sync"
+
+	"github.com/netauth/netauth/internal/db"
+
+	pb "github.com/netauth/protocol"
+)
+
+// GroupRuleKey is the key of the KV data that holds the rule of a
+// dynamic group.  The members of a dynamic group are the entities
+// that match its rule, in addition to any direct members, and are
+// kept up to date as entities change.  The rule is set and cleared
+// through the group KV chains, which check that it can be parsed.
+const GroupRuleKey = "netauth.rule"
+
+// ruleSet holds the parsed rules of the dynamic groups by name.
+type ruleSet struct {
+	sync.RWMutex
+
+	rules map[string]*db.Rule
+}
+
+func newRuleSet() *ruleSet {
+	return &ruleSet{rules: make(map[string]*db.Rule)}
+}
+
+// GroupRule returns the rule of a dynamic group, or an empty string
+// if the group is not dynamic.
+func GroupRule(g *pb.Group) string {
+	for _, kv := range g.GetKV() {
+		if kv.GetKey() == GroupRuleKey && len(kv.GetValues()) > 0 {
+			return kv.GetValues()[0].GetValue()
+		}
+	}
+	return ""
+}
+
+// syncGroupRule updates the rule that is held for a group.  If the
+// rule has changed it is evaluated again for every entity.
+func (m *Manager) syncGroupRule(group, expr string) {
+	var rule *db.Rule
+	if expr != "" {
+		r, err := db.ParseRule(expr)
+		if err != nil {
+			m.log.Warn("Dynamic group has a bad rule and will have no dynamic members", "group", group, "rule", expr, "error", err)
+		}
+		rule = r
+	}
+
+	m.rules.Lock()
+	old := m.rules.rules[group]
+	if rule == nil {
+		delete(m.rules.rules, group)
+	} else {
+		m.rules.rules[group] = rule
+	}
+	m.rules.Unlock()
+
+	if old == nil && rule == nil {
+		return
+	}
+	if old != nil && rule != nil && old.String() == rule.String() {
+		return
+	}
+
+	m.log.Debug("Dynamic group rule changed", "group", group, "rule", expr)
+	ids, err := m.db.DiscoverEntityIDs()
+	if err != nil {
+		m.log.Warn("Unable to evaluate dynamic group rule", "group", group, "error", err)
+		return
+	}
+	for _, id := range ids {
+		e, err := m.db.LoadEntity(path.Base(id))
+		if err != nil {
+			m.log.Warn("Unchecked load error in syncGroupRule", "error", err)
+			continue
+		}
+		m.syncDynamicGroups(e)
+	}
+}
+
+// syncDynamicGroups evaluates the rules of all dynamic groups for an
+// entity and updates the resolver with the groups that match.
+func (m *Manager) syncDynamicGroups(e *pb.Entity) {
+	var groups []string
+	m.rules.RLock()
+	for g, r := range m.rules.rules {
+		if r.Matches(e) {
+			groups = append(groups, g)
+		}
+	}
+	m.rules.RUnlock()
+	m.resolver.SyncDynamicGroups(e.GetID(), groups)
+}
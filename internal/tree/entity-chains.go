@@ -3,6 +3,10 @@ package tree
 import (
 	"sort"
 
+	"github.com/golang/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/db"
+
 	pb "github.com/netauth/protocol"
 )
 
@@ -17,13 +21,17 @@ type EntityHookConstructor func(RefContext) (EntityHook, error)
 type EntityHook interface {
 	Priority() int
 	Name() string
-	Run(*pb.Entity, *pb.Entity) error
+	Run(Txn, *pb.Entity, *pb.Entity) error
 }
 
 var (
 	eHookConstructors map[string]EntityHookConstructor
 )
 
+// maxChainAttempts is the number of times that a chain is run before
+// a conflict with other chains is returned to the caller.
+const maxChainAttempts = 3
+
 func init() {
 	eHookConstructors = make(map[string]EntityHookConstructor)
 }
@@ -108,14 +116,64 @@ func (m *Manager) CheckRequiredEntityChains() error {
 // RunEntityChain runs the specified chain with de specifying values
 // to be consumed by the chain.
 func (m *Manager) RunEntityChain(chain string, de *pb.Entity) (*pb.Entity, error) {
+	return m.runEntityChain(chain, de, "")
+}
+
+// runEntityChain runs the chain, and if rev is not empty the entity
+// identified by de must be at that revision when it is loaded.
+// Chains run concurrently, so one may lose the race to commit to
+// another; unless it is held to a revision it is then run again
+// against the values that won.
+func (m *Manager) runEntityChain(chain string, de *pb.Entity, rev string) (*pb.Entity, error) {
+	for i := 1; ; i++ {
+		e, err := m.tryEntityChain(chain, de, rev)
+		if err != db.ErrConflict || rev != "" || i == maxChainAttempts {
+			return e, err
+		}
+		m.log.Debug("Retrying chain after conflict", "chain", chain, "attempt", i)
+	}
+}
+
+func (m *Manager) tryEntityChain(chain string, de *pb.Entity, rev string) (*pb.Entity, error) {
 	e := new(pb.Entity)
 	hookChain := m.entityProcesses[chain]
+
+	// All writes made by the chain are held in a transaction so
+	// that a chain which saves more than one object either lands
+	// all of its changes or none of them.  The transaction is
+	// handed to each hook, and nothing it holds is visible to
+	// anybody else until it is committed.
+	var txn *db.Txn
+	var tx Txn
+	if m.db != nil {
+		txn = m.db.Begin()
+		tx = txn
+		if rev != "" {
+			txn.ExpectEntityRevision(de.GetID(), rev)
+		}
+	}
 	for _, h := range hookChain {
 		m.log.Trace("Executing entity hook", "chain", chain, "hook", h.Name())
-		if err := h.Run(e, de); err != nil {
+		if err := h.Run(tx, e, de); err != nil {
 			m.log.Trace("Error during chain execution", "chain", chain, "hook", h.Name(), "error", err)
+			if txn != nil {
+				txn.Abort()
+			}
 			return nil, err
 		}
 	}
+	if txn != nil {
+		var before map[auditKey]proto.Message
+		if m.auditing() {
+			before = m.auditBefore(txn)
+		}
+		if err := txn.Commit(); err != nil {
+			m.log.Warn("Error committing chain", "chain", chain, "error", err)
+			return nil, err
+		}
+		if m.auditing() {
+			m.recordChanges(chain, txn, before)
+		}
+	}
 	return e, nil
 }
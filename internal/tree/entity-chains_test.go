@@ -4,8 +4,13 @@ import (
 	"errors"
 	"testing"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/hashicorp/go-hclog"
 
+	"github.com/netauth/netauth/internal/db"
+	_ "github.com/netauth/netauth/internal/db/memory"
+	"github.com/netauth/netauth/internal/startup"
+
 	pb "github.com/netauth/protocol"
 )
 
@@ -124,20 +129,140 @@ func TestECCheckRequiredEmpty(t *testing.T) {
 	}
 }
 
+func TestECRunChainAtomic(t *testing.T) {
+	startup.DoCallbacks()
+
+	mdb, err := db.New("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	em := Manager{
+		db: mdb,
+		entityProcesses: map[string][]EntityHook{
+			"TEST": {
+				&saveEntityHook{"entity1", 90},
+				&saveEntityHook{"entity2", 91},
+				&failEntityHook{},
+			},
+		},
+		log: hclog.NewNullLogger(),
+	}
+
+	if _, err := em.RunEntityChain("TEST", &pb.Entity{}); err == nil {
+		t.Fatal("Chain did not fail")
+	}
+
+	for _, id := range []string{"entity1", "entity2"} {
+		if _, err := mdb.LoadEntity(id); err != db.ErrUnknownEntity {
+			t.Errorf("Entity %s was saved by a failed chain: %v", id, err)
+		}
+	}
+
+	em.entityProcesses["TEST"] = em.entityProcesses["TEST"][:2]
+	if _, err := em.RunEntityChain("TEST", &pb.Entity{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"entity1", "entity2"} {
+		if _, err := mdb.LoadEntity(id); err != nil {
+			t.Errorf("Entity %s was not saved: %v", id, err)
+		}
+	}
+}
+
+func TestECRunChainRetry(t *testing.T) {
+	startup.DoCallbacks()
+
+	mdb, err := db.New("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.SaveEntity(&pb.Entity{ID: proto.String("entity1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &racingEntityHook{db: mdb, races: 1}
+	em := Manager{
+		db:              mdb,
+		entityProcesses: map[string][]EntityHook{"TEST": {h}},
+		log:             hclog.NewNullLogger(),
+	}
+
+	// A chain that loses a race is run again.
+	if _, err := em.RunEntityChain("TEST", &pb.Entity{}); err != nil {
+		t.Fatal(err)
+	}
+	if h.runs != 2 {
+		t.Errorf("Chain ran %d times", h.runs)
+	}
+
+	// One that keeps losing gives up.
+	h.runs, h.races = 0, maxChainAttempts
+	if _, err := em.RunEntityChain("TEST", &pb.Entity{}); err != db.ErrConflict {
+		t.Errorf("Got %v; Want %v", err, db.ErrConflict)
+	}
+	if h.runs != maxChainAttempts {
+		t.Errorf("Chain ran %d times", h.runs)
+	}
+}
+
+type saveEntityHook struct {
+	id       string
+	priority int
+}
+
+func (*saveEntityHook) Name() string    { return "save-hook" }
+func (h *saveEntityHook) Priority() int { return h.priority }
+func (h *saveEntityHook) Run(tx Txn, _, _ *pb.Entity) error {
+	return tx.SaveEntity(&pb.Entity{ID: proto.String(h.id)})
+}
+
+// racingEntityHook updates entity1, and for the first few runs
+// changes it underneath the chain so that the commit conflicts.
+type racingEntityHook struct {
+	db    *db.DB
+	runs  int
+	races int
+}
+
+func (*racingEntityHook) Name() string  { return "racing-hook" }
+func (*racingEntityHook) Priority() int { return 50 }
+func (h *racingEntityHook) Run(tx Txn, _, _ *pb.Entity) error {
+	h.runs++
+	e, err := tx.LoadEntity("entity1")
+	if err != nil {
+		return err
+	}
+	if h.runs <= h.races {
+		if err := h.db.SaveEntity(&pb.Entity{ID: proto.String("entity1"), Number: proto.Int32(int32(h.runs))}); err != nil {
+			return err
+		}
+	}
+	e.Meta = &pb.EntityMeta{DisplayName: proto.String("racer")}
+	return tx.SaveEntity(e)
+}
+
+type failEntityHook struct{}
+
+func (*failEntityHook) Name() string                     { return "fail-hook" }
+func (*failEntityHook) Priority() int                    { return 99 }
+func (*failEntityHook) Run(_ Txn, _, _ *pb.Entity) error { return errors.New("chain failure") }
+
 type nullEntityHook struct{}
 
-func (*nullEntityHook) Name() string              { return "null-hook" }
-func (*nullEntityHook) Priority() int             { return 50 }
-func (*nullEntityHook) Run(_, _ *pb.Entity) error { return nil }
+func (*nullEntityHook) Name() string                     { return "null-hook" }
+func (*nullEntityHook) Priority() int                    { return 50 }
+func (*nullEntityHook) Run(_ Txn, _, _ *pb.Entity) error { return nil }
 func goodEntityConstructor(_ RefContext) (EntityHook, error) {
 	return &nullEntityHook{}, nil
 }
 
 type nullEntityHook2 struct{}
 
-func (*nullEntityHook2) Name() string              { return "null-hook2" }
-func (*nullEntityHook2) Priority() int             { return 40 }
-func (*nullEntityHook2) Run(_, _ *pb.Entity) error { return nil }
+func (*nullEntityHook2) Name() string                     { return "null-hook2" }
+func (*nullEntityHook2) Priority() int                    { return 40 }
+func (*nullEntityHook2) Run(_ Txn, _, _ *pb.Entity) error { return nil }
 
 func goodEntityConstructor2(_ RefContext) (EntityHook, error) {
 	return &nullEntityHook2{}, nil
@@ -12,6 +12,10 @@ import (
 	pb "github.com/netauth/protocol"
 )
 
+// NumberPoolKey is the key under which the CREATE chains are passed
+// the name of the pool that a number should be allocated from.
+const NumberPoolKey = "netauth.pool"
+
 // CreateEntity creates a new entity given an ID, number, and secret.
 // Its not necessary to set the secret upon creation and it can be set
 // later.  If not set on creation then the entity will not be usable.
@@ -19,11 +23,21 @@ import (
 // generally allocated in sequence the special value '-1' may be
 // specified which will select the next available number.
 func (m *Manager) CreateEntity(ID string, number int32, secret string) error {
+	return m.CreateEntityInPool(ID, "", number, secret)
+}
+
+// CreateEntityInPool creates a new entity in the same way as
+// CreateEntity, but when number is -1 the number is allocated from
+// the named pool.  An empty pool selects the default pool.
+func (m *Manager) CreateEntityInPool(ID, pool string, number int32, secret string) error {
 	de := &pb.Entity{
 		ID:     &ID,
 		Number: &number,
 		Secret: &secret,
 	}
+	if pool != "" {
+		de.Meta = &pb.EntityMeta{KV: instructionKV(NumberPoolKey, pool)}
+	}
 
 	_, err := m.RunEntityChain("CREATE", de)
 	return err
@@ -161,30 +175,41 @@ func (m *Manager) ValidateSecret(ID string, secret string) error {
 // FetchEntity returns an entity to the caller after first making a
 // safe copy of it to remove secure fields.
 func (m *Manager) FetchEntity(ID string) (*pb.Entity, error) {
+	e, _, err := m.FetchEntityRevision(ID)
+	return e, err
+}
+
+// FetchEntityRevision returns an entity in the same way as
+// FetchEntity, but also returns the revision of the entity as it was
+// loaded.  The revision can be passed back to UpdateEntityMeta to
+// ensure that the entity has not changed in the meantime.
+func (m *Manager) FetchEntityRevision(ID string) (*pb.Entity, string, error) {
 	de := &pb.Entity{
 		ID: &ID,
 	}
 
 	e, err := m.RunEntityChain("FETCH", de)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	// The safeCopyEntity will return the entity without secrets
 	// in it, as well as an error if there were problems
 	// marshaling the proto back and forth.
-	return safeCopyEntity(e), nil
+	return safeCopyEntity(e), db.EntityRevision(e), nil
 }
 
 // UpdateEntityMeta drives the internal version by obtaining the
-// entity from the database based on the ID.
-func (m *Manager) UpdateEntityMeta(ID string, newMeta *pb.EntityMeta) error {
+// entity from the database based on the ID.  If rev is not empty the
+// update will fail with db.ErrConflict unless the entity is still at
+// that revision.
+func (m *Manager) UpdateEntityMeta(ID string, newMeta *pb.EntityMeta, rev string) error {
 	de := &pb.Entity{
 		ID:   &ID,
 		Meta: newMeta,
 	}
 
-	_, err := m.RunEntityChain("MERGE-METADATA", de)
+	_, err := m.runEntityChain("MERGE-METADATA", de, rev)
 	return err
 }
 
@@ -370,7 +395,8 @@ func (m *Manager) entityResolverCallback(e db.Event) {
 			m.log.Warn("Unchecked load error in entityResolverCallback", "error", err)
 			return
 		}
-		m.resolver.SyncDirectGroups(ent.GetID(), ent.GetMeta().GetGroups())
+		m.resolver.SyncExpiringGroups(ent.GetID(), ent.GetMeta().GetGroups(), MembershipExpiry(ent))
+		m.syncDynamicGroups(ent)
 	case db.EventEntityDestroy:
 		m.resolver.RemoveEntity(e.PK)
 	default:
@@ -59,4 +59,21 @@ var (
 	// certain criteria to be successfully procesed, and these
 	// criteria are not met.
 	ErrFailedPrecondition = errors.New("precondition failed")
+
+	// ErrUnknownDestroyMode is returned when the DESTROY chains
+	// are asked to handle references in a way that isn't known.
+	ErrUnknownDestroyMode = errors.New("the destroy mode specified is unknown")
+
+	// ErrNoRenameTarget is returned when a rename is requested
+	// without a new name.
+	ErrNoRenameTarget = errors.New("a new name must be provided")
+
+	// ErrBadScope is returned when a scoped capability can't be
+	// parsed, or names a capability that can't be held in a
+	// scope.
+	ErrBadScope = errors.New("the scoped capability is invalid")
+
+	// ErrReservedKey is returned when KV data would be changed
+	// under a key that the server maintains itself.
+	ErrReservedKey = errors.New("the key is reserved")
 )
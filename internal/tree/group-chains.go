@@ -3,6 +3,10 @@ package tree
 import (
 	"sort"
 
+	"github.com/golang/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/db"
+
 	pb "github.com/netauth/protocol"
 )
 
@@ -17,7 +21,7 @@ type GroupHookConstructor func(RefContext) (GroupHook, error)
 type GroupHook interface {
 	Priority() int
 	Name() string
-	Run(*pb.Group, *pb.Group) error
+	Run(Txn, *pb.Group, *pb.Group) error
 }
 
 var (
@@ -108,14 +112,64 @@ func (m *Manager) CheckRequiredGroupChains() error {
 // RunGroupChain runs the specified chain with de specifying values
 // to be consumed by the chain.
 func (m *Manager) RunGroupChain(chain string, de *pb.Group) (*pb.Group, error) {
+	return m.runGroupChain(chain, de, "")
+}
+
+// runGroupChain runs the chain, and if rev is not empty the group
+// identified by de must be at that revision when it is loaded.
+// Chains run concurrently, so one may lose the race to commit to
+// another; unless it is held to a revision it is then run again
+// against the values that won.
+func (m *Manager) runGroupChain(chain string, de *pb.Group, rev string) (*pb.Group, error) {
+	for i := 1; ; i++ {
+		e, err := m.tryGroupChain(chain, de, rev)
+		if err != db.ErrConflict || rev != "" || i == maxChainAttempts {
+			return e, err
+		}
+		m.log.Debug("Retrying chain after conflict", "chain", chain, "attempt", i)
+	}
+}
+
+func (m *Manager) tryGroupChain(chain string, de *pb.Group, rev string) (*pb.Group, error) {
 	e := new(pb.Group)
 	hookChain := m.groupProcesses[chain]
+
+	// All writes made by the chain are held in a transaction so
+	// that a chain which saves more than one object either lands
+	// all of its changes or none of them.  The transaction is
+	// handed to each hook, and nothing it holds is visible to
+	// anybody else until it is committed.
+	var txn *db.Txn
+	var tx Txn
+	if m.db != nil {
+		txn = m.db.Begin()
+		tx = txn
+		if rev != "" {
+			txn.ExpectGroupRevision(de.GetName(), rev)
+		}
+	}
 	for _, h := range hookChain {
 		m.log.Trace("Executing group hook", "chain", chain, "hook", h.Name())
-		if err := h.Run(e, de); err != nil {
+		if err := h.Run(tx, e, de); err != nil {
 			m.log.Trace("Error during chain execution", "chain", chain, "hook", h.Name(), "error", err)
+			if txn != nil {
+				txn.Abort()
+			}
+			return nil, err
+		}
+	}
+	if txn != nil {
+		var before map[auditKey]proto.Message
+		if m.auditing() {
+			before = m.auditBefore(txn)
+		}
+		if err := txn.Commit(); err != nil {
+			m.log.Warn("Error committing chain", "chain", chain, "error", err)
 			return nil, err
 		}
+		if m.auditing() {
+			m.recordChanges(chain, txn, before)
+		}
 	}
 	return e, nil
 }
@@ -14,12 +14,22 @@ import (
 // exist.  If the group exists then it cannot be added and an error is
 // returned.
 func (m *Manager) CreateGroup(name, displayName, managedBy string, number int32) error {
+	return m.CreateGroupInPool(name, displayName, managedBy, "", number)
+}
+
+// CreateGroupInPool creates a group in the same way as CreateGroup,
+// but when number is -1 the number is allocated from the named pool.
+// An empty pool selects the default pool.
+func (m *Manager) CreateGroupInPool(name, displayName, managedBy, pool string, number int32) error {
 	rg := &pb.Group{
 		Name:        &name,
 		DisplayName: &displayName,
 		ManagedBy:   &managedBy,
 		Number:      &number,
 	}
+	if pool != "" {
+		rg.KV = instructionKV(NumberPoolKey, pool)
+	}
 
 	_, err := m.RunGroupChain("CREATE", rg)
 	return err
@@ -30,11 +40,24 @@ func (m *Manager) CreateGroup(name, displayName, managedBy string, number int32)
 // will explain why.  This is very thin since it just obtains a value
 // from the storage layer.
 func (m *Manager) FetchGroup(name string) (*pb.Group, error) {
+	g, _, err := m.FetchGroupRevision(name)
+	return g, err
+}
+
+// FetchGroupRevision returns a group in the same way as FetchGroup,
+// but also returns the revision of the group as it was loaded.  The
+// revision can be passed back to UpdateGroupMeta to ensure that the
+// group has not changed in the meantime.
+func (m *Manager) FetchGroupRevision(name string) (*pb.Group, string, error) {
 	rg := &pb.Group{
 		Name: &name,
 	}
 
-	return m.RunGroupChain("FETCH", rg)
+	g, err := m.RunGroupChain("FETCH", rg)
+	if err != nil {
+		return nil, "", err
+	}
+	return g, db.GroupRevision(g), nil
 }
 
 // DestroyGroup unsurprisingly deletes a group.  There's no real logic
@@ -50,10 +73,11 @@ func (m *Manager) DestroyGroup(name string) error {
 
 // UpdateGroupMeta updates metadata within the group.  Certain
 // information is not mutable and so that information is not merged
-// in.
-func (m *Manager) UpdateGroupMeta(name string, update *pb.Group) error {
+// in.  If rev is not empty the update will fail with db.ErrConflict
+// unless the group is still at that revision.
+func (m *Manager) UpdateGroupMeta(name string, update *pb.Group, rev string) error {
 	update.Name = &name
-	_, err := m.RunGroupChain("MERGE-METADATA", update)
+	_, err := m.runGroupChain("MERGE-METADATA", update, rev)
 	return err
 }
 
@@ -228,7 +252,9 @@ func (m *Manager) groupResolverCallback(e db.Event) {
 			exps[parts[0]] = append(exps[parts[0]], parts[1])
 		}
 		m.resolver.SyncGroup(grp.GetName(), exps["INCLUDE"], exps["EXCLUDE"])
+		m.syncGroupRule(grp.GetName(), GroupRule(grp))
 	case db.EventGroupDestroy:
+		m.syncGroupRule(e.PK, "")
 		m.resolver.RemoveGroup(e.PK)
 	default:
 		return
@@ -0,0 +1,53 @@
+package tree
+
+import (
+	"github.com/netauth/netauth/internal/db"
+)
+
+// EntityHistory returns the revisions that are kept for an entity,
+// oldest first.  As with FetchEntity the secret is removed from each
+// revision.
+func (m *Manager) EntityHistory(ID string) ([]db.HistoricEntity, error) {
+	h, err := m.db.EntityHistory(ID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range h {
+		h[i].Entity = safeCopyEntity(h[i].Entity)
+	}
+	return h, nil
+}
+
+// GroupHistory returns the revisions that are kept for a group,
+// oldest first.
+func (m *Manager) GroupHistory(name string) ([]db.HistoricGroup, error) {
+	return m.db.GroupHistory(name)
+}
+
+// RollbackEntity restores an entity to a previous revision.  The
+// restore is carried out by the ROLLBACK chain so that it is subject
+// to the same hooks as any other change.  The secret is never rolled
+// back.  If rev is not empty the rollback will fail with
+// db.ErrConflict unless the entity is still at that revision.
+func (m *Manager) RollbackEntity(ID, to, rev string) error {
+	de, err := m.db.LoadEntityRevision(ID, to)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.runEntityChain("ROLLBACK", de, rev)
+	return err
+}
+
+// RollbackGroup restores a group to a previous revision using the
+// ROLLBACK chain.  If rev is not empty the rollback will fail with
+// db.ErrConflict unless the group is still at that revision.
+func (m *Manager) RollbackGroup(name, to, rev string) error {
+	dg, err := m.db.LoadGroupRevision(name, to)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.runGroupChain("ROLLBACK", dg, rev)
+	return err
+}
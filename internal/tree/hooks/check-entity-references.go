@@ -0,0 +1,40 @@
+package hooks
+
+import (
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+// CheckEntityReferences refuses to destroy an entity that is still a
+// direct member of any group.
+type CheckEntityReferences struct {
+	tree.BaseHook
+}
+
+// Run returns a tree.ReferenceError listing the groups that e is
+// still a direct member of, if there are any.
+func (*CheckEntityReferences) Run(_ tree.Txn, e, de *pb.Entity) error {
+	var refs []string
+	for _, g := range e.GetMeta().GetGroups() {
+		refs = append(refs, "group "+g+" (member)")
+	}
+	if len(refs) > 0 {
+		return &tree.ReferenceError{References: refs}
+	}
+	return nil
+}
+
+func init() {
+	startup.RegisterCallback(checkEntityReferencesCB)
+}
+
+func checkEntityReferencesCB() {
+	tree.RegisterEntityHookConstructor("check-entity-references", NewCheckEntityReferences)
+}
+
+// NewCheckEntityReferences returns a configured hook, ready for use.
+func NewCheckEntityReferences(c tree.RefContext) (tree.EntityHook, error) {
+	return &CheckEntityReferences{tree.NewBaseHook("check-entity-references", 40)}, nil
+}
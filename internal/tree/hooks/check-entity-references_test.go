@@ -0,0 +1,30 @@
+package hooks
+
+import (
+	"testing"
+
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestCheckEntityReferences(t *testing.T) {
+	hook, err := NewCheckEntityReferences(tree.RefContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &pb.Entity{Meta: &pb.EntityMeta{Groups: []string{"group1", "group2"}}}
+	err = hook.Run(nil, e, &pb.Entity{})
+	if rerr, ok := err.(*tree.ReferenceError); !ok || len(rerr.References) != 2 {
+		t.Errorf("Got %v; Want a ReferenceError with 2 references", err)
+	}
+
+	if err := hook.Run(nil, &pb.Entity{}, &pb.Entity{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckEntityReferencesCB(t *testing.T) {
+	checkEntityReferencesCB()
+}
@@ -0,0 +1,45 @@
+package hooks
+
+import (
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+// CheckEntityReservedKV stops the KV chains from changing KV data
+// that the server maintains itself, such as the expiry of
+// memberships.
+type CheckEntityReservedKV struct {
+	tree.BaseHook
+}
+
+// Run returns tree.ErrReservedKey if any of the KV data in de is
+// stored under a reserved key.
+func (*CheckEntityReservedKV) Run(_ tree.Txn, e, de *pb.Entity) error {
+	return checkReservedKV(de.GetMeta().GetKV())
+}
+
+// checkReservedKV checks the keys of the KV data against those that
+// are reserved.
+func checkReservedKV(kv []*pb.KVData) error {
+	for _, d := range kv {
+		if tree.ReservedKey(d.GetKey()) {
+			return tree.ErrReservedKey
+		}
+	}
+	return nil
+}
+
+func init() {
+	startup.RegisterCallback(checkEntityReservedKVCB)
+}
+
+func checkEntityReservedKVCB() {
+	tree.RegisterEntityHookConstructor("check-reserved-kv", NewCheckEntityReservedKV)
+}
+
+// NewCheckEntityReservedKV returns a configured hook for use.
+func NewCheckEntityReservedKV(c tree.RefContext) (tree.EntityHook, error) {
+	return &CheckEntityReservedKV{tree.NewBaseHook("check-reserved-kv", 40)}, nil
+}
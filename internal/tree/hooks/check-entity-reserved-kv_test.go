@@ -0,0 +1,40 @@
+package hooks
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestCheckEntityReservedKV(t *testing.T) {
+	hook, err := NewCheckEntityReservedKV(tree.RefContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		key     string
+		wantErr error
+	}{
+		{"location", nil},
+		{tree.ScopeKey, nil},
+		{tree.MembershipExpiryKey, tree.ErrReservedKey},
+		{tree.RenameKey, tree.ErrReservedKey},
+		{"netauth.future", tree.ErrReservedKey},
+	}
+
+	for i, c := range cases {
+		de := &pb.Entity{Meta: &pb.EntityMeta{KV: []*pb.KVData{{Key: proto.String(c.key)}}}}
+		if err := hook.Run(nil, &pb.Entity{}, de); err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+	}
+}
+
+func TestCheckEntityReservedKVCB(t *testing.T) {
+	checkEntityReservedKVCB()
+}
@@ -0,0 +1,52 @@
+package hooks
+
+import (
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+// CheckEntityScope checks that scoped capabilities can be parsed
+// before they are stored on an entity.
+type CheckEntityScope struct {
+	tree.BaseHook
+}
+
+// Run checks each value of the KV data in de that is stored under
+// tree.ScopeKey, and returns tree.ErrBadScope if any can't be parsed.
+func (*CheckEntityScope) Run(_ tree.Txn, e, de *pb.Entity) error {
+	return checkScopes(de.GetMeta().GetKV())
+}
+
+// checkScopes checks each value of the KV data that is stored under
+// tree.ScopeKey.
+func checkScopes(kv []*pb.KVData) error {
+	for _, d := range kv {
+		if d.GetKey() != tree.ScopeKey {
+			continue
+		}
+		if len(d.GetValues()) == 0 {
+			return tree.ErrBadScope
+		}
+		for _, v := range d.GetValues() {
+			if _, err := tree.ParseScopedCapability(v.GetValue()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func init() {
+	startup.RegisterCallback(checkEntityScopeCB)
+}
+
+func checkEntityScopeCB() {
+	tree.RegisterEntityHookConstructor("check-scope", NewCheckEntityScope)
+}
+
+// NewCheckEntityScope returns a configured hook for use.
+func NewCheckEntityScope(c tree.RefContext) (tree.EntityHook, error) {
+	return &CheckEntityScope{tree.NewBaseHook("check-scope", 40)}, nil
+}
@@ -0,0 +1,49 @@
+package hooks
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+func scopeKV(values ...string) []*pb.KVData {
+	kv := &pb.KVData{Key: proto.String(tree.ScopeKey)}
+	for _, v := range values {
+		kv.Values = append(kv.Values, &pb.KVValue{Value: proto.String(v)})
+	}
+	return []*pb.KVData{kv}
+}
+
+func TestCheckEntityScope(t *testing.T) {
+	hook, err := NewCheckEntityScope(tree.RefContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		kv      []*pb.KVData
+		wantErr error
+	}{
+		{scopeKV("CREATE_ENTITY:team-infra", "modify_group_members:team-infra"), nil},
+		{scopeKV("GLOBAL_ROOT:team-infra"), tree.ErrBadScope},
+		{scopeKV("NOT_A_CAPABILITY:team-infra"), tree.ErrBadScope},
+		{scopeKV("CREATE_ENTITY"), tree.ErrBadScope},
+		{scopeKV(), tree.ErrBadScope},
+		{[]*pb.KVData{{Key: proto.String("location")}}, nil},
+	}
+
+	for i, c := range cases {
+		de := &pb.Entity{Meta: &pb.EntityMeta{KV: c.kv}}
+		if err := hook.Run(nil, &pb.Entity{}, de); err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+	}
+}
+
+func TestCheckEntityScopeCB(t *testing.T) {
+	checkEntityScopeCB()
+}
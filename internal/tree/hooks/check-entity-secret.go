@@ -0,0 +1,175 @@
+package hooks
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/spf13/viper"
+
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+// CheckEntitySecret enforces the password policy on plaintext secrets
+// before they are secured and stored.
+type CheckEntitySecret struct {
+	tree.BaseHook
+
+	minLength int
+	classes   int
+	banned    []string
+	identity  bool
+
+	// allowEmpty skips the check when no secret was provided, so
+	// that entities may be created without one.
+	allowEmpty bool
+}
+
+// Run checks the plaintext secret in de against the policy, and
+// returns a tree.SecretPolicyError with every reason that it fails.
+// The ID and GECOS are taken from e if it has been loaded, and from
+// de otherwise.
+func (c *CheckEntitySecret) Run(_ tree.Txn, e, de *pb.Entity) error {
+	secret := de.GetSecret()
+	if secret == "" && c.allowEmpty {
+		return nil
+	}
+	lower := strings.ToLower(secret)
+	var reasons []string
+
+	if len([]rune(secret)) < c.minLength {
+		reasons = append(reasons, fmt.Sprintf("it is shorter than %d characters", c.minLength))
+	}
+	if n := charClasses(secret); n < c.classes {
+		reasons = append(reasons, fmt.Sprintf("it has %d of the %d kinds of character required", n, c.classes))
+	}
+	for _, w := range c.banned {
+		if strings.Contains(lower, w) {
+			reasons = append(reasons, "it contains a banned word")
+			break
+		}
+	}
+
+	if c.identity {
+		id := e.GetID()
+		if id == "" {
+			id = de.GetID()
+		}
+		if id != "" && strings.Contains(lower, strings.ToLower(id)) {
+			reasons = append(reasons, "it contains the entity ID")
+		}
+
+		gecos := e.GetMeta().GetGECOS()
+		if gecos == "" {
+			gecos = de.GetMeta().GetGECOS()
+		}
+		for _, w := range strings.FieldsFunc(strings.ToLower(gecos), isGECOSSeparator) {
+			if len(w) >= 3 && strings.Contains(lower, w) {
+				reasons = append(reasons, "it contains part of the GECOS")
+				break
+			}
+		}
+	}
+
+	if len(reasons) > 0 {
+		return &tree.SecretPolicyError{Reasons: reasons}
+	}
+	return nil
+}
+
+// charClasses returns how many of lower case letters, upper case
+// letters, digits, and other characters appear in s.
+func charClasses(s string) int {
+	var lower, upper, digit, other int
+	for _, r := range s {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
+
+func isGECOSSeparator(r rune) bool {
+	return r == ',' || unicode.IsSpace(r)
+}
+
+// loadBannedWords reads a file with one banned word on each line.
+// Blank lines and lines starting with # are ignored.
+func loadBannedWords(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var words []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		w := strings.ToLower(strings.TrimSpace(s.Text()))
+		if w == "" || strings.HasPrefix(w, "#") {
+			continue
+		}
+		words = append(words, w)
+	}
+	return words, s.Err()
+}
+
+func init() {
+	startup.RegisterCallback(checkEntitySecretCB)
+}
+
+func checkEntitySecretCB() {
+	tree.RegisterEntityHookConstructor("check-entity-secret", NewCheckEntitySecret)
+	tree.RegisterEntityHookConstructor("check-entity-secret-if-set", NewCheckEntitySecretIfSet)
+}
+
+// NewCheckEntitySecret returns a hook configured from the password
+// keys: password.length.min, password.classes, password.banned which
+// names a file of banned words relative to core.conf, and
+// password.identity.  With none of them set every secret is accepted.
+func NewCheckEntitySecret(c tree.RefContext) (tree.EntityHook, error) {
+	h := &CheckEntitySecret{
+		BaseHook:  tree.NewBaseHook("check-entity-secret", 40),
+		minLength: viper.GetInt("password.length.min"),
+		classes:   viper.GetInt("password.classes"),
+		identity:  viper.GetBool("password.identity"),
+	}
+
+	if f := viper.GetString("password.banned"); f != "" {
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(viper.GetString("core.conf"), f)
+		}
+		words, err := loadBannedWords(f)
+		if err != nil {
+			return nil, err
+		}
+		h.banned = words
+	}
+	return h, nil
+}
+
+// NewCheckEntitySecretIfSet returns a hook that applies the same
+// policy as check-entity-secret, but accepts an empty secret.
+func NewCheckEntitySecretIfSet(c tree.RefContext) (tree.EntityHook, error) {
+	h, err := NewCheckEntitySecret(c)
+	if err != nil {
+		return nil, err
+	}
+	ch := h.(*CheckEntitySecret)
+	ch.BaseHook = tree.NewBaseHook("check-entity-secret-if-set", 40)
+	ch.allowEmpty = true
+	return ch, nil
+}
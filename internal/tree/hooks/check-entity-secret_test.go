@@ -0,0 +1,139 @@
+package hooks
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestCheckEntitySecret(t *testing.T) {
+	hook := &CheckEntitySecret{
+		BaseHook:  tree.NewBaseHook("check-entity-secret", 40),
+		minLength: 8,
+		classes:   3,
+		banned:    []string{"password", "netauth"},
+		identity:  true,
+	}
+
+	loaded := &pb.Entity{
+		ID:   proto.String("jdoe"),
+		Meta: &pb.EntityMeta{GECOS: proto.String("Jane Doe,Room 101")},
+	}
+
+	cases := []struct {
+		e          *pb.Entity
+		secret     string
+		wantReason []string
+	}{
+		{&pb.Entity{}, "Correct-Horse-7", nil},
+		{&pb.Entity{}, "a", []string{
+			"it is shorter than 8 characters",
+			"it has 1 of the 3 kinds of character required",
+		}},
+		{&pb.Entity{}, "My-Password-1", []string{"it contains a banned word"}},
+		{loaded, "JDOE-secret-1", []string{
+			"it contains the entity ID",
+			"it contains part of the GECOS",
+		}},
+		{loaded, "Jane-secret-1", []string{"it contains part of the GECOS"}},
+		{loaded, "Doe-is-ok-101", []string{"it contains part of the GECOS"}},
+		{loaded, "Correct-Horse-7", nil},
+	}
+
+	for i, c := range cases {
+		de := &pb.Entity{ID: proto.String("jdoe"), Secret: proto.String(c.secret)}
+		err := hook.Run(nil, c.e, de)
+		if c.wantReason == nil {
+			if err != nil {
+				t.Errorf("%d: Got %v; Want nil", i, err)
+			}
+			continue
+		}
+		perr, ok := err.(*tree.SecretPolicyError)
+		if !ok {
+			t.Errorf("%d: Got %v; Want a SecretPolicyError", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(perr.Reasons, c.wantReason) {
+			t.Errorf("%d: Got %v; Want %v", i, perr.Reasons, c.wantReason)
+		}
+	}
+}
+
+func TestCheckEntitySecretEmpty(t *testing.T) {
+	defer viper.Reset()
+	viper.Set("password.length.min", 8)
+
+	de := &pb.Entity{ID: proto.String("jdoe"), Secret: proto.String("")}
+
+	h, err := NewCheckEntitySecretIfSet(tree.RefContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Run(nil, &pb.Entity{}, de); err != nil {
+		t.Errorf("Empty secret was rejected when allowed: %v", err)
+	}
+	if h.Name() != "check-entity-secret-if-set" {
+		t.Errorf("Got name %s", h.Name())
+	}
+
+	h, err = NewCheckEntitySecret(tree.RefContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := h.Run(nil, &pb.Entity{}, de).(*tree.SecretPolicyError); !ok {
+		t.Error("Empty secret was accepted when not allowed")
+	}
+
+	viper.Set("password.banned", "does-not-exist.txt")
+	if _, err := NewCheckEntitySecretIfSet(tree.RefContext{}); err == nil {
+		t.Error("Missing banned word file loaded without error")
+	}
+}
+
+func TestNewCheckEntitySecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer viper.Reset()
+
+	words := "# Banned words\n\nPassword\n  letmein \n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "banned.txt"), []byte(words), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	viper.Set("core.conf", dir)
+	viper.Set("password.length.min", 12)
+	viper.Set("password.banned", "banned.txt")
+	h, err := NewCheckEntitySecret(tree.RefContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook := h.(*CheckEntitySecret)
+	if hook.minLength != 12 {
+		t.Errorf("Got %d; Want 12", hook.minLength)
+	}
+	if want := []string{"password", "letmein"}; !reflect.DeepEqual(hook.banned, want) {
+		t.Errorf("Got %v; Want %v", hook.banned, want)
+	}
+
+	viper.Set("password.banned", "does-not-exist.txt")
+	if _, err := NewCheckEntitySecret(tree.RefContext{}); err == nil {
+		t.Error("Missing banned word file loaded without error")
+	}
+}
+
+func TestCheckEntitySecretCB(t *testing.T) {
+	checkEntitySecretCB()
+}
@@ -14,14 +14,13 @@ import (
 // cycle in the inclusion graph.
 type CheckExpansionCycles struct {
 	tree.BaseHook
-	tree.DB
 }
 
 // Run will iterate through all expansions requested in dg and ensure
 // that no cycles exist between the g and the requested include.  If
 // the mode for any expansion is DROP that expansion will be skipped
 // without checking.
-func (cec *CheckExpansionCycles) Run(g, dg *pb.Group) error {
+func (cec *CheckExpansionCycles) Run(tx tree.Txn, g, dg *pb.Group) error {
 	exps := dg.GetExpansions()
 	for i := range exps {
 		parts := strings.SplitN(exps[i], ":", 2)
@@ -30,11 +29,11 @@ func (cec *CheckExpansionCycles) Run(g, dg *pb.Group) error {
 		if parts[0] == "DROP" {
 			continue
 		}
-		child, err := cec.LoadGroup(parts[1])
+		child, err := tx.LoadGroup(parts[1])
 		if err != nil {
 			return err
 		}
-		if cec.checkGroupCycles(child, g.GetName()) {
+		if cec.checkGroupCycles(tx, child, g.GetName()) {
 			return tree.ErrExistingExpansion
 		}
 	}
@@ -45,13 +44,13 @@ func (cec *CheckExpansionCycles) Run(g, dg *pb.Group) error {
 // candidate group somewhere on the tree below the entry point.  The
 // general usage would be to push in the target of the expansion as
 // the group and then hunt for the parent group as the candidate.
-func (cec *CheckExpansionCycles) checkGroupCycles(g *pb.Group, candidate string) bool {
+func (cec *CheckExpansionCycles) checkGroupCycles(tx tree.Txn, g *pb.Group, candidate string) bool {
 	for _, exp := range g.GetExpansions() {
 		parts := strings.SplitN(exp, ":", 2)
 		if parts[1] == candidate {
 			return true
 		}
-		ng, err := cec.LoadGroup(parts[1])
+		ng, err := tx.LoadGroup(parts[1])
 		if err != nil {
 			// Play it safe, if we can't get the group
 			// something may already be wrong.  Returning
@@ -59,7 +58,7 @@ func (cec *CheckExpansionCycles) checkGroupCycles(g *pb.Group, candidate string)
 			// tree.
 			return true
 		}
-		if r := cec.checkGroupCycles(ng, candidate); r {
+		if r := cec.checkGroupCycles(tx, ng, candidate); r {
 			return r
 		}
 	}
@@ -74,5 +73,5 @@ func checkExpansionCyclesCB() {
 
 // NewCheckExpansionCycles returns a configured hook ready for use.
 func NewCheckExpansionCycles(c tree.RefContext) (tree.GroupHook, error) {
-	return &CheckExpansionCycles{tree.NewBaseHook("check-expansion-cycles", 40), c.DB}, nil
+	return &CheckExpansionCycles{tree.NewBaseHook("check-expansion-cycles", 40)}, nil
 }
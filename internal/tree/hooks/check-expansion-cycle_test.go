@@ -31,7 +31,7 @@ func TestCheckExpansionCyclesDrop(t *testing.T) {
 		Expansions: []string{"DROP:somegroup"},
 	}
 
-	if err := hook.Run(g, dg); err != nil {
+	if err := hook.Run(mdb, g, dg); err != nil {
 		t.Error(err)
 	}
 }
@@ -54,7 +54,7 @@ func TestCheckExpansionCycleUnknownChild(t *testing.T) {
 		Expansions: []string{"INCLUDE:somegroup"},
 	}
 
-	if err := hook.Run(g, dg); err != db.ErrUnknownGroup {
+	if err := hook.Run(mdb, g, dg); err != db.ErrUnknownGroup {
 		t.Error(err)
 	}
 }
@@ -81,7 +81,7 @@ func TestCheckExpansionCycleCycleFound(t *testing.T) {
 		Expansions: []string{"INCLUDE:group2"},
 	}
 
-	if err := hook.Run(g, dg); err != tree.ErrExistingExpansion {
+	if err := hook.Run(mdb, g, dg); err != tree.ErrExistingExpansion {
 		t.Error(err)
 	}
 }
@@ -112,7 +112,7 @@ func TestCheckGroupCyclesRecurser(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if !rhook.checkGroupCycles(grp1, "group2") {
+	if !rhook.checkGroupCycles(mdb, grp1, "group2") {
 		t.Fatal("Failed to detect direct loop")
 	}
 
@@ -124,7 +124,7 @@ func TestCheckGroupCyclesRecurser(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if !rhook.checkGroupCycles(grp1, "group4") {
+	if !rhook.checkGroupCycles(mdb, grp1, "group4") {
 		t.Fatal("Failed to error on an unloadable group")
 	}
 
@@ -135,7 +135,7 @@ func TestCheckGroupCyclesRecurser(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if rhook.checkGroupCycles(grp1, "group4") {
+	if rhook.checkGroupCycles(mdb, grp1, "group4") {
 		t.Fatal("Errored on an acceptable expansion")
 	}
 }
@@ -13,7 +13,6 @@ import (
 // groups that exist, unless the expansion type is DROP.
 type CheckExpansionTargets struct {
 	tree.BaseHook
-	tree.DB
 }
 
 // Run iterates through all expansions on dg and ensures that if the
@@ -21,14 +20,14 @@ type CheckExpansionTargets struct {
 // allows groups that have been deleted to effectively skip this
 // check, since the only expansion that makes sense targeting a
 // deleted group is to drop it.
-func (cet *CheckExpansionTargets) Run(g, dg *pb.Group) error {
+func (cet *CheckExpansionTargets) Run(tx tree.Txn, g, dg *pb.Group) error {
 	targets := dg.GetExpansions()
 	for i := range targets {
 		parts := strings.SplitN(targets[i], ":", 2)
 		if parts[0] == "DROP" {
 			continue
 		}
-		if _, err := cet.LoadGroup(parts[1]); err != nil {
+		if _, err := tx.LoadGroup(parts[1]); err != nil {
 			return err
 		}
 	}
@@ -45,5 +44,5 @@ func checkExpansionTargetsCB() {
 
 // NewCheckExpansionTargets returns a configured hook, ready for use.
 func NewCheckExpansionTargets(c tree.RefContext) (tree.GroupHook, error) {
-	return &CheckExpansionTargets{tree.NewBaseHook("check-expansion-targets", 40), c.DB}, nil
+	return &CheckExpansionTargets{tree.NewBaseHook("check-expansion-targets", 40)}, nil
 }
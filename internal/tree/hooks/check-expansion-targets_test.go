@@ -31,7 +31,7 @@ func TestCheckExpansionTargetsDrop(t *testing.T) {
 		},
 	}
 
-	if err := hook.Run(g, dg); err != nil {
+	if err := hook.Run(mdb, g, dg); err != nil {
 		t.Error("Spec error - please trace hook")
 	}
 }
@@ -56,7 +56,7 @@ func TestCheckExpansionTargetsBad(t *testing.T) {
 		},
 	}
 
-	if err := hook.Run(g, dg); err != db.ErrUnknownGroup {
+	if err := hook.Run(mdb, g, dg); err != db.ErrUnknownGroup {
 		t.Error("Spec error - please trace hook")
 	}
 }
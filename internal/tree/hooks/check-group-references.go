@@ -0,0 +1,60 @@
+package hooks
+
+import (
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+// CheckGroupReferences refuses to destroy a group that still has
+// direct members, is the target of an expansion, or manages another
+// group.
+type CheckGroupReferences struct {
+	tree.BaseHook
+}
+
+// Run returns a tree.ReferenceError listing every entity and group
+// that refers to g, if there are any.
+func (c *CheckGroupReferences) Run(tx tree.Txn, g, dg *pb.Group) error {
+	var refs []string
+
+	members, err := directMembers(tx, g.GetName())
+	if err != nil {
+		return err
+	}
+	for _, e := range members {
+		refs = append(refs, "entity "+e.GetID()+" (member)")
+	}
+
+	groups, err := otherGroups(tx, g.GetName())
+	if err != nil {
+		return err
+	}
+	for _, o := range groups {
+		for _, exp := range expansionsOf(o, g.GetName()) {
+			refs = append(refs, "group "+o.GetName()+" (expansion "+exp+")")
+		}
+		if o.GetManagedBy() == g.GetName() {
+			refs = append(refs, "group "+o.GetName()+" (managed by)")
+		}
+	}
+
+	if len(refs) > 0 {
+		return &tree.ReferenceError{References: refs}
+	}
+	return nil
+}
+
+func init() {
+	startup.RegisterCallback(checkGroupReferencesCB)
+}
+
+func checkGroupReferencesCB() {
+	tree.RegisterGroupHookConstructor("check-group-references", NewCheckGroupReferences)
+}
+
+// NewCheckGroupReferences returns a configured hook, ready for use.
+func NewCheckGroupReferences(c tree.RefContext) (tree.GroupHook, error) {
+	return &CheckGroupReferences{tree.NewBaseHook("check-group-references", 40)}, nil
+}
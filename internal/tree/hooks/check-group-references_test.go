@@ -0,0 +1,74 @@
+package hooks
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/db"
+	_ "github.com/netauth/netauth/internal/db/memory"
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+// newReferenceDB returns a database in which the group "target" is
+// referenced in every way that a group can be.
+func newReferenceDB(t *testing.T) *db.DB {
+	startup.DoCallbacks()
+
+	mdb, err := db.New("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groups := []*pb.Group{
+		{Name: proto.String("target")},
+		{Name: proto.String("other")},
+		{Name: proto.String("parent"), Expansions: []string{"INCLUDE:target", "EXCLUDE:other"}},
+		{Name: proto.String("managed"), ManagedBy: proto.String("target")},
+	}
+	for _, g := range groups {
+		if err := mdb.SaveGroup(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entities := []*pb.Entity{
+		{ID: proto.String("member"), Meta: &pb.EntityMeta{Groups: []string{"target", "other"}}},
+		{ID: proto.String("bystander"), Meta: &pb.EntityMeta{Groups: []string{"other"}}},
+	}
+	for _, e := range entities {
+		if err := mdb.SaveEntity(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return mdb
+}
+
+func TestCheckGroupReferences(t *testing.T) {
+	mdb := newReferenceDB(t)
+
+	hook, err := NewCheckGroupReferences(tree.RefContext{DB: mdb})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = hook.Run(mdb, &pb.Group{Name: proto.String("target")}, &pb.Group{})
+	rerr, ok := err.(*tree.ReferenceError)
+	if !ok {
+		t.Fatalf("Got %v; Want a ReferenceError", err)
+	}
+	if len(rerr.References) != 3 {
+		t.Errorf("Got references %v; Want 3", rerr.References)
+	}
+
+	if err := hook.Run(mdb, &pb.Group{Name: proto.String("managed")}, &pb.Group{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckGroupReferencesCB(t *testing.T) {
+	checkGroupReferencesCB()
+}
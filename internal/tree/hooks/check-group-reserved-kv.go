@@ -0,0 +1,33 @@
+package hooks
+
+import (
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+// CheckGroupReservedKV stops the KV chains from changing KV data
+// that the server maintains itself.
+type CheckGroupReservedKV struct {
+	tree.BaseHook
+}
+
+// Run returns tree.ErrReservedKey if any of the KV data in dg is
+// stored under a reserved key.
+func (*CheckGroupReservedKV) Run(_ tree.Txn, g, dg *pb.Group) error {
+	return checkReservedKV(dg.GetKV())
+}
+
+func init() {
+	startup.RegisterCallback(checkGroupReservedKVCB)
+}
+
+func checkGroupReservedKVCB() {
+	tree.RegisterGroupHookConstructor("check-reserved-kv", NewCheckGroupReservedKV)
+}
+
+// NewCheckGroupReservedKV returns a configured hook for use.
+func NewCheckGroupReservedKV(c tree.RefContext) (tree.GroupHook, error) {
+	return &CheckGroupReservedKV{tree.NewBaseHook("check-reserved-kv", 40)}, nil
+}
@@ -0,0 +1,29 @@
+package hooks
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestCheckGroupReservedKV(t *testing.T) {
+	hook, err := NewCheckGroupReservedKV(tree.RefContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := hook.Run(nil, &pb.Group{}, &pb.Group{KV: []*pb.KVData{{Key: proto.String(tree.GroupRuleKey)}}}); err != nil {
+		t.Errorf("Got %v; Want nil", err)
+	}
+	if err := hook.Run(nil, &pb.Group{}, &pb.Group{KV: []*pb.KVData{{Key: proto.String(tree.NumberPoolKey)}}}); err != tree.ErrReservedKey {
+		t.Errorf("Got %v; Want %v", err, tree.ErrReservedKey)
+	}
+}
+
+func TestCheckGroupReservedKVCB(t *testing.T) {
+	checkGroupReservedKVCB()
+}
@@ -0,0 +1,46 @@
+package hooks
+
+import (
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+// CheckGroupRule checks that a rule for a dynamic group can be
+// parsed before it is stored.
+type CheckGroupRule struct {
+	tree.BaseHook
+}
+
+// Run checks each value of the KV data in dg that is stored under
+// tree.GroupRuleKey.  A dynamic group has exactly one rule, and a
+// rule that can't be parsed results in db.ErrBadRule.
+func (cgr *CheckGroupRule) Run(_ tree.Txn, g, dg *pb.Group) error {
+	for _, kv := range dg.GetKV() {
+		if kv.GetKey() != tree.GroupRuleKey {
+			continue
+		}
+		if len(kv.GetValues()) != 1 {
+			return db.ErrBadRule
+		}
+		if _, err := db.ParseRule(kv.GetValues()[0].GetValue()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	startup.RegisterCallback(checkGroupRuleCB)
+}
+
+func checkGroupRuleCB() {
+	tree.RegisterGroupHookConstructor("check-group-rule", NewCheckGroupRule)
+}
+
+// NewCheckGroupRule returns a configured hook for use.
+func NewCheckGroupRule(c tree.RefContext) (tree.GroupHook, error) {
+	return &CheckGroupRule{tree.NewBaseHook("check-group-rule", 40)}, nil
+}
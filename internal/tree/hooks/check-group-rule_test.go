@@ -0,0 +1,48 @@
+package hooks
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestCheckGroupRule(t *testing.T) {
+	hook, err := NewCheckGroupRule(tree.RefContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rule := func(values ...string) *pb.Group {
+		kv := &pb.KVData{Key: proto.String(tree.GroupRuleKey)}
+		for _, v := range values {
+			kv.Values = append(kv.Values, &pb.KVValue{Value: proto.String(v)})
+		}
+		return &pb.Group{KV: []*pb.KVData{kv}}
+	}
+
+	cases := []struct {
+		dg      *pb.Group
+		wantErr error
+	}{
+		{rule("kv.location:berlin -meta.Locked:true"), nil},
+		{rule("kv.location:berlin OR kv.location:paris"), db.ErrBadRule},
+		{rule("kv.location:berlin", "kv.location:paris"), db.ErrBadRule},
+		{rule(), db.ErrBadRule},
+		{&pb.Group{KV: []*pb.KVData{{Key: proto.String("location")}}}, nil},
+	}
+
+	for i, c := range cases {
+		if err := hook.Run(nil, &pb.Group{}, c.dg); err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+	}
+}
+
+func TestCheckGroupRuleCB(t *testing.T) {
+	checkGroupRuleCB()
+}
@@ -0,0 +1,33 @@
+package hooks
+
+import (
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+// CheckGroupScope checks that scoped capabilities can be parsed
+// before they are stored on a group.
+type CheckGroupScope struct {
+	tree.BaseHook
+}
+
+// Run checks each value of the KV data in dg that is stored under
+// tree.ScopeKey, and returns tree.ErrBadScope if any can't be parsed.
+func (*CheckGroupScope) Run(_ tree.Txn, g, dg *pb.Group) error {
+	return checkScopes(dg.GetKV())
+}
+
+func init() {
+	startup.RegisterCallback(checkGroupScopeCB)
+}
+
+func checkGroupScopeCB() {
+	tree.RegisterGroupHookConstructor("check-scope", NewCheckGroupScope)
+}
+
+// NewCheckGroupScope returns a configured hook for use.
+func NewCheckGroupScope(c tree.RefContext) (tree.GroupHook, error) {
+	return &CheckGroupScope{tree.NewBaseHook("check-scope", 40)}, nil
+}
@@ -0,0 +1,27 @@
+package hooks
+
+import (
+	"testing"
+
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestCheckGroupScope(t *testing.T) {
+	hook, err := NewCheckGroupScope(tree.RefContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := hook.Run(nil, &pb.Group{}, &pb.Group{KV: scopeKV("MODIFY_GROUP_META:team-infra")}); err != nil {
+		t.Errorf("Got %v; Want nil", err)
+	}
+	if err := hook.Run(nil, &pb.Group{}, &pb.Group{KV: scopeKV("GLOBAL_ROOT:team-infra")}); err != tree.ErrBadScope {
+		t.Errorf("Got %v; Want %v", err, tree.ErrBadScope)
+	}
+}
+
+func TestCheckGroupScopeCB(t *testing.T) {
+	checkGroupScopeCB()
+}
@@ -20,7 +20,7 @@ type CheckImmediateExpansions struct {
 // to each expansion in dg.  Excepting the case of an expansion type
 // of DROP, which is unchecked, any matching expansion will result in
 // an ErrExistingExpansion being returned.
-func (cie *CheckImmediateExpansions) Run(g, dg *pb.Group) error {
+func (cie *CheckImmediateExpansions) Run(_ tree.Txn, g, dg *pb.Group) error {
 	existing := g.GetExpansions()
 	proposed := dg.GetExpansions()
 	for i := range proposed {
@@ -26,7 +26,7 @@ func TestCheckImmediateExpansionsDrop(t *testing.T) {
 		},
 	}
 
-	if err := hook.Run(g, dg); err != nil {
+	if err := hook.Run(nil, g, dg); err != nil {
 		t.Error("Spec error - please trace hook")
 	}
 }
@@ -49,7 +49,7 @@ func TestCheckImmediateExpansionsExisting(t *testing.T) {
 		},
 	}
 
-	if err := hook.Run(g, dg); err != tree.ErrExistingExpansion {
+	if err := hook.Run(nil, g, dg); err != tree.ErrExistingExpansion {
 		t.Error("Spec error - please trace hook")
 	}
 }
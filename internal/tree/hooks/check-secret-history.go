@@ -0,0 +1,101 @@
+package hooks
+
+import (
+	"encoding/json"
+
+	"github.com/spf13/viper"
+
+	"github.com/netauth/netauth/internal/crypto"
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+// CheckSecretHistory prevents an entity from choosing a secret that
+// it has had recently, and remembers the secret that is being
+// replaced.
+type CheckSecretHistory struct {
+	tree.BaseHook
+	crypto.EMCrypto
+
+	depth int
+}
+
+// Run compares the plaintext secret in de with the current secret of
+// e and with those kept in the history, and returns a
+// tree.SecretPolicyError if it matches any of them.  Otherwise the
+// current secret is added to the history, which is trimmed so that
+// together with the new secret the last depth secrets are known.
+// With a depth of zero nothing is checked or kept, but any history
+// that exists is left alone.
+func (c *CheckSecretHistory) Run(tx tree.Txn, e, de *pb.Entity) error {
+	if c.depth <= 0 {
+		return nil
+	}
+
+	b := tx.Bucket(tree.SecretHistoryBucket)
+	hist, err := loadSecretHistory(b, e.GetID())
+	if err != nil {
+		return err
+	}
+	if e.GetSecret() != "" {
+		hist = append([]string{e.GetSecret()}, hist...)
+	}
+
+	for _, h := range hist {
+		if c.VerifySecret(de.GetSecret(), h) == nil {
+			return &tree.SecretPolicyError{Reasons: []string{"it has been used recently"}}
+		}
+	}
+
+	if len(hist) > c.depth-1 {
+		hist = hist[:c.depth-1]
+	}
+	if len(hist) == 0 {
+		return b.Del(e.GetID())
+	}
+	v, err := json.Marshal(hist)
+	if err != nil {
+		return err
+	}
+	return b.Put(e.GetID(), v)
+}
+
+// loadSecretHistory returns the previous secrets of an entity, most
+// recent first.
+func loadSecretHistory(b *db.Bucket, ID string) ([]string, error) {
+	v, err := b.Get(ID)
+	switch err {
+	case nil:
+	case db.ErrNoValue:
+		return nil, nil
+	default:
+		return nil, err
+	}
+
+	var hist []string
+	if err := json.Unmarshal(v, &hist); err != nil {
+		return nil, err
+	}
+	return hist, nil
+}
+
+func init() {
+	startup.RegisterCallback(checkSecretHistoryCB)
+}
+
+func checkSecretHistoryCB() {
+	tree.RegisterEntityHookConstructor("check-secret-history", NewCheckSecretHistory)
+}
+
+// NewCheckSecretHistory returns a hook that keeps as many secrets as
+// password.history, including the current one.
+func NewCheckSecretHistory(c tree.RefContext) (tree.EntityHook, error) {
+	return &CheckSecretHistory{
+		BaseHook: tree.NewBaseHook("check-secret-history", 45),
+		EMCrypto: c.Crypto,
+		depth:    viper.GetInt("password.history"),
+	}, nil
+}
@@ -0,0 +1,96 @@
+package hooks
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/viper"
+
+	"github.com/netauth/netauth/internal/crypto/nocrypto"
+	"github.com/netauth/netauth/internal/db"
+	_ "github.com/netauth/netauth/internal/db/memory"
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestCheckSecretHistory(t *testing.T) {
+	startup.DoCallbacks()
+
+	mdb, err := db.New("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	crypt, err := nocrypto.New(hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	viper.Set("password.history", 3)
+	defer viper.Reset()
+	hook, err := NewCheckSecretHistory(tree.RefContext{DB: mdb, Crypto: crypt})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The current secret and the two before it can't be chosen.
+	current := "s1"
+	cases := []struct {
+		secret  string
+		wantErr bool
+	}{
+		{"s1", true},
+		{"s2", false},
+		{"s1", true},
+		{"s3", false},
+		{"s4", false},
+		{"s2", true},
+		{"s1", false},
+	}
+
+	for i, c := range cases {
+		e := &pb.Entity{ID: proto.String("foo"), Secret: proto.String(current)}
+		de := &pb.Entity{ID: proto.String("foo"), Secret: proto.String(c.secret)}
+		err := hook.Run(mdb, e, de)
+		if _, ok := err.(*tree.SecretPolicyError); ok != c.wantErr {
+			t.Errorf("%d: Got %v; Want error: %v", i, err, c.wantErr)
+		}
+		if err == nil {
+			current = c.secret
+		}
+	}
+
+	// Within a chain the history is only written when the
+	// transaction commits.
+	e := &pb.Entity{ID: proto.String("bar"), Secret: proto.String("s1")}
+	tx := mdb.Begin()
+	if err := hook.Run(tx, e, &pb.Entity{Secret: proto.String("s2")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.Bucket(tree.SecretHistoryBucket).Get("bar"); err != db.ErrNoValue {
+		t.Errorf("History was written by an aborted chain: %v", err)
+	}
+
+	// A depth of zero checks nothing and leaves the history alone.
+	viper.Set("password.history", 0)
+	hook, err = NewCheckSecretHistory(tree.RefContext{DB: mdb, Crypto: crypt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e = &pb.Entity{ID: proto.String("foo"), Secret: proto.String(current)}
+	if err := hook.Run(mdb, e, &pb.Entity{Secret: proto.String(current)}); err != nil {
+		t.Errorf("Got %v; Want nil", err)
+	}
+	if _, err := mdb.Bucket(tree.SecretHistoryBucket).Get("foo"); err != nil {
+		t.Errorf("History was removed: %v", err)
+	}
+}
+
+func TestCheckSecretHistoryCB(t *testing.T) {
+	checkSecretHistoryCB()
+}
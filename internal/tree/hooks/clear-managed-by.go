@@ -0,0 +1,47 @@
+package hooks
+
+import (
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+// ClearManagedBy clears the managing group of every group that is
+// managed by a group.
+type ClearManagedBy struct {
+	tree.BaseHook
+}
+
+// Run clears ManagedBy on every other group that is managed by g.
+// Those groups can then only be managed with the relevant
+// capabilities.
+func (c *ClearManagedBy) Run(tx tree.Txn, g, dg *pb.Group) error {
+	groups, err := otherGroups(tx, g.GetName())
+	if err != nil {
+		return err
+	}
+	for _, o := range groups {
+		if o.GetManagedBy() != g.GetName() {
+			continue
+		}
+		o.ManagedBy = nil
+		if err := tx.SaveGroup(o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	startup.RegisterCallback(clearManagedByCB)
+}
+
+func clearManagedByCB() {
+	tree.RegisterGroupHookConstructor("clear-managed-by", NewClearManagedBy)
+}
+
+// NewClearManagedBy returns a configured hook, ready for use.
+func NewClearManagedBy(c tree.RefContext) (tree.GroupHook, error) {
+	return &ClearManagedBy{tree.NewBaseHook("clear-managed-by", 50)}, nil
+}
@@ -0,0 +1,36 @@
+package hooks
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestClearManagedBy(t *testing.T) {
+	mdb := newReferenceDB(t)
+
+	hook, err := NewClearManagedBy(tree.RefContext{DB: mdb})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := hook.Run(mdb, &pb.Group{Name: proto.String("target")}, &pb.Group{}); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := mdb.LoadGroup("managed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.ManagedBy != nil {
+		t.Errorf("Got ManagedBy %q; Want unset", g.GetManagedBy())
+	}
+}
+
+func TestClearManagedByCB(t *testing.T) {
+	clearManagedByCB()
+}
@@ -18,7 +18,6 @@ import (
 // happens.
 type CreateEntityIfMissing struct {
 	tree.BaseHook
-	tree.DB
 	crypto.EMCrypto
 }
 
@@ -26,8 +25,8 @@ type CreateEntityIfMissing struct {
 // the load returns that the failure is due to an unknown entity, then
 // one will be created.  Any other load failure will result in an
 // error being returned.  Returned errors will be of a db.* type.
-func (c *CreateEntityIfMissing) Run(e, de *pb.Entity) error {
-	le, err := c.LoadEntity(de.GetID())
+func (c *CreateEntityIfMissing) Run(tx tree.Txn, e, de *pb.Entity) error {
+	le, err := tx.LoadEntity(de.GetID())
 	switch err {
 	case nil:
 		proto.Merge(e, le)
@@ -42,7 +41,7 @@ func (c *CreateEntityIfMissing) Run(e, de *pb.Entity) error {
 		return err
 	}
 
-	n, err := c.NextEntityNumber()
+	n, err := tx.NextEntityNumber()
 	if err != nil {
 		return err
 	}
@@ -72,5 +71,5 @@ func createEntityIfMissingCB() {
 // NewCreateEntityIfMissing returns an initialized hook for use during
 // tree initialization.
 func NewCreateEntityIfMissing(c tree.RefContext) (tree.EntityHook, error) {
-	return &CreateEntityIfMissing{tree.NewBaseHook("create-entity-if-missing", 1), c.DB, c.Crypto}, nil
+	return &CreateEntityIfMissing{tree.NewBaseHook("create-entity-if-missing", 1), c.Crypto}, nil
 }
@@ -44,7 +44,7 @@ func TestLoadExisting(t *testing.T) {
 		ID: proto.String("foo"),
 	}
 
-	if err := hook.Run(e, de); err != nil {
+	if err := hook.Run(mdb, e, de); err != nil {
 		t.Fatal(err)
 	}
 
@@ -82,7 +82,7 @@ func TestCreateNew(t *testing.T) {
 		Secret: proto.String("foo"),
 	}
 
-	if err := hook.Run(e, de); err != nil {
+	if err := hook.Run(mdb, e, de); err != nil {
 		t.Fatal(err)
 	}
 	if e.GetID() != "foo" {
@@ -37,12 +37,12 @@ func TestDestroyEntity(t *testing.T) {
 	}
 
 	// Act as though a delete was requested normally
-	if err := hook.Run(&pb.Entity{}, &pb.Entity{ID: proto.String("foo")}); err != nil {
+	if err := hook.Run(mdb, &pb.Entity{}, &pb.Entity{ID: proto.String("foo")}); err != nil {
 		t.Fatal(err)
 	}
 
 	// Act as though deleting an entity at the end of a pipeline
-	if err := hook.Run(&pb.Entity{ID: proto.String("bar")}, &pb.Entity{}); err != nil {
+	if err := hook.Run(mdb, &pb.Entity{ID: proto.String("bar")}, &pb.Entity{}); err != nil {
 		t.Fatal(err)
 	}
 }
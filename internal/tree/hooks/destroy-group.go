@@ -10,19 +10,18 @@ import (
 // DestroyGroup removes an entity from the system.
 type DestroyGroup struct {
 	tree.BaseHook
-	tree.DB
 }
 
 // Run will request the underlying datastore to remove the group,
 // returning any status provided.  If the group Name is not specified
 // in g, it will be obtained from dg.
-func (d *DestroyGroup) Run(g, dg *pb.Group) error {
+func (d *DestroyGroup) Run(tx tree.Txn, g, dg *pb.Group) error {
 	// This hook is somewhat special since it might be called
 	// after a processing pipeline, or just to remove a group.
 	if g.GetName() == "" {
 		g.Name = dg.Name
 	}
-	return d.DeleteGroup(g.GetName())
+	return tx.DeleteGroup(g.GetName())
 }
 
 func init() {
@@ -35,5 +34,5 @@ func destroyGroupCB() {
 
 // NewDestroyGroup returns an initialized DestroyGroup hook for use.
 func NewDestroyGroup(c tree.RefContext) (tree.GroupHook, error) {
-	return &DestroyGroup{tree.NewBaseHook("destroy-group", 99), c.DB}, nil
+	return &DestroyGroup{tree.NewBaseHook("destroy-group", 99)}, nil
 }
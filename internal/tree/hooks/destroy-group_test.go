@@ -37,12 +37,12 @@ func TestDestroyGroup(t *testing.T) {
 	}
 
 	// Act as though a delete was requested normally
-	if err := hook.Run(&pb.Group{}, &pb.Group{Name: proto.String("foo")}); err != nil {
+	if err := hook.Run(mdb, &pb.Group{}, &pb.Group{Name: proto.String("foo")}); err != nil {
 		t.Fatal(err)
 	}
 
 	// Act as though deleting an entity at the end of a pipeline
-	if err := hook.Run(&pb.Group{Name: proto.String("bar")}, &pb.Group{}); err != nil {
+	if err := hook.Run(mdb, &pb.Group{Name: proto.String("bar")}, &pb.Group{}); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -1,6 +1,8 @@
 package hooks
 
 import (
+	"time"
+
 	"github.com/netauth/netauth/internal/tree"
 	"github.com/netauth/netauth/internal/tree/util"
 
@@ -16,12 +18,24 @@ type DirectGroupManager struct {
 
 // Run iterates through all groups in de.Meta.Groups and adds or
 // removes them from e.Meta.Groups based on the value of dgm.mode.
-// True will add groups, false will remove them.
-func (dgm *DirectGroupManager) Run(e, de *pb.Entity) error {
+// True will add groups, false will remove them.  Added groups expire
+// at the time passed under tree.MembershipExpiryKey, or never if
+// there isn't one, and removed groups no longer expire.
+func (dgm *DirectGroupManager) Run(_ tree.Txn, e, de *pb.Entity) error {
+	var until time.Time
+	if s := instruction(de.GetMeta().GetKV(), tree.MembershipExpiryKey); s != "" && dgm.mode {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return tree.ErrFailedPrecondition
+		}
+		until = t
+	}
+
 	groups := de.GetMeta().GetGroups()
 	for i := range groups {
 		// Patch the group list and match groups exactly.
 		e.Meta.Groups = util.PatchStringSlice(e.Meta.Groups, groups[i], dgm.mode, true)
+		tree.SetMembershipExpiry(e, groups[i], until)
 	}
 	return nil
 }
@@ -3,6 +3,9 @@ package hooks
 import (
 	"sort"
 	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
 
 	"github.com/netauth/netauth/internal/tree"
 
@@ -26,7 +29,7 @@ func TestAddDirectGroup(t *testing.T) {
 		},
 	}
 
-	if err := hook.Run(e, de); err != nil {
+	if err := hook.Run(nil, e, de); err != nil {
 		t.Fatal(err)
 	}
 
@@ -59,7 +62,7 @@ func TestDelDirectGroup(t *testing.T) {
 		},
 	}
 
-	if err := hook.Run(e, de); err != nil {
+	if err := hook.Run(nil, e, de); err != nil {
 		t.Fatal(err)
 	}
 
@@ -68,3 +71,45 @@ func TestDelDirectGroup(t *testing.T) {
 		t.Error("Spec error - please trace hook")
 	}
 }
+
+func TestAddDirectGroupUntil(t *testing.T) {
+	add, err := NewAddDirectGroup(tree.RefContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	del, err := NewDelDirectGroup(tree.RefContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	until := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	e := &pb.Entity{Meta: &pb.EntityMeta{}}
+	de := &pb.Entity{
+		Meta: &pb.EntityMeta{
+			Groups: []string{"group1"},
+			KV: []*pb.KVData{{
+				Key:    proto.String(tree.MembershipExpiryKey),
+				Values: []*pb.KVValue{{Value: proto.String(until.Format(time.RFC3339))}},
+			}},
+		},
+	}
+	if err := add.Run(nil, e, de); err != nil {
+		t.Fatal(err)
+	}
+	if got := tree.MembershipExpiry(e); len(got) != 1 || !got["group1"].Equal(until) {
+		t.Errorf("Got %v; Want group1 until %v", got, until)
+	}
+
+	// Removing the membership also removes its expiry.
+	if err := del.Run(nil, e, &pb.Entity{Meta: &pb.EntityMeta{Groups: []string{"group1"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.GetMeta().GetGroups()) != 0 || len(e.GetMeta().GetKV()) != 0 {
+		t.Errorf("Got %v; Want no groups or KV", e)
+	}
+
+	de.Meta.KV[0].Values[0].Value = proto.String("tomorrow")
+	if err := add.Run(nil, e, de); err != tree.ErrFailedPrecondition {
+		t.Errorf("Got %v; Want %v", err, tree.ErrFailedPrecondition)
+	}
+}
@@ -15,7 +15,7 @@ type EnsureEntityMeta struct {
 
 // Run will apply an empty metadata struct if one is not already
 // present.
-func (*EnsureEntityMeta) Run(e, de *pb.Entity) error {
+func (*EnsureEntityMeta) Run(_ tree.Txn, e, de *pb.Entity) error {
 	if e.Meta == nil {
 		e.Meta = &pb.EntityMeta{}
 	}
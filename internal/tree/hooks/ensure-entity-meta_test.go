@@ -15,7 +15,7 @@ func TestEnsureEntityMeta(t *testing.T) {
 	}
 
 	e := &pb.Entity{}
-	if err := hook.Run(e, &pb.Entity{}); err != nil {
+	if err := hook.Run(nil, e, &pb.Entity{}); err != nil {
 		t.Fatal(err)
 	}
 
@@ -19,7 +19,7 @@ type ManageEntityCapabilities struct {
 // value of the mode variable.  When the mode is set to true, any
 // capabilities stored in de will be copied to e if they are not
 // already present.  In false capabilities will be subtracted.
-func (mec *ManageEntityCapabilities) Run(e, de *pb.Entity) error {
+func (mec *ManageEntityCapabilities) Run(_ tree.Txn, e, de *pb.Entity) error {
 	if de.Meta == nil || len(de.Meta.Capabilities) == 0 {
 		return tree.ErrUnknownCapability
 	}
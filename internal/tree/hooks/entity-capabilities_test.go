@@ -17,7 +17,7 @@ func TestEntityCapabilitiesEmptyList(t *testing.T) {
 	g := &pb.Entity{}
 	dg := &pb.Entity{}
 
-	if err := hook.Run(g, dg); err != tree.ErrUnknownCapability {
+	if err := hook.Run(nil, g, dg); err != tree.ErrUnknownCapability {
 		t.Fatal(err)
 	}
 }
@@ -39,7 +39,7 @@ func TestAddEntityCapabilities(t *testing.T) {
 		},
 	}
 
-	if err := hook.Run(e, de); err != nil {
+	if err := hook.Run(nil, e, de); err != nil {
 		t.Fatal(err)
 	}
 
@@ -72,7 +72,7 @@ func TestRemoveEntityCapabilities(t *testing.T) {
 		},
 	}
 
-	if err := hook.Run(e, de); err != nil {
+	if err := hook.Run(nil, e, de); err != nil {
 		t.Fatal(err)
 	}
 
@@ -16,7 +16,7 @@ type ManageEntityKeys struct {
 
 // Run iterates on all keys in the request and adds or removes them
 // from the entity's keystore.
-func (mek *ManageEntityKeys) Run(e, de *pb.Entity) error {
+func (mek *ManageEntityKeys) Run(_ tree.Txn, e, de *pb.Entity) error {
 	for _, k := range de.Meta.Keys {
 		e.Meta.Keys = util.PatchStringSlice(e.Meta.Keys, k, mek.mode, false)
 	}
@@ -21,7 +21,7 @@ func TestAddEntityKey(t *testing.T) {
 		},
 	}
 
-	if err := hook.Run(e, de); err != nil {
+	if err := hook.Run(nil, e, de); err != nil {
 		t.Fatal(err)
 	}
 
@@ -47,7 +47,7 @@ func TestDelEntityKey(t *testing.T) {
 		},
 	}
 
-	if err := hook.Run(e, de); err != nil {
+	if err := hook.Run(nil, e, de); err != nil {
 		t.Fatal(err)
 	}
 
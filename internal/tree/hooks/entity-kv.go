@@ -20,7 +20,7 @@ type EntityKV struct {
 
 // Run proxies to the do function which is set based on what the hook
 // is supposed to do.
-func (ekv *EntityKV) Run(e, de *pb.Entity) error {
+func (ekv *EntityKV) Run(_ tree.Txn, e, de *pb.Entity) error {
 	return ekv.do(e, de)
 }
 
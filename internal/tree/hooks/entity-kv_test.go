@@ -93,7 +93,7 @@ func TestEntityKVAdd(t *testing.T) {
 	h, _ := newEntityKVAdd(tree.RefContext{})
 
 	for i, c := range cases {
-		if err := h.Run(c.e, c.de); err != c.wantErr {
+		if err := h.Run(nil, c.e, c.de); err != c.wantErr {
 			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
 		}
 	}
@@ -183,7 +183,7 @@ func TestEntityKVDel(t *testing.T) {
 	h, _ := newEntityKVDel(tree.RefContext{})
 
 	for i, c := range cases {
-		if err := h.Run(c.e, c.de); err != c.wantErr {
+		if err := h.Run(nil, c.e, c.de); err != c.wantErr {
 			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
 		}
 	}
@@ -257,7 +257,7 @@ func TestEntityKVReplace(t *testing.T) {
 	h, _ := newEntityKVReplace(tree.RefContext{})
 
 	for i, c := range cases {
-		if err := h.Run(c.e, c.de); err != c.wantErr {
+		if err := h.Run(nil, c.e, c.de); err != c.wantErr {
 			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
 		}
 	}
@@ -18,7 +18,7 @@ type EntityLockManager struct {
 
 // Run will set the entity lock status unconditionally to the
 // configured value for the instantiated hook.
-func (elm *EntityLockManager) Run(e, de *pb.Entity) error {
+func (elm *EntityLockManager) Run(_ tree.Txn, e, de *pb.Entity) error {
 	e.Meta.Locked = proto.Bool(elm.lockstate)
 	return nil
 }
@@ -19,7 +19,7 @@ func TestEntityLock(t *testing.T) {
 	e := &pb.Entity{Meta: &pb.EntityMeta{}}
 	de := &pb.Entity{}
 
-	if err := hook.Run(e, de); err != nil {
+	if err := hook.Run(nil, e, de); err != nil {
 		t.Fatal(err)
 	}
 
@@ -37,7 +37,7 @@ func TestEntityUnLock(t *testing.T) {
 	e := &pb.Entity{Meta: &pb.EntityMeta{Locked: proto.Bool(true)}}
 	de := &pb.Entity{}
 
-	if err := hook.Run(e, de); err != nil {
+	if err := hook.Run(nil, e, de); err != nil {
 		t.Fatal(err)
 	}
 
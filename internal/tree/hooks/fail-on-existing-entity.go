@@ -12,14 +12,13 @@ import (
 // duplicate ID already exists.
 type FailOnExistingEntity struct {
 	tree.BaseHook
-	tree.DB
 }
 
 // Run contacts the data store, attempts to load an entity and
 // selectively inverts the return status from the load call (errors
 // from the storage backend will be returned to the caller).
-func (l *FailOnExistingEntity) Run(e, de *pb.Entity) error {
-	_, err := l.LoadEntity(de.GetID())
+func (l *FailOnExistingEntity) Run(tx tree.Txn, e, de *pb.Entity) error {
+	_, err := tx.LoadEntity(de.GetID())
 	if err == nil {
 		return tree.ErrDuplicateEntityID
 	}
@@ -37,5 +36,5 @@ func failOnExistingEntityCB() {
 // NewFailOnExistingEntity will return an initialized hook ready for
 // use.
 func NewFailOnExistingEntity(c tree.RefContext) (tree.EntityHook, error) {
-	return &FailOnExistingEntity{tree.NewBaseHook("fail-on-existing-entity", 0), c.DB}, nil
+	return &FailOnExistingEntity{tree.NewBaseHook("fail-on-existing-entity", 0)}, nil
 }
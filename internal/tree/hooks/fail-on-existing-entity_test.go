@@ -47,7 +47,7 @@ func TestFailOnExistingEntity(t *testing.T) {
 	for i, c := range cases {
 		e := &pb.Entity{}
 		de := &pb.Entity{ID: &c.ID}
-		if err := hook.Run(e, de); err != c.wantErr {
+		if err := hook.Run(mdb, e, de); err != c.wantErr {
 			t.Errorf("Case %d: Got: %v Want: %v", i, err, c.wantErr)
 		}
 	}
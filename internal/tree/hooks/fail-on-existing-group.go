@@ -11,14 +11,13 @@ import (
 // processes on groups.
 type FailOnExistingGroup struct {
 	tree.BaseHook
-	tree.DB
 }
 
 // Run contacts the datastore and attempts to load the group specified
 // by dg.  If the group loads successfully then an error is returned,
 // in other cases nil is returned.
-func (f *FailOnExistingGroup) Run(g, dg *pb.Group) error {
-	if _, err := f.LoadGroup(dg.GetName()); err == nil {
+func (f *FailOnExistingGroup) Run(tx tree.Txn, g, dg *pb.Group) error {
+	if _, err := tx.LoadGroup(dg.GetName()); err == nil {
 		return tree.ErrDuplicateGroupName
 	}
 	return nil
@@ -34,5 +33,5 @@ func failOnExistingGroupCB() {
 
 // NewFailOnExistingGroup returns an initialized hook ready for use.
 func NewFailOnExistingGroup(c tree.RefContext) (tree.GroupHook, error) {
-	return &FailOnExistingGroup{tree.NewBaseHook("fail-on-existing-group", 0), c.DB}, nil
+	return &FailOnExistingGroup{tree.NewBaseHook("fail-on-existing-group", 0)}, nil
 }
@@ -46,7 +46,7 @@ func TestFailOnExistingGroup(t *testing.T) {
 	for i, c := range cases {
 		g := &pb.Group{}
 		dg := &pb.Group{Name: &c.name}
-		if err := hook.Run(g, dg); err != c.wantErr {
+		if err := hook.Run(mdb, g, dg); err != c.wantErr {
 			t.Errorf("Case %d: Got: %v Want: %v", i, err, c.wantErr)
 		}
 	}
@@ -17,7 +17,7 @@ func TestGroupCapabilitiesEmptyList(t *testing.T) {
 	g := &pb.Group{}
 	dg := &pb.Group{}
 
-	if err := hook.Run(g, dg); err != tree.ErrUnknownCapability {
+	if err := hook.Run(nil, g, dg); err != tree.ErrUnknownCapability {
 		t.Fatal(err)
 	}
 }
@@ -37,7 +37,7 @@ func TestAddGroupCapabilities(t *testing.T) {
 		},
 	}
 
-	if err := hook.Run(g, dg); err != nil {
+	if err := hook.Run(nil, g, dg); err != nil {
 		t.Fatal(err)
 	}
 
@@ -66,7 +66,7 @@ func TestRemoveGroupCapabilities(t *testing.T) {
 		},
 	}
 
-	if err := hook.Run(g, dg); err != nil {
+	if err := hook.Run(nil, g, dg); err != nil {
 		t.Fatal(err)
 	}
 
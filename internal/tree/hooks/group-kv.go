@@ -20,7 +20,7 @@ type GroupKV struct {
 
 // Run proxies to the do function which is set based on what the hook
 // is supposed to do.
-func (ekv *GroupKV) Run(g, dg *pb.Group) error {
+func (ekv *GroupKV) Run(_ tree.Txn, g, dg *pb.Group) error {
 	return ekv.do(g, dg)
 }
 
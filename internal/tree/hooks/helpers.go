@@ -1,8 +1,11 @@
 package hooks
 
 import (
+	"path"
 	"strings"
 
+	"github.com/netauth/netauth/internal/tree"
+
 	pb "github.com/netauth/protocol"
 )
 
@@ -43,3 +46,82 @@ func delCapability(cap pb.Capability, caps []pb.Capability) []pb.Capability {
 	}
 	return ncaps
 }
+
+// directMembers returns the entities that list the named group
+// among their direct memberships.
+func directMembers(d tree.Txn, name string) ([]*pb.Entity, error) {
+	ids, err := d.DiscoverEntityIDs()
+	if err != nil {
+		return nil, err
+	}
+	var out []*pb.Entity
+	for _, id := range ids {
+		e, err := d.LoadEntity(path.Base(id))
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range e.GetMeta().GetGroups() {
+			if g == name {
+				out = append(out, e)
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+// otherGroups returns every group other than the named one.
+func otherGroups(d tree.Txn, name string) ([]*pb.Group, error) {
+	names, err := d.DiscoverGroupNames()
+	if err != nil {
+		return nil, err
+	}
+	var out []*pb.Group
+	for _, n := range names {
+		if path.Base(n) == name {
+			continue
+		}
+		g, err := d.LoadGroup(path.Base(n))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, g)
+	}
+	return out, nil
+}
+
+// expansionsOf returns the expansions on g that target the named
+// group.
+func expansionsOf(g *pb.Group, name string) []string {
+	var out []string
+	for _, exp := range g.GetExpansions() {
+		parts := strings.SplitN(exp, ":", 2)
+		if len(parts) == 2 && parts[1] == name {
+			out = append(out, exp)
+		}
+	}
+	return out
+}
+
+// contains returns true if s is in the list.
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// instruction returns the value passed to a chain under the given
+// key in the KV data of the data object, or the empty string if there
+// isn't one.
+func instruction(kv []*pb.KVData, key string) string {
+	for _, d := range kv {
+		if d.GetKey() != key || len(d.GetValues()) != 1 {
+			continue
+		}
+		return d.GetValues()[0].GetValue()
+	}
+	return ""
+}
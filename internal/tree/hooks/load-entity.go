@@ -12,19 +12,18 @@ import (
 // LoadEntity loads an entity from the database.
 type LoadEntity struct {
 	tree.BaseHook
-	tree.DB
 }
 
 // Run attempts to load the entity specified by de.ID and if
 // successful performs a deepcopy into the address pointed to by e.
 // Any errors returned will be from the data storage layer.
-func (l *LoadEntity) Run(e, de *pb.Entity) error {
+func (l *LoadEntity) Run(tx tree.Txn, e, de *pb.Entity) error {
 	// This is a bit odd because we only get an address for e, not
 	// the ability to point it somewhere else, so anything we want
 	// to do that alters the initial contents needs to be copied
 	// in.
 
-	le, err := l.LoadEntity(de.GetID())
+	le, err := tx.LoadEntity(de.GetID())
 	if err != nil {
 		return err
 	}
@@ -43,5 +42,5 @@ func loadEntityCB() {
 
 // NewLoadEntity returns an initialized hook ready for use.
 func NewLoadEntity(c tree.RefContext) (tree.EntityHook, error) {
-	return &LoadEntity{tree.NewBaseHook("load-entity", 0), c.DB}, nil
+	return &LoadEntity{tree.NewBaseHook("load-entity", 0)}, nil
 }
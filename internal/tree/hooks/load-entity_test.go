@@ -44,7 +44,7 @@ func TestLoadEntity(t *testing.T) {
 	}
 
 	for i, c := range cases {
-		if err := hook.Run(&pb.Entity{}, &pb.Entity{ID: &c.ID}); err != c.wantErr {
+		if err := hook.Run(memdb, &pb.Entity{}, &pb.Entity{ID: &c.ID}); err != c.wantErr {
 			t.Errorf("Case %d: Got %v Want %v", i, err, c.wantErr)
 		}
 	}
@@ -43,7 +43,7 @@ func TestLoadGroup(t *testing.T) {
 	}
 
 	for i, c := range cases {
-		if err := hook.Run(&pb.Group{}, &pb.Group{Name: &c.Name}); err != c.wantErr {
+		if err := hook.Run(memdb, &pb.Group{}, &pb.Group{Name: &c.Name}); err != c.wantErr {
 			t.Errorf("Case %d: Got %v Want %v", i, err, c.wantErr)
 		}
 	}
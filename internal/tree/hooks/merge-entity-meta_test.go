@@ -24,7 +24,7 @@ func TestMergeEntityMeta(t *testing.T) {
 		},
 	}
 
-	if err := hook.Run(e, de); err != nil {
+	if err := hook.Run(nil, e, de); err != nil {
 		t.Fatal(err)
 	}
 
@@ -22,7 +22,7 @@ func TestMergeGroupMeta(t *testing.T) {
 		DisplayName: proto.String("Some Group"),
 	}
 
-	if err := hook.Run(g, dg); err != nil {
+	if err := hook.Run(nil, g, dg); err != nil {
 		t.Fatal(err)
 	}
 
@@ -20,7 +20,7 @@ type PatchGroupExpansions struct {
 
 // Run iterates through the expansions in dg and applies them to g.
 // DROP expansions are processed with fuzzy group name matching.
-func (*PatchGroupExpansions) Run(g, dg *pb.Group) error {
+func (*PatchGroupExpansions) Run(_ tree.Txn, g, dg *pb.Group) error {
 	exps := dg.GetExpansions()
 	for i := range exps {
 		parts := strings.SplitN(exps[i], ":", 2)
@@ -21,7 +21,7 @@ func TestPatchGroupExpansionsInclude(t *testing.T) {
 		},
 	}
 
-	if err := hook.Run(g, dg); err != nil {
+	if err := hook.Run(nil, g, dg); err != nil {
 		t.Fatal(err)
 	}
 
@@ -48,7 +48,7 @@ func TestPatchGroupExpansionsDrop(t *testing.T) {
 		},
 	}
 
-	if err := hook.Run(g, dg); err != nil {
+	if err := hook.Run(nil, g, dg); err != nil {
 		t.Fatal(err)
 	}
 
@@ -0,0 +1,53 @@
+package hooks
+
+import (
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+// PruneGroupExpansions removes every expansion that targets a group
+// from the other groups.
+type PruneGroupExpansions struct {
+	tree.BaseHook
+}
+
+// Run removes the INCLUDE and EXCLUDE expansions of g from every
+// other group.
+func (p *PruneGroupExpansions) Run(tx tree.Txn, g, dg *pb.Group) error {
+	groups, err := otherGroups(tx, g.GetName())
+	if err != nil {
+		return err
+	}
+	for _, o := range groups {
+		drop := expansionsOf(o, g.GetName())
+		if len(drop) == 0 {
+			continue
+		}
+		var keep []string
+		for _, exp := range o.GetExpansions() {
+			if !contains(drop, exp) {
+				keep = append(keep, exp)
+			}
+		}
+		o.Expansions = keep
+		if err := tx.SaveGroup(o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	startup.RegisterCallback(pruneGroupExpansionsCB)
+}
+
+func pruneGroupExpansionsCB() {
+	tree.RegisterGroupHookConstructor("prune-group-expansions", NewPruneGroupExpansions)
+}
+
+// NewPruneGroupExpansions returns a configured hook, ready for use.
+func NewPruneGroupExpansions(c tree.RefContext) (tree.GroupHook, error) {
+	return &PruneGroupExpansions{tree.NewBaseHook("prune-group-expansions", 50)}, nil
+}
@@ -0,0 +1,36 @@
+package hooks
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestPruneGroupExpansions(t *testing.T) {
+	mdb := newReferenceDB(t)
+
+	hook, err := NewPruneGroupExpansions(tree.RefContext{DB: mdb})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := hook.Run(mdb, &pb.Group{Name: proto.String("target")}, &pb.Group{}); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := mdb.LoadGroup("parent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := g.GetExpansions(); len(exp) != 1 || exp[0] != "EXCLUDE:other" {
+		t.Errorf("Got expansions %v; Want [EXCLUDE:other]", exp)
+	}
+}
+
+func TestPruneGroupExpansionsCB(t *testing.T) {
+	pruneGroupExpansionsCB()
+}
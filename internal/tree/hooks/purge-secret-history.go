@@ -0,0 +1,33 @@
+package hooks
+
+import (
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+// PurgeSecretHistory forgets the previous secrets of an entity.
+type PurgeSecretHistory struct {
+	tree.BaseHook
+}
+
+// Run removes the secret history of e, so that an entity created
+// later with the same ID does not inherit it.
+func (p *PurgeSecretHistory) Run(tx tree.Txn, e, de *pb.Entity) error {
+	return tx.Bucket(tree.SecretHistoryBucket).Del(e.GetID())
+}
+
+func init() {
+	startup.RegisterCallback(purgeSecretHistoryCB)
+}
+
+func purgeSecretHistoryCB() {
+	tree.RegisterEntityHookConstructor("purge-secret-history", NewPurgeSecretHistory)
+}
+
+// NewPurgeSecretHistory returns a PurgeSecretHistory hook ready for
+// use.
+func NewPurgeSecretHistory(c tree.RefContext) (tree.EntityHook, error) {
+	return &PurgeSecretHistory{tree.NewBaseHook("purge-secret-history", 90)}, nil
+}
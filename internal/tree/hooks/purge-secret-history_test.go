@@ -0,0 +1,49 @@
+package hooks
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/db"
+	_ "github.com/netauth/netauth/internal/db/memory"
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestPurgeSecretHistory(t *testing.T) {
+	startup.DoCallbacks()
+
+	mdb, err := db.New("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hook, err := NewPurgeSecretHistory(tree.RefContext{DB: mdb})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := mdb.Bucket(tree.SecretHistoryBucket)
+	if err := b.Put("foo", []byte(`["old"]`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := hook.Run(mdb, &pb.Entity{ID: proto.String("foo")}, &pb.Entity{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Get("foo"); err != db.ErrNoValue {
+		t.Errorf("Got %v; Want %v", err, db.ErrNoValue)
+	}
+
+	// Entities without a history are fine too.
+	if err := hook.Run(mdb, &pb.Entity{ID: proto.String("bar")}, &pb.Entity{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPurgeSecretHistoryCB(t *testing.T) {
+	purgeSecretHistoryCB()
+}
@@ -0,0 +1,36 @@
+package hooks
+
+import (
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+// ReleaseEntityNumber returns the number of an entity that is being
+// destroyed to the pool it was allocated from.
+type ReleaseEntityNumber struct {
+	tree.BaseHook
+}
+
+// Run releases the number of the loaded entity.  Whether the number
+// is ever allocated again depends on the reuse policy of the pool.
+func (r *ReleaseEntityNumber) Run(tx tree.Txn, e, de *pb.Entity) error {
+	if e.Number == nil {
+		return nil
+	}
+	return tx.ReleaseEntityNumber(e.GetNumber())
+}
+
+func init() {
+	startup.RegisterCallback(releaseEntityNumberCB)
+}
+
+func releaseEntityNumberCB() {
+	tree.RegisterEntityHookConstructor("release-entity-number", NewReleaseEntityNumber)
+}
+
+// NewReleaseEntityNumber returns an initialized hook for use.
+func NewReleaseEntityNumber(c tree.RefContext) (tree.EntityHook, error) {
+	return &ReleaseEntityNumber{tree.NewBaseHook("release-entity-number", 50)}, nil
+}
@@ -0,0 +1,58 @@
+package hooks
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/db"
+	_ "github.com/netauth/netauth/internal/db/memory"
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestReleaseEntityNumber(t *testing.T) {
+	startup.DoCallbacks()
+
+	memdb, err := db.New("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = memdb.SetEntityNumbers(db.NumberConfig{
+		Pools: []db.NumberPool{{Name: "default", NumberRange: db.NumberRange{Min: 1, Max: 10}}},
+		Reuse: db.ReuseFreed,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hook, err := NewReleaseEntityNumber(tree.RefContext{DB: memdb})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := memdb.NextEntityNumber(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// An entity without a number has nothing to release.
+	if err := hook.Run(memdb, &pb.Entity{}, &pb.Entity{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := hook.Run(memdb, &pb.Entity{Number: proto.Int32(2)}, &pb.Entity{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := memdb.NextEntityNumber(); err != nil || n != 2 {
+		t.Fatal(n, err)
+	}
+}
+
+func TestReleaseEntityNumberCB(t *testing.T) {
+	releaseEntityNumberCB()
+}
@@ -0,0 +1,36 @@
+package hooks
+
+import (
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+// ReleaseGroupNumber returns the number of a group that is being
+// destroyed to the pool it was allocated from.
+type ReleaseGroupNumber struct {
+	tree.BaseHook
+}
+
+// Run releases the number of the loaded group.  Whether the number
+// is ever allocated again depends on the reuse policy of the pool.
+func (r *ReleaseGroupNumber) Run(tx tree.Txn, g, dg *pb.Group) error {
+	if g.Number == nil {
+		return nil
+	}
+	return tx.ReleaseGroupNumber(g.GetNumber())
+}
+
+func init() {
+	startup.RegisterCallback(releaseGroupNumberCB)
+}
+
+func releaseGroupNumberCB() {
+	tree.RegisterGroupHookConstructor("release-group-number", NewReleaseGroupNumber)
+}
+
+// NewReleaseGroupNumber returns an initialized hook for use.
+func NewReleaseGroupNumber(c tree.RefContext) (tree.GroupHook, error) {
+	return &ReleaseGroupNumber{tree.NewBaseHook("release-group-number", 50)}, nil
+}
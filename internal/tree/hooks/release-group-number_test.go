@@ -0,0 +1,58 @@
+package hooks
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/db"
+	_ "github.com/netauth/netauth/internal/db/memory"
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestReleaseGroupNumber(t *testing.T) {
+	startup.DoCallbacks()
+
+	memdb, err := db.New("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = memdb.SetGroupNumbers(db.NumberConfig{
+		Pools: []db.NumberPool{{Name: "default", NumberRange: db.NumberRange{Min: 1, Max: 10}}},
+		Reuse: db.ReuseFreed,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hook, err := NewReleaseGroupNumber(tree.RefContext{DB: memdb})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := memdb.NextGroupNumber(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A group without a number has nothing to release.
+	if err := hook.Run(memdb, &pb.Group{}, &pb.Group{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := hook.Run(memdb, &pb.Group{Number: proto.Int32(2)}, &pb.Group{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := memdb.NextGroupNumber(); err != nil || n != 2 {
+		t.Fatal(n, err)
+	}
+}
+
+func TestReleaseGroupNumberCB(t *testing.T) {
+	releaseGroupNumberCB()
+}
@@ -0,0 +1,52 @@
+package hooks
+
+import (
+	"time"
+
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+// RemoveGroupMemberships removes a group from the direct memberships
+// of every entity, so that a destroyed group leaves no members behind.
+type RemoveGroupMemberships struct {
+	tree.BaseHook
+}
+
+// Run removes g from the direct memberships of each entity that
+// lists it, along with the time at which the membership expires.
+func (r *RemoveGroupMemberships) Run(tx tree.Txn, g, dg *pb.Group) error {
+	members, err := directMembers(tx, g.GetName())
+	if err != nil {
+		return err
+	}
+	for _, e := range members {
+		var groups []string
+		for _, name := range e.GetMeta().GetGroups() {
+			if name != g.GetName() {
+				groups = append(groups, name)
+			}
+		}
+		e.Meta.Groups = groups
+		tree.SetMembershipExpiry(e, g.GetName(), time.Time{})
+		if err := tx.SaveEntity(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	startup.RegisterCallback(removeGroupMembershipsCB)
+}
+
+func removeGroupMembershipsCB() {
+	tree.RegisterGroupHookConstructor("remove-group-memberships", NewRemoveGroupMemberships)
+}
+
+// NewRemoveGroupMemberships returns a configured hook, ready for use.
+func NewRemoveGroupMemberships(c tree.RefContext) (tree.GroupHook, error) {
+	return &RemoveGroupMemberships{tree.NewBaseHook("remove-group-memberships", 50)}, nil
+}
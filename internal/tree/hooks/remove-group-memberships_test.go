@@ -0,0 +1,36 @@
+package hooks
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestRemoveGroupMemberships(t *testing.T) {
+	mdb := newReferenceDB(t)
+
+	hook, err := NewRemoveGroupMemberships(tree.RefContext{DB: mdb})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := hook.Run(mdb, &pb.Group{Name: proto.String("target")}, &pb.Group{}); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := mdb.LoadEntity("member")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := e.GetMeta().GetGroups(); len(g) != 1 || g[0] != "other" {
+		t.Errorf("Got groups %v; Want [other]", g)
+	}
+}
+
+func TestRemoveGroupMembershipsCB(t *testing.T) {
+	removeGroupMembershipsCB()
+}
@@ -0,0 +1,49 @@
+package hooks
+
+import (
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+// RenameEntity moves an entity to a new ID.
+type RenameEntity struct {
+	tree.BaseHook
+}
+
+// Run removes the entity from its current ID and sets the new ID
+// carried by de, which must not already be in use.  The entity is
+// written under the new ID by a later hook in the chain.
+func (r *RenameEntity) Run(tx tree.Txn, e, de *pb.Entity) error {
+	to := instruction(de.GetMeta().GetKV(), tree.RenameKey)
+	if to == "" {
+		return tree.ErrNoRenameTarget
+	}
+	if to == e.GetID() {
+		return nil
+	}
+	if _, err := tx.LoadEntity(to); err == nil {
+		return tree.ErrDuplicateEntityID
+	}
+
+	if err := tx.DeleteEntity(e.GetID()); err != nil {
+		return err
+	}
+	e.ID = &to
+	return nil
+}
+
+func init() {
+	startup.RegisterCallback(renameEntityCB)
+}
+
+func renameEntityCB() {
+	tree.RegisterEntityHookConstructor("rename-entity", NewRenameEntity)
+}
+
+// NewRenameEntity returns a RenameEntity hook configured and ready
+// for use.
+func NewRenameEntity(c tree.RefContext) (tree.EntityHook, error) {
+	return &RenameEntity{tree.NewBaseHook("rename-entity", 50)}, nil
+}
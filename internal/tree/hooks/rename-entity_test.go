@@ -0,0 +1,73 @@
+package hooks
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/db"
+	_ "github.com/netauth/netauth/internal/db/memory"
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+func renameEntityData(ID, to string) *pb.Entity {
+	de := &pb.Entity{ID: proto.String(ID), Meta: &pb.EntityMeta{}}
+	if to != "" {
+		de.Meta.KV = []*pb.KVData{{
+			Key:    proto.String(tree.RenameKey),
+			Values: []*pb.KVValue{{Value: proto.String(to)}},
+		}}
+	}
+	return de
+}
+
+func TestRenameEntity(t *testing.T) {
+	startup.DoCallbacks()
+
+	mdb, err := db.New("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hook, err := NewRenameEntity(tree.RefContext{DB: mdb})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"foo", "taken"} {
+		if err := mdb.SaveEntity(&pb.Entity{ID: proto.String(id)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		to      string
+		wantErr error
+		wantID  string
+	}{
+		{"", tree.ErrNoRenameTarget, "foo"},
+		{"taken", tree.ErrDuplicateEntityID, "foo"},
+		{"foo", nil, "foo"},
+		{"bar", nil, "bar"},
+	}
+	for i, c := range cases {
+		e := &pb.Entity{ID: proto.String("foo")}
+		if err := hook.Run(mdb, e, renameEntityData("foo", c.to)); err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+		if e.GetID() != c.wantID {
+			t.Errorf("%d: Got %v; Want %v", i, e.GetID(), c.wantID)
+		}
+	}
+
+	if _, err := mdb.LoadEntity("foo"); err != db.ErrUnknownEntity {
+		t.Errorf("Got %v; Want %v", err, db.ErrUnknownEntity)
+	}
+}
+
+func TestRenameEntityCB(t *testing.T) {
+	renameEntityCB()
+}
@@ -0,0 +1,104 @@
+package hooks
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+// RenameGroupReferences rewrites every reference to a group that is
+// being renamed.
+type RenameGroupReferences struct {
+	tree.BaseHook
+}
+
+// Run replaces the name held in dg with the new name wherever it
+// appears: in the direct memberships, membership expiry times, and
+// primary group of entities, in the expansions and managing group of
+// other groups, and in the scoped capabilities of both.  The name is
+// taken from dg rather than g since g may already have been renamed.
+func (r *RenameGroupReferences) Run(tx tree.Txn, g, dg *pb.Group) error {
+	from, to := dg.GetName(), instruction(dg.GetKV(), tree.RenameKey)
+	if to == "" || to == from {
+		return nil
+	}
+
+	ids, err := tx.DiscoverEntityIDs()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		e, err := tx.LoadEntity(path.Base(id))
+		if err != nil {
+			return err
+		}
+		changed := false
+		if e.GetMeta().GetPrimaryGroup() == from {
+			e.Meta.PrimaryGroup = &to
+			changed = true
+		}
+		for i, name := range e.GetMeta().GetGroups() {
+			if name == from {
+				e.Meta.Groups[i] = to
+				changed = true
+			}
+		}
+		if until, ok := tree.MembershipExpiry(e)[from]; ok {
+			tree.SetMembershipExpiry(e, from, time.Time{})
+			tree.SetMembershipExpiry(e, to, until)
+			changed = true
+		}
+		if tree.RenameScope(e.GetMeta().GetKV(), from, to) {
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		if err := tx.SaveEntity(e); err != nil {
+			return err
+		}
+	}
+
+	groups, err := otherGroups(tx, from)
+	if err != nil {
+		return err
+	}
+	tree.RenameScope(g.GetKV(), from, to)
+	for _, o := range groups {
+		exps := expansionsOf(o, from)
+		scoped := tree.RenameScope(o.GetKV(), from, to)
+		if len(exps) == 0 && o.GetManagedBy() != from && !scoped {
+			continue
+		}
+		for i, exp := range o.GetExpansions() {
+			if contains(exps, exp) {
+				o.Expansions[i] = strings.SplitN(exp, ":", 2)[0] + ":" + to
+			}
+		}
+		if o.GetManagedBy() == from {
+			o.ManagedBy = &to
+		}
+		if err := tx.SaveGroup(o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	startup.RegisterCallback(renameGroupReferencesCB)
+}
+
+func renameGroupReferencesCB() {
+	tree.RegisterGroupHookConstructor("rename-group-references", NewRenameGroupReferences)
+}
+
+// NewRenameGroupReferences returns a configured hook, ready for use.
+func NewRenameGroupReferences(c tree.RefContext) (tree.GroupHook, error) {
+	return &RenameGroupReferences{tree.NewBaseHook("rename-group-references", 50)}, nil
+}
@@ -0,0 +1,83 @@
+package hooks
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestRenameGroupReferences(t *testing.T) {
+	mdb := newReferenceDB(t)
+	e, err := mdb.LoadEntity("bystander")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Meta.PrimaryGroup = proto.String("target")
+	e.Meta.KV = scopeKV("CREATE_ENTITY:target", "LOCK_ENTITY:other")
+	if err := mdb.SaveEntity(e); err != nil {
+		t.Fatal(err)
+	}
+	g, err := mdb.LoadGroup("managed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.KV = scopeKV("MODIFY_GROUP_MEMBERS:target")
+	if err := mdb.SaveGroup(g); err != nil {
+		t.Fatal(err)
+	}
+
+	hook, err := NewRenameGroupReferences(tree.RefContext{DB: mdb})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := hook.Run(mdb, &pb.Group{}, renameGroupData("target", "renamed")); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err = mdb.LoadEntity("member")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := e.GetMeta().GetGroups(); len(g) != 2 || g[0] != "renamed" || g[1] != "other" {
+		t.Errorf("Got %v; Want [renamed other]", g)
+	}
+
+	e, err = mdb.LoadEntity("bystander")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.GetMeta().GetPrimaryGroup() != "renamed" {
+		t.Errorf("Got %v; Want renamed", e.GetMeta().GetPrimaryGroup())
+	}
+	if v := e.GetMeta().GetKV()[0].GetValues(); v[0].GetValue() != "CREATE_ENTITY:renamed" || v[1].GetValue() != "LOCK_ENTITY:other" {
+		t.Errorf("Got %v; Want CREATE_ENTITY:renamed and LOCK_ENTITY:other", v)
+	}
+
+	g, err = mdb.LoadGroup("parent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x := g.GetExpansions(); len(x) != 2 || x[0] != "INCLUDE:renamed" || x[1] != "EXCLUDE:other" {
+		t.Errorf("Got %v; Want [INCLUDE:renamed EXCLUDE:other]", x)
+	}
+
+	g, err = mdb.LoadGroup("managed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.GetManagedBy() != "renamed" {
+		t.Errorf("Got %v; Want renamed", g.GetManagedBy())
+	}
+	if v := g.GetKV()[0].GetValues()[0].GetValue(); v != "MODIFY_GROUP_MEMBERS:renamed" {
+		t.Errorf("Got %v; Want MODIFY_GROUP_MEMBERS:renamed", v)
+	}
+}
+
+func TestRenameGroupReferencesCB(t *testing.T) {
+	renameGroupReferencesCB()
+}
@@ -0,0 +1,53 @@
+package hooks
+
+import (
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+// RenameGroup moves a group to a new name.
+type RenameGroup struct {
+	tree.BaseHook
+}
+
+// Run removes the group from its current name and sets the new name
+// carried by dg, which must not already be in use.  A group that
+// manages itself continues to do so.  The group is written under the
+// new name by a later hook in the chain.
+func (r *RenameGroup) Run(tx tree.Txn, g, dg *pb.Group) error {
+	to := instruction(dg.GetKV(), tree.RenameKey)
+	if to == "" {
+		return tree.ErrNoRenameTarget
+	}
+	if to == g.GetName() {
+		return nil
+	}
+	if _, err := tx.LoadGroup(to); err == nil {
+		return tree.ErrDuplicateGroupName
+	}
+
+	if err := tx.DeleteGroup(g.GetName()); err != nil {
+		return err
+	}
+	if g.GetManagedBy() == g.GetName() {
+		g.ManagedBy = &to
+	}
+	g.Name = &to
+	return nil
+}
+
+func init() {
+	startup.RegisterCallback(renameGroupCB)
+}
+
+func renameGroupCB() {
+	tree.RegisterGroupHookConstructor("rename-group", NewRenameGroup)
+}
+
+// NewRenameGroup returns a RenameGroup hook configured and ready for
+// use.
+func NewRenameGroup(c tree.RefContext) (tree.GroupHook, error) {
+	return &RenameGroup{tree.NewBaseHook("rename-group", 50)}, nil
+}
@@ -0,0 +1,60 @@
+package hooks
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+func renameGroupData(name, to string) *pb.Group {
+	dg := &pb.Group{Name: proto.String(name)}
+	if to != "" {
+		dg.KV = []*pb.KVData{{
+			Key:    proto.String(tree.RenameKey),
+			Values: []*pb.KVValue{{Value: proto.String(to)}},
+		}}
+	}
+	return dg
+}
+
+func TestRenameGroup(t *testing.T) {
+	mdb := newReferenceDB(t)
+
+	hook, err := NewRenameGroup(tree.RefContext{DB: mdb})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		to      string
+		wantErr error
+		wantID  string
+	}{
+		{"", tree.ErrNoRenameTarget, "target"},
+		{"other", tree.ErrDuplicateGroupName, "target"},
+		{"target", nil, "target"},
+		{"renamed", nil, "renamed"},
+	}
+	for i, c := range cases {
+		g := &pb.Group{Name: proto.String("target"), ManagedBy: proto.String("target")}
+		if err := hook.Run(mdb, g, renameGroupData("target", c.to)); err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+		if g.GetName() != c.wantID || g.GetManagedBy() != c.wantID {
+			t.Errorf("%d: Got %v managed by %v; Want %v", i, g.GetName(), g.GetManagedBy(), c.wantID)
+		}
+	}
+
+	if _, err := mdb.LoadGroup("target"); err != db.ErrUnknownGroup {
+		t.Errorf("Got %v; Want %v", err, db.ErrUnknownGroup)
+	}
+}
+
+func TestRenameGroupCB(t *testing.T) {
+	renameGroupCB()
+}
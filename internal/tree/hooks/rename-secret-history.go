@@ -0,0 +1,54 @@
+package hooks
+
+import (
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+// RenameSecretHistory moves the previous secrets of an entity along
+// with it when it is renamed.
+type RenameSecretHistory struct {
+	tree.BaseHook
+}
+
+// Run moves the secret history from the ID in de to the ID that e has
+// been renamed to, so that the renamed entity still can't reuse its
+// recent secrets and an entity created later with the old ID does not
+// inherit them.
+func (r *RenameSecretHistory) Run(tx tree.Txn, e, de *pb.Entity) error {
+	from, to := de.GetID(), e.GetID()
+	if from == to {
+		return nil
+	}
+
+	b := tx.Bucket(tree.SecretHistoryBucket)
+	v, err := b.Get(from)
+	switch err {
+	case nil:
+	case db.ErrNoValue:
+		return nil
+	default:
+		return err
+	}
+	if err := b.Put(to, v); err != nil {
+		return err
+	}
+	return b.Del(from)
+}
+
+func init() {
+	startup.RegisterCallback(renameSecretHistoryCB)
+}
+
+func renameSecretHistoryCB() {
+	tree.RegisterEntityHookConstructor("rename-secret-history", NewRenameSecretHistory)
+}
+
+// NewRenameSecretHistory returns a RenameSecretHistory hook ready for
+// use.
+func NewRenameSecretHistory(c tree.RefContext) (tree.EntityHook, error) {
+	return &RenameSecretHistory{tree.NewBaseHook("rename-secret-history", 55)}, nil
+}
@@ -0,0 +1,62 @@
+package hooks
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/db"
+	_ "github.com/netauth/netauth/internal/db/memory"
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestRenameSecretHistory(t *testing.T) {
+	startup.DoCallbacks()
+
+	mdb, err := db.New("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hook, err := NewRenameSecretHistory(tree.RefContext{DB: mdb})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := mdb.Bucket(tree.SecretHistoryBucket)
+	if err := b.Put("foo", []byte(`["old"]`)); err != nil {
+		t.Fatal(err)
+	}
+
+	tx := mdb.Begin()
+	if err := hook.Run(tx, &pb.Entity{ID: proto.String("bar")}, &pb.Entity{ID: proto.String("foo")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing moves until the transaction is committed.
+	if _, err := b.Get("bar"); err != db.ErrNoValue {
+		t.Errorf("Got %v; Want %v", err, db.ErrNoValue)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := b.Get("bar"); err != nil || string(v) != `["old"]` {
+		t.Errorf("History wasn't moved: %s %v", v, err)
+	}
+	if _, err := b.Get("foo"); err != db.ErrNoValue {
+		t.Errorf("Got %v; Want %v", err, db.ErrNoValue)
+	}
+
+	// Entities without a history are fine too.
+	if err := hook.Run(mdb, &pb.Entity{ID: proto.String("baz")}, &pb.Entity{ID: proto.String("qux")}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRenameSecretHistoryCB(t *testing.T) {
+	renameSecretHistoryCB()
+}
@@ -0,0 +1,42 @@
+package hooks
+
+import (
+	"github.com/golang/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+// RollbackEntity provides a hook to replace an entity with a previous
+// revision of itself.
+type RollbackEntity struct {
+	tree.BaseHook
+}
+
+// Run replaces the entity with the revision held in the data entity.
+// The secret is kept as it is, since rolling back an entity must
+// never resurrect a password that has been changed.
+func (*RollbackEntity) Run(_ tree.Txn, e, de *pb.Entity) error {
+	secret := e.Secret
+
+	e.Reset()
+	proto.Merge(e, de)
+	e.Secret = secret
+	return nil
+}
+
+func init() {
+	startup.RegisterCallback(rollbackEntityCB)
+}
+
+func rollbackEntityCB() {
+	tree.RegisterEntityHookConstructor("rollback-entity", NewRollbackEntity)
+}
+
+// NewRollbackEntity returns a RollbackEntity hook configured and
+// ready for use.
+func NewRollbackEntity(c tree.RefContext) (tree.EntityHook, error) {
+	return &RollbackEntity{tree.NewBaseHook("rollback-entity", 50)}, nil
+}
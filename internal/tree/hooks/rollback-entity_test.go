@@ -0,0 +1,52 @@
+package hooks
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestRollbackEntity(t *testing.T) {
+	hook, err := NewRollbackEntity(tree.RefContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &pb.Entity{
+		ID:     proto.String("foo"),
+		Secret: proto.String("current"),
+		Meta: &pb.EntityMeta{
+			Shell:  proto.String("/bin/zsh"),
+			Groups: []string{"bar"},
+		},
+	}
+	de := &pb.Entity{
+		ID:     proto.String("foo"),
+		Secret: proto.String("previous"),
+		Meta: &pb.EntityMeta{
+			DisplayName: proto.String("Foo"),
+		},
+	}
+
+	if err := hook.Run(nil, e, de); err != nil {
+		t.Fatal(err)
+	}
+
+	if e.GetSecret() != "current" {
+		t.Error("Secret was rolled back")
+	}
+	if e.GetMeta().GetShell() != "" || len(e.GetMeta().GetGroups()) != 0 {
+		t.Error("Fields not in the revision were kept")
+	}
+	if e.GetMeta().GetDisplayName() != "Foo" {
+		t.Error("Fields in the revision were not restored")
+	}
+}
+
+func TestRollbackEntityCB(t *testing.T) {
+	rollbackEntityCB()
+}
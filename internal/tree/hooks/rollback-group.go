@@ -0,0 +1,37 @@
+package hooks
+
+import (
+	"github.com/golang/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+// RollbackGroup provides a hook to replace a group with a previous
+// revision of itself.
+type RollbackGroup struct {
+	tree.BaseHook
+}
+
+// Run replaces the group with the revision held in the data group.
+func (*RollbackGroup) Run(_ tree.Txn, g, dg *pb.Group) error {
+	g.Reset()
+	proto.Merge(g, dg)
+	return nil
+}
+
+func init() {
+	startup.RegisterCallback(rollbackGroupCB)
+}
+
+func rollbackGroupCB() {
+	tree.RegisterGroupHookConstructor("rollback-group", NewRollbackGroup)
+}
+
+// NewRollbackGroup returns a RollbackGroup hook configured and ready
+// for use.
+func NewRollbackGroup(c tree.RefContext) (tree.GroupHook, error) {
+	return &RollbackGroup{tree.NewBaseHook("rollback-group", 50)}, nil
+}
@@ -0,0 +1,43 @@
+package hooks
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestRollbackGroup(t *testing.T) {
+	hook, err := NewRollbackGroup(tree.RefContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := &pb.Group{
+		Name:       proto.String("foo"),
+		ManagedBy:  proto.String("bar"),
+		Expansions: []string{"INCLUDE:baz"},
+	}
+	dg := &pb.Group{
+		Name:        proto.String("foo"),
+		DisplayName: proto.String("Foo"),
+	}
+
+	if err := hook.Run(nil, g, dg); err != nil {
+		t.Fatal(err)
+	}
+
+	if g.GetManagedBy() != "" || len(g.GetExpansions()) != 0 {
+		t.Error("Fields not in the revision were kept")
+	}
+	if g.GetName() != "foo" || g.GetDisplayName() != "Foo" {
+		t.Error("Fields in the revision were not restored")
+	}
+}
+
+func TestRollbackGroupCB(t *testing.T) {
+	rollbackGroupCB()
+}
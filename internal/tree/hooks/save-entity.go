@@ -11,13 +11,12 @@ import (
 // success, the provided entity will be saved to the data store.
 type SaveEntity struct {
 	tree.BaseHook
-	tree.DB
 }
 
 // Run will pass e to the data storage mechanism's "SaveEntity"
 // method.
-func (s *SaveEntity) Run(e, de *pb.Entity) error {
-	return s.SaveEntity(e)
+func (s *SaveEntity) Run(tx tree.Txn, e, de *pb.Entity) error {
+	return tx.SaveEntity(e)
 }
 
 func init() {
@@ -30,5 +29,5 @@ func saveEntityCB() {
 
 // NewSaveEntity returns an initialized hook ready for use.
 func NewSaveEntity(c tree.RefContext) (tree.EntityHook, error) {
-	return &SaveEntity{tree.NewBaseHook("save-entity", 99), c.DB}, nil
+	return &SaveEntity{tree.NewBaseHook("save-entity", 99)}, nil
 }
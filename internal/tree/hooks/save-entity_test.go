@@ -28,7 +28,7 @@ func TestSaveEntity(t *testing.T) {
 
 	e := &pb.Entity{ID: proto.String("foobar")}
 
-	if err := hook.Run(e, &pb.Entity{}); err != nil {
+	if err := hook.Run(mdb, e, &pb.Entity{}); err != nil {
 		t.Fatal(err)
 	}
 
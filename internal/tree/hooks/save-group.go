@@ -11,13 +11,12 @@ import (
 // saving a modified group to the database.
 type SaveGroup struct {
 	tree.BaseHook
-	tree.DB
 }
 
 // Run will pass the group specified by g to the datastore and request
 // it to be saved.
-func (s *SaveGroup) Run(g, dg *pb.Group) error {
-	return s.SaveGroup(g)
+func (s *SaveGroup) Run(tx tree.Txn, g, dg *pb.Group) error {
+	return tx.SaveGroup(g)
 }
 
 func init() {
@@ -30,5 +29,5 @@ func saveGroupCB() {
 
 // NewSaveGroup returns a configured hook for use.
 func NewSaveGroup(c tree.RefContext) (tree.GroupHook, error) {
-	return &SaveGroup{tree.NewBaseHook("save-group", 99), c.DB}, nil
+	return &SaveGroup{tree.NewBaseHook("save-group", 99)}, nil
 }
@@ -28,7 +28,7 @@ func TestSaveGroup(t *testing.T) {
 
 	g := &pb.Group{Name: proto.String("fooGroup")}
 
-	if err := hook.Run(g, &pb.Group{}); err != nil {
+	if err := hook.Run(mdb, g, &pb.Group{}); err != nil {
 		t.Fatal(err)
 	}
 
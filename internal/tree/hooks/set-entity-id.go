@@ -14,7 +14,7 @@ type SetEntityID struct {
 
 // Run copies the ID from de to e, no checks are enforced during the
 // copy.
-func (*SetEntityID) Run(e, de *pb.Entity) error {
+func (*SetEntityID) Run(_ tree.Txn, e, de *pb.Entity) error {
 	e.ID = de.ID
 	return nil
 }
@@ -19,7 +19,7 @@ func TestSetEntityID(t *testing.T) {
 	e := &pb.Entity{}
 	de := &pb.Entity{ID: proto.String("entity-id")}
 
-	if err := hook.Run(e, de); err != nil {
+	if err := hook.Run(nil, e, de); err != nil {
 		t.Fatal(err)
 	}
 	if e.GetID() != "entity-id" {
@@ -12,18 +12,19 @@ import (
 // number.
 type SetEntityNumber struct {
 	tree.BaseHook
-	tree.DB
 }
 
 // Run will provision a number in one of two ways.  If the number is
 // not equal to -1 then it will be used directly with no further
-// checks and will be applied to the entity.  If the number is -1 then
-// the data storage system will be queried for the next available
-// number.  These numbers are not guaranteed to be in order or have
-// any mathematical progression, only uniqueness.
-func (s *SetEntityNumber) Run(e, de *pb.Entity) error {
+// checks and will be applied to the entity, and the number is claimed
+// so that it will not also be allocated.  If the number is -1 then
+// the data storage system will allocate the next available number
+// from the pool named in the data entity, or the default pool if
+// none is named.  These numbers are not guaranteed to be in order or
+// have any mathematical progression, only uniqueness.
+func (s *SetEntityNumber) Run(tx tree.Txn, e, de *pb.Entity) error {
 	if de.GetNumber() == -1 {
-		n, err := s.NextEntityNumber()
+		n, err := tx.AllocateEntityNumber(instruction(de.GetMeta().GetKV(), tree.NumberPoolKey))
 		if err != nil {
 			return err
 		}
@@ -31,7 +32,7 @@ func (s *SetEntityNumber) Run(e, de *pb.Entity) error {
 		return nil
 	}
 	e.Number = de.Number
-	return nil
+	return tx.ClaimEntityNumber(de.GetNumber())
 }
 
 func init() {
@@ -44,5 +45,5 @@ func setEntityNumberCB() {
 
 // NewSetEntityNumber returns a SetEntityNumber hook ready for use.
 func NewSetEntityNumber(c tree.RefContext) (tree.EntityHook, error) {
-	return &SetEntityNumber{tree.NewBaseHook("set-entity-number", 50), c.DB}, nil
+	return &SetEntityNumber{tree.NewBaseHook("set-entity-number", 50)}, nil
 }
@@ -29,18 +29,64 @@ func TestSetEntityNumber(t *testing.T) {
 	e := &pb.Entity{}
 	de := &pb.Entity{Number: proto.Int32(-1)}
 
-	if err := hook.Run(e, de); err != nil || e.GetNumber() != 1 {
+	if err := hook.Run(memdb, e, de); err != nil || e.GetNumber() != 1 {
 		t.Log(e)
 		t.Fatal(err)
 	}
 
 	de.Number = proto.Int32(27)
-	if err := hook.Run(e, de); err != nil || e.GetNumber() != 27 {
+	if err := hook.Run(memdb, e, de); err != nil || e.GetNumber() != 27 {
 		t.Log(e)
 		t.Fatal(err)
 	}
 }
 
+func TestSetEntityNumberPool(t *testing.T) {
+	startup.DoCallbacks()
+
+	memdb, err := db.New("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = memdb.SetEntityNumbers(db.NumberConfig{Pools: []db.NumberPool{
+		{Name: "humans", NumberRange: db.NumberRange{Min: 10000, Max: 59999}},
+		{Name: "service", NumberRange: db.NumberRange{Min: 900, Max: 999}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hook, err := NewSetEntityNumber(tree.RefContext{DB: memdb})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		pool    string
+		want    int32
+		wantErr error
+	}{
+		{"", 10000, nil},
+		{"service", 900, nil},
+		{"service", 901, nil},
+		{"humans", 10001, nil},
+		{"robots", 0, db.ErrUnknownNumberPool},
+	}
+	for i, c := range cases {
+		e := &pb.Entity{}
+		de := &pb.Entity{Number: proto.Int32(-1)}
+		if c.pool != "" {
+			de.Meta = &pb.EntityMeta{KV: []*pb.KVData{{
+				Key:    proto.String(tree.NumberPoolKey),
+				Values: []*pb.KVValue{{Value: proto.String(c.pool)}},
+			}}}
+		}
+		if err := hook.Run(memdb, e, de); err != c.wantErr || e.GetNumber() != c.want {
+			t.Errorf("%d: Got %d %v; Want %d %v", i, e.GetNumber(), err, c.want, c.wantErr)
+		}
+	}
+}
+
 func TestSetEntityNumberCB(t *testing.T) {
 	setEntityNumberCB()
 }
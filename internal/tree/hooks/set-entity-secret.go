@@ -17,7 +17,7 @@ type SetEntitySecret struct {
 
 // Run takes a plaintext secret from de.Secret and secures it using a
 // crypto engine.  The secured secret will be written to e.Secret.
-func (s *SetEntitySecret) Run(e, de *pb.Entity) error {
+func (s *SetEntitySecret) Run(_ tree.Txn, e, de *pb.Entity) error {
 	ssecret, err := s.SecureSecret(de.GetSecret())
 	if err != nil {
 		return err
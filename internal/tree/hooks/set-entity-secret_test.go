@@ -26,7 +26,7 @@ func TestSetEntitySecret(t *testing.T) {
 	e := &pb.Entity{}
 	de := &pb.Entity{Secret: proto.String("security")}
 
-	if err := hook.Run(e, de); err != nil {
+	if err := hook.Run(nil, e, de); err != nil {
 		t.Fatal(err)
 	}
 
@@ -13,7 +13,7 @@ type SetGroupDisplayName struct {
 }
 
 // Run copies the DisplayName from dg to g, no checking is performed.
-func (*SetGroupDisplayName) Run(g, dg *pb.Group) error {
+func (*SetGroupDisplayName) Run(_ tree.Txn, g, dg *pb.Group) error {
 	g.DisplayName = dg.DisplayName
 	return nil
 }
@@ -19,7 +19,7 @@ func TestSetGroupDisplayName(t *testing.T) {
 	g := &pb.Group{}
 	dg := &pb.Group{DisplayName: proto.String("foo group")}
 
-	if err := hook.Run(g, dg); err != nil {
+	if err := hook.Run(nil, g, dg); err != nil {
 		t.Fatal(err)
 	}
 
@@ -14,7 +14,7 @@ type SetGroupName struct {
 
 // Run sets the name on g to the name on dg, no checks or validation
 // are run during this hook.
-func (*SetGroupName) Run(g, dg *pb.Group) error {
+func (*SetGroupName) Run(_ tree.Txn, g, dg *pb.Group) error {
 	g.Name = dg.Name
 	return nil
 }
@@ -19,7 +19,7 @@ func TestSetGroupName(t *testing.T) {
 	g := &pb.Group{}
 	dg := &pb.Group{Name: proto.String("fooGroup")}
 
-	if err := hook.Run(g, dg); err != nil {
+	if err := hook.Run(nil, g, dg); err != nil {
 		t.Fatal(err)
 	}
 
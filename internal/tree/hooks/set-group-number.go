@@ -12,18 +12,18 @@ import (
 // database.
 type SetGroupNumber struct {
 	tree.BaseHook
-	tree.DB
 }
 
 // Run will set the group number on g.  If dg.Number is provided as a
 // non-zero positive integer, it will be used directly (care should be
-// taken this number is not already allocated).  If dg.Number is -1, a
-// number will be dynamically provisioned by the database.  It is
-// recommended to use automatic provisioning unless strictly necessary
-// to do otherwise.
-func (s *SetGroupNumber) Run(g, dg *pb.Group) error {
+// taken this number is not already allocated) and claimed so that it
+// will not also be allocated.  If dg.Number is -1, a number will be
+// dynamically provisioned by the database from the pool named in dg,
+// or the default pool if none is named.  It is recommended to use
+// automatic provisioning unless strictly necessary to do otherwise.
+func (s *SetGroupNumber) Run(tx tree.Txn, g, dg *pb.Group) error {
 	if dg.GetNumber() == -1 {
-		number, err := s.NextGroupNumber()
+		number, err := tx.AllocateGroupNumber(instruction(dg.GetKV(), tree.NumberPoolKey))
 		if err != nil {
 			return err
 		}
@@ -31,7 +31,7 @@ func (s *SetGroupNumber) Run(g, dg *pb.Group) error {
 		return nil
 	}
 	g.Number = dg.Number
-	return nil
+	return tx.ClaimGroupNumber(dg.GetNumber())
 }
 
 func init() {
@@ -44,5 +44,5 @@ func setGroupNumberCB() {
 
 // NewSetGroupNumber returns a hook initialized and ready for use.
 func NewSetGroupNumber(c tree.RefContext) (tree.GroupHook, error) {
-	return &SetGroupNumber{tree.NewBaseHook("set-group-number", 50), c.DB}, nil
+	return &SetGroupNumber{tree.NewBaseHook("set-group-number", 50)}, nil
 }
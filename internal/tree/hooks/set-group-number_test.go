@@ -28,7 +28,7 @@ func TestSetGroupNumber(t *testing.T) {
 
 	g := &pb.Group{}
 
-	if err := hook.Run(g, &pb.Group{Number: proto.Int32(27)}); err != nil {
+	if err := hook.Run(db, g, &pb.Group{Number: proto.Int32(27)}); err != nil {
 		t.Fatal(err)
 	}
 
@@ -37,7 +37,7 @@ func TestSetGroupNumber(t *testing.T) {
 		t.Error("Spec failure - please trace hook")
 	}
 
-	if err := hook.Run(g, &pb.Group{Number: proto.Int32(-1)}); err != nil {
+	if err := hook.Run(db, g, &pb.Group{Number: proto.Int32(-1)}); err != nil {
 		t.Fatal(err)
 	}
 
@@ -47,6 +47,48 @@ func TestSetGroupNumber(t *testing.T) {
 	}
 }
 
+func TestSetGroupNumberPool(t *testing.T) {
+	startup.DoCallbacks()
+
+	memdb, err := db.New("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = memdb.SetGroupNumbers(db.NumberConfig{Pools: []db.NumberPool{
+		{Name: "default", NumberRange: db.NumberRange{Min: 1000, Max: 1999}},
+		{Name: "system", NumberRange: db.NumberRange{Min: 100, Max: 100}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hook, err := NewSetGroupNumber(tree.RefContext{DB: memdb})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	poolKV := []*pb.KVData{{
+		Key:    proto.String(tree.NumberPoolKey),
+		Values: []*pb.KVValue{{Value: proto.String("system")}},
+	}}
+
+	// An explicit number is claimed and then skipped.
+	g := &pb.Group{}
+	if err := hook.Run(memdb, g, &pb.Group{Number: proto.Int32(1000)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := hook.Run(memdb, g, &pb.Group{Number: proto.Int32(-1)}); err != nil || g.GetNumber() != 1001 {
+		t.Fatal(g.GetNumber(), err)
+	}
+
+	if err := hook.Run(memdb, g, &pb.Group{Number: proto.Int32(-1), KV: poolKV}); err != nil || g.GetNumber() != 100 {
+		t.Fatal(g.GetNumber(), err)
+	}
+	if err := hook.Run(memdb, g, &pb.Group{Number: proto.Int32(-1), KV: poolKV}); err != db.ErrNumberPoolExhausted {
+		t.Fatal(err)
+	}
+}
+
 func TestSetGroupNumberCB(t *testing.T) {
 	setGroupNumberCB()
 }
@@ -11,7 +11,6 @@ import (
 // and then sets it.
 type SetManagingGroup struct {
 	tree.BaseHook
-	tree.DB
 }
 
 // Run will attempt to set the managing group of g to the specified
@@ -19,7 +18,7 @@ type SetManagingGroup struct {
 // i.e. unmanaged, the hook will return immediately, otherwise the
 // group is checked for either existence, or identity to the group
 // being created.
-func (c *SetManagingGroup) Run(g, dg *pb.Group) error {
+func (c *SetManagingGroup) Run(tx tree.Txn, g, dg *pb.Group) error {
 	// If the managedby field is blank, this group is unmanaged
 	// and requires token authority to alter later.
 	if dg.GetManagedBy() == "" {
@@ -36,7 +35,7 @@ func (c *SetManagingGroup) Run(g, dg *pb.Group) error {
 
 	// If the group is not self managed but does have a manage by,
 	// then the managedby group must exist already.
-	if _, err := c.LoadGroup(dg.GetManagedBy()); err != nil {
+	if _, err := tx.LoadGroup(dg.GetManagedBy()); err != nil {
 		return err
 	}
 
@@ -55,5 +54,5 @@ func setManagingGroupCB() {
 
 // NewSetManagingGroup returns a hook initialized for use.
 func NewSetManagingGroup(c tree.RefContext) (tree.GroupHook, error) {
-	return &SetManagingGroup{tree.NewBaseHook("set-managing-group", 10), c.DB}, nil
+	return &SetManagingGroup{tree.NewBaseHook("set-managing-group", 10)}, nil
 }
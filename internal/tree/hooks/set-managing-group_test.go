@@ -48,7 +48,7 @@ func TestSetManagingGroup(t *testing.T) {
 			Name:      proto.String(c.name),
 			ManagedBy: proto.String(c.managedby),
 		}
-		if err := hook.Run(g, dg); err != c.wantErr {
+		if err := hook.Run(mdb, g, dg); err != c.wantErr {
 			t.Errorf("Case %d: Got %v Want %v", i, err, c.wantErr)
 		}
 		if g.GetManagedBy() != c.wantManagedBy {
@@ -19,7 +19,7 @@ type ManageEntityUM struct {
 // mode the plugin is configured for.  "UPSERT" will add or update
 // fields as appropriate.  "CLEARFUZZY" will ignore Z-Indexing
 // annotations.  "CLEAREXACT" will require exact key specifications.
-func (mm *ManageEntityUM) Run(e, de *pb.Entity) error {
+func (mm *ManageEntityUM) Run(_ tree.Txn, e, de *pb.Entity) error {
 	for _, m := range de.Meta.UntypedMeta {
 		key, value := splitKeyValue(m)
 		e.Meta.UntypedMeta = util.PatchKeyValueSlice(e.Meta.UntypedMeta, mm.mode, key, value)
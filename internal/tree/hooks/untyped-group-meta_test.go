@@ -19,7 +19,7 @@ func TestAddGroupUM(t *testing.T) {
 		UntypedMeta: []string{"key:value:with:colons"},
 	}
 
-	if err := hook.Run(e, de); err != nil {
+	if err := hook.Run(nil, e, de); err != nil {
 		t.Fatal(err)
 	}
 
@@ -44,7 +44,7 @@ func TestDelFuzzyGroupUM(t *testing.T) {
 		UntypedMeta: []string{"key:"},
 	}
 
-	if err := hook.Run(e, de); err != nil {
+	if err := hook.Run(nil, e, de); err != nil {
 		t.Fatal(err)
 	}
 
@@ -70,7 +70,7 @@ func TestDelExactGroupUM(t *testing.T) {
 		UntypedMeta: []string{"key{1}:"},
 	}
 
-	if err := hook.Run(e, de); err != nil {
+	if err := hook.Run(nil, e, de); err != nil {
 		t.Fatal(err)
 	}
 
@@ -16,7 +16,7 @@ type ValidateEntitySecret struct {
 }
 
 // Run calls VerifySecret to compare de.Secret with the secured copy from e.Secret.
-func (v *ValidateEntitySecret) Run(e, de *pb.Entity) error {
+func (v *ValidateEntitySecret) Run(_ tree.Txn, e, de *pb.Entity) error {
 	return v.VerifySecret(de.GetSecret(), e.GetSecret())
 }
 
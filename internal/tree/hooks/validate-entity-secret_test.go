@@ -25,7 +25,7 @@ func TestValidateEntitySecret(t *testing.T) {
 
 	e := &pb.Entity{Secret: proto.String("secret")}
 	de := &pb.Entity{Secret: proto.String("secret")}
-	if err := hook.Run(e, de); err != nil {
+	if err := hook.Run(nil, e, de); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -14,7 +14,7 @@ type ValidateEntityUnlocked struct {
 
 // Run queries the locked status of an entity and returns either
 // ErrEntityLocked or nil, depending on if the entity is locked.
-func (*ValidateEntityUnlocked) Run(e, de *pb.Entity) error {
+func (*ValidateEntityUnlocked) Run(_ tree.Txn, e, de *pb.Entity) error {
 	if e.GetMeta().GetLocked() {
 		return tree.ErrEntityLocked
 	}
@@ -25,7 +25,7 @@ func TestValidateEntityUnlocked(t *testing.T) {
 	}
 
 	for i, c := range cases {
-		if err := hook.Run(c.e, &pb.Entity{}); err != c.wantErr {
+		if err := hook.Run(nil, c.e, &pb.Entity{}); err != c.wantErr {
 			t.Errorf("Case %d - Got: %v Want: %v", i, err, c.wantErr)
 		}
 	}
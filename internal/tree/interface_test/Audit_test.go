@@ -0,0 +1,117 @@
+package interface_test
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/netauth/netauth/internal/auditlog"
+	_ "github.com/netauth/netauth/internal/auditlog/kv"
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/pkg/audit"
+)
+
+func TestAudit(t *testing.T) {
+	m, ctx := newTreeManager(t)
+
+	l, err := auditlog.New([]string{"kv"}, auditlog.Refs{DB: ctx.DB.(*db.DB)}, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SetAuditLog(l)
+
+	addEntity(t, ctx)
+
+	admin := audit.Actor{Entity: "admin", Client: "test"}
+	if err := m.WithActor(admin).LockEntity("entity1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A chain that makes no change isn't recorded.
+	if err := m.WithActor(admin).LockEntity("entity1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.UnlockEntity("entity1"); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := m.QueryAudit(&audit.Query{Target: "entity1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 {
+		t.Fatalf("Got %d records; Want 2", len(res))
+	}
+
+	r := res[0]
+	if r.Chain != "LOCK" || r.Kind != audit.KindEntity || r.Actor.Entity != "admin" || r.Actor.Client != "test" {
+		t.Errorf("Bad record: %+v", r)
+	}
+	want := audit.FieldChange{Field: "meta.Locked", New: "true"}
+	if len(r.Changes) != 1 || r.Changes[0] != want {
+		t.Errorf("Got changes %v; Want %v", r.Changes, want)
+	}
+
+	if res[1].Chain != "UNLOCK" || res[1].Actor.Entity != "" {
+		t.Errorf("Bad record: %+v", res[1])
+	}
+
+	res, err = m.QueryAudit(&audit.Query{Actor: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 {
+		t.Errorf("Got %d records; Want 1", len(res))
+	}
+}
+
+func TestAuditSideEffects(t *testing.T) {
+	m, ctx := newTreeManager(t)
+
+	l, err := auditlog.New([]string{"kv"}, auditlog.Refs{DB: ctx.DB.(*db.DB)}, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addEntity(t, ctx)
+	addGroup(t, ctx)
+	if err := m.AddEntityToGroup("entity1", "group1"); err != nil {
+		t.Fatal(err)
+	}
+	m.SetAuditLog(l)
+
+	// Destroying the group also removes the entity from it,
+	// and both changes are attributed to the actor.
+	admin := audit.Actor{Entity: "admin"}
+	if err := m.WithActor(admin).DestroyGroup("group1"); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := m.QueryAudit(&audit.Query{Actor: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 {
+		t.Fatalf("Got %d records; Want 2", len(res))
+	}
+	seen := make(map[string]bool)
+	for _, r := range res {
+		if r.Chain != "DESTROY" {
+			t.Errorf("Bad record: %+v", r)
+		}
+		seen[r.Kind+"/"+r.Target] = true
+	}
+	if !seen[audit.KindGroup+"/group1"] || !seen[audit.KindEntity+"/entity1"] {
+		t.Errorf("Missing records: %v", seen)
+	}
+
+	res, err = m.QueryAudit(&audit.Query{Target: "entity1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := audit.FieldChange{Field: "meta.Groups", Old: `["group1"]`}
+	if len(res) != 1 || len(res[0].Changes) != 1 || res[0].Changes[0] != want {
+		t.Errorf("Got %+v; Want %v", res[0].Changes, want)
+	}
+}
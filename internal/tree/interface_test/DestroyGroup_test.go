@@ -4,6 +4,9 @@ import (
 	"testing"
 
 	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
 )
 
 func TestDeleteGroup(t *testing.T) {
@@ -19,3 +22,78 @@ func TestDeleteGroup(t *testing.T) {
 		t.Error("Group wasn't deleted")
 	}
 }
+
+func TestDeleteGroupCascade(t *testing.T) {
+	m, ctx := newTreeManager(t)
+
+	addEntity(t, ctx)
+	addGroup(t, ctx)
+	if err := m.CreateGroup("group2", "", "group1", -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ModifyGroupExpansions("group2", "group1", pb.ExpansionMode_INCLUDE); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddEntityToGroup("entity1", "group1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.DestroyGroup("group1"); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := ctx.DB.LoadEntity("entity1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.GetMeta().GetGroups()) != 0 {
+		t.Errorf("Membership remains: %v", e.GetMeta().GetGroups())
+	}
+	g, err := ctx.DB.LoadGroup("group2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.GetExpansions()) != 0 || g.GetManagedBy() != "" {
+		t.Errorf("References remain: %v", g)
+	}
+}
+
+func TestDeleteGroupRestrict(t *testing.T) {
+	m, ctx := newTreeManager(t)
+	if err := m.SetDestroyMode(tree.DestroyRestrict); err != nil {
+		t.Fatal(err)
+	}
+
+	addEntity(t, ctx)
+	addGroup(t, ctx)
+	if err := m.AddEntityToGroup("entity1", "group1"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := m.DestroyGroup("group1")
+	if _, ok := err.(*tree.ReferenceError); !ok {
+		t.Fatalf("Got %v; Want a ReferenceError", err)
+	}
+	if _, err := ctx.DB.LoadGroup("group1"); err != nil {
+		t.Error("Group was deleted")
+	}
+
+	// The entity is referenced by its membership too.
+	if _, ok := m.DestroyEntity("entity1").(*tree.ReferenceError); !ok {
+		t.Error("Entity with memberships was deleted")
+	}
+
+	if err := m.RemoveEntityFromGroup("entity1", "group1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.DestroyGroup("group1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.DestroyEntity("entity1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.SetDestroyMode("bogus"); err != tree.ErrUnknownDestroyMode {
+		t.Errorf("Got %v; Want %v", err, tree.ErrUnknownDestroyMode)
+	}
+}
@@ -0,0 +1,54 @@
+package interface_test
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestGroupInScope(t *testing.T) {
+	m, ctx := newTreeManager(t)
+
+	groups := []*pb.Group{
+		{Name: proto.String("team-infra")},
+		{Name: proto.String("sub"), ManagedBy: proto.String("team-infra")},
+		{Name: proto.String("other"), ManagedBy: proto.String("team-infra")},
+		{Name: proto.String("admins")},
+		{Name: proto.String("middle")},
+	}
+	for _, g := range groups {
+		if err := ctx.DB.SaveGroup(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !m.GroupInScope("sub", "team-infra") {
+		t.Fatal("sub is not in scope")
+	}
+
+	// Once sub is included by a group holding GLOBAL_ROOT, adding
+	// members to it would hand out GLOBAL_ROOT, so it leaves the
+	// scope.
+	if err := m.SetGroupCapability2("admins", pb.Capability_GLOBAL_ROOT.Enum()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ModifyGroupExpansions("admins", "sub", pb.ExpansionMode_INCLUDE); err != nil {
+		t.Fatal(err)
+	}
+	if m.GroupInScope("sub", "team-infra") {
+		t.Error("sub is in scope while admins includes it")
+	}
+
+	// The same holds when the inclusion is indirect.
+	if err := m.ModifyGroupExpansions("admins", "middle", pb.ExpansionMode_INCLUDE); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ModifyGroupExpansions("middle", "other", pb.ExpansionMode_INCLUDE); err != nil {
+		t.Fatal(err)
+	}
+	if m.GroupInScope("other", "team-infra") {
+		t.Error("other is in scope while admins includes it through middle")
+	}
+}
@@ -0,0 +1,139 @@
+package interface_test
+
+import (
+	"testing"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestRenameEntity(t *testing.T) {
+	m, ctx := newTreeManager(t)
+
+	addEntity(t, ctx)
+	addGroup(t, ctx)
+	if err := m.AddEntityToGroup("entity1", "group1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CreateEntity("entity2", -1, "entity2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.RenameEntity("entity1", "entity2", ""); err != tree.ErrDuplicateEntityID {
+		t.Errorf("Got %v; Want %v", err, tree.ErrDuplicateEntityID)
+	}
+	if err := m.RenameEntity("entity1", "renamed", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ctx.DB.LoadEntity("entity1"); err != db.ErrUnknownEntity {
+		t.Errorf("Got %v; Want %v", err, db.ErrUnknownEntity)
+	}
+	e, err := m.FetchEntity("renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.GetNumber() != 1 {
+		t.Errorf("Got number %d; Want 1", e.GetNumber())
+	}
+	if err := m.ValidateSecret("renamed", "entity1"); err != nil {
+		t.Error(err)
+	}
+
+	members, err := m.ListMembers("group1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || members[0].GetID() != "renamed" {
+		t.Errorf("Got %v; Want [renamed]", members)
+	}
+
+	res, err := m.SearchEntities(db.SearchRequest{Expression: "ID:entity1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 0 {
+		t.Errorf("Old ID is still indexed: %v", res)
+	}
+	res, err = m.SearchEntities(db.SearchRequest{Expression: "ID:renamed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 {
+		t.Errorf("New ID is not indexed: %v", res)
+	}
+}
+
+func TestRenameGroup(t *testing.T) {
+	m, ctx := newTreeManager(t)
+
+	addEntity(t, ctx)
+	addGroup(t, ctx)
+	if err := m.CreateGroup("group2", "", "group1", -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CreateGroup("group3", "", "", -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ModifyGroupExpansions("group3", "group1", pb.ExpansionMode_INCLUDE); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddEntityToGroup("entity1", "group1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.RenameGroup("group1", "group2", ""); err != tree.ErrDuplicateGroupName {
+		t.Errorf("Got %v; Want %v", err, tree.ErrDuplicateGroupName)
+	}
+	if err := m.RenameGroup("group1", "renamed", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ctx.DB.LoadGroup("group1"); err != db.ErrUnknownGroup {
+		t.Errorf("Got %v; Want %v", err, db.ErrUnknownGroup)
+	}
+	g, err := m.FetchGroup("renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.GetDisplayName() != "Group One" {
+		t.Errorf("Got %s; Want Group One", g.GetDisplayName())
+	}
+
+	g, err = m.FetchGroup("group2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.GetManagedBy() != "renamed" {
+		t.Errorf("Got %s; Want renamed", g.GetManagedBy())
+	}
+
+	// The membership is found both directly and through the
+	// rewritten expansion.
+	for _, name := range []string{"renamed", "group3"} {
+		members, err := m.ListMembers(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(members) != 1 || members[0].GetID() != "entity1" {
+			t.Errorf("%s: Got %v; Want [entity1]", name, members)
+		}
+	}
+	e, err := m.FetchEntity("entity1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if groups := m.GetMemberships(e); len(groups) != 2 {
+		t.Errorf("Got %v; Want [renamed group3]", groups)
+	}
+
+	res, err := m.SearchGroups(db.SearchRequest{Expression: "Name:renamed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 {
+		t.Errorf("New name is not indexed: %v", res)
+	}
+}
@@ -0,0 +1,110 @@
+package interface_test
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/db"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestRollbackEntity(t *testing.T) {
+	m, ctx := newTreeManager(t)
+
+	addEntity(t, ctx)
+
+	meta := &pb.EntityMeta{DisplayName: proto.String("First")}
+	if err := m.UpdateEntityMeta("entity1", meta, ""); err != nil {
+		t.Fatal(err)
+	}
+	meta = &pb.EntityMeta{DisplayName: proto.String("Second")}
+	if err := m.UpdateEntityMeta("entity1", meta, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetSecret("entity1", "changed"); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := m.EntityHistory("entity1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h) != 4 {
+		t.Fatalf("Got %d revisions; Want 4", len(h))
+	}
+	if h[0].Entity.GetSecret() != "<REDACTED>" {
+		t.Error("Secret was not redacted")
+	}
+
+	_, rev, err := m.FetchEntityRevision("entity1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A stale revision is rejected.
+	if err := m.RollbackEntity("entity1", h[1].Revision, h[1].Revision); err != db.ErrConflict {
+		t.Errorf("Got %v; Want %v", err, db.ErrConflict)
+	}
+
+	if err := m.RollbackEntity("entity1", h[1].Revision, rev); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := m.FetchEntity("entity1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.GetMeta().GetDisplayName() != "First" {
+		t.Errorf("Got %s; Want First", e.GetMeta().GetDisplayName())
+	}
+
+	// The secret is never rolled back.
+	if err := m.ValidateSecret("entity1", "changed"); err != nil {
+		t.Error(err)
+	}
+
+	if err := m.RollbackEntity("entity1", "0000000000000000", ""); err != db.ErrUnknownRevision {
+		t.Errorf("Got %v; Want %v", err, db.ErrUnknownRevision)
+	}
+}
+
+func TestRollbackGroup(t *testing.T) {
+	m, ctx := newTreeManager(t)
+
+	addGroup(t, ctx)
+
+	update := &pb.Group{DisplayName: proto.String("Changed")}
+	if err := m.UpdateGroupMeta("group1", update, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := m.GroupHistory("group1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h) != 2 {
+		t.Fatalf("Got %d revisions; Want 2", len(h))
+	}
+
+	if err := m.RollbackGroup("group1", h[0].Revision, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := m.FetchGroup("group1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.GetDisplayName() != "Group One" {
+		t.Errorf("Got %s; Want Group One", g.GetDisplayName())
+	}
+
+	// A group that doesn't exist can't be rolled back.
+	if err := m.DestroyGroup("group1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RollbackGroup("group1", h[0].Revision, ""); err != db.ErrUnknownGroup {
+		t.Errorf("Got %v; Want %v", err, db.ErrUnknownGroup)
+	}
+}
@@ -5,6 +5,8 @@ import (
 
 	"github.com/golang/protobuf/proto"
 
+	"github.com/netauth/netauth/internal/db"
+
 	pb "github.com/netauth/protocol"
 )
 
@@ -17,7 +19,7 @@ func TestUpdateEntityMeta(t *testing.T) {
 		GECOS: proto.String("A Test Entity"),
 	}
 
-	if err := m.UpdateEntityMeta("entity1", meta); err != nil {
+	if err := m.UpdateEntityMeta("entity1", meta, ""); err != nil {
 		t.Fatal(err)
 	}
 
@@ -30,3 +32,36 @@ func TestUpdateEntityMeta(t *testing.T) {
 		t.Error("Metadata not set")
 	}
 }
+
+func TestUpdateEntityMetaRevision(t *testing.T) {
+	m, ctx := newTreeManager(t)
+
+	addEntity(t, ctx)
+
+	_, rev, err := m.FetchEntityRevision("entity1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta := &pb.EntityMeta{
+		GECOS: proto.String("A Test Entity"),
+	}
+	if err := m.UpdateEntityMeta("entity1", meta, rev); err != nil {
+		t.Fatal(err)
+	}
+
+	// The revision is now stale since the update above changed
+	// the entity.
+	meta.GECOS = proto.String("Another Test Entity")
+	if err := m.UpdateEntityMeta("entity1", meta, rev); err != db.ErrConflict {
+		t.Errorf("Stale revision was accepted: %v", err)
+	}
+
+	e, err := ctx.DB.LoadEntity("entity1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.GetMeta().GetGECOS() != "A Test Entity" {
+		t.Error("Conflicting update was applied")
+	}
+}
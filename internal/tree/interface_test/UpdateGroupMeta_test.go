@@ -5,6 +5,8 @@ import (
 
 	"github.com/golang/protobuf/proto"
 
+	"github.com/netauth/netauth/internal/db"
+
 	pb "github.com/netauth/protocol"
 )
 
@@ -17,7 +19,7 @@ func TestUpdateGroupMeta(t *testing.T) {
 		DisplayName: proto.String("SomeGroup"),
 	}
 
-	if err := m.UpdateGroupMeta("group1", update); err != nil {
+	if err := m.UpdateGroupMeta("group1", update, ""); err != nil {
 		t.Fatal(err)
 	}
 
@@ -30,3 +32,30 @@ func TestUpdateGroupMeta(t *testing.T) {
 		t.Error("Group metadata not updated")
 	}
 }
+
+func TestUpdateGroupMetaRevision(t *testing.T) {
+	m, ctx := newTreeManager(t)
+
+	addGroup(t, ctx)
+
+	_, rev, err := m.FetchGroupRevision("group1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.UpdateGroupMeta("group1", &pb.Group{DisplayName: proto.String("SomeGroup")}, rev); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.UpdateGroupMeta("group1", &pb.Group{DisplayName: proto.String("OtherGroup")}, rev); err != db.ErrConflict {
+		t.Errorf("Stale revision was accepted: %v", err)
+	}
+
+	g, err := ctx.DB.LoadGroup("group1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.GetDisplayName() != "SomeGroup" {
+		t.Error("Conflicting update was applied")
+	}
+}
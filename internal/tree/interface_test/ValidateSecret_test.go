@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/netauth/netauth/internal/crypto"
+	"github.com/netauth/netauth/internal/db"
 )
 
 func TestValidateSecret(t *testing.T) {
@@ -19,3 +20,29 @@ func TestValidateSecret(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestValidateSecretHistory(t *testing.T) {
+	m, ctx := newTreeManager(t)
+
+	addEntity(t, ctx)
+	before, err := ctx.DB.(*db.DB).EntityHistory("entity1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Logging in saves the entity, but doesn't change it, so it
+	// mustn't push the real revisions out of the history.
+	for i := 0; i < 12; i++ {
+		if err := m.ValidateSecret("entity1", "entity1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	after, err := ctx.DB.(*db.DB).EntityHistory("entity1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before) {
+		t.Errorf("Got %d revisions; Want %d", len(after), len(before))
+	}
+}
@@ -24,6 +24,7 @@ func New(db DB, crypto crypto.EMCrypto, l hclog.Logger) (*Manager, error) {
 	x.crypto = crypto
 	x.resolver = mresolver.New()
 	x.resolver.SetParentLogger(x.log)
+	x.rules = newRuleSet()
 	x.refContext = RefContext{
 		DB:     db,
 		Crypto: crypto,
@@ -31,6 +32,7 @@ func New(db DB, crypto crypto.EMCrypto, l hclog.Logger) (*Manager, error) {
 
 	x.db.RegisterCallback("entity-resolver", x.entityResolverCallback)
 	x.db.RegisterCallback("group-resolver", x.groupResolverCallback)
+	x.db.ConfigureMembership(x.resolver.MembersOfGroup)
 
 	// Initialize all entity hooks and bind to names.
 	x.entityHooks = make(map[string]EntityHook)
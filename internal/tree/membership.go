@@ -1,21 +1,48 @@
 package tree
 
 import (
+	"context"
 	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/mresolver"
 
 	pb "github.com/netauth/protocol"
 	rpc "github.com/netauth/protocol/v2"
 )
 
+// MembershipExpiryKey is the key of the entity KV data that holds the
+// times at which direct memberships expire.  Each value is the name
+// of a group and the time in RFC 3339 format, separated by a colon.
+// The GROUP-ADD chain is passed the expiry time under the same key.
+const MembershipExpiryKey = "netauth.until"
+
 // AddEntityToGroup is the same as the internal function, but takes an
 // entity ID rather than a pointer
 func (m *Manager) AddEntityToGroup(entityID, groupName string) error {
+	return m.AddEntityToGroupUntil(entityID, groupName, time.Time{})
+}
+
+// AddEntityToGroupUntil adds an entity to a group until the given
+// time, after which the membership is no longer honoured and is
+// removed by ReapMemberships.  If until is the zero time the
+// membership doesn't expire, and adding an entity to a group that it
+// is already in changes when the membership expires.
+func (m *Manager) AddEntityToGroupUntil(entityID, groupName string, until time.Time) error {
 	de := &pb.Entity{
 		ID: &entityID,
 		Meta: &pb.EntityMeta{
 			Groups: []string{groupName},
 		},
 	}
+	if !until.IsZero() {
+		de.Meta.KV = instructionKV(MembershipExpiryKey, until.UTC().Format(time.RFC3339))
+	}
 
 	_, err := m.RunEntityChain("GROUP-ADD", de)
 	return err
@@ -35,6 +62,122 @@ func (m *Manager) RemoveEntityFromGroup(entityID, groupName string) error {
 	return err
 }
 
+// ReapMemberships removes the direct memberships that expired at or
+// before now, returning how many were removed.  Each membership is
+// removed with the GROUP-DEL chain in the same way as if it had been
+// removed by hand.  A membership that can't be removed doesn't stop
+// the others from being reaped; each failure is logged and they are
+// returned together.
+func (m *Manager) ReapMemberships(now time.Time) (int, error) {
+	ids, err := m.db.DiscoverEntityIDs()
+	if err != nil {
+		return 0, err
+	}
+
+	reaped := 0
+	var failed []string
+	for _, id := range ids {
+		e, err := m.db.LoadEntity(path.Base(id))
+		if err == db.ErrUnknownEntity {
+			// Removed since the IDs were discovered.
+			continue
+		}
+		if err != nil {
+			m.log.Warn("Error loading entity to reap memberships", "entity", path.Base(id), "error", err)
+			failed = append(failed, fmt.Sprintf("%s: %v", path.Base(id), err))
+			continue
+		}
+		for group, until := range MembershipExpiry(e) {
+			if now.Before(until) {
+				continue
+			}
+			if err := m.RemoveEntityFromGroup(e.GetID(), group); err != nil {
+				m.log.Warn("Error removing expired membership", "entity", e.GetID(), "group", group, "error", err)
+				failed = append(failed, fmt.Sprintf("%s in %s: %v", e.GetID(), group, err))
+				continue
+			}
+			m.log.Info("Expired membership removed", "entity", e.GetID(), "group", group, "until", until)
+			reaped++
+		}
+	}
+	if len(failed) > 0 {
+		return reaped, fmt.Errorf("%d memberships could not be reaped: %s", len(failed), strings.Join(failed, "; "))
+	}
+	return reaped, nil
+}
+
+// RunReaper calls ReapMemberships every interval until the context is
+// cancelled.  Failures are logged and the memberships are reaped
+// again at the usual time.  When the database is shared by a cluster
+// only the leader reaps, so that each membership is removed once.
+func (m *Manager) RunReaper(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			if !m.db.Leader() {
+				continue
+			}
+			if _, err := m.ReapMemberships(now); err != nil {
+				m.log.Error("Error reaping expired memberships", "error", err)
+			}
+		}
+	}
+}
+
+// MembershipExpiry returns the times at which the direct memberships
+// of an entity expire by group name.  Memberships that don't expire
+// are not included.
+func MembershipExpiry(e *pb.Entity) map[string]time.Time {
+	out := make(map[string]time.Time)
+	for _, kv := range e.GetMeta().GetKV() {
+		if kv.GetKey() != MembershipExpiryKey {
+			continue
+		}
+		for _, v := range kv.GetValues() {
+			parts := strings.SplitN(v.GetValue(), ":", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, parts[1])
+			if err != nil {
+				continue
+			}
+			out[parts[0]] = t
+		}
+	}
+	return out
+}
+
+// SetMembershipExpiry sets the time at which the direct membership of
+// an entity in a group expires, or makes it permanent if until is the
+// zero time.  The entity must have metadata.
+func SetMembershipExpiry(e *pb.Entity, group string, until time.Time) {
+	var values []*pb.KVValue
+	var kv []*pb.KVData
+	for _, d := range e.GetMeta().GetKV() {
+		if d.GetKey() != MembershipExpiryKey {
+			kv = append(kv, d)
+			continue
+		}
+		for _, v := range d.GetValues() {
+			if strings.SplitN(v.GetValue(), ":", 2)[0] != group {
+				values = append(values, v)
+			}
+		}
+	}
+	if !until.IsZero() {
+		values = append(values, &pb.KVValue{Value: proto.String(group + ":" + until.UTC().Format(time.RFC3339))})
+	}
+	if len(values) > 0 {
+		kv = append(kv, &pb.KVData{Key: proto.String(MembershipExpiryKey), Values: values})
+	}
+	e.Meta.KV = kv
+}
+
 // GetMemberships returns a list of group names that an entity is a
 // member of.  This membership may either be direct or it may be via
 // an expanded group rule.  This difference is not distinguished.
@@ -66,6 +209,19 @@ func (m *Manager) ListMembers(groupID string) ([]*pb.Entity, error) {
 	return safeMembers, nil
 }
 
+// ExplainMembership explains why an entity is or isn't a member of a
+// group, showing the direct and dynamic memberships and the
+// expansions that were used to decide it.
+func (m *Manager) ExplainMembership(entityID, groupName string) (mresolver.Explanation, error) {
+	if _, err := m.db.LoadEntity(entityID); err != nil {
+		return mresolver.Explanation{}, err
+	}
+	if _, err := m.db.LoadGroup(groupName); err != nil {
+		return mresolver.Explanation{}, err
+	}
+	return m.resolver.Explain(entityID, groupName)
+}
+
 // ModifyGroupExpansions handles changing the expansions on a group.
 // This can include adding an INCLUDE or EXCLUDE type expansion, or
 // using the special expansion type DROP, removing an existing one.
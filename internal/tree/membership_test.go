@@ -0,0 +1,91 @@
+package tree
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/netauth/netauth/internal/db"
+	_ "github.com/netauth/netauth/internal/db/memory"
+	"github.com/netauth/netauth/internal/startup"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestSetMembershipExpiry(t *testing.T) {
+	e := &pb.Entity{
+		Meta: &pb.EntityMeta{
+			KV: []*pb.KVData{{
+				Key:    proto.String("location"),
+				Values: []*pb.KVValue{{Value: proto.String("berlin")}},
+			}},
+		},
+	}
+	t1 := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+
+	SetMembershipExpiry(e, "group1", t1)
+	SetMembershipExpiry(e, "group2", t1)
+	SetMembershipExpiry(e, "group2", t2)
+	got := MembershipExpiry(e)
+	if len(got) != 2 || !got["group1"].Equal(t1) || !got["group2"].Equal(t2) {
+		t.Errorf("Got %v; Want group1 until %v and group2 until %v", got, t1, t2)
+	}
+
+	// Making every membership permanent removes the key, and
+	// other keys are left alone.
+	SetMembershipExpiry(e, "group1", time.Time{})
+	SetMembershipExpiry(e, "group2", time.Time{})
+	if len(MembershipExpiry(e)) != 0 || len(e.GetMeta().GetKV()) != 1 || e.GetMeta().GetKV()[0].GetKey() != "location" {
+		t.Errorf("Got %v; Want only the location key", e.GetMeta().GetKV())
+	}
+}
+
+func TestReapMembershipsContinues(t *testing.T) {
+	startup.DoCallbacks()
+
+	mdb, err := db.New("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := Manager{
+		db: mdb,
+		entityProcesses: map[string][]EntityHook{
+			"GROUP-DEL": {&failForEntityHook{"entity1"}},
+		},
+		log: hclog.NewNullLogger(),
+	}
+
+	now := time.Now()
+	for _, id := range []string{"entity1", "entity2"} {
+		e := &pb.Entity{ID: proto.String(id), Meta: &pb.EntityMeta{Groups: []string{"group1"}}}
+		SetMembershipExpiry(e, "group1", now)
+		if err := mdb.SaveEntity(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The membership that can't be removed doesn't stop the
+	// other from being reaped.
+	n, err := m.ReapMemberships(now)
+	if n != 1 || err == nil {
+		t.Errorf("Got %d %v; Want 1 and an error", n, err)
+	}
+}
+
+// failForEntityHook fails the chain for one entity only.
+type failForEntityHook struct {
+	id string
+}
+
+func (*failForEntityHook) Name() string  { return "fail-for-hook" }
+func (*failForEntityHook) Priority() int { return 50 }
+func (h *failForEntityHook) Run(_ Txn, _, de *pb.Entity) error {
+	if de.GetID() == h.id {
+		return errors.New("chain failure")
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package tree
+
+import (
+	"strings"
+)
+
+// The ways in which a DESTROY chain can handle the references that
+// other entities and groups hold to the object being destroyed.  In
+// cascade mode the references are removed along with the object, and
+// in restrict mode the object can't be destroyed while any remain.
+const (
+	DestroyCascade  = "cascade"
+	DestroyRestrict = "restrict"
+)
+
+// A ReferenceError is returned when an object can't be destroyed
+// because it is still referenced.  Each reference describes one
+// object that refers to it, and how.
+type ReferenceError struct {
+	References []string
+}
+
+func (e *ReferenceError) Error() string {
+	return "still referenced by " + strings.Join(e.References, ", ")
+}
+
+// SetDestroyMode replaces the DESTROY chains with those for the
+// given mode, which must be one of DestroyCascade or DestroyRestrict.
+// Any hooks that should run in addition to the defaults must be
+// added after this is called.
+func (m *Manager) SetDestroyMode(mode string) error {
+	ec, ok := destroyEntityChains[mode]
+	if !ok {
+		return ErrUnknownDestroyMode
+	}
+	gc := destroyGroupChains[mode]
+
+	m.entityProcesses["DESTROY"] = nil
+	for _, h := range ec {
+		if err := m.RegisterEntityHookToChain(h, "DESTROY"); err != nil {
+			return err
+		}
+	}
+	m.groupProcesses["DESTROY"] = nil
+	for _, h := range gc {
+		if err := m.RegisterGroupHookToChain(h, "DESTROY"); err != nil {
+			return err
+		}
+	}
+	m.log.Debug("Destroy mode set", "mode", mode)
+	return nil
+}
@@ -0,0 +1,64 @@
+package tree
+
+import (
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/netauth/protocol"
+)
+
+// RenameKey is the key under which the RENAME chains are passed the
+// new name.  The data entity or group names the object as it is
+// currently known, and carries the new name as the only value of this
+// key.
+const RenameKey = "netauth.rename"
+
+// RenameEntity changes the ID of an entity.  The entity keeps its
+// number, secret, and metadata.  If rev is not empty the rename will
+// fail with db.ErrConflict unless the entity is still at that
+// revision.
+func (m *Manager) RenameEntity(ID, to, rev string) error {
+	de := &pb.Entity{
+		ID:   &ID,
+		Meta: &pb.EntityMeta{KV: instructionKV(RenameKey, to)},
+	}
+
+	_, err := m.runEntityChain("RENAME", de, rev)
+	return err
+}
+
+// RenameGroup changes the name of a group.  Every reference to the
+// group is rewritten to the new name in the same transaction,
+// including direct memberships, expansions, and groups that it
+// manages.  If rev is not empty the rename will fail with
+// db.ErrConflict unless the group is still at that revision.
+func (m *Manager) RenameGroup(name, to, rev string) error {
+	dg := &pb.Group{
+		Name: &name,
+		KV:   instructionKV(RenameKey, to),
+	}
+
+	_, err := m.runGroupChain("RENAME", dg, rev)
+	return err
+}
+
+// instructionKV returns the KV data that passes a single value to a
+// chain under the given key.
+func instructionKV(key, value string) []*pb.KVData {
+	return []*pb.KVData{{
+		Key:    proto.String(key),
+		Values: []*pb.KVValue{{Value: proto.String(value)}},
+	}}
+}
+
+// ReservedKey returns true if KV data under the key is maintained by
+// the server and can't be changed with the KV chains.  This is every
+// key in the netauth namespace except ScopeKey and GroupRuleKey, which
+// are set with the KV chains and checked as they are.
+func ReservedKey(key string) bool {
+	if key == ScopeKey || key == GroupRuleKey {
+		return false
+	}
+	return strings.HasPrefix(key, "netauth.")
+}
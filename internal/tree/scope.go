@@ -0,0 +1,169 @@
+package tree
+
+import (
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/netauth/protocol"
+)
+
+// ScopeKey is the key of the KV data that grants capabilities in a
+// scope rather than globally.  Each value is the name of a capability
+// and the name of a group, separated by a colon.  When the key is set
+// on an entity the entity holds the capabilities, and when it is set
+// on a group every member of the group holds them.
+//
+// The scope of a group is the group itself and every group that is
+// managed by a group in the scope, followed through as many levels
+// of ManagedBy as there are.  The entities in the scope are the
+// members of those groups.  Groups and entities that hold any
+// capability, whether global or scoped, are never in a scope so that
+// a scoped capability can't be used to gain another one.  Neither are
+// groups that are included by a group holding a capability, since
+// their members hold it too.
+const ScopeKey = "netauth.scope"
+
+// A ScopedCapability is a capability that is held only over the
+// groups and entities in the scope of a group.
+type ScopedCapability struct {
+	Capability pb.Capability
+	Scope      string
+}
+
+// String returns the scoped capability in the form in which it is
+// stored.
+func (sc ScopedCapability) String() string {
+	return sc.Capability.String() + ":" + sc.Scope
+}
+
+// ParseScopedCapability parses a value stored under ScopeKey.
+// GLOBAL_ROOT can't be held in a scope.
+func ParseScopedCapability(s string) (ScopedCapability, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return ScopedCapability{}, ErrBadScope
+	}
+	c, ok := pb.Capability_value[strings.ToUpper(parts[0])]
+	if !ok || pb.Capability(c) == pb.Capability_GLOBAL_ROOT {
+		return ScopedCapability{}, ErrBadScope
+	}
+	return ScopedCapability{Capability: pb.Capability(c), Scope: parts[1]}, nil
+}
+
+// scopedCapabilities returns the scoped capabilities stored in KV
+// data.  Values that can't be parsed are ignored.
+func scopedCapabilities(kv []*pb.KVData) []ScopedCapability {
+	var out []ScopedCapability
+	for _, d := range kv {
+		if d.GetKey() != ScopeKey {
+			continue
+		}
+		for _, v := range d.GetValues() {
+			if sc, err := ParseScopedCapability(v.GetValue()); err == nil {
+				out = append(out, sc)
+			}
+		}
+	}
+	return out
+}
+
+// RenameScope rewrites the scoped capabilities in KV data that name
+// the group from so that they name the group to instead.  It returns
+// true if anything was changed.
+func RenameScope(kv []*pb.KVData, from, to string) bool {
+	changed := false
+	for _, d := range kv {
+		if d.GetKey() != ScopeKey {
+			continue
+		}
+		for _, v := range d.GetValues() {
+			sc, err := ParseScopedCapability(v.GetValue())
+			if err != nil || sc.Scope != from {
+				continue
+			}
+			sc.Scope = to
+			v.Value = proto.String(sc.String())
+			changed = true
+		}
+	}
+	return changed
+}
+
+// EntityScopedCapabilities returns the scoped capabilities that an
+// entity holds, either itself or through the groups that it is a
+// member of.
+func (m *Manager) EntityScopedCapabilities(entityID string) []ScopedCapability {
+	e, err := m.db.LoadEntity(entityID)
+	if err != nil {
+		return nil
+	}
+	out := scopedCapabilities(e.GetMeta().GetKV())
+	for _, name := range m.resolver.GroupsForEntity(entityID) {
+		g, err := m.db.LoadGroup(name)
+		if err != nil {
+			continue
+		}
+		out = append(out, scopedCapabilities(g.GetKV())...)
+	}
+	return out
+}
+
+// ScopeCovers returns true if the group is the root of the scope or
+// is managed from within it.  It doesn't check whether the group
+// holds any capabilities, which GroupInScope does.
+func (m *Manager) ScopeCovers(group, scope string) bool {
+	seen := make(map[string]bool)
+	for name := group; name != "" && !seen[name]; {
+		if name == scope {
+			return true
+		}
+		seen[name] = true
+		g, err := m.db.LoadGroup(name)
+		if err != nil {
+			return false
+		}
+		name = g.GetManagedBy()
+	}
+	return false
+}
+
+// GroupInScope returns true if the group is in the scope of another
+// group.
+func (m *Manager) GroupInScope(group, scope string) bool {
+	if m.groupHoldsCapabilities(group) {
+		return false
+	}
+	for _, name := range m.resolver.GroupsIncluding(group) {
+		if m.groupHoldsCapabilities(name) {
+			return false
+		}
+	}
+	return m.ScopeCovers(group, scope)
+}
+
+// groupHoldsCapabilities returns true if the group holds any
+// capability, whether global or scoped.  A group that can't be loaded
+// is assumed to hold one.
+func (m *Manager) groupHoldsCapabilities(name string) bool {
+	g, err := m.db.LoadGroup(name)
+	if err != nil {
+		return true
+	}
+	return len(g.GetCapabilities()) > 0 || len(scopedCapabilities(g.GetKV())) > 0
+}
+
+// EntityInScope returns true if the entity is a member of any group
+// in the scope of another group.
+func (m *Manager) EntityInScope(entityID, scope string) bool {
+	grants, err := m.EffectiveCapabilities(entityID, nil)
+	if err != nil || len(grants) > 0 || len(m.EntityScopedCapabilities(entityID)) > 0 {
+		return false
+	}
+	for _, name := range m.resolver.GroupsForEntity(entityID) {
+		if m.ScopeCovers(name, scope) {
+			return true
+		}
+	}
+	return false
+}
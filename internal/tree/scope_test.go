@@ -0,0 +1,55 @@
+package tree
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestParseScopedCapability(t *testing.T) {
+	cases := []struct {
+		s       string
+		want    ScopedCapability
+		wantErr error
+	}{
+		{"CREATE_ENTITY:team-infra", ScopedCapability{pb.Capability_CREATE_ENTITY, "team-infra"}, nil},
+		{"modify_group_members:team:infra", ScopedCapability{pb.Capability_MODIFY_GROUP_MEMBERS, "team:infra"}, nil},
+		{"GLOBAL_ROOT:team-infra", ScopedCapability{}, ErrBadScope},
+		{"NOT_A_CAPABILITY:team-infra", ScopedCapability{}, ErrBadScope},
+		{"CREATE_ENTITY:", ScopedCapability{}, ErrBadScope},
+		{"CREATE_ENTITY", ScopedCapability{}, ErrBadScope},
+	}
+
+	for i, c := range cases {
+		sc, err := ParseScopedCapability(c.s)
+		if sc != c.want || err != c.wantErr {
+			t.Errorf("%d: Got %v, %v; Want %v, %v", i, sc, err, c.want, c.wantErr)
+		}
+	}
+}
+
+func TestRenameScope(t *testing.T) {
+	kv := []*pb.KVData{
+		{Key: proto.String("location"), Values: []*pb.KVValue{{Value: proto.String("CREATE_ENTITY:team-infra")}}},
+		{Key: proto.String(ScopeKey), Values: []*pb.KVValue{
+			{Value: proto.String("CREATE_ENTITY:team-infra")},
+			{Value: proto.String("LOCK_ENTITY:team-web")},
+		}},
+	}
+
+	if !RenameScope(kv, "team-infra", "team-ops") {
+		t.Error("Scope was not renamed")
+	}
+	if RenameScope(kv, "team-infra", "team-ops") {
+		t.Error("Scope was renamed twice")
+	}
+	want := []string{"CREATE_ENTITY:team-infra", "CREATE_ENTITY:team-ops", "LOCK_ENTITY:team-web"}
+	got := []string{kv[0].Values[0].GetValue(), kv[1].Values[0].GetValue(), kv[1].Values[1].GetValue()}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%d: Got %v; Want %v", i, got[i], want[i])
+		}
+	}
+}
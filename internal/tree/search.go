@@ -26,3 +26,26 @@ func (m *Manager) SearchEntities(r db.SearchRequest) ([]*pb.Entity, error) {
 	}
 	return out, nil
 }
+
+// SearchGroupPage returns a page of the groups filtered by the search
+// criteria, and the token for the next page, which is empty if there
+// are no more groups.
+func (m *Manager) SearchGroupPage(r db.SearchRequest) ([]*pb.Group, string, error) {
+	return m.db.SearchGroupPage(r)
+}
+
+// SearchEntityPage returns a page of the entities filtered by the
+// search criteria, and the token for the next page, which is empty
+// if there are no more entities.
+func (m *Manager) SearchEntityPage(r db.SearchRequest) ([]*pb.Entity, string, error) {
+	entities, next, err := m.db.SearchEntityPage(r)
+	if err != nil {
+		return nil, "", err
+	}
+
+	out := make([]*pb.Entity, len(entities))
+	for i := range entities {
+		out[i] = safeCopyEntity(entities[i])
+	}
+	return out, next, nil
+}
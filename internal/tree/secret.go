@@ -0,0 +1,33 @@
+package tree
+
+import (
+	"strings"
+
+	"github.com/netauth/netauth/internal/db"
+)
+
+// SecretHistoryBucket is the bucket that the previous secrets of each
+// entity are kept in, under the ID of the entity.  The secrets are
+// kept apart from the entities so that they are never returned or
+// indexed with them.
+const SecretHistoryBucket = db.SecretHistoryBucket
+
+// A SecretPolicyError is returned when a secret is rejected by the
+// password policy.  Each reason describes one way in which the secret
+// falls short, so that the person choosing it can pick a better one.
+type SecretPolicyError struct {
+	Reasons []string
+}
+
+func (e *SecretPolicyError) Error() string {
+	return "the secret does not meet the password policy: " + strings.Join(e.Reasons, ", ")
+}
+
+// PurgeSecretHistory forgets the previous secrets of an entity, so
+// that any of them may be chosen again.
+func (m *Manager) PurgeSecretHistory(ID string) error {
+	if _, err := m.db.LoadEntity(ID); err != nil {
+		return err
+	}
+	return m.db.Bucket(SecretHistoryBucket).Del(ID)
+}
@@ -3,9 +3,11 @@ package tree
 import (
 	"github.com/hashicorp/go-hclog"
 
+	"github.com/netauth/netauth/internal/auditlog"
 	"github.com/netauth/netauth/internal/crypto"
 	"github.com/netauth/netauth/internal/db"
 	"github.com/netauth/netauth/internal/mresolver"
+	"github.com/netauth/netauth/pkg/audit"
 
 	types "github.com/netauth/protocol"
 )
@@ -41,6 +43,16 @@ type Manager struct {
 
 	resolver *mresolver.MResolver
 
+	// The rules of the dynamic groups are evaluated for each
+	// entity as it changes.
+	rules *ruleSet
+
+	// The audit log records each change made by a chain, and the
+	// actor is who the change is attributed to.  The actor is
+	// only set on the copies returned by WithActor.
+	auditLog *auditlog.Log
+	actor    *audit.Actor
+
 	log hclog.Logger
 }
 
@@ -52,7 +64,11 @@ type DB interface {
 	SaveEntity(*types.Entity) error
 	DeleteEntity(string) error
 	NextEntityNumber() (int32, error)
+	AllocateEntityNumber(string) (int32, error)
+	ClaimEntityNumber(int32) error
+	ReleaseEntityNumber(int32) error
 	SearchEntities(db.SearchRequest) ([]*types.Entity, error)
+	SearchEntityPage(db.SearchRequest) ([]*types.Entity, string, error)
 
 	// Group handling
 	DiscoverGroupNames() ([]string, error)
@@ -60,10 +76,57 @@ type DB interface {
 	SaveGroup(*types.Group) error
 	DeleteGroup(string) error
 	NextGroupNumber() (int32, error)
+	AllocateGroupNumber(string) (int32, error)
+	ClaimGroupNumber(int32) error
+	ReleaseGroupNumber(int32) error
 	SearchGroups(db.SearchRequest) ([]*types.Group, error)
+	SearchGroupPage(db.SearchRequest) ([]*types.Group, string, error)
+
+	// Transactions
+	Begin() *db.Txn
+
+	// History
+	EntityHistory(string) ([]db.HistoricEntity, error)
+	GroupHistory(string) ([]db.HistoricGroup, error)
+	LoadEntityRevision(string, string) (*types.Entity, error)
+	LoadGroupRevision(string, string) (*types.Group, error)
 
 	// Callbacks
 	RegisterCallback(string, db.Callback)
+	ConfigureMembership(func(string) []string)
+
+	// Storage for data kept alongside entities and groups
+	Bucket(string) *db.Bucket
+
+	// Clustering
+	Leader() bool
+}
+
+// A Txn is the view of the database that is handed to hooks.  When a
+// chain is run it is the transaction of that chain, so that reads
+// observe the writes made by earlier hooks and nothing is visible to
+// anybody else until the chain has completed.  Outside of a chain a
+// DB may be used directly.
+type Txn interface {
+	DiscoverEntityIDs() ([]string, error)
+	LoadEntity(string) (*types.Entity, error)
+	SaveEntity(*types.Entity) error
+	DeleteEntity(string) error
+	NextEntityNumber() (int32, error)
+	AllocateEntityNumber(string) (int32, error)
+	ClaimEntityNumber(int32) error
+	ReleaseEntityNumber(int32) error
+
+	DiscoverGroupNames() ([]string, error)
+	LoadGroup(string) (*types.Group, error)
+	SaveGroup(*types.Group) error
+	DeleteGroup(string) error
+	NextGroupNumber() (int32, error)
+	AllocateGroupNumber(string) (int32, error)
+	ClaimGroupNumber(int32) error
+	ReleaseGroupNumber(int32) error
+
+	Bucket(string) *db.Bucket
 }
 
 // A RefContext is a container of references that are needed to
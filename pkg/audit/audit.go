@@ -0,0 +1,156 @@
+// Package audit defines the records that a NetAuth server keeps of
+// every change made to an entity or group, and the service used to
+// query them.  Each record says who made the change, what chain in
+// the tree carried it out, and which fields changed.
+//
+// The service is not part of the protocol definitions and so is
+// described here by hand.  Messages are encoded as JSON.
+package audit
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/netauth/netauth/internal/grpcjson"
+)
+
+// ServiceName is the name of the audit service on the wire.
+const ServiceName = "netauth.audit.Audit"
+
+// The kinds of object that a record may describe.
+const (
+	KindEntity = "entity"
+	KindGroup  = "group"
+)
+
+// Actor identifies who made a change.  Entity and Capabilities come
+// from the token that authorized the change, while Client and
+// Service are the names the client provided.  Changes made by the
+// server itself have an empty Actor.
+type Actor struct {
+	Entity       string
+	Capabilities []string `json:",omitempty"`
+	Client       string   `json:",omitempty"`
+	Service      string   `json:",omitempty"`
+}
+
+// FieldChange is the change to a single field.  Nested fields are
+// named with dots, such as meta.Shell.  An empty Old or New means the
+// field was unset before or after the change.
+type FieldChange struct {
+	Field string
+	Old   string `json:",omitempty"`
+	New   string `json:",omitempty"`
+}
+
+// Record is a single change to an entity or group.  Target is the
+// entity ID or group name.
+type Record struct {
+	Time    time.Time
+	Actor   Actor
+	Chain   string
+	Kind    string
+	Target  string
+	Changes []FieldChange
+}
+
+// Query selects records.  Target and Actor match the target of the
+// change and the entity that made it.  Since and Until bound the
+// time of the change.  Any field that is left empty matches all
+// records.  If Limit is greater than zero only the most recent Limit
+// matching records are returned.
+type Query struct {
+	Target string
+	Actor  string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// Matches returns true if the record is one that the query selects.
+func (q *Query) Matches(r *Record) bool {
+	if q.Target != "" && q.Target != r.Target {
+		return false
+	}
+	if q.Actor != "" && q.Actor != r.Actor.Entity {
+		return false
+	}
+	if !q.Since.IsZero() && r.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && r.Time.After(q.Until) {
+		return false
+	}
+	return true
+}
+
+// QueryResult carries the records that matched a query, oldest
+// first.
+type QueryResult struct {
+	Records []*Record
+}
+
+// Server is implemented by the NetAuth server to answer queries.
+type Server interface {
+	AuditQuery(context.Context, *Query) (*QueryResult, error)
+}
+
+// RegisterServer binds an audit server to a gRPC server.
+func RegisterServer(s *grpc.Server, srv Server) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*Server)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Query",
+			Handler:    queryHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+func queryHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	q := new(Query)
+	if err := dec(q); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(Server).AuditQuery(ctx, q)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + ServiceName + "/Query",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(Server).AuditQuery(ctx, req.(*Query))
+	}
+	return interceptor(ctx, q, info, handler)
+}
+
+// Client queries the audit log on a NetAuth server.
+type Client interface {
+	AuditQuery(context.Context, *Query, ...grpc.CallOption) (*QueryResult, error)
+}
+
+// NewClient returns a Client using the provided connection.
+func NewClient(cc grpc.ClientConnInterface) Client {
+	return &client{cc}
+}
+
+type client struct {
+	cc grpc.ClientConnInterface
+}
+
+func (c *client) AuditQuery(ctx context.Context, q *Query, opts ...grpc.CallOption) (*QueryResult, error) {
+	opts = append(opts, grpcjson.CallOption())
+	out := new(QueryResult)
+	if err := c.cc.Invoke(ctx, "/"+ServiceName+"/Query", q, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,37 @@
+package audit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryMatches(t *testing.T) {
+	now := time.Now()
+	r := &Record{
+		Time:   now,
+		Actor:  Actor{Entity: "admin"},
+		Target: "entity1",
+	}
+
+	cases := []struct {
+		q    Query
+		want bool
+	}{
+		{Query{}, true},
+		{Query{Target: "entity1"}, true},
+		{Query{Target: "entity2"}, false},
+		{Query{Actor: "admin"}, true},
+		{Query{Actor: "entity1"}, false},
+		{Query{Since: now.Add(-time.Minute)}, true},
+		{Query{Since: now.Add(time.Minute)}, false},
+		{Query{Until: now.Add(time.Minute)}, true},
+		{Query{Until: now.Add(-time.Minute)}, false},
+		{Query{Target: "entity1", Actor: "admin", Since: now.Add(-time.Minute), Until: now.Add(time.Minute)}, true},
+	}
+
+	for i, c := range cases {
+		assert.Equalf(t, c.want, c.q.Matches(r), "Test Number %d", i)
+	}
+}
@@ -0,0 +1,126 @@
+// Package capabilities defines the service used to show the
+// capabilities that an entity holds on a NetAuth server, and to
+// evaluate what they would be after a proposed change.
+//
+// The service is not part of the protocol definitions and so is
+// described here by hand.  Messages are encoded as JSON.
+package capabilities
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+
+	"github.com/netauth/netauth/internal/grpcjson"
+)
+
+// ServiceName is the name of the capabilities service on the wire.
+const ServiceName = "netauth.capabilities.Capabilities"
+
+// The kinds of change that can be proposed.
+const (
+	KindMembership = "membership"
+	KindExpansion  = "expansion"
+	KindCapability = "capability"
+)
+
+// Request names the entity whose capabilities are wanted.  If WhatIf
+// is not empty the capabilities that the entity would hold after the
+// changes are returned as well.
+type Request struct {
+	Entity string
+	WhatIf []*Change `json:",omitempty"`
+}
+
+// Change is a proposed change that is evaluated but not applied.
+//
+// A membership change adds the entity to Group or removes it, and has
+// a Mode of ADD or DROP.  An expansion change has a Mode of INCLUDE,
+// EXCLUDE, or DROP, and changes the expansion of Child in Group.  A
+// capability change adds or drops Capability, which is held by Group
+// or, if Group is empty, by the entity itself.
+type Change struct {
+	Kind       string
+	Mode       string
+	Group      string `json:",omitempty"`
+	Child      string `json:",omitempty"`
+	Capability string `json:",omitempty"`
+}
+
+// Grant is a capability that the entity holds.  Direct is set if the
+// entity holds it itself, and Groups names the groups that grant it.
+type Grant struct {
+	Capability string
+	Direct     bool     `json:",omitempty"`
+	Groups     []string `json:",omitempty"`
+}
+
+// Result carries the capabilities that the entity holds now, and
+// those that it would hold if the proposed changes were made.
+type Result struct {
+	Capabilities []*Grant
+	WhatIf       []*Grant `json:",omitempty"`
+}
+
+// Server is implemented by the NetAuth server to serve capabilities.
+type Server interface {
+	EntityCapabilities(context.Context, *Request) (*Result, error)
+}
+
+// RegisterServer binds a capabilities server to a gRPC server.
+func RegisterServer(s *grpc.Server, srv Server) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*Server)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "EntityCapabilities",
+			Handler:    entityCapabilitiesHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+func entityCapabilitiesHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	r := new(Request)
+	if err := dec(r); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(Server).EntityCapabilities(ctx, r)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + ServiceName + "/EntityCapabilities",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(Server).EntityCapabilities(ctx, req.(*Request))
+	}
+	return interceptor(ctx, r, info, handler)
+}
+
+// Client shows the capabilities of entities on a NetAuth server.
+type Client interface {
+	EntityCapabilities(context.Context, *Request, ...grpc.CallOption) (*Result, error)
+}
+
+// NewClient returns a Client using the provided connection.
+func NewClient(cc grpc.ClientConnInterface) Client {
+	return &client{cc}
+}
+
+type client struct {
+	cc grpc.ClientConnInterface
+}
+
+func (c *client) EntityCapabilities(ctx context.Context, r *Request, opts ...grpc.CallOption) (*Result, error) {
+	opts = append(opts, grpcjson.CallOption())
+	out := new(Result)
+	if err := c.cc.Invoke(ctx, "/"+ServiceName+"/EntityCapabilities", r, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,112 @@
+// Package explain defines the service used to explain why an entity
+// is or isn't a member of a group on a NetAuth server.
+//
+// The service is not part of the protocol definitions and so is
+// described here by hand.  Messages are encoded as JSON.
+package explain
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+
+	"github.com/netauth/netauth/internal/grpcjson"
+)
+
+// ServiceName is the name of the explain service on the wire.
+const ServiceName = "netauth.explain.Explain"
+
+// Request asks how the membership of an entity in a group was
+// decided.
+type Request struct {
+	Entity string
+	Group  string
+}
+
+// Explanation shows how the membership of an entity in a group was
+// decided.  The entity is a member if it is a direct or dynamic
+// member of the group or a member of any included group, and is not a
+// member of any excluded group.
+//
+// Direct is set if the entity is a direct member of the group,
+// Dynamic if it matches the rule of a dynamic group, and Expired if
+// it was a direct member but the membership has expired.  Include
+// holds the included groups that have something to do with the
+// entity, and Exclude the excluded groups that removed it.
+type Explanation struct {
+	Group   string
+	Member  bool
+	Direct  bool           `json:",omitempty"`
+	Dynamic bool           `json:",omitempty"`
+	Expired bool           `json:",omitempty"`
+	Include []*Explanation `json:",omitempty"`
+	Exclude []*Explanation `json:",omitempty"`
+}
+
+// Result carries the explanation for the requested group.
+type Result struct {
+	Explanation *Explanation
+}
+
+// Server is implemented by the NetAuth server to serve explanations.
+type Server interface {
+	EntityExplain(context.Context, *Request) (*Result, error)
+}
+
+// RegisterServer binds an explain server to a gRPC server.
+func RegisterServer(s *grpc.Server, srv Server) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*Server)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "EntityExplain",
+			Handler:    entityExplainHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+func entityExplainHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	r := new(Request)
+	if err := dec(r); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(Server).EntityExplain(ctx, r)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + ServiceName + "/EntityExplain",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(Server).EntityExplain(ctx, req.(*Request))
+	}
+	return interceptor(ctx, r, info, handler)
+}
+
+// Client explains group memberships on a NetAuth server.
+type Client interface {
+	EntityExplain(context.Context, *Request, ...grpc.CallOption) (*Result, error)
+}
+
+// NewClient returns a Client using the provided connection.
+func NewClient(cc grpc.ClientConnInterface) Client {
+	return &client{cc}
+}
+
+type client struct {
+	cc grpc.ClientConnInterface
+}
+
+func (c *client) EntityExplain(ctx context.Context, r *Request, opts ...grpc.CallOption) (*Result, error) {
+	opts = append(opts, grpcjson.CallOption())
+	out := new(Result)
+	if err := c.cc.Invoke(ctx, "/"+ServiceName+"/EntityExplain", r, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
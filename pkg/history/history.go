@@ -0,0 +1,160 @@
+// Package history defines the service used to list the previous
+// revisions of an entity or group that a NetAuth server keeps, and to
+// roll an entity or group back to one of them.
+//
+// The service is not part of the protocol definitions and so is
+// described here by hand.  Messages are encoded as JSON.
+package history
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/netauth/netauth/internal/grpcjson"
+
+	pb "github.com/netauth/protocol"
+)
+
+// ServiceName is the name of the history service on the wire.
+const ServiceName = "netauth.history.History"
+
+// The kinds of object that have a history.
+const (
+	KindEntity = "entity"
+	KindGroup  = "group"
+)
+
+// Request names the entity or group whose history is wanted.  ID is
+// the entity ID or group name.
+type Request struct {
+	Kind string
+	ID   string
+}
+
+// Revision is an entity or group as it was at some point in the
+// past.  Seq orders revisions of the same object, and Time is when
+// the revision was made.  Only one of Entity and Group is set,
+// depending on the kind of object.  Entity secrets are never
+// included.
+type Revision struct {
+	Revision string
+	Seq      uint64
+	Time     time.Time
+	Entity   *pb.Entity `json:",omitempty"`
+	Group    *pb.Group  `json:",omitempty"`
+}
+
+// Result carries the revisions that are kept for an object, oldest
+// first.
+type Result struct {
+	Revisions []*Revision
+}
+
+// RollbackRequest asks for an entity or group to be restored to the
+// named revision.
+type RollbackRequest struct {
+	Kind     string
+	ID       string
+	Revision string
+}
+
+// RollbackResult is returned by a successful rollback.
+type RollbackResult struct{}
+
+// Server is implemented by the NetAuth server to serve history.
+type Server interface {
+	HistoryList(context.Context, *Request) (*Result, error)
+	HistoryRollback(context.Context, *RollbackRequest) (*RollbackResult, error)
+}
+
+// RegisterServer binds a history server to a gRPC server.
+func RegisterServer(s *grpc.Server, srv Server) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*Server)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "List",
+			Handler:    listHandler,
+		},
+		{
+			MethodName: "Rollback",
+			Handler:    rollbackHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+func listHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	r := new(Request)
+	if err := dec(r); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(Server).HistoryList(ctx, r)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + ServiceName + "/List",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(Server).HistoryList(ctx, req.(*Request))
+	}
+	return interceptor(ctx, r, info, handler)
+}
+
+func rollbackHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	r := new(RollbackRequest)
+	if err := dec(r); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(Server).HistoryRollback(ctx, r)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + ServiceName + "/Rollback",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(Server).HistoryRollback(ctx, req.(*RollbackRequest))
+	}
+	return interceptor(ctx, r, info, handler)
+}
+
+// Client reads and rolls back history on a NetAuth server.
+type Client interface {
+	HistoryList(context.Context, *Request, ...grpc.CallOption) (*Result, error)
+	HistoryRollback(context.Context, *RollbackRequest, ...grpc.CallOption) (*RollbackResult, error)
+}
+
+// NewClient returns a Client using the provided connection.
+func NewClient(cc grpc.ClientConnInterface) Client {
+	return &client{cc}
+}
+
+type client struct {
+	cc grpc.ClientConnInterface
+}
+
+func (c *client) HistoryList(ctx context.Context, r *Request, opts ...grpc.CallOption) (*Result, error) {
+	opts = append(opts, grpcjson.CallOption())
+	out := new(Result)
+	if err := c.cc.Invoke(ctx, "/"+ServiceName+"/List", r, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *client) HistoryRollback(ctx context.Context, r *RollbackRequest, opts ...grpc.CallOption) (*RollbackResult, error) {
+	opts = append(opts, grpcjson.CallOption())
+	out := new(RollbackResult)
+	if err := c.cc.Invoke(ctx, "/"+ServiceName+"/Rollback", r, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,20 @@
+package netauth
+
+import (
+	"context"
+
+	"github.com/netauth/netauth/pkg/audit"
+)
+
+// AuditQuery returns the changes recorded in the server's audit log
+// that match the query, oldest first.  This requires a token with
+// GLOBAL_ROOT, and returns codes.FailedPrecondition if the server
+// does not keep an audit log that can be queried.
+func (c *Client) AuditQuery(ctx context.Context, q *audit.Query) ([]*audit.Record, error) {
+	ctx = c.appendMetadata(ctx)
+	res, err := c.audit.AuditQuery(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return res.Records, nil
+}
@@ -0,0 +1,17 @@
+package netauth
+
+import (
+	"context"
+
+	"github.com/netauth/netauth/pkg/capabilities"
+)
+
+// EntityCapabilities returns the capabilities that an entity holds,
+// and whether it holds each one directly or through which groups.  If
+// any changes are given the result also carries the capabilities
+// that the entity would hold after them, but the changes are not
+// made.  This function does not require authentication.
+func (c *Client) EntityCapabilities(ctx context.Context, id string, whatIf ...*capabilities.Change) (*capabilities.Result, error) {
+	ctx = c.appendMetadata(ctx)
+	return c.caps.EntityCapabilities(ctx, &capabilities.Request{Entity: id, WhatIf: whatIf})
+}
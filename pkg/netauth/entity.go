@@ -7,6 +7,8 @@ import (
 	"strings"
 
 	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 
 	pb "github.com/netauth/protocol"
 	rpc "github.com/netauth/protocol/v2"
@@ -34,7 +36,9 @@ func (c *Client) EntityCreate(ctx context.Context, id, secret string, number int
 }
 
 // EntityUpdate alters the generic metadata on an existing entity.  It
-// cannot modify keys or untyped metadata.
+// cannot modify keys or untyped metadata.  To prevent clobbering a
+// concurrent change, pass a context from WithRevision carrying the
+// revision returned by EntityInfoRevision.
 func (c *Client) EntityUpdate(ctx context.Context, id string, meta *pb.EntityMeta) error {
 	if err := c.makeWritable(); err != nil {
 		return err
@@ -69,17 +73,70 @@ func (c *Client) EntityInfo(ctx context.Context, id string) (pb.Entity, error) {
 	return *res.GetEntities()[0], nil
 }
 
+// EntityInfoRevision returns information about an entity in the same
+// way as EntityInfo, and additionally returns the revision of the
+// entity that was returned.  The revision may be passed to
+// WithRevision to make a subsequent update conditional.
+func (c *Client) EntityInfoRevision(ctx context.Context, id string) (pb.Entity, string, error) {
+	ctx = c.appendMetadata(ctx)
+	r := rpc.EntityRequest{
+		Entity: &pb.Entity{
+			ID: &id,
+		},
+	}
+
+	var md metadata.MD
+	res, err := c.rpc.EntityInfo(ctx, &r, grpc.Header(&md))
+	if err != nil {
+		return pb.Entity{}, "", err
+	}
+	return *res.GetEntities()[0], revisionFromHeader(md), nil
+}
+
 // EntitySearch performs a search of all entities.  This search will
 // return a slice of zero or more entities that matched the search
 // criteria.  Searching does not require an authenticated context.
 func (c *Client) EntitySearch(ctx context.Context, expr string) ([]*pb.Entity, error) {
+	return c.EntitySearchWithOptions(ctx, expr, SearchOptions{})
+}
+
+// EntitySearchWithOptions performs a search of all entities in the
+// same way as EntitySearch, with the results ordered and trimmed as
+// set in opts.  The results are fetched a page at a time, but are
+// all returned together.
+func (c *Client) EntitySearchWithOptions(ctx context.Context, expr string, opts SearchOptions) ([]*pb.Entity, error) {
+	var out []*pb.Entity
+	err := c.EntitySearchPages(ctx, expr, opts, func(page []*pb.Entity) error {
+		out = append(out, page...)
+		return nil
+	})
+	return out, err
+}
+
+// EntitySearchPages performs a search of all entities and calls fn
+// with each page of results as it is fetched, which avoids holding
+// every result of a large search at once.  If fn returns an error no
+// more pages are fetched and the error is returned.
+func (c *Client) EntitySearchPages(ctx context.Context, expr string, opts SearchOptions, fn func([]*pb.Entity) error) error {
 	ctx = c.appendMetadata(ctx)
 	r := rpc.SearchRequest{
 		Expression: &expr,
 	}
 
-	res, err := c.rpc.EntitySearch(ctx, &r)
-	return res.GetEntities(), err
+	token := ""
+	for {
+		var md metadata.MD
+		res, err := c.rpc.EntitySearch(withSearchOptions(ctx, opts, token), &r, grpc.Header(&md))
+		if err != nil {
+			return err
+		}
+		if err := fn(res.GetEntities()); err != nil {
+			return err
+		}
+		if token = nextPageFromHeader(md); token == "" {
+			return nil
+		}
+	}
 }
 
 // EntityUM handles operations concerning the untyped key-value store
@@ -0,0 +1,20 @@
+package netauth
+
+import (
+	"context"
+
+	"github.com/netauth/netauth/pkg/explain"
+)
+
+// EntityExplain explains why an entity is or isn't a member of a
+// group, showing the direct and dynamic memberships and the
+// expansions that were used to decide it.  This function does not
+// require authentication.
+func (c *Client) EntityExplain(ctx context.Context, entity, group string) (*explain.Explanation, error) {
+	ctx = c.appendMetadata(ctx)
+	res, err := c.explain.EntityExplain(ctx, &explain.Request{Entity: entity, Group: group})
+	if err != nil {
+		return nil, err
+	}
+	return res.Explanation, nil
+}
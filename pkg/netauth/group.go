@@ -8,6 +8,8 @@ import (
 	"strings"
 
 	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 
 	pb "github.com/netauth/protocol"
 	rpc "github.com/netauth/protocol/v2"
@@ -38,7 +40,9 @@ func (c *Client) GroupCreate(ctx context.Context, name, displayName, managedBy s
 
 // GroupUpdate allows an existing group to be updated.  Only some
 // fields on each group can be updated though, so this function will
-// silently unset fields that are not permissible to edit.
+// silently unset fields that are not permissible to edit.  To prevent
+// clobbering a concurrent change, pass a context from WithRevision
+// carrying the revision returned by GroupInfoRevision.
 func (c *Client) GroupUpdate(ctx context.Context, update *pb.Group) error {
 	if err := c.makeWritable(); err != nil {
 		return err
@@ -76,6 +80,25 @@ func (c *Client) GroupInfo(ctx context.Context, name string) (*pb.Group, []*pb.G
 	return res.GetGroups()[0], res2, nil
 }
 
+// GroupInfoRevision returns a group and its revision.  The revision
+// may be passed to WithRevision to make a subsequent GroupUpdate
+// conditional.
+func (c *Client) GroupInfoRevision(ctx context.Context, name string) (*pb.Group, string, error) {
+	ctx = c.appendMetadata(ctx)
+	r := rpc.GroupRequest{
+		Group: &pb.Group{
+			Name: &name,
+		},
+	}
+
+	var md metadata.MD
+	res, err := c.rpc.GroupInfo(ctx, &r, grpc.Header(&md))
+	if err != nil {
+		return nil, "", err
+	}
+	return res.GetGroups()[0], revisionFromHeader(md), nil
+}
+
 // GroupUM handles operations concerning the untyped key-value store
 // on each group.  This data is not directly processed by NetAuth or
 // visible in search indexes, but is useful for integrating with 3rd
@@ -338,14 +361,45 @@ func (c *Client) GroupMembers(ctx context.Context, name string) ([]*pb.Entity, e
 // GroupSearch returns a list of groups that satisfy the given search
 // expression.  This function requires no authorization.
 func (c *Client) GroupSearch(ctx context.Context, expression string) ([]*pb.Group, error) {
+	return c.GroupSearchWithOptions(ctx, expression, SearchOptions{})
+}
+
+// GroupSearchWithOptions returns the groups that satisfy the given
+// search expression in the same way as GroupSearch, with the results
+// ordered and trimmed as set in opts.
+func (c *Client) GroupSearchWithOptions(ctx context.Context, expression string, opts SearchOptions) ([]*pb.Group, error) {
+	var out []*pb.Group
+	err := c.GroupSearchPages(ctx, expression, opts, func(page []*pb.Group) error {
+		out = append(out, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GroupSearchPages searches for groups and calls fn with each page of
+// results as it is fetched.  If fn returns an error no more pages are
+// fetched and the error is returned.
+func (c *Client) GroupSearchPages(ctx context.Context, expression string, opts SearchOptions, fn func([]*pb.Group) error) error {
 	ctx = c.appendMetadata(ctx)
 	r := rpc.SearchRequest{
 		Expression: &expression,
 	}
-	res, err := c.rpc.GroupSearch(ctx, &r)
-	if err != nil {
-		return nil, err
 
+	token := ""
+	for {
+		var md metadata.MD
+		res, err := c.rpc.GroupSearch(withSearchOptions(ctx, opts, token), &r, grpc.Header(&md))
+		if err != nil {
+			return err
+		}
+		if err := fn(res.GetGroups()); err != nil {
+			return err
+		}
+		if token = nextPageFromHeader(md); token == "" {
+			return nil
+		}
 	}
-	return res.GetGroups(), err
 }
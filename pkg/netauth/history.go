@@ -0,0 +1,58 @@
+package netauth
+
+import (
+	"context"
+
+	"github.com/netauth/netauth/pkg/history"
+)
+
+// EntityHistory returns the revisions of an entity that the server
+// keeps, oldest first.  This function does not require
+// authentication, and secrets are never returned.
+func (c *Client) EntityHistory(ctx context.Context, id string) ([]*history.Revision, error) {
+	return c.historyList(ctx, history.KindEntity, id)
+}
+
+// GroupHistory returns the revisions of a group that the server
+// keeps, oldest first.  This function does not require
+// authentication.
+func (c *Client) GroupHistory(ctx context.Context, name string) ([]*history.Revision, error) {
+	return c.historyList(ctx, history.KindGroup, name)
+}
+
+// EntityRollback restores an entity to a previous revision, which
+// must be one of those returned by EntityHistory.  The secret of the
+// entity is not changed.  This requires a token with GLOBAL_ROOT.  To
+// avoid clobbering a concurrent change, pass a context from
+// WithRevision carrying the revision returned by EntityInfoRevision.
+func (c *Client) EntityRollback(ctx context.Context, id, rev string) error {
+	return c.historyRollback(ctx, history.KindEntity, id, rev)
+}
+
+// GroupRollback restores a group to a previous revision, which must
+// be one of those returned by GroupHistory.  This requires a token
+// with GLOBAL_ROOT.  To avoid clobbering a concurrent change, pass a
+// context from WithRevision carrying the revision returned by
+// GroupInfoRevision.
+func (c *Client) GroupRollback(ctx context.Context, name, rev string) error {
+	return c.historyRollback(ctx, history.KindGroup, name, rev)
+}
+
+func (c *Client) historyList(ctx context.Context, kind, id string) ([]*history.Revision, error) {
+	ctx = c.appendMetadata(ctx)
+	res, err := c.history.HistoryList(ctx, &history.Request{Kind: kind, ID: id})
+	if err != nil {
+		return nil, err
+	}
+	return res.Revisions, nil
+}
+
+func (c *Client) historyRollback(ctx context.Context, kind, id, rev string) error {
+	if err := c.makeWritable(); err != nil {
+		return err
+	}
+
+	ctx = c.appendMetadata(ctx)
+	_, err := c.history.HistoryRollback(ctx, &history.RollbackRequest{Kind: kind, ID: id, Revision: rev})
+	return err
+}
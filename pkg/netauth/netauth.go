@@ -13,7 +13,14 @@ import (
 
 	"github.com/netauth/netauth/internal/startup"
 	"github.com/netauth/netauth/internal/token"
+	"github.com/netauth/netauth/pkg/audit"
+	"github.com/netauth/netauth/pkg/capabilities"
+	"github.com/netauth/netauth/pkg/explain"
+	"github.com/netauth/netauth/pkg/history"
 	"github.com/netauth/netauth/pkg/netauth/cache"
+	"github.com/netauth/netauth/pkg/rename"
+	"github.com/netauth/netauth/pkg/secrets"
+	"github.com/netauth/netauth/pkg/watch"
 
 	// The default token service is the jwt implementation, and
 	// since its internal, the client needs to import it on behalf
@@ -77,6 +84,13 @@ func NewWithLog(l hclog.Logger) (*Client, error) {
 		TokenCache: cache,
 		Service:    ts,
 		rpc:        rpc.NewNetAuth2Client(conn),
+		watch:      watch.NewClient(conn),
+		audit:      audit.NewClient(conn),
+		history:    history.NewClient(conn),
+		rename:     rename.NewClient(conn),
+		secrets:    secrets.NewClient(conn),
+		explain:    explain.NewClient(conn),
+		caps:       capabilities.NewClient(conn),
 		log:        l,
 		clientName: viper.GetString("client.ID"),
 	}, nil
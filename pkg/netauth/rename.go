@@ -0,0 +1,37 @@
+package netauth
+
+import (
+	"context"
+
+	"github.com/netauth/netauth/pkg/rename"
+)
+
+// EntityRename changes the ID of an entity.  The entity keeps its
+// number, secret, and metadata.  This requires a token with both
+// CREATE_ENTITY and DESTROY_ENTITY.  To avoid clobbering a concurrent
+// change, pass a context from WithRevision carrying the revision
+// returned by EntityInfoRevision.
+func (c *Client) EntityRename(ctx context.Context, id, to string) error {
+	if err := c.makeWritable(); err != nil {
+		return err
+	}
+
+	ctx = c.appendMetadata(ctx)
+	_, err := c.rename.EntityRename(ctx, &rename.Request{ID: id, To: to})
+	return err
+}
+
+// GroupRename changes the name of a group.  Memberships, expansions,
+// and groups managed by the group all follow it to the new name.
+// This requires a token with both CREATE_GROUP and DESTROY_GROUP.  To
+// avoid clobbering a concurrent change, pass a context from
+// WithRevision carrying the revision returned by GroupInfoRevision.
+func (c *Client) GroupRename(ctx context.Context, name, to string) error {
+	if err := c.makeWritable(); err != nil {
+		return err
+	}
+
+	ctx = c.appendMetadata(ctx)
+	_, err := c.rename.GroupRename(ctx, &rename.Request{ID: name, To: to})
+	return err
+}
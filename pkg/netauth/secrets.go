@@ -0,0 +1,20 @@
+package netauth
+
+import (
+	"context"
+
+	"github.com/netauth/netauth/pkg/secrets"
+)
+
+// SecretHistoryPurge forgets the previous secrets of an entity, so
+// that any of them may be chosen again.  This requires a token with
+// CHANGE_ENTITY_SECRET.
+func (c *Client) SecretHistoryPurge(ctx context.Context, id string) error {
+	if err := c.makeWritable(); err != nil {
+		return err
+	}
+
+	ctx = c.appendMetadata(ctx)
+	_, err := c.secrets.SecretHistoryPurge(ctx, &secrets.PurgeRequest{ID: id})
+	return err
+}
@@ -4,7 +4,14 @@ import (
 	"github.com/hashicorp/go-hclog"
 
 	"github.com/netauth/netauth/internal/token"
+	"github.com/netauth/netauth/pkg/audit"
+	"github.com/netauth/netauth/pkg/capabilities"
+	"github.com/netauth/netauth/pkg/explain"
+	"github.com/netauth/netauth/pkg/history"
 	"github.com/netauth/netauth/pkg/netauth/cache"
+	"github.com/netauth/netauth/pkg/rename"
+	"github.com/netauth/netauth/pkg/secrets"
+	"github.com/netauth/netauth/pkg/watch"
 
 	rpc "github.com/netauth/protocol/v2"
 )
@@ -17,11 +24,38 @@ type Client struct {
 	cache.TokenCache
 	token.Service
 
-	rpc rpc.NetAuth2Client
-	log hclog.Logger
+	rpc     rpc.NetAuth2Client
+	watch   watch.Client
+	audit   audit.Client
+	history history.Client
+	rename  rename.Client
+	secrets secrets.Client
+	explain explain.Client
+	caps    capabilities.Client
+	log     hclog.Logger
 
 	clientName  string
 	serviceName string
 
 	writeable bool
 }
+
+// SearchOptions control how the results of a search are returned.
+// The zero value returns every field of every result, in order of how
+// well they match.
+type SearchOptions struct {
+	// PageSize is the number of results fetched by each request
+	// to the server.  If it is not set DefaultSearchPageSize is
+	// used.
+	PageSize int
+
+	// Sort lists the fields that results are ordered by, and a
+	// field prefixed with '-' is sorted in descending order.
+	// Results can be ordered by their ID or name with '_id'.
+	Sort []string
+
+	// Fields lists the fields to return for each result, named
+	// in the same way as in search expressions.  The ID of an
+	// entity or name of a group is always returned.
+	Fields []string
+}
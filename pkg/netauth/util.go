@@ -6,10 +6,28 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"google.golang.org/grpc/metadata"
 )
 
+// DefaultSearchPageSize is the number of results fetched by each
+// request to the server during a search, unless the SearchOptions
+// set a different size.
+const DefaultSearchPageSize = 500
+
+// GroupRuleKey is the key of the group KV data that holds the rule of
+// a dynamic group.  The members of a dynamic group are the entities
+// that match its rule, which is a list of field:value clauses written
+// in the same way as a search expression.
+const GroupRuleKey = "netauth.rule"
+
+// ScopeKey is the key of the KV data that grants capabilities over
+// the scope of a group.  Each value is written CAPABILITY:group, and
+// the scope is the group and every group managed from within it.
+// Changing this key requires GLOBAL_ROOT.
+const ScopeKey = "netauth.scope"
+
 var (
 	kvIndexRegexp = regexp.MustCompile(`{(\d+)}$`)
 )
@@ -20,6 +38,67 @@ func Authorize(ctx context.Context, token string) context.Context {
 	return metadata.AppendToOutgoingContext(ctx, "authorization", token)
 }
 
+// WithRevision attaches a revision to a provided context, returning a
+// new context in which updates are conditional.  An update made with
+// this context will fail with codes.Aborted if the entity or group
+// has been changed since the revision was obtained, in which case
+// the caller should fetch the current value and try again.
+func WithRevision(ctx context.Context, rev string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "revision", rev)
+}
+
+// WithNumberPool attaches the name of a number pool to a provided
+// context, returning a new context in which entities and groups that
+// are created without a number have one allocated from that pool.
+func WithNumberPool(ctx context.Context, pool string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "number-pool", pool)
+}
+
+// WithMembershipExpiry attaches an expiry time to a provided context,
+// returning a new context in which entities added to groups are only
+// members until that time.
+func WithMembershipExpiry(ctx context.Context, until time.Time) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "until", until.UTC().Format(time.RFC3339))
+}
+
+// revisionFromHeader extracts the revision returned by the server.
+func revisionFromHeader(md metadata.MD) string {
+	if r := md.Get("revision"); len(r) == 1 {
+		return r[0]
+	}
+	return ""
+}
+
+// withSearchOptions attaches the search options to a provided
+// context, along with the token of the page to fetch.
+func withSearchOptions(ctx context.Context, opts SearchOptions, token string) context.Context {
+	size := opts.PageSize
+	if size <= 0 {
+		size = DefaultSearchPageSize
+	}
+	kv := []string{"page-size", strconv.Itoa(size)}
+	if token != "" {
+		kv = append(kv, "page-token", token)
+	}
+	for _, s := range opts.Sort {
+		kv = append(kv, "sort", s)
+	}
+	for _, f := range opts.Fields {
+		kv = append(kv, "fields", f)
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
+// nextPageFromHeader extracts the token of the next page of search
+// results returned by the server.  The token is empty after the last
+// page.
+func nextPageFromHeader(md metadata.MD) string {
+	if t := md.Get("next-page-token"); len(t) == 1 {
+		return t[0]
+	}
+	return ""
+}
+
 // parseKV turns an unsorted list of strings into a map of key to
 // sorted values.
 func parseKV(in []string) map[string][]string {
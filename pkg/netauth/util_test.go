@@ -2,7 +2,9 @@ package netauth
 
 import (
 	"context"
+	"reflect"
 	"testing"
+	"time"
 
 	"google.golang.org/grpc/metadata"
 )
@@ -21,6 +23,50 @@ func TestAuthorize(t *testing.T) {
 	}
 }
 
+func TestWithRevision(t *testing.T) {
+	ctx := WithRevision(context.Background(), "abc123")
+
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if !ok {
+		t.Fatal("Bad metadata")
+	}
+
+	if rev := revisionFromHeader(md); rev != "abc123" {
+		t.Errorf("Revision was not correctly attached: %s", rev)
+	}
+
+	if rev := revisionFromHeader(metadata.MD{}); rev != "" {
+		t.Errorf("Revision from nowhere: %s", rev)
+	}
+}
+
+func TestWithNumberPool(t *testing.T) {
+	ctx := WithNumberPool(context.Background(), "service")
+
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if !ok {
+		t.Fatal("Bad metadata")
+	}
+
+	if p := md.Get("number-pool"); len(p) != 1 || p[0] != "service" {
+		t.Errorf("Pool was not correctly attached: %v", p)
+	}
+}
+
+func TestWithMembershipExpiry(t *testing.T) {
+	until := time.Date(2020, 1, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600))
+	ctx := WithMembershipExpiry(context.Background(), until)
+
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if !ok {
+		t.Fatal("Bad metadata")
+	}
+
+	if u := md.Get("until"); len(u) != 1 || u[0] != "2020-01-01T11:00:00Z" {
+		t.Errorf("Expiry was not correctly attached: %v", u)
+	}
+}
+
 func TestParseKV(t *testing.T) {
 	kv1 := []string{
 		"key{1}:value1",
@@ -40,3 +86,32 @@ func TestParseKV(t *testing.T) {
 		t.Errorf("k does not contain the correct sorted value!: %v", res["k"])
 	}
 }
+
+func TestWithSearchOptions(t *testing.T) {
+	cases := []struct {
+		opts  SearchOptions
+		token string
+		want  metadata.MD
+	}{
+		{SearchOptions{}, "", metadata.Pairs("page-size", "500")},
+		{
+			SearchOptions{PageSize: 10, Sort: []string{"-Number", "_id"}, Fields: []string{"meta.Shell"}},
+			"abc",
+			metadata.Pairs("page-size", "10", "page-token", "abc", "sort", "-Number", "sort", "_id", "fields", "meta.Shell"),
+		},
+	}
+
+	for i, c := range cases {
+		md, ok := metadata.FromOutgoingContext(withSearchOptions(context.Background(), c.opts, c.token))
+		if !ok || !reflect.DeepEqual(md, c.want) {
+			t.Errorf("%d: Got %v; Want %v", i, md, c.want)
+		}
+	}
+
+	if next := nextPageFromHeader(metadata.Pairs("next-page-token", "def")); next != "def" {
+		t.Errorf("Next page was not correctly extracted: %s", next)
+	}
+	if next := nextPageFromHeader(metadata.MD{}); next != "" {
+		t.Errorf("Next page from nowhere: %s", next)
+	}
+}
@@ -0,0 +1,37 @@
+package netauth
+
+import (
+	"context"
+
+	"github.com/netauth/netauth/pkg/watch"
+)
+
+// Watch streams changes to entities and groups to the provided
+// function until the context is cancelled, the function returns an
+// error, or the connection is lost.  This function does not require
+// authentication.
+//
+// The cursor in the request is advanced as each event is handled, so
+// after an error the same request may be passed to Watch again to
+// resume without missing any events.  If the server returns
+// codes.OutOfRange the cursor has expired, and the caller should
+// refetch anything it is interested in and clear the cursor before
+// watching again.
+func (c *Client) Watch(ctx context.Context, r *watch.Request, f func(*watch.Event) error) error {
+	ctx = c.appendMetadata(ctx)
+	stream, err := c.watch.Watch(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	for {
+		e, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if err := f(e); err != nil {
+			return err
+		}
+		r.Cursor = e.Cursor
+	}
+}
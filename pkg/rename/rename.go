@@ -0,0 +1,123 @@
+// Package rename defines the service used to rename entities and
+// groups on a NetAuth server.
+//
+// The service is not part of the protocol definitions and so is
+// described here by hand.  Messages are encoded as JSON.
+package rename
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+
+	"github.com/netauth/netauth/internal/grpcjson"
+)
+
+// ServiceName is the name of the rename service on the wire.
+const ServiceName = "netauth.rename.Rename"
+
+// Request asks for the entity or group named by ID to be known as To
+// from now on.
+type Request struct {
+	ID string
+	To string
+}
+
+// Result is returned by a successful rename.
+type Result struct{}
+
+// Server is implemented by the NetAuth server to serve renames.
+type Server interface {
+	EntityRename(context.Context, *Request) (*Result, error)
+	GroupRename(context.Context, *Request) (*Result, error)
+}
+
+// RegisterServer binds a rename server to a gRPC server.
+func RegisterServer(s *grpc.Server, srv Server) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*Server)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "EntityRename",
+			Handler:    entityRenameHandler,
+		},
+		{
+			MethodName: "GroupRename",
+			Handler:    groupRenameHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+func entityRenameHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	r := new(Request)
+	if err := dec(r); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(Server).EntityRename(ctx, r)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + ServiceName + "/EntityRename",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(Server).EntityRename(ctx, req.(*Request))
+	}
+	return interceptor(ctx, r, info, handler)
+}
+
+func groupRenameHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	r := new(Request)
+	if err := dec(r); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(Server).GroupRename(ctx, r)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + ServiceName + "/GroupRename",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(Server).GroupRename(ctx, req.(*Request))
+	}
+	return interceptor(ctx, r, info, handler)
+}
+
+// Client renames entities and groups on a NetAuth server.
+type Client interface {
+	EntityRename(context.Context, *Request, ...grpc.CallOption) (*Result, error)
+	GroupRename(context.Context, *Request, ...grpc.CallOption) (*Result, error)
+}
+
+// NewClient returns a Client using the provided connection.
+func NewClient(cc grpc.ClientConnInterface) Client {
+	return &client{cc}
+}
+
+type client struct {
+	cc grpc.ClientConnInterface
+}
+
+func (c *client) EntityRename(ctx context.Context, r *Request, opts ...grpc.CallOption) (*Result, error) {
+	opts = append(opts, grpcjson.CallOption())
+	out := new(Result)
+	if err := c.cc.Invoke(ctx, "/"+ServiceName+"/EntityRename", r, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *client) GroupRename(ctx context.Context, r *Request, opts ...grpc.CallOption) (*Result, error) {
+	opts = append(opts, grpcjson.CallOption())
+	out := new(Result)
+	if err := c.cc.Invoke(ctx, "/"+ServiceName+"/GroupRename", r, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,90 @@
+// Package secrets defines the service used to manage the previous
+// secrets of entities that a NetAuth server keeps to stop them from
+// being chosen again.
+//
+// The service is not part of the protocol definitions and so is
+// described here by hand.  Messages are encoded as JSON.
+package secrets
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+
+	"github.com/netauth/netauth/internal/grpcjson"
+)
+
+// ServiceName is the name of the secrets service on the wire.
+const ServiceName = "netauth.secrets.Secrets"
+
+// PurgeRequest asks for the previous secrets of the entity named by
+// ID to be forgotten.
+type PurgeRequest struct {
+	ID string
+}
+
+// PurgeResult is returned by a successful purge.
+type PurgeResult struct{}
+
+// Server is implemented by the NetAuth server to manage secrets.
+type Server interface {
+	SecretHistoryPurge(context.Context, *PurgeRequest) (*PurgeResult, error)
+}
+
+// RegisterServer binds a secrets server to a gRPC server.
+func RegisterServer(s *grpc.Server, srv Server) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*Server)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "HistoryPurge",
+			Handler:    historyPurgeHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+func historyPurgeHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	r := new(PurgeRequest)
+	if err := dec(r); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(Server).SecretHistoryPurge(ctx, r)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + ServiceName + "/HistoryPurge",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(Server).SecretHistoryPurge(ctx, req.(*PurgeRequest))
+	}
+	return interceptor(ctx, r, info, handler)
+}
+
+// Client manages secrets on a NetAuth server.
+type Client interface {
+	SecretHistoryPurge(context.Context, *PurgeRequest, ...grpc.CallOption) (*PurgeResult, error)
+}
+
+// NewClient returns a Client using the provided connection.
+func NewClient(cc grpc.ClientConnInterface) Client {
+	return &client{cc}
+}
+
+type client struct {
+	cc grpc.ClientConnInterface
+}
+
+func (c *client) SecretHistoryPurge(ctx context.Context, r *PurgeRequest, opts ...grpc.CallOption) (*PurgeResult, error) {
+	opts = append(opts, grpcjson.CallOption())
+	out := new(PurgeResult)
+	if err := c.cc.Invoke(ctx, "/"+ServiceName+"/HistoryPurge", r, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,175 @@
+// Package watch defines the change stream that a NetAuth server
+// offers alongside the protocol.  A client opens a watch and is sent
+// an event each time an entity or group is created, updated or
+// destroyed.  Every event carries a cursor, and a client that
+// reconnects with the last cursor it saw will be sent everything it
+// missed in the meantime.
+//
+// The service is not part of the protocol definitions and so is
+// described here by hand.  Messages are encoded as JSON.
+package watch
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+
+	"github.com/netauth/netauth/internal/grpcjson"
+)
+
+// ServiceName is the name of the watch service on the wire.
+const ServiceName = "netauth.watch.Watch"
+
+// An EventType describes what happened to an entity or group.
+type EventType string
+
+// The types of event that may be watched.
+const (
+	EntityCreate  EventType = "ENTITY_CREATE"
+	EntityUpdate  EventType = "ENTITY_UPDATE"
+	EntityDestroy EventType = "ENTITY_DESTROY"
+	GroupCreate   EventType = "GROUP_CREATE"
+	GroupUpdate   EventType = "GROUP_UPDATE"
+	GroupDestroy  EventType = "GROUP_DESTROY"
+)
+
+// Request opens a watch.  If Cursor is empty only events that happen
+// after the watch is opened are sent, otherwise every event after
+// the one that carried the cursor is sent.  Types and IDs restrict
+// the events that are sent, and are ignored if empty.
+type Request struct {
+	Cursor string
+	Types  []EventType
+	IDs    []string
+}
+
+// Event is sent each time a watched entity or group changes.  The ID
+// is the entity ID or the group name.
+type Event struct {
+	Cursor string
+	Type   EventType
+	ID     string
+}
+
+// Matches returns true if the event is one that the request asked
+// for.
+func (r *Request) Matches(e *Event) bool {
+	if len(r.Types) > 0 && !containsType(r.Types, e.Type) {
+		return false
+	}
+	if len(r.IDs) > 0 && !containsString(r.IDs, e.ID) {
+		return false
+	}
+	return true
+}
+
+func containsType(l []EventType, t EventType) bool {
+	for _, i := range l {
+		if i == t {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(l []string, s string) bool {
+	for _, i := range l {
+		if i == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Server is implemented by the NetAuth server to provide watches.
+type Server interface {
+	Watch(*Request, ServerStream) error
+}
+
+// ServerStream is the server's half of a watch.
+type ServerStream interface {
+	Send(*Event) error
+	Context() context.Context
+}
+
+type serverStream struct {
+	grpc.ServerStream
+}
+
+func (s *serverStream) Send(e *Event) error {
+	return s.ServerStream.SendMsg(e)
+}
+
+// RegisterServer binds a watch server to a gRPC server.
+func RegisterServer(s *grpc.Server, srv Server) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*Server)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       watchHandler,
+			ServerStreams: true,
+		},
+	},
+}
+
+func watchHandler(srv interface{}, stream grpc.ServerStream) error {
+	r := new(Request)
+	if err := stream.RecvMsg(r); err != nil {
+		return err
+	}
+	return srv.(Server).Watch(r, &serverStream{stream})
+}
+
+// Client opens watches on a NetAuth server.
+type Client interface {
+	Watch(context.Context, *Request, ...grpc.CallOption) (ClientStream, error)
+}
+
+// ClientStream is the client's half of a watch.  Recv blocks until
+// the next event arrives or the watch ends.
+type ClientStream interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+// NewClient returns a Client using the provided connection.
+func NewClient(cc grpc.ClientConnInterface) Client {
+	return &client{cc}
+}
+
+type client struct {
+	cc grpc.ClientConnInterface
+}
+
+func (c *client) Watch(ctx context.Context, r *Request, opts ...grpc.CallOption) (ClientStream, error) {
+	opts = append(opts, grpcjson.CallOption())
+	stream, err := c.cc.NewStream(ctx, &serviceDesc.Streams[0], "/"+ServiceName+"/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(r); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return &clientStream{stream}, nil
+}
+
+type clientStream struct {
+	grpc.ClientStream
+}
+
+func (s *clientStream) Recv() (*Event, error) {
+	e := new(Event)
+	if err := s.ClientStream.RecvMsg(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
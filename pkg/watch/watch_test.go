@@ -0,0 +1,26 @@
+package watch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestMatches(t *testing.T) {
+	cases := []struct {
+		req  Request
+		want bool
+	}{
+		{Request{}, true},
+		{Request{Types: []EventType{EntityUpdate}}, true},
+		{Request{Types: []EventType{GroupUpdate}}, false},
+		{Request{IDs: []string{"entity1", "entity2"}}, true},
+		{Request{IDs: []string{"entity2"}}, false},
+		{Request{Types: []EventType{EntityUpdate}, IDs: []string{"entity2"}}, false},
+	}
+
+	e := &Event{Type: EntityUpdate, ID: "entity1"}
+	for i, c := range cases {
+		assert.Equalf(t, c.want, c.req.Matches(e), "Test Number %d", i)
+	}
+}